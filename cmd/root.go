@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/charmbracelet/bubbles/filepicker"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list" // Legacy groups have no access hash; supergroups (migrated) do.
 	"github.com/gotd/td/tg"
 	"go.dalton.dog/bubbleup"
@@ -50,6 +52,8 @@ func newRootCmd(version string, telegramAPIID, telegramAPIHash string) *cobra.Co
 				return fmt.Errorf("failed to initialize telegram client: %w", err)
 			}
 			telegram.Cligram = cligram
+			// the relay has to outlive the client context to deliver the "dead" connection state
+			appCtx := ctx
 			err = telegram.Cligram.Run(ctx, func(ctx context.Context) error {
 				accountsOnThisDevice := getAccountDirsOnThisDevice(telegramAPIID, telegramAPIHash)
 				if err := cligram.Auth(ctx, accountsOnThisDevice); err != nil {
@@ -57,7 +61,11 @@ func newRootCmd(version string, telegramAPIID, telegramAPIHash string) *cobra.Co
 					return fmt.Errorf("authentication failed: %w", err)
 				}
 
-				userChatsResult, err := telegram.Cligram.GetAllChats(ctx, 0, 0)
+				// a cached chat list renders instantly, the model refreshes it from the server on init
+				userChatsResult, chatsFromCache := telegram.Cligram.CachedChats()
+				if !chatsFromCache {
+					userChatsResult, err = telegram.Cligram.GetAllChats(ctx, 0, 0)
+				}
 				modalContent := ""
 				isModalVisible := false
 				userChats := userChatsResult.PrivateChats
@@ -133,6 +141,9 @@ func newRootCmd(version string, telegramAPIID, telegramAPIHash string) *cobra.Co
 				fp.AllowedTypes = []string{}
 				fp.DirAllowed = false
 				fp.CurrentDirectory, _ = os.UserHomeDir()
+				// space marks files for an album, on a directory it opens it like enter does
+				fp.KeyMap.Open = key.NewBinding(key.WithKeys("l", "right", "enter", " "), key.WithHelp("l", "open"))
+				fp.KeyMap.Select = key.NewBinding(key.WithKeys("enter", " "), key.WithHelp("enter", "select"))
 
 				model.Filepicker = fp
 				model.Input = input
@@ -146,11 +157,12 @@ func newRootCmd(version string, telegramAPIID, telegramAPIHash string) *cobra.Co
 				model.Mode = ui.ModeUsers
 				model.FocusedOn = ui.SideBar
 				model.ChatUI = chatList
-				model.SelectedFile = ""
 				model.OffsetDate = userChatsResult.OffsetDate
 				model.OffsetID = userChatsResult.OffsetID
 				model.OnPagination = false
 				model.Bots = botsList
+				model.ChatsFromCache = chatsFromCache
+				model.Connection = telegram.Cligram.ConnectionStatus()
 
 				model.Stories = []types.Stories{}
 
@@ -172,10 +184,16 @@ func newRootCmd(version string, telegramAPIID, telegramAPIHash string) *cobra.Co
 				}
 
 				Program = tea.NewProgram(manager, tea.WithAltScreen())
+				go func() {
+					// catches up on whatever arrived while we were closed, then keeps the update state in sync
+					if err := telegram.Cligram.RunUpdates(ctx); err != nil && !errors.Is(err, context.Canceled) {
+						slog.Error("updates manager stopped", "error", err)
+					}
+				}()
 				go func() {
 					for {
 						select {
-						case <-ctx.Done():
+						case <-appCtx.Done():
 							return
 						case msg, ok := <-updateChannel:
 							if !ok {
@@ -196,6 +214,39 @@ func newRootCmd(version string, telegramAPIID, telegramAPIHash string) *cobra.Co
 							if msg.SearchResult != nil {
 								Program.Send(*msg.SearchResult)
 							}
+							if msg.MessageEdited != nil {
+								Program.Send(*msg.MessageEdited)
+							}
+							if msg.MessagesDeleted != nil {
+								Program.Send(*msg.MessagesDeleted)
+							}
+							if msg.MessageReactions != nil {
+								Program.Send(*msg.MessageReactions)
+							}
+							if msg.PollUpdate != nil {
+								Program.Send(*msg.PollUpdate)
+							}
+							if msg.BotCommands != nil {
+								Program.Send(*msg.BotCommands)
+							}
+							if msg.ServiceMessage != nil {
+								Program.Send(*msg.ServiceMessage)
+							}
+							if msg.ChannelTooLong != nil {
+								Program.Send(*msg.ChannelTooLong)
+							}
+							if msg.ConnectionState != nil {
+								Program.Send(*msg.ConnectionState)
+							}
+							if msg.Outbox != nil {
+								Program.Send(*msg.Outbox)
+							}
+							if msg.Download != nil {
+								Program.Send(*msg.Download)
+							}
+							if msg.Upload != nil {
+								Program.Send(*msg.Upload)
+							}
 						}
 					}
 				}()
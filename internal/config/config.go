@@ -12,6 +12,10 @@ type CliGramConfig struct {
 	Chat struct {
 		SendTypingState *bool   `json:"sendTypingState,omitempty"`
 		ReadReceiptMode *string `json:"readReceiptMode,omitempty"`
+		// colors code blocks by their language, on unless set to false
+		SyntaxHighlighting *bool `json:"syntaxHighlighting,omitempty"`
+		// how typed messages are formatted: markdown (default), html or plain
+		MessageFormat *string `json:"messageFormat,omitempty"`
 	} `json:"chat"`
 	ReadStories bool `json:"readStories"`
 	Privacy     struct {
@@ -21,6 +25,16 @@ type CliGramConfig struct {
 		Enabled            *bool `json:"enabled,omitempty"`
 		ShowMessagePreview *bool `json:"showMessagePreview,omitempty"`
 	} `json:"notifications"`
+	Downloads struct {
+		// where media is saved, ~/Downloads/cligram when unset
+		Directory *string `json:"directory,omitempty"`
+	} `json:"downloads"`
+	Images struct {
+		// kitty, iterm2, sixel, blocks or none, detected from the terminal when unset or "auto"
+		Protocol *string `json:"protocol,omitempty"`
+		// small previews of photos in the chat, on by default
+		Previews *bool `json:"previews,omitempty"`
+	} `json:"images"`
 }
 
 func defaultCliGramConfig() CliGramConfig {
@@ -32,6 +46,10 @@ func defaultCliGramConfig() CliGramConfig {
 		Chat: struct {
 			SendTypingState *bool   `json:"sendTypingState,omitempty"`
 			ReadReceiptMode *string `json:"readReceiptMode,omitempty"`
+			// colors code blocks by their language, on unless set to false
+			SyntaxHighlighting *bool `json:"syntaxHighlighting,omitempty"`
+			// how typed messages are formatted: markdown (default), html or plain
+			MessageFormat *string `json:"messageFormat,omitempty"`
 		}{
 			SendTypingState: &sendTyping,
 			ReadReceiptMode: &readReceipt,
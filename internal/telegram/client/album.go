@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// sendAlbum sends req.FilePaths as one album with req.Message as its caption. every file is
+// uploaded and turned into server side media first, the album then goes out in one request
+func (c *Client) sendAlbum(ctx context.Context, req types.SendMessageRequest, peer tg.InputPeerClass) (*types.SendMessageResponse, error) {
+	if len(req.FilePaths) > types.MaxAlbumSize {
+		return nil, types.NewTelegramError(types.ErrorCodeInvalidFile, fmt.Sprintf("an album holds at most %d files", types.MaxAlbumSize), nil)
+	}
+	// the outbox picks these, without them a retry could post the album a second time
+	if len(req.AlbumRandIDs) != len(req.FilePaths)-1 {
+		return nil, errors.New("album files have no random ids")
+	}
+	files := make([]*os.File, 0, len(req.FilePaths))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	sizes := make([]int64, 0, len(req.FilePaths))
+	var total int64
+	// photos and videos can share an album, anything else only goes with other files
+	asFile := req.SendAsFile
+	for _, path := range req.FilePaths {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if fileInfo.IsDir() {
+			return nil, types.NewTelegramError(types.ErrorCodeInvalidFile, "file is a directory", nil)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		sizes = append(sizes, fileInfo.Size())
+		total += fileInfo.Size()
+
+		mimeType, err := detectMimeType(file, path)
+		if err != nil {
+			return nil, err
+		}
+		switch mimeType {
+		case "image/jpeg", "image/png", "video/mp4", "video/quicktime":
+		default:
+			asFile = true
+		}
+	}
+
+	progress := &uploadProgress{client: c, req: req, peer: peer, fileName: fmt.Sprintf("album of %d files", len(files)), total: total}
+	caption, entities := shared.ParseMarkup(req.Message)
+	multiMedia := make([]tg.InputSingleMedia, 0, len(files))
+	randomIDs := make([]int64, 0, len(files))
+	for i, file := range files {
+		fileUpload, err := c.uploadFile(ctx, file, sizes[i], progress)
+		if err != nil {
+			return nil, err
+		}
+		progress.done += sizes[i]
+
+		item := req
+		item.FilePath, item.SendAsFile = req.FilePaths[i], asFile
+		media, err := c.uploadedMedia(ctx, item, file, sizes[i], fileUpload)
+		if err != nil {
+			return nil, err
+		}
+		// albums only take media that is on the server already
+		uploaded, err := c.GetAPI().MessagesUploadMedia(ctx, &tg.MessagesUploadMediaRequest{Peer: peer, Media: media})
+		if err != nil {
+			return nil, err
+		}
+		inputMedia, err := albumInputMedia(uploaded)
+		if err != nil {
+			return nil, err
+		}
+
+		// the first message carries the caption and the rand id the outbox knows the send by
+		single := tg.InputSingleMedia{Media: inputMedia}
+		if i == 0 {
+			single.RandomID = int64(req.RandID)
+			single.Message = caption
+			single.SetEntities(shared.ToTGEntities(entities))
+		} else {
+			single.RandomID = req.AlbumRandIDs[i-1]
+		}
+		multiMedia = append(multiMedia, single)
+		randomIDs = append(randomIDs, single.RandomID)
+	}
+
+	updates, err := c.GetAPI().MessagesSendMultiMedia(ctx, &tg.MessagesSendMultiMediaRequest{
+		Peer:       peer,
+		MultiMedia: multiMedia,
+		ReplyTo:    inputReplyTo(parseReplyID(req.ReplyToMessageID), req.TopMsgID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return albumResponse(updates, randomIDs), nil
+}
+
+func albumInputMedia(media tg.MessageMediaClass) (tg.InputMediaClass, error) {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		if photo, ok := m.Photo.(*tg.Photo); ok {
+			return &tg.InputMediaPhoto{ID: photo.AsInput()}, nil
+		}
+	case *tg.MessageMediaDocument:
+		if document, ok := m.Document.(*tg.Document); ok {
+			return &tg.InputMediaDocument{ID: document.AsInput()}, nil
+		}
+	}
+	return nil, errors.New("the server returned no media for an album file")
+}
+
+// albumResponse finds the ids of the sent messages in the order of the files
+func albumResponse(updatesClass tg.UpdatesClass, randomIDs []int64) *types.SendMessageResponse {
+	messageIDs := map[int64]int{}
+	response := &types.SendMessageResponse{}
+	for _, update := range updateList(updatesClass) {
+		var message tg.MessageClass
+		switch u := update.(type) {
+		case *tg.UpdateMessageID:
+			messageIDs[u.RandomID] = u.ID
+		case *tg.UpdateNewMessage:
+			message = u.Message
+		case *tg.UpdateNewChannelMessage:
+			message = u.Message
+		}
+		if msg, ok := message.(*tg.Message); ok && msg.GroupedID != 0 {
+			response.GroupedID = msg.GroupedID
+		}
+	}
+	for _, randomID := range randomIDs {
+		if id, ok := messageIDs[randomID]; ok {
+			response.AlbumIDs = append(response.AlbumIDs, id)
+		}
+	}
+	if len(response.AlbumIDs) > 0 {
+		first := response.AlbumIDs[0]
+		response.MessageID = &first
+	}
+	return response
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// PressBotButton presses a callback or game button under a bot message, the bot's answer comes
+// back in the BotCallbackAnswerMsg
+func (c *Client) PressBotButton(ctx context.Context, req types.BotCallbackRequest) tea.Cmd {
+	return func() tea.Msg {
+		inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+		if err != nil {
+			return types.BotCallbackAnswerMsg{Request: req, Err: err}
+		}
+		request := &tg.MessagesGetBotCallbackAnswerRequest{
+			Peer:  inputPeer,
+			MsgID: req.MessageID,
+		}
+		if req.Game {
+			request.SetGame(true)
+		} else {
+			request.SetData(req.Data)
+		}
+		answer, err := c.GetAPI().MessagesGetBotCallbackAnswer(ctx, request)
+		if err != nil {
+			return types.BotCallbackAnswerMsg{Request: req, Err: types.NewTelegramError(types.ErrorCodeSendFailed, "the bot didn't answer", err)}
+		}
+		return types.BotCallbackAnswerMsg{
+			Request: req,
+			Message: answer.Message,
+			Alert:   answer.Alert,
+			URL:     answer.URL,
+		}
+	}
+}
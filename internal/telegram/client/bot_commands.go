@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// botCommandCache keeps the commands of every bot we asked for and the bots of every group, so
+// typing / doesn't fetch the full chat each time. a bot's entry is only trusted while its bot info
+// version in the peer cache stays the same, the update handler replaces it when the bot changes
+// its commands and drops a group's bot list when someone joins or leaves
+type botCommandCache struct {
+	mu    sync.Mutex
+	bots  map[int64]cachedBotCommands
+	chats map[string][]int64
+}
+
+type cachedBotCommands struct {
+	version  int
+	username string
+	commands []tg.BotCommand
+}
+
+func newBotCommandCache() *botCommandCache {
+	return &botCommandCache{
+		bots:  map[int64]cachedBotCommands{},
+		chats: map[string][]int64{},
+	}
+}
+
+func (bc *botCommandCache) setBot(botID int64, entry cachedBotCommands) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.bots[botID] = entry
+}
+
+// setCommands replaces the commands of a bot we already know, the version and username stay
+func (bc *botCommandCache) setCommands(botID int64, commands []tg.BotCommand) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if entry, ok := bc.bots[botID]; ok {
+		entry.commands = commands
+		bc.bots[botID] = entry
+	}
+}
+
+func (bc *botCommandCache) setChatBots(peerID string, botIDs []int64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.chats[peerID] = botIDs
+}
+
+// forgetChat drops the bot list of a group whose members changed, the next / fetches it again
+func (bc *botCommandCache) forgetChat(peerID int64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	delete(bc.chats, strconv.FormatInt(peerID, 10))
+}
+
+// lookup returns the commands of the bots, ok is false when one of them is missing or outdated
+func (bc *botCommandCache) lookup(peers *peerCache, botIDs []int64) ([]types.BotCommand, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	var commands []types.BotCommand
+	for _, botID := range botIDs {
+		entry, ok := bc.bots[botID]
+		if !ok {
+			return nil, false
+		}
+		if user, known := peers.User(botID); known && user.BotInfoVersion != entry.version {
+			return nil, false
+		}
+		commands = append(commands, convertBotCommands(botID, entry)...)
+	}
+	return commands, true
+}
+
+func (bc *botCommandCache) chatBots(peerID string) ([]int64, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	botIDs, ok := bc.chats[peerID]
+	return botIDs, ok
+}
+
+func convertBotCommands(botID int64, entry cachedBotCommands) []types.BotCommand {
+	commands := make([]types.BotCommand, 0, len(entry.commands))
+	for _, command := range entry.commands {
+		commands = append(commands, types.BotCommand{
+			Command:     command.Command,
+			Description: command.Description,
+			BotID:       strconv.FormatInt(botID, 10),
+			BotUsername: entry.username,
+		})
+	}
+	return commands
+}
+
+// GetBotCommands gets the commands of the bot of a bot chat or of every bot in a group
+func (c *Client) GetBotCommands(ctx context.Context, req types.BotCommandsRequest) tea.Cmd {
+	return func() tea.Msg {
+		commands, err := c.botCommands(ctx, req.Peer)
+		if err != nil {
+			return types.BotCommandsMsg{Request: req, Err: types.NewTelegramError(types.ErrorCodeGetMessagesFailed, "failed to get the bot commands", err)}
+		}
+		return types.BotCommandsMsg{Request: req, Commands: commands}
+	}
+}
+
+func (c *Client) botCommands(ctx context.Context, peer types.Peer) ([]types.BotCommand, error) {
+	peerID, err := strconv.ParseInt(peer.ID, 10, 64)
+	if err != nil {
+		return nil, types.NewInvalidPeerError(peer.ID)
+	}
+	if peer.ChatType == types.UserChat || peer.ChatType == types.BotChat {
+		if commands, ok := c.botCommandCache.lookup(c.peers, []int64{peerID}); ok {
+			return commands, nil
+		}
+		accessHash, err := strconv.ParseInt(peer.AccessHash, 10, 64)
+		if err != nil {
+			return nil, types.NewInvalidPeerError(peer.AccessHash)
+		}
+		full, err := c.GetAPI().UsersGetFullUser(ctx, &tg.InputUser{UserID: peerID, AccessHash: accessHash})
+		if err != nil {
+			return nil, err
+		}
+		c.peers.AddUsers(full.Users...)
+		botInfo, _ := full.FullUser.GetBotInfo()
+		c.cacheBot(peerID, botInfo.Commands)
+		commands, _ := c.botCommandCache.lookup(c.peers, []int64{peerID})
+		return commands, nil
+	}
+
+	if botIDs, ok := c.botCommandCache.chatBots(peer.ID); ok {
+		if commands, ok := c.botCommandCache.lookup(c.peers, botIDs); ok {
+			return commands, nil
+		}
+	}
+	var full *tg.MessagesChatFull
+	if peer.ChatType == types.GroupChat && peer.AccessHash == "" {
+		full, err = c.GetAPI().MessagesGetFullChat(ctx, peerID)
+	} else {
+		accessHash, parseErr := strconv.ParseInt(peer.AccessHash, 10, 64)
+		if parseErr != nil {
+			return nil, types.NewInvalidPeerError(peer.AccessHash)
+		}
+		full, err = c.GetAPI().ChannelsGetFullChannel(ctx, &tg.InputChannel{ChannelID: peerID, AccessHash: accessHash})
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.peers.AddUsers(full.Users...)
+	c.peers.AddChats(full.Chats...)
+	var botInfos []tg.BotInfo
+	switch chat := full.FullChat.(type) {
+	case *tg.ChatFull:
+		botInfos = chat.BotInfo
+	case *tg.ChannelFull:
+		botInfos = chat.BotInfo
+	}
+	botIDs := make([]int64, 0, len(botInfos))
+	for _, botInfo := range botInfos {
+		botID, ok := botInfo.GetUserID()
+		if !ok || slices.Contains(botIDs, botID) {
+			continue
+		}
+		c.cacheBot(botID, botInfo.Commands)
+		botIDs = append(botIDs, botID)
+	}
+	c.botCommandCache.setChatBots(peer.ID, botIDs)
+	commands, _ := c.botCommandCache.lookup(c.peers, botIDs)
+	return commands, nil
+}
+
+// cacheBot keeps the commands with the version and username the peer cache has for the bot
+func (c *Client) cacheBot(botID int64, commands []tg.BotCommand) {
+	entry := cachedBotCommands{commands: commands}
+	if user, ok := c.peers.User(botID); ok {
+		entry.version = user.BotInfoVersion
+		entry.username = user.Username
+	}
+	c.botCommandCache.setBot(botID, entry)
+}
@@ -0,0 +1,382 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// how many messages we keep on disk for a single chat
+const maxCachedMessagesPerChat = 500
+
+// localCache keeps dialogs, message history and user/channel entities of one
+// account on disk (~/.cligram/<account>/cache) so chats render before the
+// server answers and stay readable while we are offline.
+//
+// everything is plain json except the few tg types that hold interfaces
+// (reactions, web page previews, notify settings); those are stored in their
+// TL binary form because encoding/json can't decode interface fields.
+type localCache struct {
+	dir string
+	mu  sync.Mutex
+	// entities.json is read once and kept here, nil until first used
+	entities *cachedEntities
+}
+
+type cachedUser struct {
+	types.UserInfo
+	NotifySettingsTL []byte `json:"notifySettingsTL,omitempty"`
+}
+
+type cachedChannel struct {
+	types.ChannelInfo
+	NotifySettingsTL []byte `json:"notifySettingsTL,omitempty"`
+}
+
+type cachedDialogs struct {
+	Users      []cachedUser    `json:"users"`
+	Channels   []cachedChannel `json:"channels"`
+	Groups     []cachedChannel `json:"groups"`
+	OffsetDate int             `json:"offsetDate"`
+	OffsetID   int             `json:"offsetId"`
+	SavedAt    time.Time       `json:"savedAt"`
+}
+
+type cachedMessage struct {
+	types.FormattedMessage
+	ReactionsTL []byte `json:"reactionsTL,omitempty"`
+	WebPageTL   []byte `json:"webPageTL,omitempty"`
+}
+
+type cachedEntities struct {
+	Users    map[string]cachedUser    `json:"users"`
+	Channels map[string]cachedChannel `json:"channels"`
+}
+
+func newLocalCache(account string) (*localCache, error) {
+	dir, err := accountDir(account)
+	if err != nil {
+		return nil, err
+	}
+	cacheDir := filepath.Join(dir, "cache")
+	if err := ensureDir(filepath.Join(cacheDir, "messages")); err != nil {
+		return nil, err
+	}
+	return &localCache{dir: cacheDir}, nil
+}
+
+func (lc *localCache) SaveDialogs(chats types.GetAllChatsResponse) error {
+	if lc == nil {
+		return nil
+	}
+	dialogs := cachedDialogs{
+		OffsetDate: chats.OffsetDate,
+		OffsetID:   chats.OffsetID,
+		SavedAt:    time.Now(),
+	}
+	for _, u := range chats.PrivateChats {
+		dialogs.Users = append(dialogs.Users, toCachedUser(u))
+	}
+	for _, c := range chats.Channels {
+		dialogs.Channels = append(dialogs.Channels, toCachedChannel(c))
+	}
+	for _, g := range chats.Groups {
+		dialogs.Groups = append(dialogs.Groups, toCachedChannel(g))
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.writeJSON("dialogs.json", dialogs)
+}
+
+// LoadDialogs returns the chat list saved by the last successful GetAllChats,
+// found is false when nothing was cached yet
+func (lc *localCache) LoadDialogs() (chats types.GetAllChatsResponse, found bool, err error) {
+	if lc == nil {
+		return chats, false, nil
+	}
+	var dialogs cachedDialogs
+
+	lc.mu.Lock()
+	found, err = lc.readJSON("dialogs.json", &dialogs)
+	lc.mu.Unlock()
+	if err != nil || !found {
+		return chats, false, err
+	}
+
+	chats.PrivateChats = []types.UserInfo{}
+	chats.Channels = []types.ChannelInfo{}
+	chats.Groups = []types.ChannelInfo{}
+	for _, u := range dialogs.Users {
+		chats.PrivateChats = append(chats.PrivateChats, u.toUserInfo())
+	}
+	for _, c := range dialogs.Channels {
+		chats.Channels = append(chats.Channels, c.toChannelInfo())
+	}
+	for _, g := range dialogs.Groups {
+		chats.Groups = append(chats.Groups, g.toChannelInfo())
+	}
+	chats.OffsetDate = dialogs.OffsetDate
+	chats.OffsetID = dialogs.OffsetID
+	return chats, true, nil
+}
+
+// SaveMessages merges a page of history into what we already have for the chat.
+// messages are kept sorted oldest first and trimmed to maxCachedMessagesPerChat
+func (lc *localCache) SaveMessages(peer types.Peer, topMsgID *int, messages []types.FormattedMessage) error {
+	if lc == nil || len(messages) == 0 {
+		return nil
+	}
+	name := messagesFileName(peer, topMsgID)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	var rows []cachedMessage
+	if _, err := lc.readJSON(name, &rows); err != nil {
+		// a broken file shouldn't block us from writing a fresh one
+		rows = nil
+	}
+
+	byID := make(map[int]cachedMessage, len(rows)+len(messages))
+	for _, row := range rows {
+		byID[row.ID] = row
+	}
+	for _, msg := range messages {
+		if msg.ID == 0 {
+			continue
+		}
+		byID[msg.ID] = toCachedMessage(msg)
+	}
+
+	merged := make([]cachedMessage, 0, len(byID))
+	for _, row := range byID {
+		merged = append(merged, row)
+	}
+	slices.SortFunc(merged, func(a, b cachedMessage) int { return a.ID - b.ID })
+	if len(merged) > maxCachedMessagesPerChat {
+		merged = merged[len(merged)-maxCachedMessagesPerChat:]
+	}
+	return lc.writeJSON(name, merged)
+}
+
+// LoadMessages returns up to limit of the newest cached messages of the chat, oldest first
+func (lc *localCache) LoadMessages(peer types.Peer, topMsgID *int, limit int) ([]types.FormattedMessage, error) {
+	if lc == nil {
+		return nil, nil
+	}
+	var rows []cachedMessage
+
+	lc.mu.Lock()
+	found, err := lc.readJSON(messagesFileName(peer, topMsgID), &rows)
+	lc.mu.Unlock()
+	if err != nil || !found {
+		return nil, err
+	}
+
+	if limit > 0 && len(rows) > limit {
+		rows = rows[len(rows)-limit:]
+	}
+	messages := make([]types.FormattedMessage, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, row.toFormattedMessage())
+	}
+	return messages, nil
+}
+
+func (lc *localCache) SaveEntities(users []types.UserInfo, channels []types.ChannelInfo) error {
+	if lc == nil || (len(users) == 0 && len(channels) == 0) {
+		return nil
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	entities := lc.loadEntities()
+	for _, u := range users {
+		// unread counters belong to the dialog list, not to the entity
+		u.UnreadCount = 0
+		u.IsTyping = false
+		entities.Users[u.PeerID] = toCachedUser(u)
+	}
+	for _, c := range channels {
+		c.UnreadCount = 0
+		entities.Channels[c.ID] = toCachedChannel(c)
+	}
+	return lc.writeJSON("entities.json", entities)
+}
+
+func (lc *localCache) LoadUser(userID string) (*types.UserInfo, bool) {
+	if lc == nil {
+		return nil, false
+	}
+	lc.mu.Lock()
+	u, ok := lc.loadEntities().Users[userID]
+	lc.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	userInfo := u.toUserInfo()
+	return &userInfo, true
+}
+
+// loadEntities reads entities.json the first time it is needed, must be called with lc.mu held
+func (lc *localCache) loadEntities() *cachedEntities {
+	if lc.entities != nil {
+		return lc.entities
+	}
+	entities := &cachedEntities{}
+	if _, err := lc.readJSON("entities.json", entities); err != nil {
+		// a broken file shouldn't block us from writing a fresh one
+		entities = &cachedEntities{}
+	}
+	if entities.Users == nil {
+		entities.Users = map[string]cachedUser{}
+	}
+	if entities.Channels == nil {
+		entities.Channels = map[string]cachedChannel{}
+	}
+	lc.entities = entities
+	return entities
+}
+
+func (lc *localCache) writeJSON(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(lc.dir, name)
+	// write to a temp file first so a crash never leaves half a json file behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (lc *localCache) readJSON(name string, v any) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(lc.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func messagesFileName(peer types.Peer, topMsgID *int) string {
+	if topMsgID != nil {
+		return filepath.Join("messages", fmt.Sprintf("%s_%s_%d.json", peer.ChatType, peer.ID, *topMsgID))
+	}
+	return filepath.Join("messages", fmt.Sprintf("%s_%s.json", peer.ChatType, peer.ID))
+}
+
+func toCachedUser(u types.UserInfo) cachedUser {
+	row := cachedUser{UserInfo: u}
+	if u.NotifySettings != nil {
+		row.NotifySettingsTL = encodeTL(u.NotifySettings)
+		row.NotifySettings = nil
+	}
+	return row
+}
+
+func (u cachedUser) toUserInfo() types.UserInfo {
+	userInfo := u.UserInfo
+	if len(u.NotifySettingsTL) > 0 {
+		var settings tg.PeerNotifySettings
+		if decodeTL(u.NotifySettingsTL, &settings) == nil {
+			userInfo.NotifySettings = &settings
+		}
+	}
+	return userInfo
+}
+
+func toCachedChannel(c types.ChannelInfo) cachedChannel {
+	row := cachedChannel{ChannelInfo: c}
+	if c.NotifySettings != nil {
+		row.NotifySettingsTL = encodeTL(c.NotifySettings)
+		row.NotifySettings = nil
+	}
+	return row
+}
+
+func (c cachedChannel) toChannelInfo() types.ChannelInfo {
+	channelInfo := c.ChannelInfo
+	if len(c.NotifySettingsTL) > 0 {
+		var settings tg.PeerNotifySettings
+		if decodeTL(c.NotifySettingsTL, &settings) == nil {
+			channelInfo.NotifySettings = &settings
+		}
+	}
+	return channelInfo
+}
+
+func toCachedMessage(msg types.FormattedMessage) cachedMessage {
+	row := cachedMessage{FormattedMessage: msg}
+	if msg.Reactions != nil {
+		row.ReactionsTL = encodeTL(msg.Reactions)
+		row.Reactions = nil
+	}
+	if msg.MessageMediaWebPage != nil {
+		row.WebPageTL = encodeTL(msg.MessageMediaWebPage)
+		row.MessageMediaWebPage = nil
+	}
+	if row.SenderUserInfo != nil {
+		sender := *row.SenderUserInfo
+		sender.NotifySettings = nil
+		row.SenderUserInfo = &sender
+	}
+	if row.ReplyTo != nil {
+		// only the text of the replied message is ever rendered
+		reply := *row.ReplyTo
+		reply.Reactions = nil
+		reply.MessageMediaWebPage = nil
+		reply.SenderUserInfo = nil
+		reply.ReplyTo = nil
+		row.ReplyTo = &reply
+	}
+	return row
+}
+
+func (row cachedMessage) toFormattedMessage() types.FormattedMessage {
+	msg := row.FormattedMessage
+	if len(row.ReactionsTL) > 0 {
+		var reactions tg.MessageReactions
+		if decodeTL(row.ReactionsTL, &reactions) == nil {
+			msg.Reactions = &reactions
+		}
+	}
+	if len(row.WebPageTL) > 0 {
+		var webPage tg.MessageMediaWebPage
+		if decodeTL(row.WebPageTL, &webPage) == nil {
+			msg.MessageMediaWebPage = &webPage
+		}
+	}
+	return msg
+}
+
+func encodeTL(v bin.Encoder) []byte {
+	var b bin.Buffer
+	if err := v.Encode(&b); err != nil {
+		return nil
+	}
+	return b.Copy()
+}
+
+func decodeTL(data []byte, v bin.Decoder) error {
+	return v.Decode(&bin.Buffer{Buf: data})
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+func newTestCache(t *testing.T) *localCache {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ensureDir(filepath.Join(dir, "messages")); err != nil {
+		t.Fatal(err)
+	}
+	return &localCache{dir: dir}
+}
+
+func TestCachedMessageRoundTrip(t *testing.T) {
+	lc := newTestCache(t)
+	peer := types.Peer{ID: "42", ChatType: types.UserChat}
+	date := time.Unix(1700000000, 0).UTC()
+
+	msg := types.FormattedMessage{
+		ID:      7,
+		Sender:  "alice",
+		Content: "hello",
+		Date:    date,
+		Reactions: &tg.MessageReactions{
+			Results: []tg.ReactionCount{{Reaction: &tg.ReactionEmoji{Emoticon: "👍"}, Count: 3}},
+		},
+		MessageMediaWebPage: &tg.MessageMediaWebPage{
+			Webpage: &tg.WebPage{ID: 5, URL: "https://example.com", DisplayURL: "example.com"},
+		},
+		SenderUserInfo: &types.UserInfo{
+			FirstName:      "alice",
+			PeerID:         "1",
+			NotifySettings: &tg.PeerNotifySettings{Silent: true},
+		},
+		ReplyTo: &types.FormattedMessage{
+			ID:        3,
+			Content:   "earlier",
+			Reactions: &tg.MessageReactions{},
+			ReplyTo:   &types.FormattedMessage{ID: 1},
+		},
+		Entities: []types.MessageEntity{{Type: types.EntityBold, Offset: 0, Length: 5}},
+	}
+	if err := lc.SaveMessages(peer, nil, []types.FormattedMessage{msg}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := lc.LoadMessages(peer, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("loaded %d messages, want 1", len(loaded))
+	}
+	got := loaded[0]
+	if got.ID != 7 || got.Content != "hello" || !got.Date.Equal(date) {
+		t.Errorf("got id %d content %q date %v", got.ID, got.Content, got.Date)
+	}
+	if got.Reactions == nil || len(got.Reactions.Results) != 1 || got.Reactions.Results[0].Count != 3 {
+		t.Fatalf("reactions not restored: %+v", got.Reactions)
+	}
+	if emoji, ok := got.Reactions.Results[0].Reaction.(*tg.ReactionEmoji); !ok || emoji.Emoticon != "👍" {
+		t.Errorf("reaction = %+v, want 👍", got.Reactions.Results[0].Reaction)
+	}
+	if got.MessageMediaWebPage == nil {
+		t.Fatal("web page not restored")
+	}
+	if page, ok := got.MessageMediaWebPage.Webpage.(*tg.WebPage); !ok || page.URL != "https://example.com" {
+		t.Errorf("web page = %+v", got.MessageMediaWebPage.Webpage)
+	}
+	if got.SenderUserInfo == nil || got.SenderUserInfo.FirstName != "alice" || got.SenderUserInfo.NotifySettings != nil {
+		t.Errorf("sender = %+v, want alice without notify settings", got.SenderUserInfo)
+	}
+	if got.ReplyTo == nil || got.ReplyTo.Content != "earlier" || got.ReplyTo.Reactions != nil || got.ReplyTo.ReplyTo != nil {
+		t.Errorf("reply = %+v, want only its text", got.ReplyTo)
+	}
+	if len(got.Entities) != 1 || got.Entities[0].Type != types.EntityBold {
+		t.Errorf("entities = %+v", got.Entities)
+	}
+	// the original must not lose what was moved into the TL fields
+	if msg.Reactions == nil || msg.SenderUserInfo.NotifySettings == nil {
+		t.Error("saving modified the message it was given")
+	}
+}
+
+func TestSaveMessagesMergesAndTrims(t *testing.T) {
+	lc := newTestCache(t)
+	peer := types.Peer{ID: "42", ChatType: types.GroupChat}
+	topic := 9
+
+	var first []types.FormattedMessage
+	for id := maxCachedMessagesPerChat + 10; id > 10; id-- {
+		first = append(first, types.FormattedMessage{ID: id, Content: "old"})
+	}
+	if err := lc.SaveMessages(peer, &topic, first); err != nil {
+		t.Fatal(err)
+	}
+	second := []types.FormattedMessage{
+		{ID: maxCachedMessagesPerChat + 10, Content: "edited"},
+		{ID: maxCachedMessagesPerChat + 11, Content: "new"},
+		{ID: 0, Content: "not sent yet"},
+	}
+	if err := lc.SaveMessages(peer, &topic, second); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := lc.LoadMessages(peer, &topic, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != maxCachedMessagesPerChat {
+		t.Fatalf("kept %d messages, want %d", len(all), maxCachedMessagesPerChat)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].ID >= all[i].ID {
+			t.Fatalf("messages out of order at %d: %d then %d", i, all[i-1].ID, all[i].ID)
+		}
+	}
+	last := all[len(all)-1]
+	if last.ID != maxCachedMessagesPerChat+11 || last.Content != "new" {
+		t.Errorf("newest = %d %q", last.ID, last.Content)
+	}
+	if edited := all[len(all)-2]; edited.Content != "edited" {
+		t.Errorf("edited message = %q, want the later copy", edited.Content)
+	}
+
+	page, err := lc.LoadMessages(peer, &topic, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[1].ID != last.ID {
+		t.Errorf("limited page = %+v, want the 2 newest", page)
+	}
+
+	// the topic has its own file, the chat itself has nothing cached
+	if rest, err := lc.LoadMessages(peer, nil, 0); err != nil || len(rest) != 0 {
+		t.Errorf("chat without topic = %d messages, err %v", len(rest), err)
+	}
+}
+
+func TestDialogsRoundTrip(t *testing.T) {
+	lc := newTestCache(t)
+	if _, found, err := lc.LoadDialogs(); found || err != nil {
+		t.Fatalf("empty cache found %v err %v", found, err)
+	}
+
+	chats := types.GetAllChatsResponse{
+		PrivateChats: []types.UserInfo{{FirstName: "bob", PeerID: "2", NotifySettings: &tg.PeerNotifySettings{MuteUntil: 100}}},
+		Channels:     []types.ChannelInfo{{ChannelTitle: "news", ID: "3", IsBroadcast: true}},
+		Groups:       []types.ChannelInfo{{ChannelTitle: "friends", ID: "4", NotifySettings: &tg.PeerNotifySettings{Silent: true}}},
+		OffsetDate:   1700000000,
+		OffsetID:     55,
+	}
+	if err := lc.SaveDialogs(chats); err != nil {
+		t.Fatal(err)
+	}
+	got, found, err := lc.LoadDialogs()
+	if err != nil || !found {
+		t.Fatalf("found %v err %v", found, err)
+	}
+	if len(got.PrivateChats) != 1 || got.PrivateChats[0].FirstName != "bob" {
+		t.Fatalf("private chats = %+v", got.PrivateChats)
+	}
+	if settings := got.PrivateChats[0].NotifySettings; settings == nil || settings.MuteUntil != 100 {
+		t.Errorf("user notify settings = %+v", settings)
+	}
+	if len(got.Channels) != 1 || !got.Channels[0].IsBroadcast || got.Channels[0].NotifySettings != nil {
+		t.Errorf("channels = %+v", got.Channels)
+	}
+	if len(got.Groups) != 1 || got.Groups[0].NotifySettings == nil || !got.Groups[0].NotifySettings.Silent {
+		t.Errorf("groups = %+v", got.Groups)
+	}
+	if got.OffsetDate != chats.OffsetDate || got.OffsetID != chats.OffsetID {
+		t.Errorf("offsets = %d/%d", got.OffsetDate, got.OffsetID)
+	}
+}
+
+func TestEntitiesLoadedOnce(t *testing.T) {
+	lc := newTestCache(t)
+	users := []types.UserInfo{{FirstName: "carol", PeerID: "5", UnreadCount: 4, IsTyping: true}}
+	channels := []types.ChannelInfo{{ChannelTitle: "news", ID: "6", UnreadCount: 2}}
+	if err := lc.SaveEntities(users, channels); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh cache reads what the first one wrote
+	reopened := &localCache{dir: lc.dir}
+	user, ok := reopened.LoadUser("5")
+	if !ok {
+		t.Fatal("user not found after reopening")
+	}
+	if user.FirstName != "carol" || user.UnreadCount != 0 || user.IsTyping {
+		t.Errorf("user = %+v, want carol without dialog state", user)
+	}
+	if _, ok := reopened.LoadUser("7"); ok {
+		t.Error("found a user that was never saved")
+	}
+
+	// later lookups are answered from memory
+	if err := os.Remove(filepath.Join(lc.dir, "entities.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.LoadUser("5"); !ok {
+		t.Error("user lost once the file was gone, entities were read again")
+	}
+
+	// saving keeps the entities already there
+	if err := reopened.SaveEntities([]types.UserInfo{{FirstName: "dave", PeerID: "8"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	again := &localCache{dir: lc.dir}
+	for _, id := range []string{"5", "8"} {
+		if _, ok := again.LoadUser(id); !ok {
+			t.Errorf("user %s missing after the second save", id)
+		}
+	}
+}
@@ -3,20 +3,21 @@ package client
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	mathRand "math/rand"
-	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/query"
 	"github.com/gotd/td/telegram/query/dialogs"
-	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/telegram/updates"
 	"github.com/gotd/td/tg"
 	"golang.org/x/time/rate"
 
@@ -32,6 +33,19 @@ type Client struct {
 	*telegram.Client
 	ctx           context.Context
 	updateChannel chan types.Notification
+	notifications *notificationQueue
+	connection    *connectionTracker
+	cache         *localCache
+	peers         *peerCache
+	updates       *updates.Manager
+	outbox        *outbox
+	uploads       runningUploads
+	// the commands bots registered, shown when typing / in a chat with bots
+	botCommandCache *botCommandCache
+	// usernames typed as inline queries that aren't inline bots
+	notInlineBots *usernameSet
+	// where finished downloads were saved
+	downloads *downloadRecord
 }
 
 type Config struct {
@@ -48,7 +62,37 @@ func NewClient(ctx context.Context, config Config, account string) (*Client, err
 		return nil, types.NewTelegramError(types.ErrorCodeSessionFailed, "failed to create session storage", err)
 	}
 
-	updateHandler := newUpdateHandler(config.UpdateChannel)
+	cache, err := newLocalCache(account)
+	if err != nil {
+		// we can live without the cache, everything just goes over the network
+		slog.Error("failed to open local cache", "error", err)
+	}
+
+	updateStorage, err := newFileUpdateStorage(account)
+	if err != nil {
+		// updates still arrive, we just can't catch up on the ones missed while closed
+		slog.Error("failed to open update state storage", "error", err)
+		updateStorage = nil
+	}
+
+	outbox, err := newOutbox(account)
+	if err != nil {
+		slog.Error("failed to open outbox", "error", err)
+		outbox = newMemoryOutbox()
+	}
+
+	downloads, err := newDownloadRecord(account)
+	if err != nil {
+		slog.Error("failed to open download record", "error", err)
+		downloads = newMemoryDownloadRecord()
+	}
+
+	peers := newPeerCache()
+	botCommands := newBotCommandCache()
+	notifications := newNotificationQueue(config.UpdateChannel)
+	go notifications.run(ctx)
+	connection := newConnectionTracker(notifications)
+	updateHandler := newUpdateHandler(notifications, updateStorage, peers, botCommands)
 
 	waiter := floodwait.NewSimpleWaiter()
 
@@ -60,20 +104,78 @@ func NewClient(ctx context.Context, config Config, account string) (*Client, err
 		SessionStorage: sessionStorage,
 		UpdateHandler:  updateHandler,
 		NoUpdates:      false,
+		// gotd reconnects by itself after OnDead, OnSelfSuccess tells us the new session works
 		OnDead: func(err error) {
-			slog.Error("telegram connection is dead", "error", err)
+			connection.Set(types.ConnectionReconnecting, err)
+		},
+		OnSelfSuccess: func(self *tg.User) {
+			connection.Set(types.ConnectionConnected, nil)
 		},
 	}
 
 	Cligram = telegram.NewClient(config.AppID, config.AppHash, options)
 
 	return &Client{
-		Client:        Cligram,
-		ctx:           ctx,
-		updateChannel: config.UpdateChannel,
+		Client:          Cligram,
+		ctx:             ctx,
+		updateChannel:   config.UpdateChannel,
+		notifications:   notifications,
+		connection:      connection,
+		cache:           cache,
+		peers:           peers,
+		updates:         updateHandler,
+		outbox:          outbox,
+		botCommandCache: botCommands,
+		notInlineBots:   newUsernameSet(),
+		downloads:       downloads,
 	}, nil
 }
 
+// Run wraps telegram.Client.Run to keep the connection state current. f runs once the
+// client is ready, if the client hits a fatal error while f is still running (the ui)
+// the state turns dead so the user knows nothing will be sent anymore
+func (c *Client) Run(ctx context.Context, f func(ctx context.Context) error) error {
+	c.connection.Set(types.ConnectionConnecting, nil)
+	err := c.Client.Run(ctx, func(runCtx context.Context) error {
+		c.connection.Set(types.ConnectionConnected, nil)
+		stop := context.AfterFunc(runCtx, func() {
+			if ctx.Err() == nil {
+				c.connection.Set(types.ConnectionDead, context.Cause(runCtx))
+			}
+		})
+		defer stop()
+		go c.retryOutbox(runCtx)
+		return f(runCtx)
+	})
+	if err != nil && ctx.Err() == nil {
+		c.connection.Set(types.ConnectionDead, err)
+	}
+	return err
+}
+
+// ConnectionStatus returns the current connection state, later changes arrive as notifications
+func (c *Client) ConnectionStatus() types.ConnectionStateNotification {
+	return c.connection.Status()
+}
+
+// DeliveryStats reports how many notifications were queued, delivered to the ui and coalesced
+func (c *Client) DeliveryStats() DeliveryStats {
+	return c.notifications.Stats()
+}
+
+// RunUpdates starts the updates manager for the logged in user and blocks until ctx is done.
+// on startup it compares the saved pts/qts/seq with the server and fetches everything we
+// missed while cligram was closed, those updates go through the same handlers as live ones
+func (c *Client) RunUpdates(ctx context.Context) error {
+	self, err := c.Self(ctx)
+	if err != nil {
+		return types.NewTelegramError(types.ErrorCodeSessionFailed, "failed to get current user", err)
+	}
+	return c.updates.Run(ctx, c.API(), self.ID, updates.AuthOptions{
+		IsBot: self.Bot,
+	})
+}
+
 func NewClientFromEnv(ctx context.Context, updateChannel chan types.Notification, telegramAPIID, telegramAPIHash, account string) (*Client, error) {
 	appID, err := strconv.Atoi(telegramAPIID)
 	if err != nil {
@@ -137,12 +239,15 @@ func (c *Client) GetEntityInfo(entity *types.EntityPreviewInfo) tea.Cmd {
 	}
 }
 
+// SendMessage puts the message in the outbox first, so it is retried after a failure
+// and still there after a restart if it never made it out
 func (c *Client) SendMessage(ctx context.Context, req types.SendMessageRequest) tea.Cmd {
-	if req.IsFile {
-		// even when not replying to a specific message
-		return c.sendMedia(ctx, req.Peer, req.FilePath, req.Message, parseReplyID(req.ReplyToMessageID), req.RandID, req.TopMsgID)
+	if err := c.outbox.Add(req); err != nil {
+		slog.Error("failed to save message to outbox", "error", err)
+	}
+	return func() tea.Msg {
+		return c.sendOutboxEntry(ctx, req)
 	}
-	return c.sendText(ctx, req.Peer, req.Message, parseReplyID(req.ReplyToMessageID), req.RandID, req.TopMsgID)
 }
 
 func (c *Client) sendText(ctx context.Context, peer types.Peer, text string, replyTo *int, randID int, topMsgID *int) tea.Cmd {
@@ -152,23 +257,14 @@ func (c *Client) sendText(ctx context.Context, peer types.Peer, text string, rep
 			return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: randID}
 		}
 
-		var replyToClass tg.InputReplyToClass
-		if replyTo != nil {
-			reply := &tg.InputReplyToMessage{ReplyToMsgID: *replyTo}
-			if topMsgID != nil && *topMsgID != 1 && *replyTo != *topMsgID {
-				reply.TopMsgID = *topMsgID
-				reply.SetFlags()
-			}
-			replyToClass = reply
-		} else if topMsgID != nil {
-			replyToClass = &tg.InputReplyToMessage{ReplyToMsgID: *topMsgID}
-		}
-
+		message, entities := shared.ParseMarkup(text)
+		// the random id is stable across retries, the server drops a duplicate if an earlier attempt got through
 		updateClass, err := c.GetAPI().MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
 			Peer:     inputPeer,
-			ReplyTo:  replyToClass,
-			Message:  text,
-			RandomID: mathRand.Int63(),
+			ReplyTo:  inputReplyTo(replyTo, topMsgID),
+			Message:  message,
+			Entities: shared.ToTGEntities(entities),
+			RandomID: int64(randID),
 		})
 		if err != nil {
 			return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: randID}
@@ -179,6 +275,23 @@ func (c *Client) sendText(ctx context.Context, peer types.Peer, text string, rep
 	}
 }
 
+// inputReplyTo is where a message goes in a thread: the message it replies to, or the forum
+// topic it is posted in
+func inputReplyTo(replyTo *int, topMsgID *int) tg.InputReplyToClass {
+	if replyTo != nil {
+		reply := &tg.InputReplyToMessage{ReplyToMsgID: *replyTo}
+		if topMsgID != nil && *topMsgID != 1 && *replyTo != *topMsgID {
+			reply.TopMsgID = *topMsgID
+			reply.SetFlags()
+		}
+		return reply
+	}
+	if topMsgID != nil {
+		return &tg.InputReplyToMessage{ReplyToMsgID: *topMsgID}
+	}
+	return nil
+}
+
 func extractMessageID(updateClass tg.UpdatesClass) *int {
 	var id *int
 	switch u := updateClass.(type) {
@@ -224,23 +337,35 @@ func extractMessageID(updateClass tg.UpdatesClass) *int {
 	return id
 }
 
-func (c *Client) sendMedia(ctx context.Context, peer types.Peer, filePath string, caption string, replyTo *int, randID int, topMsgID *int) tea.Cmd {
+func (c *Client) sendMedia(ctx context.Context, req types.SendMessageRequest) tea.Cmd {
 	return func() tea.Msg {
-		inputPeer, err := shared.ConvertPeerToInputPeer(peer)
+		inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
 		if err != nil {
-			return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: randID}
+			return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: req.RandID}
 		}
 
-		messageID, err := c.sendMediaFile(ctx, filePath, caption, inputPeer, replyTo, topMsgID)
+		// the whole send can be cancelled from the ui while the file goes up
+		ctx, done := c.uploads.start(ctx, req.RandID)
+		defer done()
+		if len(req.FilePaths) > 0 {
+			response, err := c.sendAlbum(ctx, req, inputPeer)
+			if err != nil {
+				return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: req.RandID}
+			}
+			return types.SendMessageMsg{Response: response, RandID: req.RandID}
+		}
+		messageID, err := c.sendMediaFile(ctx, req, inputPeer)
 		if err != nil {
-			return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: randID}
+			return types.SendMessageMsg{Err: types.NewSendMessageError(err), RandID: req.RandID}
 		}
 
-		return types.SendMessageMsg{Response: &types.SendMessageResponse{MessageID: messageID}, RandID: randID}
+		return types.SendMessageMsg{Response: &types.SendMessageResponse{MessageID: messageID}, RandID: req.RandID}
 	}
 }
 
-func (c *Client) sendMediaFile(ctx context.Context, path string, caption string, peer tg.InputPeerClass, replyTo *int, topMsgID *int) (*int, error) {
+func (c *Client) sendMediaFile(ctx context.Context, req types.SendMessageRequest, peer tg.InputPeerClass) (*int, error) {
+	path, caption, randID, topMsgID := req.FilePath, req.Message, req.RandID, req.TopMsgID
+	replyTo := parseReplyID(req.ReplyToMessageID)
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		return nil, err
@@ -256,45 +381,25 @@ func (c *Client) sendMediaFile(ctx context.Context, path string, caption string,
 	}
 	defer file.Close()
 
-	upload := uploader.NewUpload(filepath.Base(path), file, fileInfo.Size())
-	fileUpload, err := uploader.NewUploader(c.GetAPI()).Upload(ctx, upload)
+	progress := &uploadProgress{client: c, req: req, peer: peer, fileName: filepath.Base(path)}
+	fileUpload, err := c.uploadFile(ctx, file, fileInfo.Size(), progress)
 	if err != nil {
-		return nil, types.NewTelegramError(types.ErrorCodeUploadFailed, "failed to upload file", err)
-	}
-
-	if _, err := file.Seek(0, 0); err != nil {
 		return nil, err
 	}
-	buffer := make([]byte, 512)
-	n, _ := file.Read(buffer)
-	mimeType := http.DetectContentType(buffer[:n])
 
-	attributes := []tg.DocumentAttributeClass{
-		&tg.DocumentAttributeFilename{FileName: filepath.Base(path)},
-	}
-
-	var replyToClass tg.InputReplyToClass
-	if replyTo != nil {
-		reply := &tg.InputReplyToMessage{ReplyToMsgID: *replyTo}
-		if topMsgID != nil && *topMsgID != 1 && *replyTo != *topMsgID {
-			reply.TopMsgID = *topMsgID
-			reply.SetFlags()
-		}
-		replyToClass = reply
-	} else if topMsgID != nil {
-		replyToClass = &tg.InputReplyToMessage{ReplyToMsgID: *topMsgID}
+	media, err := c.uploadedMedia(ctx, req, file, fileInfo.Size(), fileUpload)
+	if err != nil {
+		return nil, err
 	}
 
+	caption, entities := shared.ParseMarkup(caption)
 	sendMediaUpdateClass, err := c.GetAPI().MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
-		Peer: peer,
-		Media: &tg.InputMediaUploadedDocument{
-			File:       fileUpload,
-			MimeType:   mimeType,
-			Attributes: attributes,
-		},
+		Peer:     peer,
+		Media:    media,
 		Message:  caption,
-		RandomID: mathRand.Int63(),
-		ReplyTo:  replyToClass,
+		Entities: shared.ToTGEntities(entities),
+		RandomID: int64(randID),
+		ReplyTo:  inputReplyTo(replyTo, topMsgID),
 	})
 	if err != nil {
 		return nil, err
@@ -304,7 +409,11 @@ func (c *Client) sendMediaFile(ctx context.Context, path string, caption string,
 }
 
 func (c *Client) GetMessages(ctx context.Context, req types.GetMessagesRequest) tea.Cmd {
-	return c.GetChatHistoryCmd(ctx, req.Peer, req.Limit, req.OffsetID, req.TopMsgID)
+	// an offset without a direction is a jump to that message, like for t.me links
+	if req.Direction == types.HistoryLatest && (req.OffsetID != nil || req.OffsetDate != 0) {
+		req.Direction = types.HistoryAround
+	}
+	return c.GetChatHistoryCmd(ctx, req)
 }
 
 func (c *Client) GetUserChats(ctx context.Context, chatType types.ChatType, offsetDate, offsetID int) tea.Cmd {
@@ -315,43 +424,120 @@ func (c *Client) GetUserChannels(ctx context.Context, isBroadCast bool, offsetDa
 	return c.GetChannelsCmd(ctx, isBroadCast, offsetDate, offsetID)
 }
 
-func (c *Client) GetChatHistoryCmd(ctx context.Context, peer types.Peer, limit int, offsetID *int, topMsgID *int) tea.Cmd {
+func (c *Client) GetChatHistoryCmd(ctx context.Context, req types.GetMessagesRequest) tea.Cmd {
 	return func() tea.Msg {
-		messages, err := c.GetChatHistory(ctx, peer, limit, offsetID, topMsgID)
+		msg := types.GetMessagesMsg{Peer: req.Peer, TopMsgID: req.TopMsgID, Direction: req.Direction}
+		messages, err := c.GetChatHistory(ctx, req)
 		if err != nil {
-			return types.GetMessagesMsg{Messages: [50]types.FormattedMessage{}, Err: err}
+			msg.Err = err
+			return msg
+		}
+		msg.Messages = messages
+		msg.HasMore = len(messages) >= req.Limit
+		return msg
+	}
+}
+
+// GetCachedChatHistoryCmd loads the newest messages we have on disk for the chat.
+// it returns a nil msg when nothing is cached so the ui just waits for the server
+func (c *Client) GetCachedChatHistoryCmd(peer types.Peer, limit int, topMsgID *int) tea.Cmd {
+	return func() tea.Msg {
+		messages, err := c.cache.LoadMessages(peer, topMsgID, limit)
+		if err != nil {
+			slog.Warn("failed to read cached messages", "peer", peer.ID, "error", err)
+			return nil
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		return types.GetMessagesMsg{
+			Messages:  messages[max(0, len(messages)-limit):],
+			FromCache: true,
+			Peer:      peer,
+			TopMsgID:  topMsgID,
+			HasMore:   true,
 		}
-		var msgArray [50]types.FormattedMessage
-		copy(msgArray[:], messages)
-		return types.GetMessagesMsg{Messages: msgArray}
 	}
 }
 
-func (c *Client) GetChatHistory(ctx context.Context, peer types.Peer, limit int, offsetID *int, topMsgID *int) ([]types.FormattedMessage, error) {
+// GetChatHistory fetches a page of history from the server and keeps the latest page in the
+// local cache. when the server can't be reached we fall back to whatever is cached
+func (c *Client) GetChatHistory(ctx context.Context, req types.GetMessagesRequest) ([]types.FormattedMessage, error) {
+	if req.Direction != types.HistoryLatest && req.OffsetID == nil && req.OffsetDate == 0 {
+		return nil, types.NewTelegramError(types.ErrorCodeGetMessagesFailed, "paging history needs an offset", nil)
+	}
+	messages, err := c.fetchChatHistory(ctx, req)
+	if err != nil {
+		if req.Direction != types.HistoryLatest {
+			return nil, err
+		}
+		cached, cacheErr := c.cache.LoadMessages(req.Peer, req.TopMsgID, req.Limit)
+		if cacheErr != nil || len(cached) == 0 {
+			return nil, err
+		}
+		slog.Warn("failed to fetch history, using cached messages", "peer", req.Peer.ID, "error", err)
+		return cached, nil
+	}
+
+	if req.Direction == types.HistoryLatest {
+		if err := c.cache.SaveMessages(req.Peer, req.TopMsgID, messages); err != nil {
+			slog.Warn("failed to cache messages", "peer", req.Peer.ID, "error", err)
+		}
+	}
+	return messages, nil
+}
+
+type historyOffset struct {
+	id, date, add int
+}
+
+// historyOffsets turns a direction into the offset_id/offset_date/add_offset triple both
+// messages.getHistory and messages.getReplies expect
+func historyOffsets(req types.GetMessagesRequest) historyOffset {
+	switch {
+	case req.Direction == types.HistoryAround && req.OffsetID != nil:
+		return historyOffset{id: *req.OffsetID, add: -(req.Limit / 2)}
+	case req.Direction == types.HistoryAround && req.OffsetDate != 0:
+		// offset_date returns what was sent before it, step back half a page to see both sides
+		return historyOffset{date: req.OffsetDate, add: -(req.Limit / 2)}
+	case req.Direction == types.HistoryOlder && req.OffsetID != nil:
+		return historyOffset{id: *req.OffsetID}
+	case req.Direction == types.HistoryNewer && req.OffsetID != nil:
+		// offset_id itself is exclusive, start one past it and walk back a whole page
+		return historyOffset{id: *req.OffsetID + 1, add: -req.Limit}
+	default:
+		return historyOffset{}
+	}
+}
+
+func (c *Client) fetchChatHistory(ctx context.Context, req types.GetMessagesRequest) ([]types.FormattedMessage, error) {
+	peer, limit, topMsgID := req.Peer, req.Limit, req.TopMsgID
 	inputPeer, err := shared.ConvertPeerToInputPeer(peer)
 	if err != nil {
 		return nil, err
 	}
 
+	offset := historyOffsets(req)
+
 	var history tg.MessagesMessagesClass
 	if topMsgID != nil {
 		// Use MessagesGetReplies for forum topic messages
-		repliesReq := &tg.MessagesGetRepliesRequest{
-			Peer:  inputPeer,
-			MsgID: *topMsgID,
-			Limit: limit,
-		}
-		if offsetID != nil {
-			repliesReq.OffsetID = *offsetID
-		}
-		history, err = c.GetAPI().MessagesGetReplies(ctx, repliesReq)
+		history, err = c.GetAPI().MessagesGetReplies(ctx, &tg.MessagesGetRepliesRequest{
+			Peer:       inputPeer,
+			MsgID:      *topMsgID,
+			Limit:      limit,
+			OffsetID:   offset.id,
+			OffsetDate: offset.date,
+			AddOffset:  offset.add,
+		})
 	} else {
-		req := &tg.MessagesGetHistoryRequest{Peer: inputPeer, Limit: limit}
-		if offsetID != nil {
-			req.OffsetID = *offsetID
-			req.AddOffset = -(limit / 2)
-		}
-		history, err = c.GetAPI().MessagesGetHistory(ctx, req)
+		history, err = c.GetAPI().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:       inputPeer,
+			Limit:      limit,
+			OffsetID:   offset.id,
+			OffsetDate: offset.date,
+			AddOffset:  offset.add,
+		})
 	}
 	if err != nil {
 		return nil, types.NewGetMessagesError(err)
@@ -362,6 +548,16 @@ func (c *Client) GetChatHistory(ctx context.Context, peer types.Peer, limit int,
 		return nil, err
 	}
 
+	c.cacheEntities(entities.Users, entities.Chats)
+
+	formattedMessages := formatHistoryMessages(peer, entities)
+	slices.Reverse(formattedMessages)
+	return formattedMessages, nil
+}
+
+// formatHistoryMessages turns a page of history or search results into FormattedMessages,
+// keeping the order the server sent them in (newest first)
+func formatHistoryMessages(peer types.Peer, entities *shared.MessageHistoryEntities) []types.FormattedMessage {
 	areWeInUserModeOrBotMode := peer.ChatType == types.BotChat || peer.ChatType == types.UserChat
 
 	var userInfo *types.UserInfo
@@ -377,8 +573,15 @@ func (c *Client) GetChatHistory(ctx context.Context, peer types.Peer, limit int,
 		}
 	}
 
+	peerNames := shared.HistoryPeerNames(entities.Users, entities.Chats)
 	var formattedMessages []types.FormattedMessage
 	for _, msgClass := range entities.Messages {
+		if service, ok := msgClass.(*tg.MessageService); ok {
+			formattedMessage := shared.FormatServiceMessage(service, peerNames)
+			formattedMessage.PeerID = &peer.ID
+			formattedMessages = append(formattedMessages, *formattedMessage)
+			continue
+		}
 		msg, ok := msgClass.(*tg.Message)
 		if !ok {
 			continue
@@ -406,8 +609,7 @@ func (c *Client) GetChatHistory(ctx context.Context, peer types.Peer, limit int,
 		}
 	}
 
-	slices.Reverse(formattedMessages)
-	return formattedMessages, nil
+	return formattedMessages
 }
 
 func (c *Client) GetUserChatsCmd(ctx context.Context, isBot bool, offsetDate, offsetID int) tea.Cmd {
@@ -468,7 +670,55 @@ func (c *Client) GetChannelForums(peer types.Peer) tea.Cmd {
 	}
 }
 
+// GetAllChats fetches the first page of dialogs and saves it to the local cache.
+// if the server can't be reached the cached dialogs from the last run are returned instead
 func (c *Client) GetAllChats(ctx context.Context, offsetDate int, offsetID int) (types.GetAllChatsResponse, error) {
+	chats, err := c.fetchAllChats(ctx, offsetDate, offsetID)
+	if err != nil {
+		if offsetDate != 0 || offsetID != 0 {
+			return chats, err
+		}
+		cached, found, cacheErr := c.cache.LoadDialogs()
+		if cacheErr != nil || !found {
+			return chats, err
+		}
+		slog.Warn("failed to fetch dialogs, using cached dialogs", "error", err)
+		return cached, nil
+	}
+	if offsetDate == 0 && offsetID == 0 {
+		if err := c.cache.SaveDialogs(chats); err != nil {
+			slog.Warn("failed to cache dialogs", "error", err)
+		}
+	}
+	return chats, nil
+}
+
+// CachedChats returns the dialogs saved during the previous run, found is false on first run
+func (c *Client) CachedChats() (types.GetAllChatsResponse, bool) {
+	chats, found, err := c.cache.LoadDialogs()
+	if err != nil {
+		slog.Warn("failed to read cached dialogs", "error", err)
+		return chats, false
+	}
+	return chats, found
+}
+
+// RefreshAllChats fetches dialogs from the server so the ui can reconcile the chat list it
+// rendered from cache
+func (c *Client) RefreshAllChats(ctx context.Context) tea.Cmd {
+	return func() tea.Msg {
+		chats, err := c.fetchAllChats(ctx, 0, 0)
+		if err != nil {
+			return types.AllChatsMsg{Err: err}
+		}
+		if err := c.cache.SaveDialogs(chats); err != nil {
+			slog.Warn("failed to cache dialogs", "error", err)
+		}
+		return types.AllChatsMsg{Response: &chats}
+	}
+}
+
+func (c *Client) fetchAllChats(ctx context.Context, offsetDate int, offsetID int) (types.GetAllChatsResponse, error) {
 	ds, err := c.getAllDialogs(ctx, offsetDate, offsetID)
 	if err != nil {
 		return types.GetAllChatsResponse{}, types.NewTelegramError(types.ErrorCodeGetMessagesFailed, "failed to get dialogs", err)
@@ -485,6 +735,7 @@ func (c *Client) GetAllChats(ctx context.Context, offsetDate int, offsetID int)
 		u.NotifySettings = getNotifySettings(ds.Dialogs, tgUser.ID)
 		u.ReadInboxMaxID = readInboxMaxID
 		u.ReadOutboxMaxID = readOutboxMaxID
+		u.TopMessageID = getTopMessageID(ds.Dialogs, tgUser.ID)
 
 		users = append(users, *u)
 	}
@@ -503,6 +754,7 @@ func (c *Client) GetAllChats(ctx context.Context, offsetDate int, offsetID int)
 			info.UnreadCount = getUnreadCount(ds.Dialogs, channel.ID)
 			info.NotifySettings = getNotifySettings(ds.Dialogs, channel.ID)
 			info.IsForum = channel.GetForum()
+			info.TopMessageID = getTopMessageID(ds.Dialogs, channel.ID)
 			if channel.Broadcast {
 				channels = append(channels, *info)
 			} else {
@@ -519,10 +771,19 @@ func (c *Client) GetAllChats(ctx context.Context, offsetDate int, offsetID int)
 			info.ReadOutboxMaxID = readOutboxMaxID
 			info.UnreadCount = getUnreadCount(ds.Dialogs, chat.ID)
 			info.NotifySettings = getNotifySettings(ds.Dialogs, chat.ID)
+			info.TopMessageID = getTopMessageID(ds.Dialogs, chat.ID)
 			groups = append(groups, *info)
 		}
 	}
 
+	for _, user := range ds.Users {
+		c.peers.AddUsers(user)
+	}
+	c.peers.AddChats(ds.Chats...)
+	if err := c.cache.SaveEntities(users, slices.Concat(channels, groups)); err != nil {
+		slog.Warn("failed to cache entities", "error", err)
+	}
+
 	return types.GetAllChatsResponse{
 		PrivateChats: users,
 		Channels:     channels,
@@ -613,24 +874,32 @@ func (c *Client) GetChannelInfo(ctx context.Context, peer types.Peer) (*types.Ch
 }
 
 func (c *Client) UserInfoFromPeerClass(ctx context.Context, peerClass *tg.PeerUser) *types.UserInfo {
-	userClasses, err := c.GetAPI().UsersGetUsers(ctx, []tg.InputUserClass{
-		&tg.InputUser{UserID: peerClass.UserID},
-	})
-	if err != nil || len(userClasses) == 0 {
-		return nil
-	}
-	user, ok := userClasses[0].(*tg.User)
-	if !ok {
+	userInfo, err := c.GetUserInfo(ctx, peerClass.UserID)
+	if err != nil {
 		return nil
 	}
-	return shared.ConvertTGUserToUserInfo(user)
+	return userInfo
 }
 
 func (c *Client) DeleteMessage(ctx context.Context, req types.DeleteMessageRequest) (types.DeleteMessageResponse, error) {
-	_, err := c.GetAPI().MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
-		Revoke: true,
-		ID:     []int{req.MessageID},
-	})
+	inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+	if err != nil {
+		return types.DeleteMessageResponse{Status: "failed"}, types.NewDeleteMessageError(err)
+	}
+	ids := append([]int{req.MessageID}, req.AlbumIDs...)
+	// message ids of channels and supergroups are their own, messages.deleteMessages only
+	// knows the ones of private chats and basic groups
+	if channel, ok := inputPeer.(*tg.InputPeerChannel); ok {
+		_, err = c.GetAPI().ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+			ID:      ids,
+		})
+	} else {
+		_, err = c.GetAPI().MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
+			Revoke: true,
+			ID:     ids,
+		})
+	}
 	if err != nil {
 		return types.DeleteMessageResponse{Status: "failed"}, types.NewDeleteMessageError(err)
 	}
@@ -643,15 +912,17 @@ func (c *Client) EditMessage(ctx context.Context, req types.EditMessageRequest)
 		return types.EditMessageMsg{Err: types.NewEditMessageError(err)}
 	}
 
+	message, entities := shared.ParseMarkupAs(shared.MarkupMode(req.Markup), req.NewMessage)
 	_, err = c.GetAPI().MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
-		Peer:    inputPeer,
-		ID:      req.MessageID,
-		Message: req.NewMessage,
+		Peer:     inputPeer,
+		ID:       req.MessageID,
+		Message:  message,
+		Entities: shared.ToTGEntities(entities),
 	})
 	if err != nil {
 		return types.EditMessageMsg{Err: types.NewEditMessageError(err)}
 	}
-	return types.EditMessageMsg{Response: true}
+	return types.EditMessageMsg{Response: true, UpdatedMessage: message, Entities: entities}
 }
 
 func (c *Client) ForwardMessages(ctx context.Context, req types.ForwardMessagesRequest) error {
@@ -665,11 +936,16 @@ func (c *Client) ForwardMessages(ctx context.Context, req types.ForwardMessagesR
 		return types.NewForwardMessageError(err)
 	}
 
+	// one random id per message, albums are forwarded as a whole
+	randomIDs := make([]int64, len(req.MessageIDs))
+	for i := range randomIDs {
+		randomIDs[i] = mathRand.Int63()
+	}
 	_, err = c.GetAPI().MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
 		FromPeer: fromPeer,
 		ToPeer:   toPeer,
 		ID:       req.MessageIDs,
-		RandomID: []int64{mathRand.Int63()},
+		RandomID: randomIDs,
 	})
 	if err != nil {
 		return types.NewForwardMessageError(err)
@@ -709,15 +985,14 @@ func (c *Client) SetUserTyping(ctx context.Context, req types.SetTypingRequest)
 }
 
 func (c *Client) GetUserInfo(ctx context.Context, userID int64) (*types.UserInfo, error) {
-	userClasses, err := c.GetAPI().UsersGetUsers(ctx, []tg.InputUserClass{&tg.InputUser{UserID: userID}})
-	if err != nil || len(userClasses) == 0 {
-		return nil, types.NewUserNotFoundError(userID)
-	}
-	tgUser, ok := userClasses[0].(*tg.User)
-	if !ok {
+	userInfo, err := c.peers.UserInfo(ctx, c.GetAPI(), userID)
+	if err != nil {
+		if cached, ok := c.cache.LoadUser(strconv.FormatInt(userID, 10)); ok {
+			return cached, nil
+		}
 		return nil, types.NewUserNotFoundError(userID)
 	}
-	return shared.ConvertTGUserToUserInfo(tgUser), nil
+	return userInfo, nil
 }
 
 func (c *Client) GetUserStatus(ctx context.Context, userID int64) (*types.UserStatus, error) {
@@ -745,12 +1020,12 @@ func (c *Client) GetUserStatus(ctx context.Context, userID int64) (*types.UserSt
 
 func (c *Client) SearchUsers(ctx context.Context, searchQuery string) {
 	users, err := c.searchUsers(ctx, searchQuery)
-	c.updateChannel <- types.Notification{
+	c.notifications.Push(types.Notification{
 		SearchResult: &types.SearchUsersMsg{
 			Response: &users,
 			Err:      err,
 		},
-	}
+	})
 }
 
 func (c *Client) searchUsers(ctx context.Context, q string) ([]types.UserInfo, error) {
@@ -768,6 +1043,214 @@ func (c *Client) searchUsers(ctx context.Context, q string) ([]types.UserInfo, e
 	return users, nil
 }
 
+// SearchMessages searches the messages of one chat, or one forum topic, newest first
+func (c *Client) SearchMessages(ctx context.Context, req types.SearchMessagesRequest) tea.Cmd {
+	return func() tea.Msg {
+		messages, count, err := c.searchMessages(ctx, req)
+		return types.SearchMessagesMsg{Request: req, Messages: messages, Count: count, Err: err}
+	}
+}
+
+func (c *Client) searchMessages(ctx context.Context, req types.SearchMessagesRequest) ([]types.FormattedMessage, int, error) {
+	inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request := &tg.MessagesSearchRequest{
+		Peer:     inputPeer,
+		Q:        req.Query,
+		Filter:   searchFilter(req.Filter),
+		Limit:    req.Limit,
+		OffsetID: req.OffsetID,
+	}
+	if req.TopMsgID != nil {
+		request.TopMsgID = *req.TopMsgID
+	}
+	from, err := c.searchSender(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from != nil {
+		request.FromID = from
+	}
+
+	result, err := c.GetAPI().MessagesSearch(ctx, request)
+	if err != nil {
+		return nil, 0, types.NewSearchMessagesError(err)
+	}
+	entities, err := shared.GetMessageAndUserClasses(result)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.cacheEntities(entities.Users, entities.Chats)
+
+	messages := formatHistoryMessages(req.Peer, entities)
+	count := len(messages)
+	switch r := result.(type) {
+	case *tg.MessagesMessagesSlice:
+		count = r.Count
+	case *tg.MessagesChannelMessages:
+		count = r.Count
+	}
+	return messages, count, nil
+}
+
+func (c *Client) searchSender(ctx context.Context, req types.SearchMessagesRequest) (tg.InputPeerClass, error) {
+	switch {
+	case req.From != nil:
+		return shared.ConvertPeerToInputPeer(*req.From)
+	case req.FromUsername == "me":
+		return &tg.InputPeerSelf{}, nil
+	case req.FromUsername != "":
+		resolved, err := c.GetAPI().ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
+			Username: strings.TrimPrefix(req.FromUsername, "@"),
+		})
+		if err != nil {
+			return nil, types.NewSearchMessagesError(err)
+		}
+		c.cacheEntities(resolved.Users, resolved.Chats)
+		if peerUser, ok := resolved.Peer.(*tg.PeerUser); ok {
+			for _, userClass := range resolved.Users {
+				if user, ok := userClass.(*tg.User); ok && user.ID == peerUser.UserID {
+					return user.AsInputPeer(), nil
+				}
+			}
+		}
+		return nil, types.NewSearchMessagesError(fmt.Errorf("%s is not a user", req.FromUsername))
+	}
+	return nil, nil
+}
+
+// SearchGlobal searches messages in every chat we are in, newest first
+func (c *Client) SearchGlobal(ctx context.Context, req types.SearchGlobalRequest) tea.Cmd {
+	return func() tea.Msg {
+		result, err := c.searchGlobal(ctx, req)
+		result.Request = req
+		result.Err = err
+		return result
+	}
+}
+
+func (c *Client) searchGlobal(ctx context.Context, req types.SearchGlobalRequest) (types.SearchGlobalMsg, error) {
+	var offsetPeer tg.InputPeerClass = &tg.InputPeerEmpty{}
+	if req.OffsetPeer != nil {
+		peer, err := shared.ConvertPeerToInputPeer(*req.OffsetPeer)
+		if err != nil {
+			return types.SearchGlobalMsg{}, err
+		}
+		offsetPeer = peer
+	}
+	result, err := c.GetAPI().MessagesSearchGlobal(ctx, &tg.MessagesSearchGlobalRequest{
+		Q:          req.Query,
+		Filter:     searchFilter(req.Filter),
+		OffsetRate: req.OffsetRate,
+		OffsetPeer: offsetPeer,
+		OffsetID:   req.OffsetID,
+		Limit:      req.Limit,
+	})
+	if err != nil {
+		return types.SearchGlobalMsg{}, types.NewSearchMessagesError(err)
+	}
+	entities, err := shared.GetMessageAndUserClasses(result)
+	if err != nil {
+		return types.SearchGlobalMsg{}, err
+	}
+	c.cacheEntities(entities.Users, entities.Chats)
+
+	var response types.SearchGlobalMsg
+	for _, msgClass := range entities.Messages {
+		msg, ok := msgClass.(*tg.Message)
+		if !ok {
+			continue
+		}
+		found, ok := globalSearchResult(msg, entities)
+		if !ok {
+			continue
+		}
+		response.Results = append(response.Results, found)
+	}
+
+	response.Count = len(response.Results)
+	if slice, ok := result.(*tg.MessagesMessagesSlice); ok {
+		response.Count = slice.Count
+		response.NextRate = slice.NextRate
+	}
+	return response, nil
+}
+
+// globalSearchResult works out which chat a search hit is in
+func globalSearchResult(msg *tg.Message, entities *shared.MessageHistoryEntities) (types.GlobalSearchResult, bool) {
+	var found types.GlobalSearchResult
+	switch peer := msg.PeerID.(type) {
+	case *tg.PeerUser:
+		user := getUserFromClasses(entities.Users, peer.UserID)
+		if user == nil {
+			return found, false
+		}
+		chatType := types.UserChat
+		if user.IsBot {
+			chatType = types.BotChat
+		}
+		found.Peer = types.Peer{ID: user.PeerID, AccessHash: user.AccessHash, ChatType: chatType}
+		found.ChatTitle = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		found.User = user
+	case *tg.PeerChannel:
+		channel := getChannelFromClasses(entities.Chats, peer.ChannelID)
+		if channel == nil {
+			return found, false
+		}
+		chatType := types.ChannelChat
+		if !channel.IsBroadcast {
+			chatType = types.GroupChat
+		}
+		found.Peer = types.Peer{ID: channel.ID, AccessHash: channel.AccessHash, ChatType: chatType}
+		found.ChatTitle = channel.ChannelTitle
+		found.Channel = channel
+	case *tg.PeerChat:
+		// basic groups have no access hash, they open from the groups list like supergroups
+		var group *types.ChannelInfo
+		for _, chatClass := range entities.Chats {
+			if chat, ok := chatClass.(*tg.Chat); ok && chat.ID == peer.ChatID {
+				group = convertToChannelInfo(chat)
+				break
+			}
+		}
+		if group == nil {
+			return found, false
+		}
+		found.Peer = types.Peer{ID: group.ID, ChatType: types.GroupChat}
+		found.ChatTitle = group.ChannelTitle
+		found.Channel = group
+	default:
+		return found, false
+	}
+
+	messages := formatHistoryMessages(found.Peer, &shared.MessageHistoryEntities{
+		Messages: []tg.MessageClass{msg},
+		Users:    entities.Users,
+		Chats:    entities.Chats,
+	})
+	if len(messages) == 0 {
+		return found, false
+	}
+	found.Message = messages[0]
+	return found, true
+}
+
+func searchFilter(filter types.MessageSearchFilter) tg.MessagesFilterClass {
+	switch filter {
+	case types.SearchFilterLinks:
+		return &tg.InputMessagesFilterURL{}
+	case types.SearchFilterFiles:
+		return &tg.InputMessagesFilterDocument{}
+	case types.SearchFilterPhotos:
+		return &tg.InputMessagesFilterPhotos{}
+	default:
+		return &tg.InputMessagesFilterEmpty{}
+	}
+}
+
 func (c *Client) GetAvailableReactions(ctx context.Context) tea.Cmd {
 	return func() tea.Msg {
 		result, err := c.GetAPI().MessagesGetAvailableReactions(ctx, 0)
@@ -823,6 +1306,10 @@ func (c *Client) GetAllStories(ctx context.Context) tea.Cmd {
 			return nil
 		}
 
+		// the response already carries every story owner, no need to ask for them one by one
+		c.peers.AddUsers(allUserStories.Users...)
+		c.peers.AddChats(allUserStories.Chats...)
+
 		var AllStories []types.Stories
 		for _, peerStorie := range allUserStories.PeerStories {
 			peerUser, ok := peerStorie.Peer.(*tg.PeerUser)
@@ -830,17 +1317,10 @@ func (c *Client) GetAllStories(ctx context.Context) tea.Cmd {
 				continue
 			}
 
-			userClass, err := c.GetAPI().UsersGetUsers(ctx, []tg.InputUserClass{&tg.InputUser{UserID: peerUser.UserID}})
-			if err != nil || len(userClass) == 0 {
-				continue
-			}
-
-			tgUser, ok := userClass[0].(*tg.User)
-			if !ok {
+			userInfo, err := c.peers.UserInfo(ctx, c.GetAPI(), peerUser.UserID)
+			if err != nil {
 				continue
 			}
-
-			userInfo := shared.ConvertTGUserToUserInfo(tgUser)
 			userInfo.HasStories = true
 
 			for _, storyItemClass := range peerStorie.Stories {
@@ -876,7 +1356,9 @@ func (c *Client) GetAllStories(ctx context.Context) tea.Cmd {
 	}
 }
 
-func (c *Client) GetPeerStories(ctx context.Context, peer types.Peer) tea.Cmd {
+// GetPeerStories downloads the stories of a peer and opens them in the default app. with
+// viewPhotos the photos are left for the image viewer and listed in the result instead
+func (c *Client) GetPeerStories(ctx context.Context, peer types.Peer, viewPhotos bool) tea.Cmd {
 	return func() tea.Msg {
 		inputPeer, err := shared.ConvertPeerToInputPeer(peer)
 		if err != nil {
@@ -890,13 +1372,17 @@ func (c *Client) GetPeerStories(ctx context.Context, peer types.Peer) tea.Cmd {
 		homeDir, _ := os.UserHomeDir()
 		cligramDir := filepath.Join(homeDir, ".cligram")
 		var readStoriesIDs []int
+		var photos []string
 		for _, v := range peerUserStories.Stories.Stories {
 			if storyItem, ok := v.(*tg.StoryItem); ok {
-				id, err := shared.DownloadStoryMedia(ctx, c.Client, storyItem, cligramDir, peer.ID)
+				id, path, err := shared.DownloadStoryMedia(ctx, c.Client, storyItem, cligramDir, peer.ID, !viewPhotos)
 				if err != nil {
 					continue
 				}
 				readStoriesIDs = append(readStoriesIDs, *id)
+				if viewPhotos && strings.HasSuffix(path, ".jpg") {
+					photos = append(photos, path)
+				}
 			}
 		}
 
@@ -907,7 +1393,7 @@ func (c *Client) GetPeerStories(ctx context.Context, peer types.Peer) tea.Cmd {
 					slog.Error("failed to mark stories as read", "err", readErr)
 				}
 			}
-			return types.StoriesDownloadStatusMsg{IDs: readStoriesIDs, Done: true, Peer: peer}
+			return types.StoriesDownloadStatusMsg{IDs: readStoriesIDs, Done: true, Peer: peer, Photos: photos}
 		}
 		return types.StoriesDownloadStatusMsg{
 			IDs:  []int{},
@@ -969,6 +1455,30 @@ func (c *Client) getAllDialogs(ctx context.Context, offsetDate, offsetID int) (*
 	return &result, nil
 }
 
+func (c *Client) cacheEntities(userClasses []tg.UserClass, chatClasses []tg.ChatClass) {
+	c.peers.AddUsers(userClasses...)
+	c.peers.AddChats(chatClasses...)
+
+	var users []types.UserInfo
+	for _, userClass := range userClasses {
+		if user, ok := userClass.(*tg.User); ok {
+			users = append(users, *shared.ConvertTGUserToUserInfo(user))
+		}
+	}
+	var channels []types.ChannelInfo
+	for _, chatClass := range chatClasses {
+		switch chat := chatClass.(type) {
+		case *tg.Channel:
+			channels = append(channels, *convertToChannelInfo(chat))
+		case *tg.Chat:
+			channels = append(channels, *convertToChannelInfo(chat))
+		}
+	}
+	if err := c.cache.SaveEntities(users, channels); err != nil {
+		slog.Warn("failed to cache entities", "error", err)
+	}
+}
+
 func getUserFromClasses(users []tg.UserClass, peerID int64) *types.UserInfo {
 	for _, userClass := range users {
 		if user, ok := userClass.(*tg.User); ok && user.ID == peerID {
@@ -1021,6 +1531,23 @@ func getReadMaxMessageID(chatDialogs []*tg.Dialog, peerID int64) (int, int) {
 	return 0, 0
 }
 
+// the newest message id the dialog had when we fetched it, anything up to it is
+// already part of UnreadCount
+func getTopMessageID(chatDialogs []*tg.Dialog, peerID int64) int {
+	for _, p := range chatDialogs {
+		if tgPeerUser, ok := p.Peer.(*tg.PeerUser); ok && tgPeerUser.UserID == peerID {
+			return p.TopMessage
+		}
+		if tgPeerChannel, ok := p.Peer.(*tg.PeerChannel); ok && tgPeerChannel.ChannelID == peerID {
+			return p.TopMessage
+		}
+		if tgPeerChat, ok := p.Peer.(*tg.PeerChat); ok && tgPeerChat.ChatID == peerID {
+			return p.TopMessage
+		}
+	}
+	return 0
+}
+
 func getNotifySettings(chatDialogs []*tg.Dialog, peerID int64) *tg.PeerNotifySettings {
 	for _, p := range chatDialogs {
 		if tgPeerUser, ok := p.Peer.(*tg.PeerUser); ok && tgPeerUser.UserID == peerID {
@@ -1085,46 +1612,11 @@ func (c *Client) GetMe(ctx context.Context) (*types.UserInfo, error) {
 
 func (c *Client) GetSingleMessage(ctx context.Context, peer types.Peer, messageID int) tea.Cmd {
 	return func() tea.Msg {
-		inputPeer, err := shared.ConvertPeerToInputPeer(peer)
+		msg, entities, err := c.getMessage(ctx, peer, messageID)
 		if err != nil {
 			return types.SingleMessageMsg{Err: err}
 		}
 
-		var id tg.InputMessageClass = &tg.InputMessageID{ID: messageID}
-		var messagesClass tg.MessagesMessagesClass
-
-		if peer.ChatType == types.ChannelChat || peer.ChatType == types.GroupChat {
-			inputChannel, ok := inputPeer.(*tg.InputPeerChannel)
-			if ok {
-				messagesClass, err = c.GetAPI().ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
-					Channel: &tg.InputChannel{ChannelID: inputChannel.ChannelID, AccessHash: inputChannel.AccessHash},
-					ID:      []tg.InputMessageClass{id},
-				})
-			} else {
-				messagesClass, err = c.GetAPI().MessagesGetMessages(ctx, []tg.InputMessageClass{id})
-			}
-		} else {
-			messagesClass, err = c.GetAPI().MessagesGetMessages(ctx, []tg.InputMessageClass{id})
-		}
-
-		if err != nil {
-			return types.SingleMessageMsg{Err: err}
-		}
-
-		entities, err := shared.GetMessageAndUserClasses(messagesClass)
-		if err != nil {
-			return types.SingleMessageMsg{Err: err}
-		}
-
-		if len(entities.Messages) == 0 {
-			return types.SingleMessageMsg{Err: errors.New("message not found")}
-		}
-
-		msg, ok := entities.Messages[0].(*tg.Message)
-		if !ok {
-			return types.SingleMessageMsg{Err: errors.New("unexpected message type")}
-		}
-
 		var userInfo *types.UserInfo
 		if peer.ChatType == types.UserChat || peer.ChatType == types.BotChat {
 			if id, err := strconv.ParseInt(peer.ID, 10, 64); err == nil {
@@ -1139,9 +1631,52 @@ func (c *Client) GetSingleMessage(ctx context.Context, peer types.Peer, messageI
 	}
 }
 
+func (c *Client) getMessage(ctx context.Context, peer types.Peer, messageID int) (*tg.Message, *shared.MessageHistoryEntities, error) {
+	inputPeer, err := shared.ConvertPeerToInputPeer(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var id tg.InputMessageClass = &tg.InputMessageID{ID: messageID}
+	var messagesClass tg.MessagesMessagesClass
+
+	if peer.ChatType == types.ChannelChat || peer.ChatType == types.GroupChat {
+		inputChannel, ok := inputPeer.(*tg.InputPeerChannel)
+		if ok {
+			messagesClass, err = c.GetAPI().ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+				Channel: &tg.InputChannel{ChannelID: inputChannel.ChannelID, AccessHash: inputChannel.AccessHash},
+				ID:      []tg.InputMessageClass{id},
+			})
+		} else {
+			messagesClass, err = c.GetAPI().MessagesGetMessages(ctx, []tg.InputMessageClass{id})
+		}
+	} else {
+		messagesClass, err = c.GetAPI().MessagesGetMessages(ctx, []tg.InputMessageClass{id})
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entities, err := shared.GetMessageAndUserClasses(messagesClass)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(entities.Messages) == 0 {
+		return nil, nil, errors.New("message not found")
+	}
+
+	msg, ok := entities.Messages[0].(*tg.Message)
+	if !ok {
+		return nil, nil, errors.New("unexpected message type")
+	}
+	return msg, entities, nil
+}
+
 func (c *Client) GetLastMessage(ctx context.Context, peer types.Peer) tea.Cmd {
 	return func() tea.Msg {
-		messages, err := c.GetChatHistory(ctx, peer, 1, nil, nil)
+		messages, err := c.GetChatHistory(ctx, types.GetMessagesRequest{Peer: peer, Limit: 1})
 		if err != nil {
 			return types.SingleMessageMsg{Err: err}
 		}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// connectionTracker follows the state of the MTProto connection and publishes
+// every change as a ConnectionState notification. gotd reconnects on its own,
+// we only learn about it through OnDead (connection lost) and OnSelfSuccess
+// (session usable again), so those two hooks drive most of the transitions.
+type connectionTracker struct {
+	notifications *notificationQueue
+
+	mu           sync.Mutex
+	state        types.ConnectionState
+	err          error
+	since        time.Time
+	pendingSends int
+	// closed and replaced on every state change, lets senders wait for a reconnect
+	changed chan struct{}
+}
+
+func newConnectionTracker(notifications *notificationQueue) *connectionTracker {
+	return &connectionTracker{
+		notifications: notifications,
+		state:         types.ConnectionConnecting,
+		since:         time.Now(),
+		changed:       make(chan struct{}),
+	}
+}
+
+func (t *connectionTracker) Set(state types.ConnectionState, err error) {
+	t.mu.Lock()
+	if t.state == state && err == nil {
+		t.mu.Unlock()
+		return
+	}
+	// once dead we stay dead, late hooks from the closing client must not hide it
+	if t.state == types.ConnectionDead {
+		t.mu.Unlock()
+		return
+	}
+	t.state = state
+	t.err = err
+	t.since = time.Now()
+	close(t.changed)
+	t.changed = make(chan struct{})
+	status := t.statusLocked()
+	t.mu.Unlock()
+
+	if err != nil {
+		slog.Warn("telegram connection state changed", "state", state, "error", err)
+	} else {
+		slog.Info("telegram connection state changed", "state", state)
+	}
+	t.notifications.Push(types.Notification{ConnectionState: &status})
+}
+
+func (t *connectionTracker) Status() types.ConnectionStateNotification {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.statusLocked()
+}
+
+// errOffline fails sends that don't go through the outbox, sent later they'd show up at a
+// time the user doesn't expect or not work at all, inline results expire quickly
+var errOffline = errors.New("not connected, try again once the connection is back")
+
+// RequireConnected returns errOffline unless we are connected right now
+func (t *connectionTracker) RequireConnected() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state != types.ConnectionConnected {
+		return errOffline
+	}
+	return nil
+}
+
+// WaitConnected blocks until the connection is usable, messages sent while offline
+// wait here and go out as soon as the client is back
+func (t *connectionTracker) WaitConnected(ctx context.Context) error {
+	t.mu.Lock()
+	if t.state == types.ConnectionConnected {
+		t.mu.Unlock()
+		return nil
+	}
+	t.pendingSends++
+	t.publishLocked()
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		t.pendingSends--
+		t.publishLocked()
+		t.mu.Unlock()
+	}()
+
+	for {
+		t.mu.Lock()
+		state, err, changed := t.state, t.err, t.changed
+		t.mu.Unlock()
+
+		switch state {
+		case types.ConnectionConnected:
+			return nil
+		case types.ConnectionDead:
+			return types.NewTelegramError(types.ErrorCodeSendFailed, "connection is dead", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// publishLocked pushes the current status without a state change, for the pending sends counter.
+// must be called with t.mu held
+func (t *connectionTracker) publishLocked() {
+	status := t.statusLocked()
+	t.notifications.Push(types.Notification{ConnectionState: &status})
+}
+
+func (t *connectionTracker) statusLocked() types.ConnectionStateNotification {
+	return types.ConnectionStateNotification{
+		State:        t.state,
+		Err:          t.err,
+		Since:        t.since,
+		PendingSends: t.pendingSends,
+	}
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// DeliveryStats counts what went through the notification queue since startup
+type DeliveryStats struct {
+	Queued    uint64
+	Delivered uint64
+	// Coalesced status/typing events that were replaced by a newer one for the same peer
+	Coalesced uint64
+	// MaxPending is the longest the queue got while the ui was busy
+	MaxPending int
+	// Pending is how many notifications are waiting right now
+	Pending int
+}
+
+type queuedNotification struct {
+	key          string
+	notification types.Notification
+}
+
+// notificationQueue sits between the update handlers and the update channel.
+// Push never blocks and never drops: messages, edits and deletes are always kept
+// in order, while status and typing events for the same peer replace the one still
+// waiting in the queue since only the latest of them matters to the ui.
+type notificationQueue struct {
+	out chan types.Notification
+
+	mu      sync.Mutex
+	pending []*queuedNotification
+	// coalescable notifications that are still waiting, keyed by coalesceKey
+	waiting map[string]*queuedNotification
+	stats   DeliveryStats
+	wake    chan struct{}
+}
+
+func newNotificationQueue(out chan types.Notification) *notificationQueue {
+	return &notificationQueue{
+		out:     out,
+		waiting: map[string]*queuedNotification{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (q *notificationQueue) Push(notification types.Notification) {
+	key := coalesceKey(notification)
+
+	q.mu.Lock()
+	q.stats.Queued++
+	if existing, ok := q.waiting[key]; ok && key != "" {
+		existing.notification = notification
+		q.stats.Coalesced++
+		q.mu.Unlock()
+		return
+	}
+	item := &queuedNotification{key: key, notification: notification}
+	q.pending = append(q.pending, item)
+	if key != "" {
+		q.waiting[key] = item
+	}
+	q.stats.MaxPending = max(q.stats.MaxPending, len(q.pending))
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *notificationQueue) Stats() DeliveryStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Pending = len(q.pending)
+	return stats
+}
+
+// run feeds the update channel until ctx is done, blocking on it when the ui falls behind
+func (q *notificationQueue) run(ctx context.Context) {
+	defer func() {
+		stats := q.Stats()
+		slog.Info("notification delivery stopped",
+			"queued", stats.Queued,
+			"delivered", stats.Delivered,
+			"coalesced", stats.Coalesced,
+			"maxPending", stats.MaxPending,
+		)
+	}()
+
+	for {
+		notification, ok := q.pop()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case q.out <- notification:
+			q.mu.Lock()
+			q.stats.Delivered++
+			q.mu.Unlock()
+		}
+	}
+}
+
+func (q *notificationQueue) pop() (types.Notification, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return types.Notification{}, false
+	}
+	item := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	if item.key != "" && q.waiting[item.key] == item {
+		delete(q.waiting, item.key)
+	}
+	return item.notification, true
+}
+
+// coalesceKey returns the peer a notification can be merged on, empty for the ones
+// that must be delivered one by one
+func coalesceKey(notification types.Notification) string {
+	switch {
+	case notification.ConnectionState != nil:
+		return "connection"
+	case notification.UserStatus != nil:
+		return "status:" + notification.UserStatus.UserInfo.PeerID
+	case notification.UserTyping != nil:
+		typing := notification.UserTyping
+		return "typing:" + string(typing.PeerType) + ":" + typing.PeerID + ":" + typing.User.PeerID
+	case notification.Upload != nil:
+		return "upload:" + strconv.Itoa(notification.Upload.RandID)
+	case notification.Download != nil:
+		return "download:" + notification.Download.Peer.ID + ":" + strconv.Itoa(notification.Download.MessageID)
+	case notification.BotCommands != nil:
+		// a burst of member changes only needs the menu loaded once
+		return "bots:" + notification.BotCommands.PeerID + ":" + notification.BotCommands.BotID
+	default:
+		return ""
+	}
+}
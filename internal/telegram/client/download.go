@@ -0,0 +1,273 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// how often a running download reports its progress
+const downloadProgressInterval = 250 * time.Millisecond
+
+// DownloadMedia saves the file of a message, progress is reported as Download notifications
+// while the result comes back as a DownloadMediaMsg
+func (c *Client) DownloadMedia(ctx context.Context, req types.DownloadMediaRequest) tea.Cmd {
+	return func() tea.Msg {
+		path, err := c.downloadMedia(ctx, req)
+		if err != nil {
+			return types.DownloadMediaMsg{Request: req, Err: types.NewDownloadMediaError(err)}
+		}
+		if req.Open {
+			if err := shared.OpenFileInDefaultApp(path); err != nil {
+				return types.DownloadMediaMsg{Request: req, Path: path, Err: types.NewTelegramError(types.ErrorCodeDownloadFailed, "saved to "+path+" but failed to open it", err)}
+			}
+		}
+		return types.DownloadMediaMsg{Request: req, Path: path}
+	}
+}
+
+func (c *Client) downloadMedia(ctx context.Context, req types.DownloadMediaRequest) (string, error) {
+	// the message is fetched again, file references expire and the one we got with the history may be stale
+	msg, _, err := c.getMessage(ctx, req.Peer, req.MessageID)
+	if err != nil {
+		return "", err
+	}
+	location, info := shared.MediaFileLocation(msg.Media)
+	if location == nil {
+		return "", errors.New("this message has no file to download")
+	}
+
+	directory, err := shared.DownloadDirectory()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return "", err
+	}
+	key := downloadKey(location)
+	if path, ok := c.downloads.Lookup(key, info.Size); ok {
+		return path, nil
+	}
+	path, part, err := reserveDownloadPath(directory, shared.MediaFileName(info, req.Peer.ID, req.MessageID))
+	if err != nil {
+		return "", err
+	}
+
+	var lastReport atomic.Int64
+	progress := func(written int64) {
+		now := time.Now().UnixMilli()
+		last := lastReport.Load()
+		if now-last < downloadProgressInterval.Milliseconds() || !lastReport.CompareAndSwap(last, now) {
+			return
+		}
+		c.notifications.Push(types.Notification{Download: &types.DownloadProgressNotification{
+			Peer:       req.Peer,
+			MessageID:  req.MessageID,
+			Downloaded: written,
+			Total:      info.Size,
+		}})
+	}
+	if err := shared.DownloadInto(ctx, c.GetAPI(), part, location, progress); err != nil {
+		return "", err
+	}
+	if err := finishDownload(part.Name(), path); err != nil {
+		return "", err
+	}
+	if err := c.downloads.Add(key, path); err != nil {
+		slog.Error("failed to record download", "path", path, "error", err)
+	}
+	return path, nil
+}
+
+// LoadImage reads the picture of a message into memory, it comes back as a LoadImageMsg
+func (c *Client) LoadImage(ctx context.Context, req types.LoadImageRequest) tea.Cmd {
+	return func() tea.Msg {
+		data, err := c.loadImage(ctx, req)
+		if err != nil {
+			return types.LoadImageMsg{Request: req, Err: types.NewDownloadMediaError(err)}
+		}
+		return types.LoadImageMsg{Request: req, Data: data}
+	}
+}
+
+func (c *Client) loadImage(ctx context.Context, req types.LoadImageRequest) ([]byte, error) {
+	msg, _, err := c.getMessage(ctx, req.Peer, req.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	location := shared.ImageLocation(msg.Media)
+	if location == nil {
+		return nil, errors.New("this message has no picture to show")
+	}
+	var data bytes.Buffer
+	if _, err := downloader.NewDownloader().Download(c.GetAPI(), location).Stream(ctx, &limitedWriter{w: &data, left: shared.MaxImageSize}); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// limitedWriter fails once more than left bytes were written, a thumbnail that turns out huge
+// must not fill the memory
+type limitedWriter struct {
+	w    io.Writer
+	left int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.left {
+		return 0, errors.New("the picture is too big to show")
+	}
+	l.left -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// downloadPaths is held while a download picks its path and while it moves its .part file
+// there, so two downloads never end up at the same path
+var downloadPaths sync.Mutex
+
+// reserveDownloadPath finds a free path for a new download and creates its .part file, a file
+// that is already there gets a number added instead of being overwritten
+func reserveDownloadPath(directory, name string) (string, *os.File, error) {
+	downloadPaths.Lock()
+	defer downloadPaths.Unlock()
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	for i := 0; ; i++ {
+		path := filepath.Join(directory, name)
+		if i > 0 {
+			path = filepath.Join(directory, fmt.Sprintf("%s (%d)%s", base, i, extension))
+		}
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		// the .part file is the reservation, another download of the same name has one already
+		part, err := os.OpenFile(path+".part", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return path, part, nil
+	}
+}
+
+func finishDownload(partPath, path string) error {
+	downloadPaths.Lock()
+	defer downloadPaths.Unlock()
+	return os.Rename(partPath, path)
+}
+
+// downloadKey names the file a location points at, the same photo or document has the same key
+// in every message it is forwarded to. empty for locations we don't keep track of
+func downloadKey(location tg.InputFileLocationClass) string {
+	switch location := location.(type) {
+	case *tg.InputDocumentFileLocation:
+		return fmt.Sprintf("document:%d:%s", location.ID, location.ThumbSize)
+	case *tg.InputPhotoFileLocation:
+		return fmt.Sprintf("photo:%d:%s", location.ID, location.ThumbSize)
+	}
+	return ""
+}
+
+// downloadRecord remembers where finished downloads were saved in
+// ~/.cligram/<account>/downloads.json, so downloading a file again opens the saved one
+type downloadRecord struct {
+	path string
+
+	mu    sync.Mutex
+	files map[string]string
+}
+
+// newMemoryDownloadRecord is used when the record can't be opened, files are downloaded again
+// after a restart then
+func newMemoryDownloadRecord() *downloadRecord {
+	return &downloadRecord{files: map[string]string{}}
+}
+
+func newDownloadRecord(account string) (*downloadRecord, error) {
+	dir, err := accountDir(account)
+	if err != nil {
+		return nil, err
+	}
+	r := newMemoryDownloadRecord()
+	r.path = filepath.Join(dir, "downloads.json")
+
+	content, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a corrupted record only costs downloading files again
+	if err := json.Unmarshal(content, &r.files); err != nil || r.files == nil {
+		r.files = map[string]string{}
+	}
+	return r, nil
+}
+
+// Lookup returns where the file was saved, as long as it is still there with the size it
+// had. files that were moved or changed since are forgotten
+func (r *downloadRecord) Lookup(key string, size int64) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path, ok := r.files[key]
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err == nil && (size <= 0 || info.Size() == size) {
+		return path, true
+	}
+	delete(r.files, key)
+	if err := r.flush(); err != nil {
+		slog.Error("failed to update download record", "error", err)
+	}
+	return "", false
+}
+
+func (r *downloadRecord) Add(key, path string) error {
+	if key == "" {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[key] = path
+	return r.flush()
+}
+
+// must be called with r.mu held
+func (r *downloadRecord) flush() error {
+	if r.path == "" {
+		return nil
+	}
+	content, err := json.Marshal(r.files)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// QueryInlineBot asks an inline bot for the results of what was typed after its username
+func (c *Client) QueryInlineBot(ctx context.Context, req types.InlineQueryRequest) tea.Cmd {
+	return func() tea.Msg {
+		msg, err := c.queryInlineBot(ctx, req)
+		if err != nil {
+			return types.InlineQueryMsg{Request: req, NotInlineBot: errors.Is(err, errNotInlineBot), Err: err}
+		}
+		return msg
+	}
+}
+
+func (c *Client) queryInlineBot(ctx context.Context, req types.InlineQueryRequest) (types.InlineQueryMsg, error) {
+	bot, err := c.inlineBot(ctx, req.Bot)
+	if err != nil {
+		return types.InlineQueryMsg{}, err
+	}
+	inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+	if err != nil {
+		return types.InlineQueryMsg{}, err
+	}
+	results, err := c.GetAPI().MessagesGetInlineBotResults(ctx, &tg.MessagesGetInlineBotResultsRequest{
+		Bot:    bot.AsInput(),
+		Peer:   inputPeer,
+		Query:  req.Query,
+		Offset: req.Offset,
+	})
+	if err != nil {
+		return types.InlineQueryMsg{}, types.NewTelegramError(types.ErrorCodeSearchFailed, "the inline bot didn't answer", err)
+	}
+	c.peers.AddUsers(results.Users...)
+	msg := types.InlineQueryMsg{
+		Request:     req,
+		NextOffset:  results.NextOffset,
+		Placeholder: bot.BotInlinePlaceholder,
+	}
+	for _, result := range results.Results {
+		msg.Results = append(msg.Results, inlineResult(results.QueryID, result))
+	}
+	return msg, nil
+}
+
+// errNotInlineBot is behind the errors of usernames that won't ever answer an inline query
+var errNotInlineBot = errors.New("not an inline bot")
+
+// usernameSet remembers usernames case insensitively
+type usernameSet struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func newUsernameSet() *usernameSet {
+	return &usernameSet{names: map[string]bool{}}
+}
+
+func (s *usernameSet) add(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[strings.ToLower(username)] = true
+}
+
+func (s *usernameSet) has(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.names[strings.ToLower(username)]
+}
+
+// inlineBot finds the bot by username, in the peer cache when we saw it before. every message
+// starting with a mention looks like an inline query, so names that turned out not to be inline
+// bots are remembered and never resolved again, resolving is heavily flood limited
+func (c *Client) inlineBot(ctx context.Context, username string) (*tg.User, error) {
+	if c.notInlineBots.has(username) {
+		return nil, types.NewTelegramError(types.ErrorCodeUserNotFound, fmt.Sprintf("@%s is not an inline bot", username), errNotInlineBot)
+	}
+	user, ok := c.peers.UserByUsername(username)
+	if !ok {
+		resolved, err := c.GetAPI().ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{Username: username})
+		if tgerr.Is(err, "USERNAME_NOT_OCCUPIED", "USERNAME_INVALID") {
+			c.notInlineBots.add(username)
+			return nil, types.NewTelegramError(types.ErrorCodeUserNotFound, "@"+username+" not found", errNotInlineBot)
+		}
+		if err != nil {
+			return nil, types.NewTelegramError(types.ErrorCodeUserNotFound, "@"+username+" not found", err)
+		}
+		c.cacheEntities(resolved.Users, resolved.Chats)
+		if peerUser, isUser := resolved.Peer.(*tg.PeerUser); isUser {
+			user, ok = c.peers.User(peerUser.UserID)
+		}
+	}
+	if !ok || !user.Bot {
+		c.notInlineBots.add(username)
+		return nil, types.NewTelegramError(types.ErrorCodeUserNotFound, fmt.Sprintf("@%s is not a bot", username), errNotInlineBot)
+	}
+	if _, inline := user.GetBotInlinePlaceholder(); !inline {
+		c.notInlineBots.add(username)
+		return nil, types.NewTelegramError(types.ErrorCodeUserNotFound, fmt.Sprintf("@%s doesn't answer inline queries", username), errNotInlineBot)
+	}
+	return user, nil
+}
+
+func inlineResult(queryID int64, result tg.BotInlineResultClass) types.InlineResult {
+	converted := types.InlineResult{QueryID: queryID, ID: result.GetID(), Type: result.GetType()}
+	switch result := result.(type) {
+	case *tg.BotInlineResult:
+		converted.Title = result.Title
+		converted.Description = result.Description
+		converted.URL = result.URL
+	case *tg.BotInlineMediaResult:
+		converted.Title = result.Title
+		converted.Description = result.Description
+	}
+	switch message := result.GetSendMessage().(type) {
+	case *tg.BotInlineMessageText:
+		converted.Text = message.Message
+	case *tg.BotInlineMessageMediaAuto:
+		converted.Text = message.Message
+	case *tg.BotInlineMessageMediaWebPage:
+		converted.Text = message.Message
+	}
+	return converted
+}
+
+// SendInlineResult sends the picked result of an inline query to the chat
+func (c *Client) SendInlineResult(ctx context.Context, req types.SendInlineResultRequest) tea.Cmd {
+	return func() tea.Msg {
+		if err := c.connection.RequireConnected(); err != nil {
+			return types.SendInlineResultMsg{Request: req, Err: types.NewTelegramError(types.ErrorCodeSendFailed, "failed to send the result", err)}
+		}
+		inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+		if err != nil {
+			return types.SendInlineResultMsg{Request: req, Err: err}
+		}
+		request := &tg.MessagesSendInlineBotResultRequest{
+			Peer:     inputPeer,
+			RandomID: int64(req.RandID),
+			QueryID:  req.QueryID,
+			ID:       req.ResultID,
+		}
+		request.SetClearDraft(true)
+		if replyTo := inputReplyTo(nil, req.TopMsgID); replyTo != nil {
+			request.SetReplyTo(replyTo)
+		}
+		updates, err := c.GetAPI().MessagesSendInlineBotResult(ctx, request)
+		if err != nil {
+			return types.SendInlineResultMsg{Request: req, Err: types.NewTelegramError(types.ErrorCodeSendFailed, "failed to send the result", err)}
+		}
+		return types.SendInlineResultMsg{Request: req, Message: sentMessage(updates)}
+	}
+}
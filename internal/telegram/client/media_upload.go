@@ -0,0 +1,392 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	_ "image/gif" // decoders for image.DecodeConfig and image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+const (
+	// limits of a native photo, bigger images go out as files
+	maxPhotoSize      = 10 << 20
+	maxPhotoDimension = 10000
+	maxPhotoRatio     = 20
+	// telegram shows thumbnails of at most 320px on the longest side
+	thumbnailSize = 320
+	// moov boxes bigger than this are not read, they only come with hours of video
+	maxMP4HeaderSize = 64 << 20
+)
+
+// detectMimeType sniffs the file and falls back to the extension, sniffing doesn't know
+// quicktime videos and many other formats
+func detectMimeType(file io.ReadSeeker, path string) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	buffer := make([]byte, 512)
+	n, _ := io.ReadFull(file, buffer)
+	mimeType := http.DetectContentType(buffer[:n])
+	if mimeType == "application/octet-stream" {
+		if byExtension := mime.TypeByExtension(filepath.Ext(path)); byExtension != "" {
+			mimeType = byExtension
+		}
+	}
+	return mimeType, nil
+}
+
+// uploadedMedia decides how an uploaded file is sent: jpeg and png as photos, mp4 and quicktime
+// as playable videos and everything else, or anything when req.SendAsFile is set, as a document
+func (c *Client) uploadedMedia(ctx context.Context, req types.SendMessageRequest, file *os.File, size int64, fileUpload tg.InputFileClass) (tg.InputMediaClass, error) {
+	mimeType, err := detectMimeType(file, req.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	document := &tg.InputMediaUploadedDocument{
+		File:     fileUpload,
+		MimeType: mimeType,
+		Attributes: []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeFilename{FileName: filepath.Base(req.FilePath)},
+		},
+	}
+
+	var thumbnail []byte
+	switch mimeType {
+	case "image/jpeg", "image/png":
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		config, _, err := image.DecodeConfig(file)
+		if err != nil {
+			break
+		}
+		if !req.SendAsFile && fitsPhotoLimits(config, size) {
+			return &tg.InputMediaUploadedPhoto{File: fileUpload}, nil
+		}
+		document.Attributes = append(document.Attributes, &tg.DocumentAttributeImageSize{W: config.Width, H: config.Height})
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			thumbnail = makeThumbnail(file)
+		}
+	case "video/mp4", "video/quicktime":
+		if req.SendAsFile {
+			break
+		}
+		info, err := parseMP4(file, size)
+		if err != nil || info.Width == 0 {
+			break
+		}
+		document.Attributes = append(document.Attributes, &tg.DocumentAttributeVideo{
+			Duration:          info.Duration,
+			W:                 info.Width,
+			H:                 info.Height,
+			SupportsStreaming: info.Streamable,
+		})
+		// go has no h.264 or hevc decoder to take a frame from, so the cover art is the only real
+		// picture we have. videos without one get a poster of their shape with a play sign
+		if info.Cover != nil {
+			thumbnail = makeThumbnail(bytes.NewReader(info.Cover))
+		}
+		if thumbnail == nil {
+			thumbnail = posterThumbnail(info.Width, info.Height)
+		}
+	}
+	if req.SendAsFile {
+		document.ForceFile = true
+	}
+	if thumbnail != nil {
+		thumb, err := uploader.NewUploader(c.GetAPI()).FromBytes(ctx, "thumb.jpg", thumbnail)
+		if err == nil {
+			document.SetThumb(thumb)
+		}
+	}
+	return document, nil
+}
+
+func fitsPhotoLimits(config image.Config, size int64) bool {
+	if size > maxPhotoSize || config.Width == 0 || config.Height == 0 {
+		return false
+	}
+	if config.Width+config.Height > maxPhotoDimension {
+		return false
+	}
+	longest, shortest := max(config.Width, config.Height), min(config.Width, config.Height)
+	return longest <= shortest*maxPhotoRatio
+}
+
+// makeThumbnail scales an image down to a jpeg of at most 320px, nil when it can't be decoded
+func makeThumbnail(r io.Reader) []byte {
+	source, _, err := image.Decode(r)
+	if err != nil {
+		return nil
+	}
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil
+	}
+	scale := min(1, float64(thumbnailSize)/float64(max(width, height)))
+	thumbWidth, thumbHeight := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := range thumbHeight {
+		for x := range thumbWidth {
+			thumb.Set(x, y, averageColor(source, bounds, x*width/thumbWidth, y*height/thumbHeight, (x+1)*width/thumbWidth, (y+1)*height/thumbHeight))
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil
+	}
+	return encoded.Bytes()
+}
+
+// posterThumbnail draws a dark jpeg of the video's shape with a play sign in the middle, the
+// stand in for a frame of videos without cover art
+func posterThumbnail(width, height int) []byte {
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	scale := min(1, float64(thumbnailSize)/float64(max(width, height)))
+	thumbWidth, thumbHeight := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+
+	poster := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	background := color.RGBA{R: 0x20, G: 0x20, B: 0x24, A: 0xff}
+	sign := color.RGBA{R: 0xe0, G: 0xe0, B: 0xe0, A: 0xff}
+	// a triangle pointing right, as high as a third of the shorter side
+	side := max(2, min(thumbWidth, thumbHeight)/3)
+	left, top := (thumbWidth-side)/2, (thumbHeight-side)/2
+	for y := range thumbHeight {
+		for x := range thumbWidth {
+			poster.Set(x, y, background)
+			dx, dy := x-left, y-top
+			if dx < 0 || dy < 0 || dx >= side || dy >= side {
+				continue
+			}
+			// the rows get shorter away from the middle one, which reaches the tip
+			if dx <= side-abs(2*dy-side) {
+				poster.Set(x, y, sign)
+			}
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, poster, &jpeg.Options{Quality: 80}); err != nil {
+		return nil
+	}
+	return encoded.Bytes()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// averageColor averages a few pixels of the source area of one thumbnail pixel, every pixel
+// would be slow for big photos and a single one gives jagged thumbnails
+func averageColor(source image.Image, bounds image.Rectangle, x0, y0, x1, y1 int) color.Color {
+	const samples = 3
+	var r, g, b, count uint32
+	for i := range samples {
+		for j := range samples {
+			x := bounds.Min.X + x0 + (x1-x0)*i/samples
+			y := bounds.Min.Y + y0 + (y1-y0)*j/samples
+			sr, sg, sb, _ := source.At(x, y).RGBA()
+			r, g, b, count = r+sr, g+sg, b+sb, count+1
+		}
+	}
+	return color.RGBA64{R: uint16(r / count), G: uint16(g / count), B: uint16(b / count), A: 0xffff}
+}
+
+type mp4Info struct {
+	// Duration in seconds
+	Duration      float64
+	Width, Height int
+	// Streamable is set when the moov box comes before the media data, players can start
+	// before the whole file is there
+	Streamable bool
+	// Cover is embedded cover art, if any
+	Cover []byte
+}
+
+// parseMP4 reads what a video attribute needs from the boxes of an mp4 or quicktime file
+func parseMP4(file io.ReadSeeker, size int64) (*mp4Info, error) {
+	info := &mp4Info{}
+	seenMediaData := false
+	for offset := int64(0); offset < size; {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			return nil, err
+		}
+		boxSize, boxType, headerSize := int64(binary.BigEndian.Uint32(header)), string(header[4:8]), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := io.ReadFull(file, header[8:16]); err != nil {
+				return nil, err
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		// sizes come from the file, a box can't be smaller than its header or reach past the end
+		if boxSize < headerSize || boxSize > size-offset {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		switch boxType {
+		case "mdat":
+			seenMediaData = true
+		case "moov":
+			if boxSize > maxMP4HeaderSize {
+				return nil, io.ErrShortBuffer
+			}
+			moov := make([]byte, boxSize-headerSize)
+			if _, err := io.ReadFull(file, moov); err != nil {
+				return nil, err
+			}
+			info.Streamable = !seenMediaData
+			parseMoov(moov, info)
+			return info, nil
+		}
+		offset += boxSize
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// walkBoxes calls fn for every box directly inside data
+func walkBoxes(data []byte, fn func(boxType string, body []byte)) {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		headerSize := 8
+		if size == 1 && len(data) >= 16 {
+			size, headerSize = int(binary.BigEndian.Uint64(data[8:])), 16
+		} else if size == 0 {
+			size = len(data)
+		}
+		if size < headerSize || size > len(data) {
+			return
+		}
+		fn(string(data[4:8]), data[headerSize:size])
+		data = data[size:]
+	}
+}
+
+func parseMoov(moov []byte, info *mp4Info) {
+	walkBoxes(moov, func(boxType string, body []byte) {
+		switch boxType {
+		case "mvhd":
+			if timescale, duration, ok := parseDuration(body, 12, 20); ok {
+				info.Duration = duration / timescale
+			}
+		case "trak":
+			if width, height, ok := parseVideoTrack(body); ok && info.Width == 0 {
+				info.Width, info.Height = width, height
+			}
+		case "udta":
+			info.Cover = findCover(body)
+		}
+	})
+}
+
+// parseDuration reads the timescale and duration of an mvhd or mdhd box, where they are depends
+// on the box version. v0Offset and v1Offset are where the timescale is in each version
+func parseDuration(body []byte, v0Offset, v1Offset int) (float64, float64, bool) {
+	if len(body) < 4 {
+		return 0, 0, false
+	}
+	if body[0] == 1 {
+		if len(body) < v1Offset+12 {
+			return 0, 0, false
+		}
+		timescale := binary.BigEndian.Uint32(body[v1Offset:])
+		duration := binary.BigEndian.Uint64(body[v1Offset+4:])
+		return float64(timescale), float64(duration), timescale != 0
+	}
+	if len(body) < v0Offset+8 {
+		return 0, 0, false
+	}
+	timescale := binary.BigEndian.Uint32(body[v0Offset:])
+	duration := binary.BigEndian.Uint32(body[v0Offset+4:])
+	return float64(timescale), float64(duration), timescale != 0
+}
+
+// parseVideoTrack returns the display size of a video track, false for sound and other tracks
+func parseVideoTrack(trak []byte) (int, int, bool) {
+	var isVideo bool
+	var tkhd []byte
+	walkBoxes(trak, func(boxType string, body []byte) {
+		switch boxType {
+		case "tkhd":
+			tkhd = body
+		case "mdia":
+			walkBoxes(body, func(boxType string, body []byte) {
+				// version and flags, pre_defined, then the handler type
+				if boxType == "hdlr" && len(body) >= 12 && string(body[8:12]) == "vide" {
+					isVideo = true
+				}
+			})
+		}
+	})
+	if !isVideo || len(tkhd) < 4 {
+		return 0, 0, false
+	}
+	// the matrix and the size come after the times, which are 4 or 8 bytes depending on the version
+	matrixOffset := 40
+	if tkhd[0] == 1 {
+		matrixOffset = 52
+	}
+	if len(tkhd) < matrixOffset+44 {
+		return 0, 0, false
+	}
+	width := int(binary.BigEndian.Uint32(tkhd[matrixOffset+36:]) >> 16)
+	height := int(binary.BigEndian.Uint32(tkhd[matrixOffset+40:]) >> 16)
+	// phones record sideways and rotate with the matrix, a 90 or 270 degree turn has a zero first entry
+	if binary.BigEndian.Uint32(tkhd[matrixOffset:]) == 0 {
+		width, height = height, width
+	}
+	return width, height, width > 0 && height > 0
+}
+
+// findCover digs the cover art out of udta/meta/ilst/covr/data
+func findCover(udta []byte) []byte {
+	var cover []byte
+	walkBoxes(udta, func(boxType string, body []byte) {
+		// meta is a full box, its children start after version and flags
+		if boxType != "meta" || len(body) < 4 {
+			return
+		}
+		walkBoxes(body[4:], func(boxType string, body []byte) {
+			if boxType != "ilst" {
+				return
+			}
+			walkBoxes(body, func(boxType string, body []byte) {
+				if boxType != "covr" {
+					return
+				}
+				walkBoxes(body, func(boxType string, body []byte) {
+					// type indicator and locale come before the image
+					if boxType == "data" && len(body) > 8 && cover == nil {
+						cover = body[8:]
+					}
+				})
+			})
+		})
+	})
+	return cover
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// box builds an mp4 box with a 32-bit size
+func box(boxType string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, boxType...), body...)
+}
+
+// boxHeader is a box header claiming size bytes, for boxes whose body isn't really there
+func boxHeader(boxType string, size uint32) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, size), boxType...)
+}
+
+// largeBoxHeader is a box header with a 64-bit size
+func largeBoxHeader(boxType string, size uint64) []byte {
+	out := append(binary.BigEndian.AppendUint32(nil, 1), boxType...)
+	return binary.BigEndian.AppendUint64(out, size)
+}
+
+func mvhd(timescale, duration uint32) []byte {
+	body := make([]byte, 20)
+	binary.BigEndian.PutUint32(body[12:], timescale)
+	binary.BigEndian.PutUint32(body[16:], duration)
+	return box("mvhd", body)
+}
+
+func tkhd(width, height uint32, rotated bool) []byte {
+	body := make([]byte, 84)
+	if !rotated {
+		binary.BigEndian.PutUint32(body[40:], 0x00010000)
+	}
+	binary.BigEndian.PutUint32(body[76:], width<<16)
+	binary.BigEndian.PutUint32(body[80:], height<<16)
+	return box("tkhd", body)
+}
+
+func hdlr(handler string) []byte {
+	body := make([]byte, 12)
+	copy(body[8:], handler)
+	return box("hdlr", body)
+}
+
+func trak(handler string, width, height uint32, rotated bool) []byte {
+	return box("trak", tkhd(width, height, rotated), box("mdia", hdlr(handler)))
+}
+
+func cover(image []byte) []byte {
+	meta := append([]byte{0, 0, 0, 0}, box("ilst", box("covr", box("data", make([]byte, 8), image)))...)
+	return box("udta", box("meta", meta))
+}
+
+func parseFixture(data []byte) (*mp4Info, error) {
+	return parseMP4(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestParseMP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	moov := box("moov", mvhd(1000, 5500), trak("soun", 0, 0, false), trak("vide", 1280, 720, false))
+	mdat := box("mdat", make([]byte, 32))
+
+	info, err := parseFixture(bytes.Join([][]byte{ftyp, moov, mdat}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 5.5 || info.Width != 1280 || info.Height != 720 || !info.Streamable {
+		t.Errorf("got %+v, want 5.5s 1280x720 streamable", info)
+	}
+
+	info, err = parseFixture(bytes.Join([][]byte{ftyp, mdat, moov}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Streamable {
+		t.Error("moov after mdat can't be streamed")
+	}
+}
+
+func TestParseMP4Rotated(t *testing.T) {
+	info, err := parseFixture(box("moov", mvhd(600, 600), trak("vide", 1920, 1080, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 1080 || info.Height != 1920 {
+		t.Errorf("got %dx%d, want 1080x1920", info.Width, info.Height)
+	}
+}
+
+func TestParseMP4Cover(t *testing.T) {
+	art := []byte("\xff\xd8 not really a jpeg")
+	info, err := parseFixture(box("moov", mvhd(1, 1), trak("vide", 2, 2, false), cover(art)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.Cover, art) {
+		t.Errorf("cover = %q, want %q", info.Cover, art)
+	}
+}
+
+func TestParseMP4Malformed(t *testing.T) {
+	moov := box("moov", mvhd(1000, 1000), trak("vide", 640, 480, false))
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no moov", box("mdat", make([]byte, 16))},
+		{"truncated header", []byte{0, 0, 0}},
+		{"truncated moov", moov[:len(moov)-10]},
+		{"box smaller than its header", boxHeader("free", 4)},
+		{"box past the end", append(boxHeader("mdat", 1<<30), make([]byte, 16)...)},
+		{"negative large size", largeBoxHeader("mdat", 1<<63)},
+		{"large size past the end", largeBoxHeader("moov", 1<<40)},
+		{"large size smaller than its header", largeBoxHeader("moov", 8)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := parseFixture(tc.data)
+			if err == nil {
+				t.Fatalf("got %+v, want an error", info)
+			}
+		})
+	}
+}
+
+func TestParseMP4HugeMoovIsNotRead(t *testing.T) {
+	_, err := parseMP4(bytes.NewReader(boxHeader("moov", maxMP4HeaderSize+9)), maxMP4HeaderSize+9)
+	if !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("err = %v, want %v", err, io.ErrShortBuffer)
+	}
+}
+
+func TestWalkBoxesStopsAtBadSizes(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{"two boxes", append(box("free"), box("skip", []byte{1})...), []string{"free", "skip"}},
+		{"child past the end", append(box("free"), boxHeader("skip", 100)...), []string{"free"}},
+		{"size below header", boxHeader("free", 3), nil},
+		{"huge large size", largeBoxHeader("free", 1<<63), nil},
+		{"large size past the end", largeBoxHeader("free", 1<<20), nil},
+		{"zero size runs to the end", boxHeader("free", 0), []string{"free"}},
+		{"trailing bytes", append(box("free"), 0, 0, 0), []string{"free"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			walkBoxes(tc.data, func(boxType string, body []byte) { got = append(got, boxType) })
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindCoverMalformed(t *testing.T) {
+	cases := map[string][]byte{
+		"meta without version": box("meta", []byte{0, 0}),
+		"data without image":   box("meta", append([]byte{0, 0, 0, 0}, box("ilst", box("covr", box("data", make([]byte, 8))))...)),
+		"covr past the end":    box("meta", append([]byte{0, 0, 0, 0}, box("ilst", boxHeader("covr", 200))...)),
+	}
+	for name, udta := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := findCover(udta); got != nil {
+				t.Errorf("got %q, want no cover", got)
+			}
+		})
+	}
+}
+
+func TestParseDurationShortBodies(t *testing.T) {
+	for _, body := range [][]byte{nil, {0, 0, 0}, make([]byte, 19), append([]byte{1}, make([]byte, 30)...)} {
+		if _, _, ok := parseDuration(body, 12, 20); ok {
+			t.Errorf("parsed a duration out of %d bytes", len(body))
+		}
+	}
+	if _, _, ok := parseVideoTrack(box("tkhd", make([]byte, 10))); ok {
+		t.Error("parsed a size out of a short tkhd")
+	}
+}
+
+func TestPosterThumbnail(t *testing.T) {
+	for _, size := range [][2]int{{1920, 1080}, {1080, 1920}, {100, 50}, {1, 1}} {
+		data := posterThumbnail(size[0], size[1])
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%dx%d: %v", size[0], size[1], err)
+		}
+		if max(config.Width, config.Height) > thumbnailSize {
+			t.Errorf("%dx%d: poster is %dx%d, bigger than %d", size[0], size[1], config.Width, config.Height, thumbnailSize)
+		}
+		if (size[0] > size[1]) != (config.Width > config.Height) {
+			t.Errorf("%dx%d: poster is %dx%d, the shape changed", size[0], size[1], config.Width, config.Height)
+		}
+	}
+	if posterThumbnail(0, 720) != nil {
+		t.Error("made a poster for a video without a size")
+	}
+}
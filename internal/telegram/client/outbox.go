@@ -0,0 +1,389 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	mathRand "math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/tgerr"
+
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+const (
+	// failed messages are retried automatically this many times, after that only by hand
+	maxOutboxAutoRetries = 5
+	outboxBaseBackoff    = 2 * time.Second
+	outboxMaxBackoff     = 5 * time.Minute
+)
+
+// errNotInOutbox is returned for a message that was sent or discarded in the meantime
+var errNotInOutbox = errors.New("message is no longer in the outbox")
+
+// outbox keeps messages that are not confirmed by the server yet in
+// ~/.cligram/<account>/outbox.json, so a failed send or a restart never loses what
+// the user typed. sent messages are dropped from it right away.
+type outbox struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[int]*types.OutboxEntry
+	// rand ids with a send in flight, so the retry loop and the ui never send the same entry twice
+	sending map[int]bool
+}
+
+// newMemoryOutbox is used when the outbox file can't be opened, retries still work
+// but unsent messages are lost on exit
+func newMemoryOutbox() *outbox {
+	return &outbox{
+		entries: map[int]*types.OutboxEntry{},
+		sending: map[int]bool{},
+	}
+}
+
+func newOutbox(account string) (*outbox, error) {
+	dir, err := accountDir(account)
+	if err != nil {
+		return nil, err
+	}
+	o := newMemoryOutbox()
+	o.path = filepath.Join(dir, "outbox.json")
+
+	content, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []types.OutboxEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		entry.Request = withAlbumRandIDs(entry.Request)
+		o.entries[entry.Request.RandID] = &entry
+	}
+	return o, nil
+}
+
+// Add stores a new message and marks it in flight, the caller sends it right away
+func (o *outbox) Add(req types.SendMessageRequest) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries[req.RandID] = &types.OutboxEntry{
+		Request:   withAlbumRandIDs(req),
+		State:     types.OutboxPending,
+		CreatedAt: time.Now(),
+	}
+	o.sending[req.RandID] = true
+	return o.flush()
+}
+
+// withAlbumRandIDs gives every file of an album after the first one its random id, entries
+// saved before albums had them get theirs when they are loaded
+func withAlbumRandIDs(req types.SendMessageRequest) types.SendMessageRequest {
+	if len(req.FilePaths) < 2 || len(req.AlbumRandIDs) == len(req.FilePaths)-1 {
+		return req
+	}
+	req.AlbumRandIDs = make([]int64, len(req.FilePaths)-1)
+	for i := range req.AlbumRandIDs {
+		req.AlbumRandIDs[i] = mathRand.Int63()
+	}
+	return req
+}
+
+// Begin marks the entry as in flight, false means it is gone or already being sent
+func (o *outbox) Begin(randID int) (types.OutboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entry, ok := o.entries[randID]
+	if !ok || o.sending[randID] {
+		return types.OutboxEntry{}, false
+	}
+	o.sending[randID] = true
+	entry.State = types.OutboxPending
+	return *entry, true
+}
+
+func (o *outbox) Succeeded(randID int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.sending, randID)
+	delete(o.entries, randID)
+	return o.flush()
+}
+
+func (o *outbox) Failed(randID int, sendErr error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.sending, randID)
+	entry, ok := o.entries[randID]
+	if !ok {
+		// discarded while the send was running
+		return nil
+	}
+	entry.State = types.OutboxFailed
+	entry.Attempts++
+	entry.LastError = sendErr.Error()
+	entry.NextAttempt = time.Now().Add(outboxBackoff(entry.Attempts))
+	entry.Permanent = permanentSendError(sendErr)
+	return o.flush()
+}
+
+// Retry makes a failed entry eligible for sending again and resets its automatic retries
+func (o *outbox) Retry(randID int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entry, ok := o.entries[randID]
+	if !ok {
+		return errNotInOutbox
+	}
+	entry.Attempts = 0
+	entry.NextAttempt = time.Time{}
+	entry.Permanent = false
+	return o.flush()
+}
+
+func (o *outbox) Discard(randID int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.entries, randID)
+	return o.flush()
+}
+
+// Reconnected makes failed messages due right away, most of them failed because the
+// connection went away and there is no point in waiting out their backoff now
+func (o *outbox) Reconnected() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, entry := range o.entries {
+		if entry.State == types.OutboxFailed && !entry.Permanent && entry.Attempts < maxOutboxAutoRetries {
+			entry.NextAttempt = time.Time{}
+		}
+	}
+}
+
+// Due returns entries the retry loop should send now: leftovers from the last run and
+// failed ones whose backoff has passed
+func (o *outbox) Due(now time.Time) []types.OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var due []types.OutboxEntry
+	for randID, entry := range o.entries {
+		if o.sending[randID] {
+			continue
+		}
+		if entry.State == types.OutboxFailed && (entry.Permanent || entry.Attempts >= maxOutboxAutoRetries || now.Before(entry.NextAttempt)) {
+			continue
+		}
+		due = append(due, *entry)
+	}
+	slices.SortFunc(due, func(a, b types.OutboxEntry) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return due
+}
+
+// For returns the unsent messages of one chat, oldest first
+func (o *outbox) For(peer types.Peer, topMsgID *int) []types.OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var entries []types.OutboxEntry
+	for _, entry := range o.entries {
+		req := entry.Request
+		if req.Peer.ID != peer.ID || req.Peer.ChatType != peer.ChatType {
+			continue
+		}
+		if (req.TopMsgID == nil) != (topMsgID == nil) || (topMsgID != nil && *req.TopMsgID != *topMsgID) {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	slices.SortFunc(entries, func(a, b types.OutboxEntry) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return entries
+}
+
+// must be called with o.mu held
+func (o *outbox) flush() error {
+	if o.path == "" {
+		return nil
+	}
+	entries := make([]types.OutboxEntry, 0, len(o.entries))
+	for _, entry := range o.entries {
+		entries = append(entries, *entry)
+	}
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
+
+// permanentSendError tells whether sending the same message again fails the same way: telegram
+// refused the request itself (400, 403, 406) or the file can't be sent at all. flood waits,
+// server errors and lost connections are worth retrying
+func permanentSendError(err error) bool {
+	var telegramErr *types.TelegramError
+	if errors.As(err, &telegramErr) && telegramErr.Code == types.ErrorCodeInvalidFile {
+		return true
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	rpcErr, ok := tgerr.As(err)
+	if !ok || strings.HasPrefix(rpcErr.Type, "FILE_PART") {
+		// lost upload parts are uploaded again on the retry
+		return false
+	}
+	switch rpcErr.Code {
+	case 400, 403, 406:
+		return true
+	}
+	return false
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff << max(0, attempts-1)
+	if backoff <= 0 || backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return backoff
+}
+
+// deliver sends an entry that is not in flight yet, false when it is gone or already being sent
+func (c *Client) deliver(ctx context.Context, randID int) (types.SendMessageMsg, bool) {
+	entry, ok := c.outbox.Begin(randID)
+	if !ok {
+		return types.SendMessageMsg{}, false
+	}
+	return c.sendOutboxEntry(ctx, entry.Request), true
+}
+
+// sendOutboxEntry sends a message that is already marked in flight and records the outcome,
+// the returned msg is what the ui gets
+func (c *Client) sendOutboxEntry(ctx context.Context, req types.SendMessageRequest) types.SendMessageMsg {
+	result := types.SendMessageMsg{RandID: req.RandID}
+	// while offline the message waits here instead of failing right away
+	if err := c.connection.WaitConnected(ctx); err != nil {
+		result.Err = types.NewSendMessageError(err)
+	} else {
+		var send tea.Cmd
+		if req.IsFile {
+			send = c.sendMedia(ctx, req)
+		} else {
+			send = c.sendText(ctx, req.Peer, req.Message, parseReplyID(req.ReplyToMessageID), req.RandID, req.TopMsgID)
+		}
+		if msg, ok := send().(types.SendMessageMsg); ok {
+			result = msg
+		}
+	}
+	// the random id is what tells telegram it has this message already, a send that went
+	// through right before a crash or a lost answer comes back as a duplicate
+	if tgerr.Is(result.Err, "RANDOM_ID_DUPLICATE") {
+		slog.Info("outbox message was already sent", "randId", req.RandID)
+		result.Err = nil
+	}
+
+	var err error
+	if result.Err != nil {
+		err = c.outbox.Failed(req.RandID, result.Err)
+	} else {
+		err = c.outbox.Succeeded(req.RandID)
+	}
+	if err != nil {
+		slog.Error("failed to update outbox", "error", err)
+	}
+	return result
+}
+
+// RetryOutboxMessage sends a failed message again right away
+func (c *Client) RetryOutboxMessage(ctx context.Context, randID int) tea.Cmd {
+	err := c.outbox.Retry(randID)
+	if errors.Is(err, errNotInOutbox) {
+		return nil
+	}
+	if err != nil {
+		// the entry is reset in memory, only saving it failed, so send it anyway
+		slog.Error("failed to update outbox", "error", err)
+	}
+	return func() tea.Msg {
+		result, ok := c.deliver(ctx, randID)
+		if !ok {
+			return nil
+		}
+		return result
+	}
+}
+
+// DiscardOutboxMessage forgets a failed message, it will never be sent
+func (c *Client) DiscardOutboxMessage(randID int) {
+	if err := c.outbox.Discard(randID); err != nil {
+		slog.Error("failed to update outbox", "error", err)
+	}
+}
+
+// OutboxMessages returns the messages of a chat that are still waiting to be sent
+func (c *Client) OutboxMessages(peer types.Peer, topMsgID *int) []types.OutboxEntry {
+	return c.outbox.For(peer, topMsgID)
+}
+
+// retryOutbox resends what is left in the outbox: messages from the last run as soon as we
+// are connected and failed ones with an exponential backoff, each result is reported as an
+// Outbox notification since nothing in the ui is waiting for it
+func (c *Client) retryOutbox(ctx context.Context) {
+	wasConnected := true
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if c.connection.Status().State != types.ConnectionConnected {
+			wasConnected = false
+			continue
+		}
+		if !wasConnected {
+			c.outbox.Reconnected()
+			wasConnected = true
+		}
+		for _, due := range c.outbox.Due(time.Now()) {
+			entry, ok := c.outbox.Begin(due.Request.RandID)
+			if !ok {
+				continue
+			}
+			c.notifications.Push(types.Notification{Outbox: &types.OutboxNotification{
+				RandID: entry.Request.RandID,
+				Peer:   entry.Request.Peer,
+				State:  types.OutboxPending,
+			}})
+
+			result := c.sendOutboxEntry(ctx, entry.Request)
+			notification := &types.OutboxNotification{
+				RandID: entry.Request.RandID,
+				Peer:   entry.Request.Peer,
+				State:  types.OutboxSent,
+				Err:    result.Err,
+			}
+			if result.Err != nil {
+				notification.State = types.OutboxFailed
+			} else if result.Response != nil {
+				notification.MessageID = result.Response.MessageID
+			}
+			c.notifications.Push(types.Notification{Outbox: notification})
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/gotd/td/tg"
+
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// maxCachedPeers caps each of the users, chats and channels maps, a long session
+// in big groups would otherwise keep every member it ever saw
+const maxCachedPeers = 20000
+
+// peerCache keeps the users, chats and channels we have seen in memory. it is fed
+// from update entities, dialog fetches and history responses, so looking up who
+// is typing or who just came online doesn't cost a UsersGetUsers call every time.
+// it is shared between the update handlers and the ui commands, hence the lock.
+type peerCache struct {
+	mu       sync.RWMutex
+	users    *boundedPeers[*tg.User]
+	chats    *boundedPeers[*tg.Chat]
+	channels *boundedPeers[*tg.Channel]
+	// lowercased username -> user id, so inline queries don't scan every user
+	usernames map[string]int64
+}
+
+func newPeerCache() *peerCache {
+	return &peerCache{
+		users:     newBoundedPeers[*tg.User](maxCachedPeers),
+		chats:     newBoundedPeers[*tg.Chat](maxCachedPeers),
+		channels:  newBoundedPeers[*tg.Channel](maxCachedPeers),
+		usernames: map[string]int64{},
+	}
+}
+
+func (pc *peerCache) AddUsers(users ...tg.UserClass) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, userClass := range users {
+		if user, ok := userClass.(*tg.User); ok {
+			pc.addUser(user)
+		}
+	}
+}
+
+func (pc *peerCache) AddChats(chats ...tg.ChatClass) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, chatClass := range chats {
+		switch chat := chatClass.(type) {
+		case *tg.Chat:
+			pc.chats.set(chat.ID, chat)
+		case *tg.Channel:
+			pc.addChannel(chat)
+		}
+	}
+}
+
+func (pc *peerCache) User(userID int64) (*tg.User, bool) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.users.get(userID)
+}
+
+// UserByUsername finds a user we have seen by username, case doesn't matter
+func (pc *peerCache) UserByUsername(username string) (*tg.User, bool) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	userID, ok := pc.usernames[strings.ToLower(username)]
+	if !ok {
+		return nil, false
+	}
+	return pc.users.get(userID)
+}
+
+func (pc *peerCache) Channel(channelID int64) (*tg.Channel, bool) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.channels.get(channelID)
+}
+
+func (pc *peerCache) Chat(chatID int64) (*tg.Chat, bool) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.chats.get(chatID)
+}
+
+// SetUserStatus keeps the last seen of a cached user current, status updates carry no entities
+func (pc *peerCache) SetUserStatus(userID int64, status tg.UserStatusClass) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	user, ok := pc.users.get(userID)
+	if !ok {
+		return
+	}
+	// entries are shared with readers, never modify them in place
+	updated := *user
+	updated.Status = status
+	pc.users.set(userID, &updated)
+}
+
+// UserInfo returns the cached user and only asks the server for users we have never seen
+func (pc *peerCache) UserInfo(ctx context.Context, api *tg.Client, userID int64) (*types.UserInfo, error) {
+	if user, ok := pc.User(userID); ok {
+		return shared.ConvertTGUserToUserInfo(user), nil
+	}
+	userClasses, err := api.UsersGetUsers(ctx, []tg.InputUserClass{&tg.InputUser{UserID: userID}})
+	if err != nil {
+		return nil, types.NewTelegramError(types.ErrorCodeGetMessagesFailed, "users.getUsers failed", err)
+	}
+	if len(userClasses) == 0 {
+		return nil, types.NewUserNotFoundError(userID)
+	}
+	user, ok := userClasses[0].(*tg.User)
+	if !ok {
+		return nil, types.NewUserNotFoundError(userID)
+	}
+	pc.AddUsers(user)
+	return shared.ConvertTGUserToUserInfo(user), nil
+}
+
+// must be called with pc.mu held
+func (pc *peerCache) addUser(user *tg.User) {
+	// min constructors lack a usable access hash, don't let them replace a full one
+	existing, ok := pc.users.get(user.ID)
+	if ok && user.Min && !existing.Min {
+		return
+	}
+	if ok && !strings.EqualFold(existing.Username, user.Username) {
+		pc.forgetUsername(existing)
+	}
+	if user.Username != "" {
+		pc.usernames[strings.ToLower(user.Username)] = user.ID
+	}
+	if evicted, ok := pc.users.set(user.ID, user); ok {
+		pc.forgetUsername(evicted)
+	}
+}
+
+// must be called with pc.mu held
+func (pc *peerCache) forgetUsername(user *tg.User) {
+	key := strings.ToLower(user.Username)
+	// someone else may have taken the username over since
+	if id, ok := pc.usernames[key]; ok && id == user.ID {
+		delete(pc.usernames, key)
+	}
+}
+
+// ChangedBots returns the bots among users whose bot info version differs from the cached one,
+// bots we never saw before aren't counted
+func (pc *peerCache) ChangedBots(users ...tg.UserClass) []int64 {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	var changed []int64
+	for _, userClass := range users {
+		user, ok := userClass.(*tg.User)
+		if !ok || !user.Bot || user.Min {
+			continue
+		}
+		if existing, known := pc.users.get(user.ID); known && existing.BotInfoVersion != user.BotInfoVersion {
+			changed = append(changed, user.ID)
+		}
+	}
+	return changed
+}
+
+// must be called with pc.mu held
+func (pc *peerCache) addChannel(channel *tg.Channel) {
+	if existing, ok := pc.channels.get(channel.ID); ok && channel.Min && !existing.Min {
+		return
+	}
+	pc.channels.set(channel.ID, channel)
+}
+
+// boundedPeers is a map that drops the peer added first once it holds more than
+// limit entries. evicted peers just get fetched or delivered by an update again.
+// it has no lock of its own, peerCache guards it.
+type boundedPeers[T any] struct {
+	limit int
+	items map[int64]T
+	order []int64
+}
+
+func newBoundedPeers[T any](limit int) *boundedPeers[T] {
+	return &boundedPeers[T]{limit: limit, items: map[int64]T{}}
+}
+
+func (b *boundedPeers[T]) get(id int64) (T, bool) {
+	item, ok := b.items[id]
+	return item, ok
+}
+
+// set stores item under id and returns the peer it evicted to make room, if any
+func (b *boundedPeers[T]) set(id int64, item T) (T, bool) {
+	var evicted T
+	if _, ok := b.items[id]; ok {
+		b.items[id] = item
+		return evicted, false
+	}
+	b.items[id] = item
+	b.order = append(b.order, id)
+	if len(b.items) <= b.limit {
+		return evicted, false
+	}
+	oldest := b.order[0]
+	b.order = b.order[1:]
+	evicted = b.items[oldest]
+	delete(b.items, oldest)
+	return evicted, true
+}
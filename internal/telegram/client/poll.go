@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// VotePoll votes in the poll of a message or takes the vote back, the new results come back in
+// the VotePollMsg
+func (c *Client) VotePoll(ctx context.Context, req types.VotePollRequest) tea.Cmd {
+	return func() tea.Msg {
+		inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+		if err != nil {
+			return types.VotePollMsg{Request: req, Err: err}
+		}
+		updates, err := c.GetAPI().MessagesSendVote(ctx, &tg.MessagesSendVoteRequest{
+			Peer:    inputPeer,
+			MsgID:   req.MessageID,
+			Options: req.Options,
+		})
+		if err != nil {
+			return types.VotePollMsg{Request: req, Err: types.NewTelegramError(types.ErrorCodeSendFailed, "failed to vote", err)}
+		}
+		msg := types.VotePollMsg{Request: req}
+		for _, update := range updateList(updates) {
+			if pollUpdate, ok := update.(*tg.UpdateMessagePoll); ok {
+				msg.Update = pollUpdateNotification(pollUpdate)
+			}
+		}
+		return msg
+	}
+}
+
+// SendPoll sends a poll or quiz written in the composer
+func (c *Client) SendPoll(ctx context.Context, req types.SendPollRequest) tea.Cmd {
+	return func() tea.Msg {
+		message, err := c.sendPoll(ctx, req)
+		if err != nil {
+			return types.SendPollMsg{Request: req, Err: err}
+		}
+		return types.SendPollMsg{Request: req, Message: message}
+	}
+}
+
+func (c *Client) sendPoll(ctx context.Context, req types.SendPollRequest) (*tg.Message, error) {
+	if err := c.connection.RequireConnected(); err != nil {
+		return nil, types.NewTelegramError(types.ErrorCodeSendFailed, "failed to send the poll", err)
+	}
+	inputPeer, err := shared.ConvertPeerToInputPeer(req.Peer)
+	if err != nil {
+		return nil, err
+	}
+	answers := make([]tg.PollAnswerClass, 0, len(req.Poll.Options))
+	for _, option := range req.Poll.Options {
+		answers = append(answers, &tg.InputPollAnswer{Text: tg.TextWithEntities{Text: option}})
+	}
+	poll := tg.Poll{Question: tg.TextWithEntities{Text: req.Poll.Question}, Answers: answers}
+	poll.SetMultipleChoice(req.Poll.MultipleChoice && !req.Poll.Quiz)
+	poll.SetQuiz(req.Poll.Quiz)
+	media := &tg.InputMediaPoll{Poll: poll}
+	if req.Poll.Quiz {
+		media.SetCorrectAnswers([]int{req.Poll.CorrectOption})
+		if req.Poll.Solution != "" {
+			media.SetSolution(req.Poll.Solution)
+		}
+	}
+	request := &tg.MessagesSendMediaRequest{
+		Peer:     inputPeer,
+		Media:    media,
+		RandomID: int64(req.RandID),
+	}
+	if replyTo := inputReplyTo(nil, req.TopMsgID); replyTo != nil {
+		request.SetReplyTo(replyTo)
+	}
+	updates, err := c.GetAPI().MessagesSendMedia(ctx, request)
+	if err != nil {
+		return nil, types.NewTelegramError(types.ErrorCodeSendFailed, "failed to send the poll", err)
+	}
+	return sentMessage(updates), nil
+}
+
+// sentMessage is the new message in the result of a send request, nil when it isn't there
+func sentMessage(updates tg.UpdatesClass) *tg.Message {
+	for _, update := range updateList(updates) {
+		switch u := update.(type) {
+		case *tg.UpdateNewMessage:
+			if message, ok := u.Message.(*tg.Message); ok {
+				return message
+			}
+		case *tg.UpdateNewChannelMessage:
+			if message, ok := u.Message.(*tg.Message); ok {
+				return message
+			}
+		}
+	}
+	return nil
+}
+
+func pollUpdateNotification(update *tg.UpdateMessagePoll) *types.PollUpdateNotification {
+	notification := &types.PollUpdateNotification{PollID: update.PollID, Results: update.Results}
+	if poll, ok := update.GetPoll(); ok {
+		notification.Poll = &poll
+	}
+	return notification
+}
+
+// updateList is the updates carried by the result of a request
+func updateList(updates tg.UpdatesClass) []tg.UpdateClass {
+	switch u := updates.(type) {
+	case *tg.Updates:
+		return u.Updates
+	case *tg.UpdatesCombined:
+		return u.Updates
+	case *tg.UpdateShort:
+		return []tg.UpdateClass{u.Update}
+	}
+	return nil
+}
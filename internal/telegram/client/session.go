@@ -10,26 +10,35 @@ import (
 )
 
 func newFileSessionStorage(account string) (*telegram.FileSessionStorage, error) {
+	sessionDir, err := accountDir(account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &telegram.FileSessionStorage{
+		Path: filepath.Join(sessionDir, "session.json"),
+	}, nil
+}
+
+// accountDir returns ~/.cligram/<account>, creating it when missing
+func accountDir(account string) (string, error) {
 	if account == "" {
-		return nil, errors.New("account cannot be empty")
+		return "", errors.New("account cannot be empty")
 	}
 	if account == "." || account == ".." ||
 		strings.Contains(account, string(filepath.Separator)) {
-		return nil, errors.New("invalid account name")
+		return "", errors.New("invalid account name")
 	}
 
 	userHomeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	sessionDir := filepath.Join(userHomeDir, ".cligram", account)
-	if err := ensureDir(sessionDir); err != nil {
-		return nil, err
+	dir := filepath.Join(userHomeDir, ".cligram", account)
+	if err := ensureDir(dir); err != nil {
+		return "", err
 	}
-
-	return &telegram.FileSessionStorage{
-		Path: filepath.Join(sessionDir, "session.json"),
-	}, nil
+	return dir, nil
 }
 
 func ensureDir(dir string) error {
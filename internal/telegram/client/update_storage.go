@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gotd/td/telegram/updates"
+)
+
+var (
+	_ updates.StateStorage        = (*fileUpdateStorage)(nil)
+	_ updates.ChannelAccessHasher = (*fileUpdateStorage)(nil)
+)
+
+var errUpdateStateNotFound = errors.New("update state not found")
+
+// fileUpdateStorage persists pts/qts/seq/date, channel pts and channel access
+// hashes to ~/.cligram/<account>/updates.json. with this the updates manager
+// knows where we stopped last time and fills the gap with getDifference on
+// startup instead of silently skipping whatever arrived while we were closed.
+type fileUpdateStorage struct {
+	path string
+	mu   sync.Mutex
+	data updateStorageData
+}
+
+type updateStorageData struct {
+	States       map[int64]updates.State   `json:"states"`
+	Channels     map[int64]map[int64]int   `json:"channels"`
+	AccessHashes map[int64]map[int64]int64 `json:"accessHashes"`
+}
+
+func newFileUpdateStorage(account string) (*fileUpdateStorage, error) {
+	dir, err := accountDir(account)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileUpdateStorage{
+		path: filepath.Join(dir, "updates.json"),
+		data: updateStorageData{
+			States:       map[int64]updates.State{},
+			Channels:     map[int64]map[int64]int{},
+			AccessHashes: map[int64]map[int64]int64{},
+		},
+	}
+
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &s.data); err != nil {
+		// a corrupted state only costs us the missed updates, start from the remote state
+		return s, nil
+	}
+	if s.data.States == nil {
+		s.data.States = map[int64]updates.State{}
+	}
+	if s.data.Channels == nil {
+		s.data.Channels = map[int64]map[int64]int{}
+	}
+	if s.data.AccessHashes == nil {
+		s.data.AccessHashes = map[int64]map[int64]int64{}
+	}
+	return s, nil
+}
+
+func (s *fileUpdateStorage) GetState(_ context.Context, userID int64) (updates.State, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, found := s.data.States[userID]
+	return state, found, nil
+}
+
+func (s *fileUpdateStorage) SetState(_ context.Context, userID int64, state updates.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.States[userID] = state
+	// a new common state makes the saved channel pts meaningless
+	s.data.Channels[userID] = map[int64]int{}
+	return s.flush()
+}
+
+func (s *fileUpdateStorage) SetPts(_ context.Context, userID int64, pts int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Pts = pts })
+}
+
+func (s *fileUpdateStorage) SetQts(_ context.Context, userID int64, qts int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Qts = qts })
+}
+
+func (s *fileUpdateStorage) SetDate(_ context.Context, userID int64, date int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Date = date })
+}
+
+func (s *fileUpdateStorage) SetSeq(_ context.Context, userID int64, seq int) error {
+	return s.updateState(userID, func(state *updates.State) { state.Seq = seq })
+}
+
+func (s *fileUpdateStorage) SetDateSeq(_ context.Context, userID int64, date, seq int) error {
+	return s.updateState(userID, func(state *updates.State) {
+		state.Date = date
+		state.Seq = seq
+	})
+}
+
+func (s *fileUpdateStorage) GetChannelPts(_ context.Context, userID, channelID int64) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels, ok := s.data.Channels[userID]
+	if !ok {
+		return 0, false, nil
+	}
+	pts, found := channels[channelID]
+	return pts, found, nil
+}
+
+func (s *fileUpdateStorage) SetChannelPts(_ context.Context, userID, channelID int64, pts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels, ok := s.data.Channels[userID]
+	if !ok {
+		return errUpdateStateNotFound
+	}
+	channels[channelID] = pts
+	return s.flush()
+}
+
+func (s *fileUpdateStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	s.mu.Lock()
+	channels := make(map[int64]int, len(s.data.Channels[userID]))
+	for id, pts := range s.data.Channels[userID] {
+		channels[id] = pts
+	}
+	s.mu.Unlock()
+
+	// f calls back into the access hasher so it must run without holding the lock
+	for id, pts := range channels {
+		if err := f(ctx, id, pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileUpdateStorage) SetChannelAccessHash(_ context.Context, userID, channelID, accessHash int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashes, ok := s.data.AccessHashes[userID]
+	if !ok {
+		hashes = map[int64]int64{}
+		s.data.AccessHashes[userID] = hashes
+	}
+	hashes[channelID] = accessHash
+	return s.flush()
+}
+
+func (s *fileUpdateStorage) GetChannelAccessHash(_ context.Context, userID, channelID int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash, found := s.data.AccessHashes[userID][channelID]
+	return hash, found, nil
+}
+
+func (s *fileUpdateStorage) updateState(userID int64, update func(state *updates.State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.data.States[userID]
+	if !ok {
+		return errUpdateStateNotFound
+	}
+	update(&state)
+	s.data.States[userID] = state
+	return s.flush()
+}
+
+// flush must be called with s.mu held
+func (s *fileUpdateStorage) flush() error {
+	content, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -14,18 +14,42 @@ import (
 	"github.com/kumneger0/cligram/internal/telegram/types"
 )
 
-func newUpdateHandler(updateChannel chan types.Notification) telegram.UpdateHandler {
+func newUpdateHandler(notifications *notificationQueue, storage *fileUpdateStorage, peers *peerCache, botCommands *botCommandCache) *updates.Manager {
 	dispatcher := tg.NewUpdateDispatcher()
 	dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
+		if service, ok := update.Message.(*tg.MessageService); ok {
+			forgetChatBots(notifications, botCommands, service)
+			pushServiceMessage(notifications, e, service)
+			return nil
+		}
 		msg, ok := update.Message.(*tg.Message)
 		if !ok {
 			return nil
 		}
 
-		if peerClass, ok := msg.GetFromID(); ok {
-			slog.Debug("received channel message", "peer", peerClass)
+		peer, ok := msg.GetPeerID().(*tg.PeerChannel)
+		if !ok {
+			slog.Warn("channel message without channel peer", "peer", msg.GetPeerID())
 			return nil
 		}
+
+		// supergroups are channels too, only broadcast ones belong to the channels list
+		peerType := types.GroupChat
+		if channel, ok := e.Channels[peer.ChannelID]; ok && channel.Broadcast {
+			peerType = types.ChannelChat
+		}
+
+		notification := types.Notification{
+			NewMessage: &types.NewMessageNotification{
+				ID:       msg.GetID(),
+				FromID:   strconv.FormatInt(peer.ChannelID, 10),
+				Message:  msg,
+				PeerType: peerType,
+				Sender:   messageSender(e, msg),
+			},
+		}
+
+		notifications.Push(notification)
 		return nil
 	})
 
@@ -57,15 +81,16 @@ func newUpdateHandler(updateChannel chan types.Notification) telegram.UpdateHand
 			},
 		}
 
-		select {
-		case updateChannel <- notification:
-		default:
-			slog.Warn("update channel is full, dropping read history outbox notification")
-		}
+		notifications.Push(notification)
 		return nil
 	})
 
 	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewMessage) error {
+		if service, ok := update.Message.(*tg.MessageService); ok {
+			forgetChatBots(notifications, botCommands, service)
+			pushServiceMessage(notifications, e, service)
+			return nil
+		}
 		msg, ok := update.Message.(*tg.Message)
 		if !ok {
 			return nil
@@ -85,6 +110,15 @@ func newUpdateHandler(updateChannel chan types.Notification) telegram.UpdateHand
 			}
 		}
 
+		var peerType types.ChatType
+		var sender *types.UserInfo
+		// basic group messages come through here as well, they belong to the chat and not the sender
+		if chatPeer, ok := msg.GetPeerID().(*tg.PeerChat); ok {
+			peerClass = chatPeer
+			peerType = types.GroupChat
+			sender = messageSender(e, msg)
+		}
+
 		if peerClass != nil {
 			var fromID string
 			switch peer := peerClass.(type) {
@@ -101,49 +135,187 @@ func newUpdateHandler(updateChannel chan types.Notification) telegram.UpdateHand
 
 			notification := types.Notification{
 				NewMessage: &types.NewMessageNotification{
-					ID:      msg.GetID(),
-					FromID:  fromID,
-					Message: msg,
+					ID:       msg.GetID(),
+					FromID:   fromID,
+					Message:  msg,
+					PeerType: peerType,
+					Sender:   sender,
 				},
 			}
 
-			select {
-			case updateChannel <- notification:
-			default:
-				slog.Warn("update channel is full, dropping message")
-			}
+			notifications.Push(notification)
 		} else {
 			slog.Error("could not determine peer from message")
 		}
 		return nil
 	})
 
-	dispatcher.OnUserTyping(func(ctx context.Context, e tg.Entities, update *tg.UpdateUserTyping) error {
-		userID := update.UserID
-		userInfo, err := shared.GetUserInfo(ctx, *Cligram.API(), userID)
+	onEditMessage := func(e tg.Entities, message tg.MessageClass) {
+		msg, ok := message.(*tg.Message)
+		if !ok {
+			return
+		}
+		peerID, peerType, ok := notificationPeer(e, msg.GetPeerID())
+		if !ok {
+			return
+		}
+
+		notification := types.Notification{
+			MessageEdited: &types.MessageEditedNotification{
+				PeerID:   peerID,
+				PeerType: peerType,
+				Message:  msg,
+			},
+		}
+
+		notifications.Push(notification)
+	}
+
+	dispatcher.OnEditMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditMessage) error {
+		onEditMessage(e, update.Message)
+		return nil
+	})
+
+	dispatcher.OnEditChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditChannelMessage) error {
+		onEditMessage(e, update.Message)
+		return nil
+	})
+
+	onDeleteMessages := func(peerID string, messageIDs []int) {
+		notification := types.Notification{
+			MessagesDeleted: &types.MessagesDeletedNotification{
+				PeerID:     peerID,
+				MessageIDs: messageIDs,
+			},
+		}
+
+		notifications.Push(notification)
+	}
+
+	dispatcher.OnDeleteMessages(func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteMessages) error {
+		onDeleteMessages("", update.Messages)
+		return nil
+	})
+
+	dispatcher.OnDeleteChannelMessages(func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteChannelMessages) error {
+		onDeleteMessages(strconv.FormatInt(update.ChannelID, 10), update.Messages)
+		return nil
+	})
+
+	dispatcher.OnMessageReactions(func(ctx context.Context, e tg.Entities, update *tg.UpdateMessageReactions) error {
+		peerID, peerType, ok := notificationPeer(e, update.Peer)
+		if !ok {
+			return nil
+		}
+
+		notification := types.Notification{
+			MessageReactions: &types.MessageReactionsNotification{
+				PeerID:    peerID,
+				PeerType:  peerType,
+				MessageID: update.MsgID,
+				Reactions: update.Reactions,
+			},
+		}
+
+		notifications.Push(notification)
+		return nil
+	})
+
+	dispatcher.OnMessagePoll(func(ctx context.Context, e tg.Entities, update *tg.UpdateMessagePoll) error {
+		notifications.Push(types.Notification{PollUpdate: pollUpdateNotification(update)})
+		return nil
+	})
+
+	dispatcher.OnBotCommands(func(ctx context.Context, e tg.Entities, update *tg.UpdateBotCommands) error {
+		botCommands.setCommands(update.BotID, update.Commands)
+		peerID, _, ok := notificationPeer(e, update.Peer)
+		if !ok {
+			return nil
+		}
+		notifications.Push(types.Notification{BotCommands: &types.BotCommandsNotification{
+			PeerID: peerID,
+			BotID:  strconv.FormatInt(update.BotID, 10),
+		}})
+		return nil
+	})
+
+	// a bot added to or removed from a group changes its commands
+	dispatcher.OnChatParticipants(func(ctx context.Context, e tg.Entities, update *tg.UpdateChatParticipants) error {
+		forgetGroupBots(notifications, botCommands, update.Participants.GetChatID())
+		return nil
+	})
+	dispatcher.OnChatParticipantAdd(func(ctx context.Context, e tg.Entities, update *tg.UpdateChatParticipantAdd) error {
+		forgetGroupBots(notifications, botCommands, update.ChatID)
+		return nil
+	})
+	dispatcher.OnChatParticipantDelete(func(ctx context.Context, e tg.Entities, update *tg.UpdateChatParticipantDelete) error {
+		forgetGroupBots(notifications, botCommands, update.ChatID)
+		return nil
+	})
+	dispatcher.OnChannelParticipant(func(ctx context.Context, e tg.Entities, update *tg.UpdateChannelParticipant) error {
+		forgetGroupBots(notifications, botCommands, update.ChannelID)
+		return nil
+	})
+	dispatcher.OnChannel(func(ctx context.Context, e tg.Entities, update *tg.UpdateChannel) error {
+		forgetGroupBots(notifications, botCommands, update.ChannelID)
+		return nil
+	})
+
+	onTyping := func(ctx context.Context, e tg.Entities, userID int64, peerID string, peerType types.ChatType, action tg.SendMessageActionClass) error {
+		typingAction, ok := typingActionFromTG(action)
+		if !ok {
+			return nil
+		}
+
+		userInfo, err := peers.UserInfo(ctx, Cligram.API(), userID)
 		if err != nil {
 			return types.NewTelegramError(types.ErrorCodeUserNotFound, err.Error(), nil)
 		}
 
 		userInfo.IsOnline = true
-		userInfo.IsTyping = true
+		userInfo.IsTyping = typingAction != types.TypingActionCancel
 		notification := types.Notification{
 			UserTyping: &types.UserTypingNotification{
-				User: *userInfo,
+				User:     *userInfo,
+				PeerID:   peerID,
+				PeerType: peerType,
+				Action:   typingAction,
 			},
 		}
 
-		select {
-		case updateChannel <- notification:
-		default:
-			slog.Warn("update channel is full, dropping typing notification")
-		}
+		notifications.Push(notification)
 		return nil
+	}
+
+	dispatcher.OnUserTyping(func(ctx context.Context, e tg.Entities, update *tg.UpdateUserTyping) error {
+		return onTyping(ctx, e, update.UserID, strconv.FormatInt(update.UserID, 10), types.UserChat, update.Action)
+	})
+
+	dispatcher.OnChatUserTyping(func(ctx context.Context, e tg.Entities, update *tg.UpdateChatUserTyping) error {
+		from, ok := update.FromID.(*tg.PeerUser)
+		if !ok {
+			// anonymous admins type as the chat itself, nothing useful to show
+			return nil
+		}
+		return onTyping(ctx, e, from.UserID, strconv.FormatInt(update.ChatID, 10), types.GroupChat, update.Action)
+	})
+
+	dispatcher.OnChannelUserTyping(func(ctx context.Context, e tg.Entities, update *tg.UpdateChannelUserTyping) error {
+		from, ok := update.FromID.(*tg.PeerUser)
+		if !ok {
+			return nil
+		}
+		peerType := types.GroupChat
+		if channel, ok := e.Channels[update.ChannelID]; ok && channel.Broadcast {
+			peerType = types.ChannelChat
+		}
+		return onTyping(ctx, e, from.UserID, strconv.FormatInt(update.ChannelID, 10), peerType, update.Action)
 	})
 
 	dispatcher.OnUserStatus(func(ctx context.Context, e tg.Entities, update *tg.UpdateUserStatus) error {
 		userID := update.UserID
-		userInfo, err := shared.GetUserInfo(ctx, *Cligram.API(), userID)
+		peers.SetUserStatus(userID, update.Status)
+		userInfo, err := peers.UserInfo(ctx, Cligram.API(), userID)
 		if err != nil {
 			return types.NewTelegramError(types.ErrorCodeUserNotFound, err.Error(), nil)
 		}
@@ -179,15 +351,166 @@ func newUpdateHandler(updateChannel chan types.Notification) telegram.UpdateHand
 			},
 		}
 
-		select {
-		case updateChannel <- notification:
-		default:
-			slog.Warn("update channel is full, dropping status notification")
-		}
+		notifications.Push(notification)
 		return nil
 	})
 
-	return updates.New(updates.Config{
-		Handler: dispatcher,
+	// every entity that comes with an update lands in the peer cache before the handlers run
+	handler := telegram.UpdateHandlerFunc(func(ctx context.Context, u tg.UpdatesClass) error {
+		switch u := u.(type) {
+		case *tg.Updates:
+			pushBotInfoChanges(notifications, peers, u.Users)
+			peers.AddUsers(u.Users...)
+			peers.AddChats(u.Chats...)
+		case *tg.UpdatesCombined:
+			pushBotInfoChanges(notifications, peers, u.Users)
+			peers.AddUsers(u.Users...)
+			peers.AddChats(u.Chats...)
+		}
+		return dispatcher.Handle(ctx, u)
+	})
+
+	config := updates.Config{
+		Handler: handler,
+		OnChannelTooLong: func(channelID int64) {
+			slog.Warn("too many missed updates in channel, history has to be refetched", "channelID", channelID)
+			notifications.Push(types.Notification{ChannelTooLong: &types.ChannelTooLongNotification{
+				PeerID: strconv.FormatInt(channelID, 10),
+			}})
+		},
+	}
+	// without a storage the manager falls back to memory, so nothing survives a restart
+	if storage != nil {
+		config.Storage = storage
+		config.AccessHasher = storage
+	}
+	return updates.New(config)
+}
+
+// messageSender looks up the author of a group message in the entities that came with the update
+func messageSender(e tg.Entities, msg *tg.Message) *types.UserInfo {
+	from, ok := msg.GetFromID()
+	if !ok {
+		return nil
+	}
+	peerUser, ok := from.(*tg.PeerUser)
+	if !ok {
+		return nil
+	}
+	user, ok := e.Users[peerUser.UserID]
+	if !ok {
+		return nil
+	}
+	return shared.ConvertTGUserToUserInfo(user)
+}
+
+// pushServiceMessage tells the ui something happened in a chat, the message goes to the chat it
+// happened in and not to who did it
+func pushServiceMessage(notifications *notificationQueue, e tg.Entities, msg *tg.MessageService) {
+	peerID, peerType, ok := notificationPeer(e, msg.PeerID)
+	if !ok {
+		return
+	}
+	formatted := shared.FormatServiceMessage(msg, shared.UpdatePeerNames(e))
+	formatted.PeerID = &peerID
+	notifications.Push(types.Notification{
+		ServiceMessage: &types.ServiceMessageNotification{
+			PeerID:   peerID,
+			PeerType: peerType,
+			TopicID:  shared.ReplyTopicID(msg.ReplyTo),
+			Message:  *formatted,
+		},
 	})
 }
+
+// notificationPeer turns the peer of a message into the id and chat type the ui lists use
+func notificationPeer(e tg.Entities, peerClass tg.PeerClass) (string, types.ChatType, bool) {
+	switch peer := peerClass.(type) {
+	case *tg.PeerUser:
+		return strconv.FormatInt(peer.UserID, 10), types.UserChat, true
+	case *tg.PeerChat:
+		return strconv.FormatInt(peer.ChatID, 10), types.GroupChat, true
+	case *tg.PeerChannel:
+		peerType := types.GroupChat
+		if channel, ok := e.Channels[peer.ChannelID]; ok && channel.Broadcast {
+			peerType = types.ChannelChat
+		}
+		return strconv.FormatInt(peer.ChannelID, 10), peerType, true
+	default:
+		slog.Warn("unknown peer type", "peer", peerClass)
+		return "", "", false
+	}
+}
+
+// typingActionFromTG maps a send action to what we display, emoji interactions and
+// group call activity aren't typing so they are skipped
+func typingActionFromTG(action tg.SendMessageActionClass) (types.TypingAction, bool) {
+	switch action.(type) {
+	case *tg.SendMessageTypingAction:
+		return types.TypingActionTyping, true
+	case *tg.SendMessageCancelAction:
+		return types.TypingActionCancel, true
+	case *tg.SendMessageUploadPhotoAction:
+		return types.TypingActionUploadPhoto, true
+	case *tg.SendMessageUploadVideoAction:
+		return types.TypingActionUploadVideo, true
+	case *tg.SendMessageUploadDocumentAction:
+		return types.TypingActionUploadDocument, true
+	case *tg.SendMessageUploadAudioAction:
+		return types.TypingActionUploadAudio, true
+	case *tg.SendMessageUploadRoundAction:
+		return types.TypingActionUploadRound, true
+	case *tg.SendMessageRecordAudioAction:
+		return types.TypingActionRecordVoice, true
+	case *tg.SendMessageRecordVideoAction:
+		return types.TypingActionRecordVideo, true
+	case *tg.SendMessageRecordRoundAction:
+		return types.TypingActionRecordRound, true
+	case *tg.SendMessageChooseStickerAction:
+		return types.TypingActionChooseSticker, true
+	case *tg.SendMessageChooseContactAction:
+		return types.TypingActionChooseContact, true
+	case *tg.SendMessageGeoLocationAction:
+		return types.TypingActionGeoLocation, true
+	case *tg.SendMessageGamePlayAction:
+		return types.TypingActionGamePlay, true
+	case *tg.SendMessageHistoryImportAction:
+		return types.TypingActionImportHistory, true
+	default:
+		return "", false
+	}
+}
+
+// forgetChatBots drops the bot list of a group when a service message says someone joined or
+// left, supergroups don't send participant updates to everyone
+func forgetChatBots(notifications *notificationQueue, botCommands *botCommandCache, service *tg.MessageService) {
+	switch service.Action.(type) {
+	case *tg.MessageActionChatAddUser, *tg.MessageActionChatDeleteUser, *tg.MessageActionChatJoinedByLink, *tg.MessageActionChatJoinedByRequest:
+	default:
+		return
+	}
+	switch peer := service.PeerID.(type) {
+	case *tg.PeerChat:
+		forgetGroupBots(notifications, botCommands, peer.ChatID)
+	case *tg.PeerChannel:
+		forgetGroupBots(notifications, botCommands, peer.ChannelID)
+	}
+}
+
+// forgetGroupBots drops the bot list of a group and tells the ui, an open command menu of the
+// group is loaded again
+func forgetGroupBots(notifications *notificationQueue, botCommands *botCommandCache, peerID int64) {
+	botCommands.forgetChat(peerID)
+	notifications.Push(types.Notification{BotCommands: &types.BotCommandsNotification{
+		PeerID: strconv.FormatInt(peerID, 10),
+	}})
+}
+
+// pushBotInfoChanges tells the ui about bots whose info version went up, their cached commands
+// are outdated now
+func pushBotInfoChanges(notifications *notificationQueue, peers *peerCache, users []tg.UserClass) {
+	for _, botID := range peers.ChangedBots(users...) {
+		id := strconv.FormatInt(botID, 10)
+		notifications.Push(types.Notification{BotCommands: &types.BotCommandsNotification{PeerID: id, BotID: id}})
+	}
+}
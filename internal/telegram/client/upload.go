@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+const (
+	// parts of a big file uploaded at the same time, small files go up in one request anyway
+	uploadThreads = 4
+	// how often a running upload reports its progress
+	uploadProgressInterval = 250 * time.Millisecond
+	// the peer shows "sending a file" for a few seconds only, so it is sent again this often
+	uploadActionInterval = 4 * time.Second
+)
+
+// runningUploads lets the ui cancel a file send while it is uploading, keyed by rand id
+type runningUploads struct {
+	mu     sync.Mutex
+	cancel map[int]context.CancelFunc
+}
+
+func (u *runningUploads) start(ctx context.Context, randID int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	u.mu.Lock()
+	if u.cancel == nil {
+		u.cancel = map[int]context.CancelFunc{}
+	}
+	u.cancel[randID] = cancel
+	u.mu.Unlock()
+	return ctx, func() {
+		u.mu.Lock()
+		delete(u.cancel, randID)
+		u.mu.Unlock()
+		cancel()
+	}
+}
+
+func (u *runningUploads) Running(randID int) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.cancel[randID] != nil
+}
+
+func (u *runningUploads) Cancel(randID int) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cancel, ok := u.cancel[randID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// CancelUpload stops a file send that is still running and drops it from the outbox so it isn't
+// retried, false when there is nothing running for randID
+func (c *Client) CancelUpload(randID int) bool {
+	if !c.uploads.Running(randID) {
+		return false
+	}
+	c.DiscardOutboxMessage(randID)
+	return c.uploads.Cancel(randID)
+}
+
+// uploadFile puts a file of a send on the server in parallel parts
+func (c *Client) uploadFile(ctx context.Context, file *os.File, size int64, progress *uploadProgress) (tg.InputFileClass, error) {
+	fileUpload, err := uploader.NewUploader(c.GetAPI()).
+		WithPartSize(uploader.MaximumPartSize).
+		WithThreads(uploadThreads).
+		WithProgress(progress).
+		Upload(ctx, uploader.NewUpload(filepath.Base(file.Name()), file, size))
+	if err != nil {
+		return nil, types.NewTelegramError(types.ErrorCodeUploadFailed, "failed to upload file", err)
+	}
+	return fileUpload, nil
+}
+
+// uploadProgress reports a running upload to the ui and to the peer as an upload action
+type uploadProgress struct {
+	client   *Client
+	req      types.SendMessageRequest
+	peer     tg.InputPeerClass
+	fileName string
+	// an album goes up file by file, done is what the files before this one took and total
+	// the size of all of them. both are zero for a single file
+	done, total int64
+
+	mu         sync.Mutex
+	lastReport time.Time
+	lastAction time.Time
+}
+
+func (p *uploadProgress) Chunk(ctx context.Context, state uploader.ProgressState) error {
+	uploaded, total := p.done+state.Uploaded, state.Total
+	if p.total > 0 {
+		total = p.total
+	}
+	now := time.Now()
+	p.mu.Lock()
+	report := now.Sub(p.lastReport) >= uploadProgressInterval || uploaded >= total
+	if report {
+		p.lastReport = now
+	}
+	action := now.Sub(p.lastAction) >= uploadActionInterval
+	if action {
+		p.lastAction = now
+	}
+	p.mu.Unlock()
+
+	if report {
+		p.client.notifications.Push(types.Notification{Upload: &types.UploadProgressNotification{
+			RandID:   p.req.RandID,
+			Peer:     p.req.Peer,
+			FileName: p.fileName,
+			Uploaded: uploaded,
+			Total:    total,
+		}})
+	}
+	if action {
+		percent := 0
+		if total > 0 {
+			percent = int(uploaded * 100 / total)
+		}
+		// the action is cosmetic, it must not hold up the upload
+		go p.sendAction(ctx, percent)
+	}
+	return nil
+}
+
+func (p *uploadProgress) sendAction(ctx context.Context, percent int) {
+	request := &tg.MessagesSetTypingRequest{
+		Peer:   p.peer,
+		Action: &tg.SendMessageUploadDocumentAction{Progress: percent},
+	}
+	if p.req.TopMsgID != nil {
+		request.SetTopMsgID(*p.req.TopMsgID)
+	}
+	if _, err := p.client.GetAPI().MessagesSetTyping(ctx, request); err != nil && ctx.Err() == nil {
+		slog.Debug("failed to send upload action", "error", err)
+	}
+}
@@ -0,0 +1,84 @@
+package shared
+
+import (
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// InlineKeyboard returns the rows of buttons under a bot message, nil when it has none
+func InlineKeyboard(markup tg.ReplyMarkupClass) [][]types.KeyboardButton {
+	inline, ok := markup.(*tg.ReplyInlineMarkup)
+	if !ok {
+		return nil
+	}
+	return keyboardRows(inline.Rows)
+}
+
+// ReplyKeyboard returns the keyboard a bot message shows in place of ours, or one that takes it
+// away. nil when the message doesn't change the keyboard
+func ReplyKeyboard(markup tg.ReplyMarkupClass) *types.ReplyKeyboard {
+	switch markup := markup.(type) {
+	case *tg.ReplyKeyboardMarkup:
+		return &types.ReplyKeyboard{
+			Rows:        keyboardRows(markup.Rows),
+			Placeholder: markup.Placeholder,
+			SingleUse:   markup.SingleUse,
+		}
+	case *tg.ReplyKeyboardHide:
+		return &types.ReplyKeyboard{Hide: true}
+	}
+	return nil
+}
+
+func keyboardRows(rows []tg.KeyboardButtonRow) [][]types.KeyboardButton {
+	keyboard := make([][]types.KeyboardButton, 0, len(rows))
+	for _, row := range rows {
+		buttons := make([]types.KeyboardButton, 0, len(row.Buttons))
+		for _, button := range row.Buttons {
+			buttons = append(buttons, keyboardButton(button))
+		}
+		if len(buttons) > 0 {
+			keyboard = append(keyboard, buttons)
+		}
+	}
+	if len(keyboard) == 0 {
+		return nil
+	}
+	return keyboard
+}
+
+func keyboardButton(button tg.KeyboardButtonClass) types.KeyboardButton {
+	result := types.KeyboardButton{Text: button.GetText(), Kind: types.ButtonUnsupported}
+	switch button := button.(type) {
+	case *tg.KeyboardButton:
+		result.Kind = types.ButtonText
+	case *tg.KeyboardButtonCallback:
+		result.Kind = types.ButtonCallback
+		result.Data = button.Data
+		result.RequiresPassword = button.RequiresPassword
+	case *tg.KeyboardButtonGame:
+		result.Kind = types.ButtonGame
+	case *tg.KeyboardButtonURL:
+		result.Kind = types.ButtonURL
+		result.URL = button.URL
+	case *tg.KeyboardButtonURLAuth:
+		// logging in with telegram on the site needs a confirmation we don't ask for, the plain link
+		// still works
+		result.Kind = types.ButtonURL
+		result.URL = button.URL
+	case *tg.KeyboardButtonWebView:
+		result.Kind = types.ButtonURL
+		result.URL = button.URL
+	case *tg.KeyboardButtonSimpleWebView:
+		result.Kind = types.ButtonURL
+		result.URL = button.URL
+	case *tg.KeyboardButtonSwitchInline:
+		result.Kind = types.ButtonSwitchInline
+		result.Query = button.Query
+		result.SamePeer = button.SamePeer
+	case *tg.KeyboardButtonCopy:
+		result.Kind = types.ButtonCopy
+		result.CopyText = button.CopyText
+	}
+	return result
+}
@@ -0,0 +1,664 @@
+package shared
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/config"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	xhtml "golang.org/x/net/html"
+)
+
+// MarkupMode is how typed text is turned into formatting, chosen with chat.messageFormat
+type MarkupMode string
+
+const (
+	MarkupMarkdown MarkupMode = "markdown"
+	MarkupHTML     MarkupMode = "html"
+	MarkupPlain    MarkupMode = "plain"
+)
+
+// characters a backslash makes literal in markdown mode
+const markdownEscapable = "\\`*_~|[]()"
+
+var markdownSpans = []struct {
+	marker string
+	entity types.EntityType
+}{
+	{"**", types.EntityBold},
+	{"__", types.EntityItalic},
+	{"~~", types.EntityStrike},
+	{"||", types.EntitySpoiler},
+}
+
+func ConfiguredMarkup() MarkupMode {
+	format := config.GetConfig().Chat.MessageFormat
+	if format == nil {
+		return MarkupMarkdown
+	}
+	switch mode := MarkupMode(strings.ToLower(*format)); mode {
+	case MarkupHTML, MarkupPlain:
+		return mode
+	}
+	return MarkupMarkdown
+}
+
+// ParseMarkup turns what the user typed into the text to send and its entities
+func ParseMarkup(input string) (string, []types.MessageEntity) {
+	return ParseMarkupAs(ConfiguredMarkup(), input)
+}
+
+// ParseMarkupAs is ParseMarkup with the mode picked by the caller, an empty mode is the configured one
+func ParseMarkupAs(mode MarkupMode, input string) (string, []types.MessageEntity) {
+	if mode == "" {
+		mode = ConfiguredMarkup()
+	}
+	switch mode {
+	case MarkupHTML:
+		return parseHTML(input)
+	case MarkupPlain:
+		return input, nil
+	}
+	return parseMarkdown(input)
+}
+
+// markupBuilder collects the parsed text and keeps its length in UTF-16 code units,
+// which is what entity offsets are counted in
+type markupBuilder struct {
+	text     strings.Builder
+	length   int
+	entities []types.MessageEntity
+}
+
+func (b *markupBuilder) WriteString(s string) {
+	b.text.WriteString(s)
+	b.length += utf16Len(s)
+}
+
+// wrap adds an entity around whatever write puts into the builder
+func (b *markupBuilder) wrap(entity types.MessageEntity, write func()) {
+	start := b.length
+	write()
+	if b.length == start {
+		return
+	}
+	entity.Offset = start
+	entity.Length = b.length - start
+	b.entities = append(b.entities, entity)
+}
+
+func (b *markupBuilder) result() (string, []types.MessageEntity) {
+	// outer entities first, telegram wants them ordered by offset
+	slices.SortStableFunc(b.entities, func(x, y types.MessageEntity) int {
+		if x.Offset != y.Offset {
+			return x.Offset - y.Offset
+		}
+		return y.Length - x.Length
+	})
+	return b.text.String(), b.entities
+}
+
+func utf16Len(s string) int {
+	length := 0
+	for _, r := range s {
+		length += max(1, utf16.RuneLen(r))
+	}
+	return length
+}
+
+// parseMarkdown understands **bold**, __italic__, ~~strike~~, ||spoiler||, `code`,
+// ```lang\ncode``` blocks and [text](url) links. a backslash makes the next markup
+// character literal and markers without a closing pair are left as they are, so are markers
+// inside words like __init__.py
+func parseMarkdown(input string) (string, []types.MessageEntity) {
+	b := &markupBuilder{}
+	writeMarkdown(b, input)
+	return b.result()
+}
+
+func writeMarkdown(b *markupBuilder, s string) {
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		if n := writeMarkdownToken(b, s[:i], rest); n > 0 {
+			i += n
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(rest[:size])
+		i += size
+	}
+}
+
+// writeMarkdownToken writes the markup s starts with and returns how much of s it used,
+// 0 when s doesn't start with complete markup. before is the text in front of s
+func writeMarkdownToken(b *markupBuilder, before, s string) int {
+	switch {
+	case s[0] == '\\' && len(s) > 1 && strings.IndexByte(markdownEscapable, s[1]) >= 0:
+		b.WriteString(s[1:2])
+		return 2
+	case strings.HasPrefix(s, "```"):
+		end := strings.Index(s[3:], "```")
+		if end < 0 {
+			return 0
+		}
+		language, code := splitCodeLanguage(s[3 : 3+end])
+		if code == "" {
+			return 0
+		}
+		b.wrap(types.MessageEntity{Type: types.EntityPre, Language: language}, func() { b.WriteString(code) })
+		return 3 + end + 3
+	case s[0] == '`':
+		end := findMarkdownClosing(s[1:], "`")
+		if end <= 0 {
+			return 0
+		}
+		code := unescapeCode(s[1 : 1+end])
+		b.wrap(types.MessageEntity{Type: types.EntityCode}, func() { b.WriteString(code) })
+		return 1 + end + 1
+	case s[0] == '[':
+		text, url, n := parseMarkdownLink(s)
+		if n == 0 {
+			return 0
+		}
+		b.wrap(types.MessageEntity{Type: types.EntityTextURL, URL: url}, func() { writeMarkdown(b, text) })
+		return n
+	}
+	for _, span := range markdownSpans {
+		if !strings.HasPrefix(s, span.marker) {
+			continue
+		}
+		size := len(span.marker)
+		if !wordStart(before, s[size:]) {
+			return 0
+		}
+		end := findSpanClosing(s[size:], span.marker)
+		if end <= 0 {
+			return 0
+		}
+		b.wrap(types.MessageEntity{Type: span.entity}, func() { writeMarkdown(b, s[size:size+end]) })
+		return size + end + size
+	}
+	return 0
+}
+
+// findMarkdownClosing returns where marker closes in s, skipping escaped characters and
+// code spans so a marker inside `code` doesn't end the span around it
+func findMarkdownClosing(s, marker string) int {
+	for j := 0; j < len(s); j++ {
+		switch {
+		case s[j] == '\\' && j+1 < len(s):
+			j++
+		case strings.HasPrefix(s[j:], marker):
+			return j
+		case s[j] == '`':
+			if end := findMarkdownClosing(s[j+1:], "`"); end >= 0 {
+				j += end + 1
+			}
+		}
+	}
+	return -1
+}
+
+// findSpanClosing is findMarkdownClosing for markers that only close at the end of a word
+func findSpanClosing(s, marker string) int {
+	for from := 0; from < len(s); {
+		end := findMarkdownClosing(s[from:], marker)
+		if end < 0 {
+			return -1
+		}
+		end += from
+		if end > 0 && wordEnd(s[:end], s[end+len(marker):]) {
+			return end
+		}
+		from = end + 1
+	}
+	return -1
+}
+
+// wordStart tells whether a marker between before and after opens a span: nothing but
+// punctuation between it and the previous space, and no space right after it
+func wordStart(before, after string) bool {
+	if after == "" || startsWithSpace(after) {
+		return false
+	}
+	before = strings.TrimRightFunc(before, isMarkupPunct)
+	return before == "" || endsWithSpace(before)
+}
+
+// wordEnd is wordStart for closing markers, foo__bar__baz and __init__.py stay as they are
+func wordEnd(before, after string) bool {
+	if before == "" || endsWithSpace(before) {
+		return false
+	}
+	after = strings.TrimLeftFunc(after, isMarkupPunct)
+	return after == "" || startsWithSpace(after)
+}
+
+func isMarkupPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func startsWithSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func endsWithSpace(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func unescapeCode(code string) string {
+	return strings.NewReplacer("\\`", "`", "\\\\", "\\").Replace(code)
+}
+
+// splitCodeLanguage takes the language off the first line of a ``` block, a first line
+// with spaces in it is code and not a language
+func splitCodeLanguage(body string) (string, string) {
+	var language string
+	if first, code, ok := strings.Cut(body, "\n"); ok && !strings.ContainsFunc(first, unicode.IsSpace) {
+		language, body = first, code
+	}
+	return language, strings.TrimSuffix(body, "\n")
+}
+
+func parseMarkdownLink(s string) (text string, url string, n int) {
+	closing := findMarkdownClosing(s[1:], "]")
+	if closing <= 0 {
+		return "", "", 0
+	}
+	afterText := s[1+closing+1:]
+	if !strings.HasPrefix(afterText, "(") {
+		return "", "", 0
+	}
+	urlEnd := findLinkURLEnd(afterText)
+	url = afterText[1:max(1, urlEnd)]
+	if urlEnd <= 1 || strings.ContainsFunc(url, unicode.IsSpace) {
+		return "", "", 0
+	}
+	return s[1 : 1+closing], unescapeMarkdown(url), 1 + closing + 1 + urlEnd + 1
+}
+
+// findLinkURLEnd returns where the ( that s starts with is closed, urls like wikipedia's keep
+// their own balanced parentheses and others escape them. -1 when it never closes
+func findLinkURLEnd(s string) int {
+	depth := 0
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// unescapeMarkdown drops the backslashes in front of escapable characters
+func unescapeMarkdown(s string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(markdownEscapable, s[i+1]) >= 0 {
+			i++
+		}
+		unescaped.WriteByte(s[i])
+	}
+	return unescaped.String()
+}
+
+// parseHTML understands the tags telegram's own html mode does: b, i, u, s, code, pre,
+// a href, tg-spoiler and blockquote. unknown tags are dropped and their text kept
+func parseHTML(input string) (string, []types.MessageEntity) {
+	type openTag struct {
+		name   string
+		start  int
+		entity *types.MessageEntity
+	}
+	b := &markupBuilder{}
+	var stack []openTag
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(input))
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case xhtml.ErrorToken:
+			return b.result()
+		case xhtml.TextToken:
+			b.WriteString(string(tokenizer.Text()))
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data == "br" {
+				b.WriteString("\n")
+				continue
+			}
+			if tokenType == xhtml.SelfClosingTagToken {
+				continue
+			}
+			entity := htmlTagEntity(token)
+			// <pre><code class="language-go"> is a pre block with a language, not code inside pre
+			if token.Data == "code" && len(stack) > 0 && stack[len(stack)-1].name == "pre" {
+				if pre := stack[len(stack)-1].entity; pre != nil {
+					pre.Language = strings.TrimPrefix(htmlAttr(token, "class"), "language-")
+				}
+				entity = nil
+			}
+			stack = append(stack, openTag{name: token.Data, start: b.length, entity: entity})
+		case xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			i := len(stack) - 1
+			for i >= 0 && stack[i].name != string(name) {
+				i--
+			}
+			if i < 0 {
+				continue
+			}
+			// tags left open inside this one are closed with it
+			for _, tag := range slices.Backward(stack[i:]) {
+				if tag.entity != nil && b.length > tag.start {
+					entity := *tag.entity
+					entity.Offset = tag.start
+					entity.Length = b.length - tag.start
+					b.entities = append(b.entities, entity)
+				}
+			}
+			stack = stack[:i]
+		}
+	}
+}
+
+func htmlTagEntity(token xhtml.Token) *types.MessageEntity {
+	var entity types.MessageEntity
+	switch token.Data {
+	case "b", "strong":
+		entity.Type = types.EntityBold
+	case "i", "em":
+		entity.Type = types.EntityItalic
+	case "u", "ins":
+		entity.Type = types.EntityUnderline
+	case "s", "strike", "del":
+		entity.Type = types.EntityStrike
+	case "code":
+		entity.Type = types.EntityCode
+	case "pre":
+		entity.Type = types.EntityPre
+	case "blockquote":
+		entity.Type = types.EntityBlockquote
+	case "tg-spoiler":
+		entity.Type = types.EntitySpoiler
+	case "span":
+		if htmlAttr(token, "class") != "tg-spoiler" {
+			return nil
+		}
+		entity.Type = types.EntitySpoiler
+	case "a":
+		entity.URL = htmlAttr(token, "href")
+		if entity.URL == "" {
+			return nil
+		}
+		entity.Type = types.EntityTextURL
+	default:
+		return nil
+	}
+	return &entity
+}
+
+func htmlAttr(token xhtml.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// FormatMarkup is the opposite of ParseMarkup, it writes a message back as markup so that
+// editing it doesn't lose its formatting, the mode it was written in has to be used to parse
+// it again. markdown falls back to html for formatting it can't write, like underline
+func FormatMarkup(text string, entities []types.MessageEntity) (string, MarkupMode) {
+	mode := ConfiguredMarkup()
+	if mode == MarkupMarkdown && !markdownCanWrite(text, entities) {
+		mode = MarkupHTML
+	}
+	switch mode {
+	case MarkupHTML:
+		return formatMarkup(text, entities, htmlTags, html.EscapeString), mode
+	case MarkupPlain:
+		return text, mode
+	}
+	return formatMarkup(text, entities, markdownMarkers, escapeMarkdown), mode
+}
+
+// markdownCanWrite tells whether markdown has syntax for every entity html has syntax for and
+// its spans sit at word boundaries, markers inside a word don't parse back
+func markdownCanWrite(text string, entities []types.MessageEntity) bool {
+	units := utf16.Encode([]rune(text))
+	for _, entity := range entities {
+		if _, _, ok := htmlTags(entity); !ok {
+			continue
+		}
+		if _, _, ok := markdownMarkers(entity); !ok {
+			return false
+		}
+		if _, ok := markdownSpanMarker(entity.Type); !ok {
+			continue
+		}
+		end := min(len(units), entity.Offset+entity.Length)
+		before := string(utf16.Decode(units[:min(entity.Offset, end)]))
+		inner := string(utf16.Decode(units[min(entity.Offset, end):end]))
+		after := string(utf16.Decode(units[end:]))
+		if !wordStart(before, inner) || !wordEnd(inner, after) {
+			return false
+		}
+	}
+	return true
+}
+
+func markdownSpanMarker(entityType types.EntityType) (string, bool) {
+	for _, span := range markdownSpans {
+		if span.entity == entityType {
+			return span.marker, true
+		}
+	}
+	return "", false
+}
+
+func markdownMarkers(entity types.MessageEntity) (string, string, bool) {
+	if marker, ok := markdownSpanMarker(entity.Type); ok {
+		return marker, marker, true
+	}
+	switch entity.Type {
+	case types.EntityCode:
+		return "`", "`", true
+	case types.EntityPre:
+		return "```" + entity.Language + "\n", "\n```", true
+	case types.EntityTextURL:
+		return "[", "](" + escapeLinkURL(entity.URL) + ")", true
+	}
+	return "", "", false
+}
+
+// escapeLinkURL keeps parentheses in a url from closing the link early, balanced ones are left
+// alone so urls like wikipedia's stay readable
+func escapeLinkURL(url string) string {
+	if !strings.Contains(url, "\\") && findLinkURLEnd("("+url+")") == len(url)+1 {
+		return url
+	}
+	return strings.NewReplacer("\\", "\\\\", "(", "\\(", ")", "\\)").Replace(url)
+}
+
+func htmlTags(entity types.MessageEntity) (string, string, bool) {
+	switch entity.Type {
+	case types.EntityBold:
+		return "<b>", "</b>", true
+	case types.EntityItalic:
+		return "<i>", "</i>", true
+	case types.EntityUnderline:
+		return "<u>", "</u>", true
+	case types.EntityStrike:
+		return "<s>", "</s>", true
+	case types.EntitySpoiler:
+		return "<tg-spoiler>", "</tg-spoiler>", true
+	case types.EntityCode:
+		return "<code>", "</code>", true
+	case types.EntityBlockquote:
+		return "<blockquote>", "</blockquote>", true
+	case types.EntityPre:
+		if entity.Language != "" {
+			return `<pre><code class="language-` + html.EscapeString(entity.Language) + `">`, "</code></pre>", true
+		}
+		return "<pre>", "</pre>", true
+	case types.EntityTextURL:
+		return `<a href="` + html.EscapeString(entity.URL) + `">`, "</a>", true
+	}
+	return "", "", false
+}
+
+// escapeMarkdown keeps text that looks like markup literal, a single * or _ is never markup so
+// only doubled markers, backticks, brackets and backslashes that would escape something get a backslash
+func escapeMarkdown(text string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '`' || c == '[' || c == ']':
+			escaped.WriteByte('\\')
+		case c == '\\' && (i+1 == len(text) || strings.IndexByte(markdownEscapable, text[i+1]) >= 0):
+			escaped.WriteByte('\\')
+		case strings.IndexByte("*_~|", c) >= 0 && i+1 < len(text) && text[i+1] == c:
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(c)
+	}
+	return escaped.String()
+}
+
+func formatMarkup(text string, entities []types.MessageEntity, syntax func(types.MessageEntity) (string, string, bool), escape func(string) string) string {
+	type marker struct {
+		entity        types.MessageEntity
+		open, closing string
+	}
+	var markers []marker
+	for _, entity := range entities {
+		if open, closing, ok := syntax(entity); ok {
+			markers = append(markers, marker{entity: entity, open: open, closing: closing})
+		}
+	}
+	if len(markers) == 0 {
+		return escape(text)
+	}
+
+	units := utf16.Encode([]rune(text))
+	var out strings.Builder
+	var open []marker
+	verbatim := 0
+	position := 0
+	flush := func(to int) {
+		if to <= position {
+			return
+		}
+		chunk := string(utf16.Decode(units[position:to]))
+		if verbatim > 0 {
+			out.WriteString(escapeCode(chunk, escape))
+		} else {
+			out.WriteString(escape(chunk))
+		}
+		position = to
+	}
+	isCode := func(entity types.MessageEntity) bool {
+		return entity.Type == types.EntityCode || entity.Type == types.EntityPre
+	}
+
+	slices.SortStableFunc(markers, func(a, b marker) int {
+		if a.entity.Offset != b.entity.Offset {
+			return a.entity.Offset - b.entity.Offset
+		}
+		return b.entity.Length - a.entity.Length
+	})
+	next := 0
+	for position <= len(units) {
+		// close everything ending here, innermost first
+		for len(open) > 0 && open[len(open)-1].entity.Offset+open[len(open)-1].entity.Length <= position {
+			top := open[len(open)-1]
+			open = open[:len(open)-1]
+			out.WriteString(top.closing)
+			if isCode(top.entity) {
+				verbatim--
+			}
+		}
+		for next < len(markers) && markers[next].entity.Offset <= position {
+			current := markers[next]
+			next++
+			// markup can't express overlapping entities, the one that would cross is dropped
+			if len(open) > 0 && current.entity.Offset+current.entity.Length > open[len(open)-1].entity.Offset+open[len(open)-1].entity.Length {
+				continue
+			}
+			out.WriteString(current.open)
+			open = append(open, current)
+			if isCode(current.entity) {
+				verbatim++
+			}
+		}
+		if position == len(units) {
+			break
+		}
+		boundary := len(units)
+		if len(open) > 0 {
+			boundary = min(boundary, open[len(open)-1].entity.Offset+open[len(open)-1].entity.Length)
+		}
+		if next < len(markers) {
+			boundary = min(boundary, markers[next].entity.Offset)
+		}
+		flush(boundary)
+	}
+	return out.String()
+}
+
+// escapeCode escapes text inside code, markdown only needs backticks and backslashes escaped
+// there while html needs the same escaping everywhere
+func escapeCode(code string, escape func(string) string) string {
+	if escape("`") == "`" {
+		return escape(code)
+	}
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(code)
+}
+
+// ToTGEntities converts parsed entities for sending, links, mentions and the like in plain
+// text are detected by the server and don't need to be sent
+func ToTGEntities(entities []types.MessageEntity) []tg.MessageEntityClass {
+	var converted []tg.MessageEntityClass
+	for _, entity := range entities {
+		offset, length := entity.Offset, entity.Length
+		switch entity.Type {
+		case types.EntityBold:
+			converted = append(converted, &tg.MessageEntityBold{Offset: offset, Length: length})
+		case types.EntityItalic:
+			converted = append(converted, &tg.MessageEntityItalic{Offset: offset, Length: length})
+		case types.EntityUnderline:
+			converted = append(converted, &tg.MessageEntityUnderline{Offset: offset, Length: length})
+		case types.EntityStrike:
+			converted = append(converted, &tg.MessageEntityStrike{Offset: offset, Length: length})
+		case types.EntitySpoiler:
+			converted = append(converted, &tg.MessageEntitySpoiler{Offset: offset, Length: length})
+		case types.EntityCode:
+			converted = append(converted, &tg.MessageEntityCode{Offset: offset, Length: length})
+		case types.EntityPre:
+			converted = append(converted, &tg.MessageEntityPre{Offset: offset, Length: length, Language: entity.Language})
+		case types.EntityBlockquote:
+			converted = append(converted, &tg.MessageEntityBlockquote{Offset: offset, Length: length})
+		case types.EntityTextURL:
+			converted = append(converted, &tg.MessageEntityTextURL{Offset: offset, Length: length, URL: entity.URL})
+		case types.EntityCustomEmoji:
+			converted = append(converted, &tg.MessageEntityCustomEmoji{Offset: offset, Length: length, DocumentID: entity.DocumentID})
+		}
+	}
+	return converted
+}
@@ -0,0 +1,194 @@
+package shared
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+func entity(kind types.EntityType, offset, length int) types.MessageEntity {
+	return types.MessageEntity{Type: kind, Offset: offset, Length: length}
+}
+
+func link(url string, offset, length int) types.MessageEntity {
+	return types.MessageEntity{Type: types.EntityTextURL, Offset: offset, Length: length, URL: url}
+}
+
+func pre(language string, offset, length int) types.MessageEntity {
+	return types.MessageEntity{Type: types.EntityPre, Offset: offset, Length: length, Language: language}
+}
+
+type markupCase struct {
+	name     string
+	input    string
+	text     string
+	entities []types.MessageEntity
+}
+
+func checkMarkup(t *testing.T, parse func(string) (string, []types.MessageEntity), cases []markupCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, entities := parse(tc.input)
+			if text != tc.text {
+				t.Errorf("text = %q, want %q", text, tc.text)
+			}
+			if !slices.Equal(entities, tc.entities) {
+				t.Errorf("entities = %+v, want %+v", entities, tc.entities)
+			}
+		})
+	}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	checkMarkup(t, parseMarkdown, []markupCase{
+		{"plain", "just text", "just text", nil},
+		{"bold", "**bold**", "bold", []types.MessageEntity{entity(types.EntityBold, 0, 4)}},
+		{"spans", "a __b__ ~~c~~ ||d||", "a b c d", []types.MessageEntity{
+			entity(types.EntityItalic, 2, 1),
+			entity(types.EntityStrike, 4, 1),
+			entity(types.EntitySpoiler, 6, 1),
+		}},
+		{"nested", "**bold __both__**", "bold both", []types.MessageEntity{
+			entity(types.EntityBold, 0, 9),
+			entity(types.EntityItalic, 5, 4),
+		}},
+		{"code keeps markup", "`x**y**`", "x**y**", []types.MessageEntity{entity(types.EntityCode, 0, 6)}},
+		{"marker inside code doesn't close", "**a `**` b**", "a ** b", []types.MessageEntity{
+			entity(types.EntityBold, 0, 6),
+			entity(types.EntityCode, 2, 2),
+		}},
+		{"pre with language", "```go\nx := 1\n```", "x := 1", []types.MessageEntity{pre("go", 0, 6)}},
+		{"pre without language", "```a b\nc```", "a b\nc", []types.MessageEntity{pre("", 0, 5)}},
+		{"link", "see [go](https://go.dev)", "see go", []types.MessageEntity{link("https://go.dev", 4, 2)}},
+		{"link with parentheses", "[go](https://en.wikipedia.org/wiki/Go_(programming_language)) rocks", "go rocks", []types.MessageEntity{
+			link("https://en.wikipedia.org/wiki/Go_(programming_language)", 0, 2),
+		}},
+		{"formatted link text", "[**go**](https://go.dev)", "go", []types.MessageEntity{
+			entity(types.EntityBold, 0, 2),
+			link("https://go.dev", 0, 2),
+		}},
+		{"link with space isn't one", "[go](not a url)", "[go](not a url)", nil},
+		{"unclosed link", "[go](https://go.dev", "[go](https://go.dev", nil},
+		{"unclosed marker", "**open", "**open", nil},
+		{"single star", "2 * 3", "2 * 3", nil},
+		{"empty span", "****", "****", nil},
+	})
+}
+
+func TestParseMarkdownWordBoundaries(t *testing.T) {
+	checkMarkup(t, parseMarkdown, []markupCase{
+		{"dunder file name", "__init__.py", "__init__.py", nil},
+		{"underscores inside a word", "foo__bar__baz", "foo__bar__baz", nil},
+		{"markers between spaces", "2 ** 3 ** 4", "2 ** 3 ** 4", nil},
+		{"closing marker inside a word", "__a__b c__", "a__b c", []types.MessageEntity{entity(types.EntityItalic, 0, 6)}},
+		{"punctuation around span", "(__hi__).", "(hi).", []types.MessageEntity{entity(types.EntityItalic, 1, 2)}},
+		{"dunder in a sentence", "edit __init__.py first", "edit __init__.py first", nil},
+	})
+}
+
+func TestParseMarkdownEscapes(t *testing.T) {
+	checkMarkup(t, parseMarkdown, []markupCase{
+		{"escaped stars", `\*\*not bold\*\*`, "**not bold**", nil},
+		{"escaped backticks", "\\`tick\\`", "`tick`", nil},
+		{"escaped backslash", `a\\b`, `a\b`, nil},
+		{"escaped bracket", `\[x](https://go.dev)`, "[x](https://go.dev)", nil},
+		{"backslash before a letter stays", `C:\dir`, `C:\dir`, nil},
+		{"escaped marker inside span", `**a\*\*b**`, "a**b", []types.MessageEntity{entity(types.EntityBold, 0, 4)}},
+		{"escaped backtick inside code", "`a\\`b`", "a`b", []types.MessageEntity{entity(types.EntityCode, 0, 3)}},
+	})
+}
+
+func TestParseMarkdownUTF16Offsets(t *testing.T) {
+	checkMarkup(t, parseMarkdown, []markupCase{
+		// 😀 is a surrogate pair, two code units
+		{"emoji before entity", "😀 **hi**", "😀 hi", []types.MessageEntity{entity(types.EntityBold, 3, 2)}},
+		{"astral inside entity", "**𝕏**", "𝕏", []types.MessageEntity{entity(types.EntityBold, 0, 2)}},
+		{"two byte rune is one unit", "é **x**", "é x", []types.MessageEntity{entity(types.EntityBold, 2, 1)}},
+		{"three byte rune is one unit", "中 `x`", "中 x", []types.MessageEntity{entity(types.EntityCode, 2, 1)}},
+		{"emoji in link text", "[👋 hi](https://go.dev) ok", "👋 hi ok", []types.MessageEntity{link("https://go.dev", 0, 5)}},
+	})
+}
+
+func TestParseHTML(t *testing.T) {
+	checkMarkup(t, parseHTML, []markupCase{
+		{"plain", "just text", "just text", nil},
+		{"tags", "<b>bold</b> <i>it</i> <u>u</u> <s>s</s>", "bold it u s", []types.MessageEntity{
+			entity(types.EntityBold, 0, 4),
+			entity(types.EntityItalic, 5, 2),
+			entity(types.EntityUnderline, 8, 1),
+			entity(types.EntityStrike, 10, 1),
+		}},
+		{"nested", "<b>a <i>b</i></b>", "a b", []types.MessageEntity{
+			entity(types.EntityBold, 0, 3),
+			entity(types.EntityItalic, 2, 1),
+		}},
+		{"unclosed inner closes with outer", "<b>a <i>b</b> c", "a b c", []types.MessageEntity{
+			entity(types.EntityBold, 0, 3),
+			entity(types.EntityItalic, 2, 1),
+		}},
+		{"link", `<a href="https://go.dev">go</a>`, "go", []types.MessageEntity{link("https://go.dev", 0, 2)}},
+		{"link without href", "<a>go</a>", "go", nil},
+		{"pre with language", `<pre><code class="language-go">x := 1</code></pre>`, "x := 1", []types.MessageEntity{pre("go", 0, 6)}},
+		{"spoilers", `<tg-spoiler>a</tg-spoiler><span class="tg-spoiler">b</span>`, "ab", []types.MessageEntity{
+			entity(types.EntitySpoiler, 0, 1),
+			entity(types.EntitySpoiler, 1, 1),
+		}},
+		{"escapes", "&lt;b&gt; &amp;", "<b> &", nil},
+		{"unknown tag keeps text", "<marquee>t</marquee>", "t", nil},
+		{"line break", "a<br>b", "a\nb", nil},
+		{"emoji before entity", "😀<b>x</b>", "😀x", []types.MessageEntity{entity(types.EntityBold, 2, 1)}},
+	})
+}
+
+func TestFormatMarkdownRoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		entities []types.MessageEntity
+	}{
+		{"plain with markup characters", "a ** b `c` [d]", nil},
+		{"nested", "bold both", []types.MessageEntity{entity(types.EntityBold, 0, 9), entity(types.EntityItalic, 5, 4)}},
+		{"code with backtick", "a`b", []types.MessageEntity{entity(types.EntityCode, 0, 3)}},
+		{"pre", "x := 1", []types.MessageEntity{pre("go", 0, 6)}},
+		{"link", "go", []types.MessageEntity{link("https://en.wikipedia.org/wiki/Go_(programming_language)", 0, 2)}},
+		{"link with unbalanced parenthesis", "go", []types.MessageEntity{link("https://go.dev/a)b", 0, 2)}},
+		{"link with open parenthesis and backslash", "go", []types.MessageEntity{link(`https://go.dev/(a\b`, 0, 2)}},
+		{"emoji", "😀 hi", []types.MessageEntity{entity(types.EntityBold, 3, 2)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			markup := formatMarkup(tc.text, tc.entities, markdownMarkers, escapeMarkdown)
+			text, entities := parseMarkdown(markup)
+			if text != tc.text || !slices.Equal(entities, tc.entities) {
+				t.Errorf("%q parsed back to %q %+v, want %q %+v", markup, text, entities, tc.text, tc.entities)
+			}
+		})
+	}
+}
+
+func TestMarkdownCanWrite(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		entities []types.MessageEntity
+		want     bool
+	}{
+		{"plain", "hi", nil, true},
+		{"bold word", "a bold b", []types.MessageEntity{entity(types.EntityBold, 2, 4)}, true},
+		{"mentions have no syntax anywhere", "@gopher", []types.MessageEntity{entity(types.EntityMention, 0, 7)}, true},
+		{"underline", "under", []types.MessageEntity{entity(types.EntityUnderline, 0, 5)}, false},
+		{"blockquote", "quote", []types.MessageEntity{entity(types.EntityBlockquote, 0, 5)}, false},
+		{"bold inside a word", "unbelievable", []types.MessageEntity{entity(types.EntityBold, 2, 6)}, false},
+		{"bold ending in a space", "a b", []types.MessageEntity{entity(types.EntityBold, 0, 2)}, false},
+		{"code inside a word", "a_b", []types.MessageEntity{entity(types.EntityCode, 1, 1)}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := markdownCanWrite(tc.text, tc.entities); got != tc.want {
+				t.Errorf("markdownCanWrite = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/telegram/thumbnail"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/config"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+const (
+	// parts downloaded at the same time, the default of one is slow for anything but photos
+	downloadThreads = 4
+	// MaxImageSize is the biggest image file loaded into memory to be shown in the terminal
+	MaxImageSize = 10 * 1024 * 1024
+)
+
+// ClassifyMedia describes the media of a message, unsupported is set for media we can't show
+// or download (locations, contacts...). link previews and polls are neither
+func ClassifyMedia(media tg.MessageMediaClass) (info *types.MediaInfo, unsupported bool) {
+	switch media.(type) {
+	case nil, *tg.MessageMediaWebPage, *tg.MessageMediaPoll:
+		return nil, false
+	}
+	_, info = MediaFileLocation(media)
+	return info, info == nil
+}
+
+// MediaFileLocation is where the file of a photo or document message can be downloaded from,
+// nil for media without a file
+func MediaFileLocation(media tg.MessageMediaClass) (tg.InputFileLocationClass, *types.MediaInfo) {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		photoClass, ok := m.GetPhoto()
+		if !ok {
+			return nil, nil
+		}
+		photo, ok := photoClass.AsNotEmpty()
+		if !ok {
+			return nil, nil
+		}
+		return photoLocation(photo)
+	case *tg.MessageMediaDocument:
+		documentClass, ok := m.GetDocument()
+		if !ok {
+			return nil, nil
+		}
+		document, ok := documentClass.AsNotEmpty()
+		if !ok {
+			return nil, nil
+		}
+		return document.AsInputDocumentFileLocation(), documentInfo(document)
+	}
+	return nil, nil
+}
+
+// photoLocation picks the biggest size of a photo, that is the one the official apps save
+func photoLocation(photo *tg.Photo) (tg.InputFileLocationClass, *types.MediaInfo) {
+	var best *types.MediaInfo
+	var bestType string
+	var stripped []byte
+	for _, sizeClass := range photo.Sizes {
+		var info types.MediaInfo
+		var sizeType string
+		switch size := sizeClass.(type) {
+		case *tg.PhotoStrippedSize:
+			stripped = size.Bytes
+			continue
+		case *tg.PhotoSize:
+			info = types.MediaInfo{Width: size.W, Height: size.H, Size: int64(size.Size)}
+			sizeType = size.Type
+		case *tg.PhotoSizeProgressive:
+			info = types.MediaInfo{Width: size.W, Height: size.H}
+			if len(size.Sizes) > 0 {
+				info.Size = int64(size.Sizes[len(size.Sizes)-1])
+			}
+			sizeType = size.Type
+		default:
+			continue
+		}
+		if best == nil || info.Width*info.Height > best.Width*best.Height {
+			best, bestType = &info, sizeType
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	best.Kind = types.MediaPhoto
+	best.MimeType = "image/jpeg"
+	best.Thumbnail = expandThumbnail(stripped)
+	return &tg.InputPhotoFileLocation{
+		ID:            photo.ID,
+		AccessHash:    photo.AccessHash,
+		FileReference: photo.FileReference,
+		ThumbSize:     bestType,
+	}, best
+}
+
+func documentInfo(document *tg.Document) *types.MediaInfo {
+	info := &types.MediaInfo{
+		Kind:     types.MediaDocument,
+		Size:     document.Size,
+		MimeType: document.MimeType,
+	}
+	for _, attribute := range document.Attributes {
+		switch a := attribute.(type) {
+		case *tg.DocumentAttributeFilename:
+			info.FileName = a.FileName
+		case *tg.DocumentAttributeImageSize:
+			info.Width, info.Height = a.W, a.H
+		case *tg.DocumentAttributeVideo:
+			info.Width, info.Height = a.W, a.H
+			info.Duration = time.Duration(a.Duration * float64(time.Second))
+			// a gif is an animated video, keep that kind
+			if info.Kind == types.MediaDocument {
+				info.Kind = types.MediaVideo
+			}
+			if a.RoundMessage {
+				info.Kind = types.MediaVideoNote
+			}
+		case *tg.DocumentAttributeAudio:
+			info.Duration = time.Duration(a.Duration) * time.Second
+			info.Title, info.Performer = a.Title, a.Performer
+			info.Kind = types.MediaAudio
+			if a.Voice {
+				info.Kind = types.MediaVoice
+			}
+		case *tg.DocumentAttributeAnimated:
+			info.Kind = types.MediaAnimation
+		case *tg.DocumentAttributeSticker:
+			info.Kind = types.MediaSticker
+		}
+	}
+	for _, thumb := range document.Thumbs {
+		if stripped, ok := thumb.(*tg.PhotoStrippedSize); ok {
+			info.Thumbnail = expandThumbnail(stripped.Bytes)
+		}
+	}
+	return info
+}
+
+// expandThumbnail turns a stripped thumbnail back into a jpeg. telegram leaves out the jpeg
+// header that is the same for all of them, nil when there is none or it is broken
+func expandThumbnail(stripped []byte) []byte {
+	if len(stripped) == 0 {
+		return nil
+	}
+	expanded, err := thumbnail.Expand(stripped)
+	if err != nil {
+		return nil
+	}
+	return expanded
+}
+
+// ImageLocation is where a picture of the media can be loaded from for the image viewer: the
+// photo itself, an image document or sticker that isn't too big, or else the biggest thumbnail
+// of a document (the frame of a video). nil when there is nothing to show
+func ImageLocation(media tg.MessageMediaClass) tg.InputFileLocationClass {
+	location, info := MediaFileLocation(media)
+	if location == nil || info.Kind == types.MediaPhoto {
+		return location
+	}
+	documentMedia, ok := media.(*tg.MessageMediaDocument)
+	if !ok {
+		return nil
+	}
+	document, ok := documentMedia.Document.AsNotEmpty()
+	if !ok {
+		return nil
+	}
+	switch info.MimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		if info.Size <= MaxImageSize {
+			return location
+		}
+	}
+	var bestType string
+	bestArea := 0
+	for _, thumb := range document.Thumbs {
+		if size, ok := thumb.(*tg.PhotoSize); ok && size.W*size.H > bestArea {
+			bestType, bestArea = size.Type, size.W*size.H
+		}
+	}
+	if bestType == "" {
+		return nil
+	}
+	return &tg.InputDocumentFileLocation{
+		ID:            document.ID,
+		AccessHash:    document.AccessHash,
+		FileReference: document.FileReference,
+		ThumbSize:     bestType,
+	}
+}
+
+var mediaExtensions = map[string]string{
+	"image/jpeg":              ".jpg",
+	"image/png":               ".png",
+	"image/webp":              ".webp",
+	"image/gif":               ".gif",
+	"video/mp4":               ".mp4",
+	"video/webm":              ".webm",
+	"video/quicktime":         ".mov",
+	"audio/mpeg":              ".mp3",
+	"audio/ogg":               ".ogg",
+	"audio/mp4":               ".m4a",
+	"application/pdf":         ".pdf",
+	"application/zip":         ".zip",
+	"application/x-tgsticker": ".tgs",
+	"text/plain":              ".txt",
+}
+
+// MediaFileName is the name a download is saved under. files without a name of their own are
+// named after the chat and message, so downloading one twice finds the first copy
+func MediaFileName(info *types.MediaInfo, peerID string, messageID int) string {
+	if info.FileName != "" {
+		// the name comes from the sender, it must not point anywhere else
+		if name := filepath.Base(filepath.Clean("/" + info.FileName)); name != "/" && name != "." {
+			return name
+		}
+	}
+	extension, ok := mediaExtensions[info.MimeType]
+	if !ok {
+		if extensions, err := mime.ExtensionsByType(info.MimeType); err == nil && len(extensions) > 0 {
+			extension = extensions[0]
+		} else {
+			extension = ".bin"
+		}
+	}
+	return fmt.Sprintf("%s_%s_%d%s", info.Kind, peerID, messageID, extension)
+}
+
+// DownloadDirectory is where media is saved, downloads.directory or ~/Downloads/cligram
+func DownloadDirectory() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if directory := config.GetConfig().Downloads.Directory; directory != nil && *directory != "" {
+		if rest, ok := strings.CutPrefix(*directory, "~"); ok {
+			return filepath.Join(homeDir, rest), nil
+		}
+		return *directory, nil
+	}
+	return filepath.Join(homeDir, "Downloads", "cligram"), nil
+}
+
+type progressWriter struct {
+	file     *os.File
+	written  atomic.Int64
+	progress func(int64)
+}
+
+func (w *progressWriter) WriteAt(p []byte, offset int64) (int, error) {
+	n, err := w.file.WriteAt(p, offset)
+	w.progress(w.written.Add(int64(n)))
+	return n, err
+}
+
+// DownloadFile saves a file through a .part file next to filePath, an interrupted download
+// never looks like a finished one. progress, when set, gets the number of bytes written so far
+// and is called from several goroutines
+func DownloadFile(ctx context.Context, client *tg.Client, filePath string, location tg.InputFileLocationClass, progress func(int64)) error {
+	partPath := filePath + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	if err := DownloadInto(ctx, client, file, location, progress); err != nil {
+		return err
+	}
+	return os.Rename(partPath, filePath)
+}
+
+// DownloadInto downloads into a file opened for it and closes it, the file is removed again
+// when the download fails
+func DownloadInto(ctx context.Context, client *tg.Client, file *os.File, location tg.InputFileLocationClass, progress func(int64)) error {
+	var output io.WriterAt = file
+	if progress != nil {
+		output = &progressWriter{file: file, progress: progress}
+	}
+	_, err := downloader.NewDownloader().Download(client, location).WithThreads(downloadThreads).Parallel(ctx, output)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
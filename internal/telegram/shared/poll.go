@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// MessagePoll is the poll of a message, nil when the media isn't one
+func MessagePoll(media tg.MessageMediaClass) *types.Poll {
+	pollMedia, ok := media.(*tg.MessageMediaPoll)
+	if !ok {
+		return nil
+	}
+	return UpdatePoll(nil, &pollMedia.Poll, pollMedia.Results)
+}
+
+// UpdatePoll applies new results, and the poll itself when it is set, to what we knew of a poll.
+// min results leave out which answers we chose, what we knew is kept for those
+func UpdatePoll(current *types.Poll, poll *tg.Poll, results tg.PollResults) *types.Poll {
+	updated := &types.Poll{}
+	if current != nil {
+		*updated = *current
+		updated.Answers = slices.Clone(current.Answers)
+	}
+	if poll != nil {
+		updated.ID = poll.ID
+		updated.Question = poll.Question.Text
+		updated.Closed = poll.Closed
+		updated.Quiz = poll.Quiz
+		updated.MultipleChoice = poll.MultipleChoice
+		updated.PublicVoters = poll.PublicVoters
+		updated.RevotingDisabled = poll.RevotingDisabled
+		answers := make([]types.PollAnswer, 0, len(poll.Answers))
+		for _, answerClass := range poll.Answers {
+			answer, ok := answerClass.(*tg.PollAnswer)
+			if !ok {
+				continue
+			}
+			formatted := types.PollAnswer{Text: answer.Text.Text, Option: answer.Option}
+			if i := pollAnswerIndex(updated.Answers, answer.Option); i != -1 {
+				old := updated.Answers[i]
+				formatted.Voters, formatted.Chosen, formatted.Correct = old.Voters, old.Chosen, old.Correct
+			}
+			answers = append(answers, formatted)
+		}
+		updated.Answers = answers
+	}
+
+	if voters, ok := results.GetResults(); ok {
+		for _, result := range voters {
+			i := pollAnswerIndex(updated.Answers, result.Option)
+			if i == -1 {
+				continue
+			}
+			updated.Answers[i].Voters = result.Voters
+			if !results.Min {
+				updated.Answers[i].Chosen = result.Chosen
+				updated.Answers[i].Correct = result.Correct
+			}
+		}
+	}
+	if total, ok := results.GetTotalVoters(); ok {
+		updated.TotalVoters = total
+	}
+	if solution, ok := results.GetSolution(); ok {
+		updated.Solution = solution
+	}
+	return updated
+}
+
+func pollAnswerIndex(answers []types.PollAnswer, option []byte) int {
+	return slices.IndexFunc(answers, func(answer types.PollAnswer) bool {
+		return bytes.Equal(answer.Option, option)
+	})
+}
@@ -0,0 +1,266 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// PeerNames names the user, group or channel behind a peer, empty when it isn't known
+type PeerNames func(peer tg.PeerClass) string
+
+// HistoryPeerNames looks peers up in the users and chats that came with a page of history
+func HistoryPeerNames(users []tg.UserClass, chats []tg.ChatClass) PeerNames {
+	return func(peer tg.PeerClass) string {
+		switch peer := peer.(type) {
+		case *tg.PeerUser:
+			for _, userClass := range users {
+				if user, ok := userClass.(*tg.User); ok && user.ID == peer.UserID {
+					return userName(user)
+				}
+			}
+		case *tg.PeerChat:
+			for _, chatClass := range chats {
+				if chat, ok := chatClass.(*tg.Chat); ok && chat.ID == peer.ChatID {
+					return chat.Title
+				}
+			}
+		case *tg.PeerChannel:
+			for _, chatClass := range chats {
+				if channel, ok := chatClass.(*tg.Channel); ok && channel.ID == peer.ChannelID {
+					return channel.Title
+				}
+			}
+		}
+		return ""
+	}
+}
+
+// UpdatePeerNames looks peers up in the entities that came with an update
+func UpdatePeerNames(e tg.Entities) PeerNames {
+	return func(peer tg.PeerClass) string {
+		switch peer := peer.(type) {
+		case *tg.PeerUser:
+			if user, ok := e.Users[peer.UserID]; ok {
+				return userName(user)
+			}
+		case *tg.PeerChat:
+			if chat, ok := e.Chats[peer.ChatID]; ok {
+				return chat.Title
+			}
+		case *tg.PeerChannel:
+			if channel, ok := e.Channels[peer.ChannelID]; ok {
+				return channel.Title
+			}
+		}
+		return ""
+	}
+}
+
+func userName(user *tg.User) string {
+	if user.Deleted {
+		return "Deleted Account"
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// FormatServiceMessage turns a service message, someone joined, a message got pinned and so on,
+// into a line telling what happened
+func FormatServiceMessage(msg *tg.MessageService, names PeerNames) *types.FormattedMessage {
+	if msg == nil {
+		return nil
+	}
+	name := func(peer tg.PeerClass) string {
+		if n := names(peer); n != "" {
+			return n
+		}
+		return "someone"
+	}
+	userNames := func(ids []int64) string {
+		list := make([]string, 0, len(ids))
+		for _, id := range ids {
+			list = append(list, name(&tg.PeerUser{UserID: id}))
+		}
+		return strings.Join(list, ", ")
+	}
+
+	actor := "you"
+	if !msg.Out {
+		// user chats leave the sender out, it's the other side of the chat then
+		from, ok := msg.GetFromID()
+		if !ok {
+			from = msg.PeerID
+		}
+		actor = name(from)
+	}
+
+	return &types.FormattedMessage{
+		ID:       msg.ID,
+		Sender:   actor,
+		Content:  serviceText(msg, actor, name, userNames),
+		IsFromMe: msg.Out,
+		Date:     time.Unix(int64(msg.Date), 0),
+		Service:  true,
+	}
+}
+
+func serviceText(msg *tg.MessageService, actor string, name func(tg.PeerClass) string, userNames func([]int64) string) string {
+	switch action := msg.Action.(type) {
+	case *tg.MessageActionChatCreate:
+		return fmt.Sprintf("%s created the group «%s»", actor, action.Title)
+	case *tg.MessageActionChannelCreate:
+		return fmt.Sprintf("%s created «%s»", actor, action.Title)
+	case *tg.MessageActionChatEditTitle:
+		return fmt.Sprintf("%s changed the name to «%s»", actor, action.Title)
+	case *tg.MessageActionChatEditPhoto:
+		return actor + " changed the photo"
+	case *tg.MessageActionChatDeletePhoto:
+		return actor + " removed the photo"
+	case *tg.MessageActionChatAddUser:
+		if from, ok := msg.GetFromID(); ok && len(action.Users) == 1 {
+			if user, ok := from.(*tg.PeerUser); ok && user.UserID == action.Users[0] {
+				return actor + " joined the group"
+			}
+		}
+		return fmt.Sprintf("%s added %s", actor, userNames(action.Users))
+	case *tg.MessageActionChatDeleteUser:
+		if from, ok := msg.GetFromID(); ok {
+			if user, ok := from.(*tg.PeerUser); ok && user.UserID == action.UserID {
+				return actor + " left the group"
+			}
+		}
+		return fmt.Sprintf("%s removed %s", actor, name(&tg.PeerUser{UserID: action.UserID}))
+	case *tg.MessageActionChatJoinedByLink:
+		return actor + " joined the group via invite link"
+	case *tg.MessageActionChatJoinedByRequest:
+		return actor + " was accepted into the group"
+	case *tg.MessageActionChatMigrateTo, *tg.MessageActionChannelMigrateFrom:
+		return "the group was upgraded to a supergroup"
+	case *tg.MessageActionPinMessage:
+		return actor + " pinned a message"
+	case *tg.MessageActionHistoryClear:
+		return "history was cleared"
+	case *tg.MessageActionPhoneCall:
+		return phoneCallText(msg.Out, action)
+	case *tg.MessageActionGroupCall:
+		if duration, ok := action.GetDuration(); ok {
+			return "video chat ended (" + callDuration(duration) + ")"
+		}
+		return actor + " started a video chat"
+	case *tg.MessageActionGroupCallScheduled:
+		return fmt.Sprintf("%s scheduled a video chat for %s", actor, time.Unix(int64(action.ScheduleDate), 0).Format("Jan 2 15:04"))
+	case *tg.MessageActionInviteToGroupCall:
+		return fmt.Sprintf("%s invited %s to the video chat", actor, userNames(action.Users))
+	case *tg.MessageActionTopicCreate:
+		return fmt.Sprintf("%s created the topic «%s»", actor, action.Title)
+	case *tg.MessageActionTopicEdit:
+		if closed, ok := action.GetClosed(); ok {
+			if closed {
+				return actor + " closed the topic"
+			}
+			return actor + " reopened the topic"
+		}
+		if hidden, ok := action.GetHidden(); ok {
+			if hidden {
+				return actor + " hid the topic"
+			}
+			return actor + " unhid the topic"
+		}
+		if title, ok := action.GetTitle(); ok {
+			return fmt.Sprintf("%s renamed the topic to «%s»", actor, title)
+		}
+		return actor + " changed the topic icon"
+	case *tg.MessageActionScreenshotTaken:
+		return actor + " took a screenshot"
+	case *tg.MessageActionSetMessagesTTL:
+		if action.Period == 0 {
+			return actor + " disabled auto-delete"
+		}
+		return fmt.Sprintf("%s set messages to auto-delete after %s", actor, ttlText(action.Period))
+	case *tg.MessageActionContactSignUp:
+		return actor + " joined Telegram"
+	case *tg.MessageActionCustomAction:
+		return action.Message
+	case *tg.MessageActionBotAllowed:
+		return "you allowed this bot to message you"
+	case *tg.MessageActionGameScore:
+		return fmt.Sprintf("%s scored %d", actor, action.Score)
+	case *tg.MessageActionPaymentSent:
+		return fmt.Sprintf("you paid %.2f %s", float64(action.TotalAmount)/100, action.Currency)
+	case *tg.MessageActionSetChatTheme:
+		return actor + " changed the chat theme"
+	}
+	return "This service message is not supported by this Telegram client."
+}
+
+func phoneCallText(out bool, action *tg.MessageActionPhoneCall) string {
+	call := "call"
+	if action.Video {
+		call = "video call"
+	}
+	reason, _ := action.GetReason()
+	switch reason.(type) {
+	case *tg.PhoneCallDiscardReasonMissed:
+		if out {
+			return "cancelled " + call
+		}
+		return "missed " + call
+	case *tg.PhoneCallDiscardReasonBusy:
+		if out {
+			return call + " was declined"
+		}
+		return "declined " + call
+	}
+	direction := "incoming "
+	if out {
+		direction = "outgoing "
+	}
+	if duration, ok := action.GetDuration(); ok {
+		return direction + call + " (" + callDuration(duration) + ")"
+	}
+	return direction + call
+}
+
+// callDuration writes seconds the way calls show them, m:ss or h:mm:ss
+func callDuration(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func ttlText(seconds int) string {
+	switch {
+	case seconds%(30*24*3600) == 0:
+		return pluralize(seconds/(30*24*3600), "month")
+	case seconds%(7*24*3600) == 0:
+		return pluralize(seconds/(7*24*3600), "week")
+	case seconds%(24*3600) == 0:
+		return pluralize(seconds/(24*3600), "day")
+	case seconds%3600 == 0:
+		return pluralize(seconds/3600, "hour")
+	}
+	return pluralize(seconds/60, "minute")
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// ReplyTopicID returns the forum topic a message was posted in, the general topic has id 1
+func ReplyTopicID(replyTo tg.MessageReplyHeaderClass) int {
+	header, ok := replyTo.(*tg.MessageReplyHeader)
+	if !ok || !header.ForumTopic {
+		return 1
+	}
+	if topID, ok := header.GetReplyToTopID(); ok {
+		return topID
+	}
+	return header.ReplyToMsgID
+}
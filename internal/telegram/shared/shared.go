@@ -14,8 +14,6 @@ import (
 
 	"os/exec"
 
-	"github.com/gotd/td/telegram/downloader"
-
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
 	"github.com/kumneger0/cligram/internal/telegram/types"
@@ -70,16 +68,14 @@ func FormatMessage[T ChannelOrUser](msg *tg.Message, userOrChannel *T, allMessag
 		}
 	}
 
-	isUnsupportedMessage := false
-	if msg.Media != nil {
-		if _, ok := msg.Media.(*tg.MessageMediaWebPage); !ok {
-			isUnsupportedMessage = true
-		}
-	}
+	mediaInfo, isUnsupportedMessage := ClassifyMedia(msg.Media)
 
 	var content = msg.Message
+	var entities []types.MessageEntity
 	if isUnsupportedMessage {
 		content = "This Message is not supported by this Telegram client."
+	} else {
+		entities = ConvertEntities(msg.Entities)
 	}
 
 	var view int
@@ -106,7 +102,79 @@ func FormatMessage[T ChannelOrUser](msg *tg.Message, userOrChannel *T, allMessag
 		Views:                view,
 		HasWebPagePreview:    webPageMedia != nil,
 		MessageMediaWebPage:  webPageMedia,
+		IsEdited:             IsMessageEdited(msg),
+		Entities:             entities,
+		MediaInfo:            mediaInfo,
+		GroupedID:            msg.GroupedID,
+		Poll:                 MessagePoll(msg.Media),
+		InlineKeyboard:       InlineKeyboard(msg.ReplyMarkup),
+		ReplyKeyboard:        ReplyKeyboard(msg.ReplyMarkup),
+	}
+}
+
+// IsMessageEdited reports whether the message should carry the "edited" marker,
+// bots can hide edits of their own messages (e.g. inline keyboards updates)
+func IsMessageEdited(msg *tg.Message) bool {
+	_, edited := msg.GetEditDate()
+	return edited && !msg.EditHide
+}
+
+// ConvertEntities turns the formatting of a message into our own entity list, entities we
+// don't render (like bank cards) are left out
+func ConvertEntities(entities []tg.MessageEntityClass) []types.MessageEntity {
+	var converted []types.MessageEntity
+	for _, entityClass := range entities {
+		entity := types.MessageEntity{
+			Offset: entityClass.GetOffset(),
+			Length: entityClass.GetLength(),
+		}
+		switch e := entityClass.(type) {
+		case *tg.MessageEntityBold:
+			entity.Type = types.EntityBold
+		case *tg.MessageEntityItalic:
+			entity.Type = types.EntityItalic
+		case *tg.MessageEntityUnderline:
+			entity.Type = types.EntityUnderline
+		case *tg.MessageEntityStrike:
+			entity.Type = types.EntityStrike
+		case *tg.MessageEntitySpoiler:
+			entity.Type = types.EntitySpoiler
+		case *tg.MessageEntityCode:
+			entity.Type = types.EntityCode
+		case *tg.MessageEntityPre:
+			entity.Type = types.EntityPre
+			entity.Language = e.Language
+		case *tg.MessageEntityBlockquote:
+			entity.Type = types.EntityBlockquote
+		case *tg.MessageEntityURL:
+			entity.Type = types.EntityURL
+		case *tg.MessageEntityTextURL:
+			entity.Type = types.EntityTextURL
+			entity.URL = e.URL
+		case *tg.MessageEntityEmail:
+			entity.Type = types.EntityEmail
+		case *tg.MessageEntityPhone:
+			entity.Type = types.EntityPhone
+		case *tg.MessageEntityMention:
+			entity.Type = types.EntityMention
+		case *tg.MessageEntityMentionName:
+			entity.Type = types.EntityMentionName
+			entity.UserID = e.UserID
+		case *tg.MessageEntityHashtag:
+			entity.Type = types.EntityHashtag
+		case *tg.MessageEntityCashtag:
+			entity.Type = types.EntityCashtag
+		case *tg.MessageEntityBotCommand:
+			entity.Type = types.EntityBotCommand
+		case *tg.MessageEntityCustomEmoji:
+			entity.Type = types.EntityCustomEmoji
+			entity.DocumentID = e.DocumentID
+		default:
+			continue
+		}
+		converted = append(converted, entity)
 	}
+	return converted
 }
 
 func getRelyMessage(allMessages []tg.MessageClass, messageID int) *tg.Message {
@@ -124,28 +192,6 @@ func getRelyMessage(allMessages []tg.MessageClass, messageID int) *tg.Message {
 	return message
 }
 
-func GetUserInfo(ctx context.Context, client tg.Client, userID int64) (*types.UserInfo, error) {
-	inputUser := &tg.InputUser{
-		UserID: userID,
-	}
-
-	userClasses, err := client.UsersGetUsers(ctx, []tg.InputUserClass{inputUser})
-	if err != nil {
-		return nil, types.NewTelegramError(types.ErrorCodeGetMessagesFailed, "users.getUsers failed", err)
-	}
-
-	if len(userClasses) == 0 {
-		return nil, types.NewUserNotFoundError(userID)
-	}
-
-	user, ok := userClasses[0].(*tg.User)
-
-	if !ok {
-		return nil, types.NewUserNotFoundError(userID)
-	}
-	return ConvertTGUserToUserInfo(user), nil
-}
-
 func ConvertTGUserToUserInfo(tgUser *tg.User) *types.UserInfo {
 	firstName := tgUser.FirstName
 	lastName := tgUser.LastName
@@ -302,7 +348,9 @@ func ReadStories(ctx context.Context, client *telegram.Client, peer types.Peer,
 	return nil
 }
 
-func DownloadStoryMedia(ctx context.Context, client *telegram.Client, story *tg.StoryItem, outDir string, peerID string) (*int, error) {
+// DownloadStoryMedia saves a story to outDir and opens it in the default app, photos only when
+// openPhotos is set. the path it was saved to comes back with the story id
+func DownloadStoryMedia(ctx context.Context, client *telegram.Client, story *tg.StoryItem, outDir string, peerID string, openPhotos bool) (*int, string, error) {
 	switch media := story.Media.(type) {
 	case *tg.MessageMediaDocument:
 		if doc, ok := media.Document.AsNotEmpty(); ok {
@@ -315,61 +363,40 @@ func DownloadStoryMedia(ctx context.Context, client *telegram.Client, story *tg.
 			}
 
 			filePath := filepath.Join(outDir, fmt.Sprintf("story_%d,%s.%s", story.ID, peerID, ext))
-			return &story.ID, saveMediaToFileSystem(ctx, client.API(), filePath, doc.AsInputDocumentFileLocation())
+			return &story.ID, filePath, saveMediaToFileSystem(ctx, client.API(), filePath, doc.AsInputDocumentFileLocation(), ext != "jpg" || openPhotos)
 		}
 
 	case *tg.MessageMediaPhoto:
 		if ph, ok := media.Photo.AsNotEmpty(); ok {
 			filePath := filepath.Join(outDir, fmt.Sprintf("story_%d,%s.%s", story.ID, peerID, "jpg"))
-			if fileInfo, err := os.Stat(filePath); err == nil {
-				if fileInfo.Size() > 0 {
-					err := OpenFileInDefaultApp(filePath)
-					if err != nil {
-						return nil, err
-					}
-					return &story.ID, nil
-				}
-			}
-
 			photoFileLocation := &tg.InputPhotoFileLocation{
 				ID:            ph.ID,
 				AccessHash:    ph.AccessHash,
 				FileReference: ph.FileReference,
 				ThumbSize:     "y",
 			}
-			return &story.ID, saveMediaToFileSystem(ctx, client.API(), filePath, photoFileLocation)
+			return &story.ID, filePath, saveMediaToFileSystem(ctx, client.API(), filePath, photoFileLocation, openPhotos)
 		}
 
 	default:
-		return nil, errors.New("No downloadable media in this story")
+		return nil, "", errors.New("No downloadable media in this story")
 	}
 
-	return nil, errors.New("i have no idea for some fucking reason we are not able to get the type of story")
+	return nil, "", errors.New("i have no idea for some fucking reason we are not able to get the type of story")
 }
 
-func saveMediaToFileSystem(ctx context.Context, client *tg.Client, filePath string, inputFileLocation tg.InputFileLocationClass) error {
-	dl := downloader.NewDownloader()
-	if fileInfo, err := os.Stat(filePath); err == nil {
-		if fileInfo.Size() > 0 {
-			err := OpenFileInDefaultApp(filePath)
-			if err != nil {
-				return err
-			}
-			return nil
+// saveMediaToFileSystem downloads the file unless an earlier download is there, open hands it
+// to the default app afterwards
+func saveMediaToFileSystem(ctx context.Context, client *tg.Client, filePath string, inputFileLocation tg.InputFileLocationClass, open bool) error {
+	if fileInfo, err := os.Stat(filePath); err != nil || fileInfo.Size() == 0 {
+		if err := DownloadFile(ctx, client, filePath, inputFileLocation, nil); err != nil {
+			return err
 		}
 	}
-
-	dBuilder := dl.Download(client, inputFileLocation)
-	_, err := dBuilder.ToPath(ctx, filePath)
-	if err != nil {
-		return err
-	}
-
-	err = OpenFileInDefaultApp(filePath)
-	if err != nil {
-		return err
+	if !open {
+		return nil
 	}
-	return nil
+	return OpenFileInDefaultApp(filePath)
 }
 
 func OpenFileInDefaultApp(path string) error {
@@ -381,6 +408,7 @@ func OpenFileInDefaultApp(path string) error {
 	case "darwin":
 		cmd = exec.Command("open", path)
 	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
 	default:
 		return fmt.Errorf("unsupported platform")
 	}
@@ -37,6 +37,7 @@ type UserInfo struct {
 	Premium         bool                   `json:"premium"`
 	ReadInboxMaxID  int                    `json:"readInboxMaxId"`
 	ReadOutboxMaxID int                    `json:"readOutboxMaxId"`
+	TopMessageID    int                    `json:"topMessageId"`
 }
 
 type ChannelInfo struct {
@@ -53,6 +54,7 @@ type ChannelInfo struct {
 	ReadInboxMaxID    int                    `json:"readInboxMaxId"`
 	ReadOutboxMaxID   int                    `json:"readOutboxMaxId"`
 	IsForum           bool                   `json:"isForum"`
+	TopMessageID      int                    `json:"topMessageId"`
 }
 
 type FormattedMessage struct {
@@ -73,6 +75,177 @@ type FormattedMessage struct {
 	Views                int                     `json:"view"`
 	HasWebPagePreview    bool                    `json:"hasWebPagePreview"`
 	MessageMediaWebPage  *tg.MessageMediaWebPage `json:"messageMediaWebPage"`
+	IsEdited             bool                    `json:"isEdited"`
+	// SendState is set while one of our messages sits in the outbox, empty once the server has it
+	SendState OutboxState `json:"sendState,omitempty"`
+	// formatting of Content, bold/code/links and so on
+	Entities []MessageEntity `json:"entities,omitempty"`
+	// MediaInfo describes the attached file, Content is its caption then
+	MediaInfo *MediaInfo `json:"mediaInfo,omitempty"`
+	// GroupedID is shared by the messages of one album
+	GroupedID int64 `json:"groupedId,omitempty"`
+	// Album holds every message of an album, set on the one list item the album is shown as
+	Album []FormattedMessage `json:"album,omitempty"`
+	// Poll is set for poll and quiz messages
+	Poll *Poll `json:"poll,omitempty"`
+	// InlineKeyboard holds the rows of buttons a bot put under the message
+	InlineKeyboard [][]KeyboardButton `json:"inlineKeyboard,omitempty"`
+	// ReplyKeyboard is the keyboard a bot shows in place of ours, or takes away
+	ReplyKeyboard *ReplyKeyboard `json:"replyKeyboard,omitempty"`
+	// Service is set for what happened in the chat, joins, pins, calls, Content says it in words
+	Service bool `json:"service,omitempty"`
+}
+
+// Poll is a poll or quiz with the results we know of. telegram only shares the results once
+// we voted or the poll is closed
+type Poll struct {
+	ID             int64        `json:"id"`
+	Question       string       `json:"question"`
+	Answers        []PollAnswer `json:"answers"`
+	Closed         bool         `json:"closed"`
+	Quiz           bool         `json:"quiz"`
+	MultipleChoice bool         `json:"multipleChoice"`
+	PublicVoters   bool         `json:"publicVoters"`
+	// RevotingDisabled polls and quizzes keep the first vote
+	RevotingDisabled bool `json:"revotingDisabled"`
+	TotalVoters      int  `json:"totalVoters"`
+	// Solution explains the right answer of a quiz
+	Solution string `json:"solution,omitempty"`
+}
+
+type PollAnswer struct {
+	Text string `json:"text"`
+	// Option identifies the answer when voting
+	Option  []byte `json:"option"`
+	Voters  int    `json:"voters"`
+	Chosen  bool   `json:"chosen"`
+	Correct bool   `json:"correct"`
+}
+
+// Voted tells whether we voted in the poll
+func (p *Poll) Voted() bool {
+	for _, answer := range p.Answers {
+		if answer.Chosen {
+			return true
+		}
+	}
+	return false
+}
+
+// CanVote tells whether a vote can be cast or taken back now
+func (p *Poll) CanVote() bool {
+	return !p.Closed && (!p.Voted() || (!p.Quiz && !p.RevotingDisabled))
+}
+
+// ButtonKind is what pressing a bot button does
+type ButtonKind string
+
+const (
+	// ButtonText sends its text as a message, the buttons of reply keyboards are these
+	ButtonText ButtonKind = "text"
+	// ButtonCallback asks the bot, which may answer with a short text
+	ButtonCallback     ButtonKind = "callback"
+	ButtonGame         ButtonKind = "game"
+	ButtonURL          ButtonKind = "url"
+	ButtonSwitchInline ButtonKind = "switchInline"
+	ButtonCopy         ButtonKind = "copy"
+	// ButtonUnsupported are buttons for things we can't do here, like payments or sharing a contact
+	ButtonUnsupported ButtonKind = "unsupported"
+)
+
+type KeyboardButton struct {
+	Text string     `json:"text"`
+	Kind ButtonKind `json:"kind"`
+	// Data goes to the bot when a callback button is pressed
+	Data []byte `json:"data,omitempty"`
+	// URL is opened by url buttons, CopyText is what copy buttons copy
+	URL      string `json:"url,omitempty"`
+	CopyText string `json:"copyText,omitempty"`
+	// Query is put after the bot's username for an inline query, in this chat when SamePeer is set
+	Query            string `json:"query,omitempty"`
+	SamePeer         bool   `json:"samePeer,omitempty"`
+	RequiresPassword bool   `json:"requiresPassword,omitempty"`
+}
+
+// ReplyKeyboard is shown above the input of a bot chat until another message replaces it
+type ReplyKeyboard struct {
+	Rows        [][]KeyboardButton `json:"rows,omitempty"`
+	Placeholder string             `json:"placeholder,omitempty"`
+	// SingleUse keyboards go away once a button was pressed
+	SingleUse bool `json:"singleUse,omitempty"`
+	// Hide is set when the bot removes its keyboard
+	Hide bool `json:"hide,omitempty"`
+}
+
+type MediaKind string
+
+const (
+	MediaPhoto     MediaKind = "photo"
+	MediaVideo     MediaKind = "video"
+	MediaAnimation MediaKind = "animation"
+	MediaVideoNote MediaKind = "videoNote"
+	MediaAudio     MediaKind = "audio"
+	MediaVoice     MediaKind = "voice"
+	MediaSticker   MediaKind = "sticker"
+	MediaDocument  MediaKind = "document"
+)
+
+// MediaInfo is what we know about a message's file without downloading it
+type MediaInfo struct {
+	Kind     MediaKind `json:"kind"`
+	FileName string    `json:"fileName,omitempty"`
+	// Size in bytes
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	// Duration of audio and video
+	Duration time.Duration `json:"duration,omitempty"`
+	// Title and Performer of a music file
+	Title     string `json:"title,omitempty"`
+	Performer string `json:"performer,omitempty"`
+	// Thumbnail is the tiny jpeg telegram sends along with photos and some documents, enough
+	// for a preview in the chat
+	Thumbnail []byte `json:"thumbnail,omitempty"`
+}
+
+type EntityType string
+
+const (
+	EntityBold        EntityType = "bold"
+	EntityItalic      EntityType = "italic"
+	EntityUnderline   EntityType = "underline"
+	EntityStrike      EntityType = "strike"
+	EntitySpoiler     EntityType = "spoiler"
+	EntityCode        EntityType = "code"
+	EntityPre         EntityType = "pre"
+	EntityBlockquote  EntityType = "blockquote"
+	EntityURL         EntityType = "url"
+	EntityTextURL     EntityType = "textUrl"
+	EntityEmail       EntityType = "email"
+	EntityPhone       EntityType = "phone"
+	EntityMention     EntityType = "mention"
+	EntityMentionName EntityType = "mentionName"
+	EntityHashtag     EntityType = "hashtag"
+	EntityCashtag     EntityType = "cashtag"
+	EntityBotCommand  EntityType = "botCommand"
+	EntityCustomEmoji EntityType = "customEmoji"
+)
+
+// MessageEntity marks a range of a message's text, Offset and Length count UTF-16 code
+// units the way the telegram api does and not bytes or runes
+type MessageEntity struct {
+	Type   EntityType `json:"type"`
+	Offset int        `json:"offset"`
+	Length int        `json:"length"`
+	// URL is the target of a textUrl entity
+	URL string `json:"url,omitempty"`
+	// Language of a pre block, may be empty
+	Language string `json:"language,omitempty"`
+	// UserID is who a mentionName entity points at
+	UserID int64 `json:"userId,omitempty"`
+	// DocumentID of a customEmoji entity
+	DocumentID int64 `json:"documentId,omitempty"`
 }
 
 type ShouldHighlightSpecificMessageMsg struct {
@@ -121,6 +294,113 @@ type Notification struct {
 	Error             *ErrorNotification             `json:"error,omitempty"`
 	SearchResult      *SearchUsersMsg                `json:"searchResult,omitempty"`
 	ReadHistoryOutbox *ReadHistoryOutboxNotification `json:"readHistoryOutbox,omitempty"`
+	MessageEdited     *MessageEditedNotification     `json:"messageEdited,omitempty"`
+	MessagesDeleted   *MessagesDeletedNotification   `json:"messagesDeleted,omitempty"`
+	MessageReactions  *MessageReactionsNotification  `json:"messageReactions,omitempty"`
+	ConnectionState   *ConnectionStateNotification   `json:"connectionState,omitempty"`
+	Outbox            *OutboxNotification            `json:"outbox,omitempty"`
+	Download          *DownloadProgressNotification  `json:"download,omitempty"`
+	Upload            *UploadProgressNotification    `json:"upload,omitempty"`
+	PollUpdate        *PollUpdateNotification        `json:"pollUpdate,omitempty"`
+	BotCommands       *BotCommandsNotification       `json:"botCommands,omitempty"`
+	ServiceMessage    *ServiceMessageNotification    `json:"serviceMessage,omitempty"`
+	ChannelTooLong    *ChannelTooLongNotification    `json:"channelTooLong,omitempty"`
+}
+
+// BotCommand is a command a bot registered, shown when typing / in a chat with the bot
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+	BotID       string `json:"botId"`
+	// BotUsername goes after the command in groups, where more than one bot may listen
+	BotUsername string `json:"botUsername,omitempty"`
+}
+
+// BotCommandsNotification tells that a bot changed its commands in a chat, or that the bots of
+// the group PeerID changed when BotID is empty
+type BotCommandsNotification struct {
+	PeerID string `json:"peerId"`
+	BotID  string `json:"botId"`
+}
+
+// ServiceMessageNotification tells something happened in a chat, like someone joining or a
+// message getting pinned
+type ServiceMessageNotification struct {
+	PeerID   string           `json:"peerId"`
+	PeerType ChatType         `json:"peerType"`
+	TopicID  int              `json:"topicId"`
+	Message  FormattedMessage `json:"message"`
+}
+
+// ChannelTooLongNotification tells that a channel missed more updates than telegram would
+// replay, its history and unread count have to be fetched again
+type ChannelTooLongNotification struct {
+	PeerID string `json:"peerId"`
+}
+
+type OutboxState string
+
+const (
+	OutboxPending OutboxState = "pending"
+	OutboxFailed  OutboxState = "failed"
+	OutboxSent    OutboxState = "sent"
+)
+
+// OutboxEntry is a message we are trying to send, kept on disk until the server confirms it
+type OutboxEntry struct {
+	Request     SendMessageRequest `json:"request"`
+	State       OutboxState        `json:"state"`
+	Attempts    int                `json:"attempts"`
+	LastError   string             `json:"lastError,omitempty"`
+	NextAttempt time.Time          `json:"nextAttempt"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	// Permanent is set when sending again won't help, like a chat we can't write in. those
+	// wait for a retry or discard by hand
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// OutboxNotification reports progress of a message sent in the background (automatic retries)
+type OutboxNotification struct {
+	RandID    int         `json:"randId"`
+	Peer      Peer        `json:"peer"`
+	State     OutboxState `json:"state"`
+	MessageID *int        `json:"messageId,omitempty"`
+	Err       error       `json:"error,omitempty"`
+}
+
+// DownloadProgressNotification reports how far a media download got, Total is 0 when the size isn't known
+type DownloadProgressNotification struct {
+	Peer       Peer  `json:"peer"`
+	MessageID  int   `json:"messageId"`
+	Downloaded int64 `json:"downloaded"`
+	Total      int64 `json:"total"`
+}
+
+// UploadProgressNotification reports how far the file of a message being sent got
+type UploadProgressNotification struct {
+	RandID   int    `json:"randId"`
+	Peer     Peer   `json:"peer"`
+	FileName string `json:"fileName"`
+	Uploaded int64  `json:"uploaded"`
+	Total    int64  `json:"total"`
+}
+
+type ConnectionState string
+
+const (
+	ConnectionConnecting   ConnectionState = "connecting"
+	ConnectionConnected    ConnectionState = "connected"
+	ConnectionReconnecting ConnectionState = "reconnecting"
+	// ConnectionDead means the client gave up, only a restart brings it back
+	ConnectionDead ConnectionState = "dead"
+)
+
+type ConnectionStateNotification struct {
+	State ConnectionState `json:"state"`
+	Err   error           `json:"error,omitempty"`
+	Since time.Time       `json:"since"`
+	// PendingSends is how many messages wait for the connection to come back
+	PendingSends int `json:"pendingSends"`
 }
 
 type ForumTopicInfo struct {
@@ -133,6 +413,10 @@ type NewMessageNotification struct {
 	ID      int         `json:"id"`
 	FromID  string      `json:"fromId"`
 	Message *tg.Message `json:"message"`
+	// PeerType is only set for group and channel messages, FromID is then the chat id
+	PeerType ChatType `json:"peerType,omitempty"`
+	// Sender is the user who wrote a group message, nil for channel posts
+	Sender *UserInfo `json:"sender,omitempty"`
 }
 
 type ReadHistoryOutboxNotification struct {
@@ -141,6 +425,34 @@ type ReadHistoryOutboxNotification struct {
 	PeerType ChatType `json:"peerType"`
 }
 
+type MessageEditedNotification struct {
+	PeerID   string      `json:"peerId"`
+	PeerType ChatType    `json:"peerType"`
+	Message  *tg.Message `json:"message"`
+}
+
+type MessagesDeletedNotification struct {
+	// PeerID is empty for private chats and basic groups, telegram doesn't tell us the chat
+	// because their message ids are unique across the whole account
+	PeerID     string `json:"peerId,omitempty"`
+	MessageIDs []int  `json:"messageIds"`
+}
+
+type MessageReactionsNotification struct {
+	PeerID    string              `json:"peerId"`
+	PeerType  ChatType            `json:"peerType"`
+	MessageID int                 `json:"messageId"`
+	Reactions tg.MessageReactions `json:"reactions"`
+}
+
+// PollUpdateNotification carries new results of a poll, Poll is only set when the poll itself
+// changed (closed for example). telegram identifies the poll and not the message it is in
+type PollUpdateNotification struct {
+	PollID  int64          `json:"pollId"`
+	Poll    *tg.Poll       `json:"poll,omitempty"`
+	Results tg.PollResults `json:"results"`
+}
+
 type UserStatusNotification struct {
 	UserInfo UserInfo   `json:"userInfo"`
 	Status   UserStatus `json:"status"`
@@ -148,6 +460,64 @@ type UserStatusNotification struct {
 
 type UserTypingNotification struct {
 	User UserInfo `json:"user"`
+	// PeerID is the chat the user is typing in, for private chats it's the user itself
+	PeerID   string       `json:"peerId"`
+	PeerType ChatType     `json:"peerType"`
+	Action   TypingAction `json:"action"`
+}
+
+type TypingAction string
+
+const (
+	TypingActionTyping         TypingAction = "typing"
+	TypingActionCancel         TypingAction = "cancel"
+	TypingActionUploadPhoto    TypingAction = "uploadPhoto"
+	TypingActionUploadVideo    TypingAction = "uploadVideo"
+	TypingActionUploadDocument TypingAction = "uploadDocument"
+	TypingActionUploadAudio    TypingAction = "uploadAudio"
+	TypingActionUploadRound    TypingAction = "uploadRound"
+	TypingActionRecordVoice    TypingAction = "recordVoice"
+	TypingActionRecordVideo    TypingAction = "recordVideo"
+	TypingActionRecordRound    TypingAction = "recordRound"
+	TypingActionChooseSticker  TypingAction = "chooseSticker"
+	TypingActionChooseContact  TypingAction = "chooseContact"
+	TypingActionGeoLocation    TypingAction = "geoLocation"
+	TypingActionGamePlay       TypingAction = "gamePlay"
+	TypingActionImportHistory  TypingAction = "importHistory"
+)
+
+// Describe returns what the user is doing, e.g. "is uploading a photo"
+func (a TypingAction) Describe() string {
+	switch a {
+	case TypingActionUploadPhoto:
+		return "is uploading a photo"
+	case TypingActionUploadVideo:
+		return "is uploading a video"
+	case TypingActionUploadDocument:
+		return "is uploading a file"
+	case TypingActionUploadAudio:
+		return "is uploading an audio"
+	case TypingActionUploadRound:
+		return "is uploading a video message"
+	case TypingActionRecordVoice:
+		return "is recording a voice message"
+	case TypingActionRecordVideo:
+		return "is recording a video"
+	case TypingActionRecordRound:
+		return "is recording a video message"
+	case TypingActionChooseSticker:
+		return "is choosing a sticker"
+	case TypingActionChooseContact:
+		return "is choosing a contact"
+	case TypingActionGeoLocation:
+		return "is picking a location"
+	case TypingActionGamePlay:
+		return "is playing a game"
+	case TypingActionImportHistory:
+		return "is importing history"
+	default:
+		return "is typing"
+	}
 }
 
 type ErrorNotification struct {
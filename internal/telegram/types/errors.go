@@ -31,6 +31,8 @@ const (
 	ErrorCodeSessionFailed     = 1009
 	ErrorCodeUploadFailed      = 1010
 	ErrorCodeInvalidFile       = 1011
+	ErrorCodeSearchFailed      = 1012
+	ErrorCodeDownloadFailed    = 1013
 )
 
 func NewTelegramError(code int, message string, cause error) *TelegramError {
@@ -72,3 +74,11 @@ func NewEditMessageError(cause error) *TelegramError {
 func NewForwardMessageError(cause error) *TelegramError {
 	return NewTelegramError(ErrorCodeForwardFailed, "failed to forward message", cause)
 }
+
+func NewSearchMessagesError(cause error) *TelegramError {
+	return NewTelegramError(ErrorCodeSearchFailed, "failed to search messages", cause)
+}
+
+func NewDownloadMediaError(cause error) *TelegramError {
+	return NewTelegramError(ErrorCodeDownloadFailed, "failed to download media", cause)
+}
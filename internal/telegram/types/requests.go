@@ -1,5 +1,12 @@
 package types // nolint:revive
 
+const (
+	// MaxAlbumSize is how many files telegram puts in one album
+	MaxAlbumSize = 10
+	// MaxPollOptions is how many answers a poll can have
+	MaxPollOptions = 10
+)
+
 type SendMessageRequest struct {
 	RandID           int    `json:"randId"`
 	Peer             Peer   `json:"peer"`
@@ -8,15 +15,74 @@ type SendMessageRequest struct {
 	ReplyToMessageID string `json:"replyToMessageId,omitempty"`
 	IsFile           bool   `json:"isFile"`
 	FilePath         string `json:"filePath,omitempty"`
-	TopMsgID         *int   `json:"topMsgId,omitempty"`
+	// FilePaths are sent together as one album instead of FilePath, Message is its caption
+	FilePaths []string `json:"filePaths,omitempty"`
+	TopMsgID  *int     `json:"topMsgId,omitempty"`
+	// SendAsFile sends photos and videos as plain documents so the original reaches the peer uncompressed
+	SendAsFile bool `json:"sendAsFile,omitempty"`
+	// AlbumRandIDs are the random ids of the album files after the first one, which goes by
+	// RandID. the outbox picks them once so a retry can't post the same album twice
+	AlbumRandIDs []int64 `json:"albumRandIds,omitempty"`
 }
 
+// HistoryDirection says which page of a chat's history to load relative to OffsetID
+type HistoryDirection string
+
+const (
+	// the newest messages, OffsetID is ignored
+	HistoryLatest HistoryDirection = ""
+	// messages on both sides of OffsetID or OffsetDate, used to jump to a message or a day
+	HistoryAround HistoryDirection = "around"
+	// messages older than OffsetID
+	HistoryOlder HistoryDirection = "older"
+	// messages newer than OffsetID
+	HistoryNewer HistoryDirection = "newer"
+)
+
 type GetMessagesRequest struct {
-	Peer          Peer `json:"peer"`
-	Limit         int  `json:"limit"`
-	OffsetID      *int `json:"offsetId,omitempty"`
-	ChatAreaWidth *int `json:"chatAreaWidth,omitempty"`
-	TopMsgID      *int `json:"topMsgId,omitempty"`
+	Peer          Peer             `json:"peer"`
+	Limit         int              `json:"limit"`
+	OffsetID      *int             `json:"offsetId,omitempty"`
+	ChatAreaWidth *int             `json:"chatAreaWidth,omitempty"`
+	TopMsgID      *int             `json:"topMsgId,omitempty"`
+	Direction     HistoryDirection `json:"direction,omitempty"`
+	// OffsetDate is a unix time, with HistoryAround it loads the messages sent around then
+	OffsetDate int `json:"offsetDate,omitempty"`
+}
+
+// MessageSearchFilter narrows a message search down to one kind of content
+type MessageSearchFilter string
+
+const (
+	SearchFilterNone   MessageSearchFilter = ""
+	SearchFilterLinks  MessageSearchFilter = "links"
+	SearchFilterFiles  MessageSearchFilter = "files"
+	SearchFilterPhotos MessageSearchFilter = "photos"
+)
+
+type SearchMessagesRequest struct {
+	Peer     Peer   `json:"peer"`
+	TopMsgID *int   `json:"topMsgId,omitempty"`
+	Query    string `json:"query"`
+	// From limits results to one sender, FromUsername does the same for a sender that
+	// still has to be resolved ("@name") or for ourselves ("me")
+	From         *Peer               `json:"from,omitempty"`
+	FromUsername string              `json:"fromUsername,omitempty"`
+	Filter       MessageSearchFilter `json:"filter,omitempty"`
+	Limit        int                 `json:"limit"`
+	// OffsetID continues a search below the oldest result we already have
+	OffsetID int `json:"offsetId,omitempty"`
+}
+
+// SearchGlobalRequest searches messages in every chat. the next page starts after the last
+// result, which the server identifies by its rate, peer and id
+type SearchGlobalRequest struct {
+	Query      string              `json:"query"`
+	Filter     MessageSearchFilter `json:"filter,omitempty"`
+	Limit      int                 `json:"limit"`
+	OffsetRate int                 `json:"offsetRate,omitempty"`
+	OffsetPeer *Peer               `json:"offsetPeer,omitempty"`
+	OffsetID   int                 `json:"offsetId,omitempty"`
 }
 
 type SendReactionRequest struct {
@@ -29,12 +95,16 @@ type SendReactionRequest struct {
 type DeleteMessageRequest struct {
 	Peer      Peer `json:"peer"`
 	MessageID int  `json:"messageId"`
+	// AlbumIDs are the other messages of an album, deleted along with MessageID
+	AlbumIDs []int `json:"albumIds,omitempty"`
 }
 
 type EditMessageRequest struct {
 	Peer       Peer   `json:"peer"`
 	MessageID  int    `json:"messageId"`
 	NewMessage string `json:"newMessage"`
+	// Markup is how NewMessage is written, empty for the configured chat.messageFormat
+	Markup string `json:"markup,omitempty"`
 }
 
 type ForwardMessagesRequest struct {
@@ -51,3 +121,76 @@ type MarkAsReadRequest struct {
 type SetTypingRequest struct {
 	Peer Peer `json:"peer"`
 }
+
+// DownloadMediaRequest saves the file of a message to the download directory, Open hands it to
+// the default app once it is there
+type DownloadMediaRequest struct {
+	Peer      Peer `json:"peer"`
+	MessageID int  `json:"messageId"`
+	Open      bool `json:"open"`
+}
+
+// VotePollRequest votes in the poll of a message, no Options takes the vote back
+type VotePollRequest struct {
+	Peer      Peer     `json:"peer"`
+	MessageID int      `json:"messageId"`
+	Options   [][]byte `json:"options"`
+}
+
+// NewPoll is a poll or quiz written in the poll composer
+type NewPoll struct {
+	Question       string   `json:"question"`
+	Options        []string `json:"options"`
+	MultipleChoice bool     `json:"multipleChoice"`
+	Quiz           bool     `json:"quiz"`
+	// CorrectOption is the index of the right answer of a quiz
+	CorrectOption int `json:"correctOption"`
+	// Solution is shown to quiz voters after they answered, may be empty
+	Solution string `json:"solution,omitempty"`
+}
+
+type SendPollRequest struct {
+	Peer     Peer    `json:"peer"`
+	Poll     NewPoll `json:"poll"`
+	TopMsgID *int    `json:"topMsgId,omitempty"`
+	// RandID stays the same when the request is sent again, telegram drops the duplicate
+	RandID int `json:"randId"`
+}
+
+// BotCallbackRequest presses a callback or game button under a bot message
+type BotCallbackRequest struct {
+	Peer      Peer   `json:"peer"`
+	MessageID int    `json:"messageId"`
+	Data      []byte `json:"data,omitempty"`
+	Game      bool   `json:"game,omitempty"`
+}
+
+// BotCommandsRequest asks for the commands of the bot of a bot chat or of every bot in a group
+type BotCommandsRequest struct {
+	Peer Peer `json:"peer"`
+}
+
+// InlineQueryRequest asks an inline bot for results, Offset is the NextOffset of the page before
+type InlineQueryRequest struct {
+	Peer   Peer   `json:"peer"`
+	Bot    string `json:"bot"`
+	Query  string `json:"query"`
+	Offset string `json:"offset,omitempty"`
+}
+
+// SendInlineResultRequest sends a result of an inline query as a message of ours
+type SendInlineResultRequest struct {
+	Peer     Peer   `json:"peer"`
+	QueryID  int64  `json:"queryId"`
+	ResultID string `json:"resultId"`
+	TopMsgID *int   `json:"topMsgId,omitempty"`
+	// RandID stays the same when the request is sent again, telegram drops the duplicate
+	RandID int `json:"randId"`
+}
+
+// LoadImageRequest reads the picture of a message into memory for the image viewer: the photo,
+// the image or sticker, or the thumbnail of a video
+type LoadImageRequest struct {
+	Peer      Peer `json:"peer"`
+	MessageID int  `json:"messageId"`
+}
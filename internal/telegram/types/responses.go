@@ -16,6 +16,9 @@ type GetChannelForumsResponseMsg struct {
 
 type SendMessageResponse struct {
 	MessageID *int `json:"messageId,omitempty"`
+	// AlbumIDs and GroupedID are set when an album was sent, MessageID is its first message then
+	AlbumIDs  []int `json:"albumIds,omitempty"`
+	GroupedID int64 `json:"groupedId,omitempty"`
 }
 
 type DeleteMessageResponse struct {
@@ -27,7 +30,7 @@ type MarkMessagesAsReadMsg struct {
 	Err      error `json:"error,omitempty"`
 }
 type GetMessagesResponse struct {
-	Messages [50]FormattedMessage `json:"messages"`
+	Messages []FormattedMessage `json:"messages"`
 }
 
 type UserChatsResponse struct {
@@ -73,7 +76,21 @@ type SendMessageMsg struct {
 }
 
 type GetMessagesMsg struct {
-	Messages [50]FormattedMessage `json:"messages"`
+	// oldest first
+	Messages []FormattedMessage `json:"messages"`
+	Err      error              `json:"error,omitempty"`
+	// the messages were read from the local cache, a fresh copy from the server follows
+	FromCache bool `json:"fromCache"`
+	// Peer, TopMsgID and Direction echo the request so the ui can drop pages of a chat it already left
+	Peer      Peer             `json:"peer"`
+	TopMsgID  *int             `json:"topMsgId,omitempty"`
+	Direction HistoryDirection `json:"direction,omitempty"`
+	// HasMore is false when the page came back short, there is nothing further in that direction
+	HasMore bool `json:"hasMore"`
+}
+
+type AllChatsMsg struct {
+	Response *GetAllChatsResponse `json:"response,omitempty"`
 	Err      error                `json:"error,omitempty"`
 }
 
@@ -91,6 +108,38 @@ type GroupsMsg struct {
 	Err      error              `json:"error,omitempty"`
 }
 
+type SearchMessagesMsg struct {
+	Request SearchMessagesRequest `json:"request"`
+	// newest first, the order the server returns search results in
+	Messages []FormattedMessage `json:"messages"`
+	// Count is how many messages match in total, more than len(Messages) when there are more pages
+	Count int   `json:"count"`
+	Err   error `json:"error,omitempty"`
+}
+
+// GlobalSearchResult is a message found by a global search together with the chat it is in,
+// User is set for private chats and Channel for channels and groups
+type GlobalSearchResult struct {
+	Message   FormattedMessage `json:"message"`
+	Peer      Peer             `json:"peer"`
+	ChatTitle string           `json:"chatTitle"`
+	User      *UserInfo        `json:"user,omitempty"`
+	Channel   *ChannelInfo     `json:"channel,omitempty"`
+}
+
+func (r GlobalSearchResult) FilterValue() string {
+	return r.ChatTitle + " " + r.Message.Content
+}
+
+type SearchGlobalMsg struct {
+	Request SearchGlobalRequest  `json:"request"`
+	Results []GlobalSearchResult `json:"results"`
+	// NextRate is the offset rate of the next page, 0 when this was the last one
+	NextRate int   `json:"nextRate,omitempty"`
+	Count    int   `json:"count"`
+	Err      error `json:"error,omitempty"`
+}
+
 type SearchUsersMsg struct {
 	Response *[]UserInfo `json:"response,omitempty"`
 	Err      error       `json:"error,omitempty"`
@@ -100,6 +149,7 @@ type EditMessageMsg struct {
 	Response       bool
 	Err            error
 	UpdatedMessage string
+	Entities       []MessageEntity
 }
 
 type Stories struct {
@@ -130,6 +180,9 @@ type StoriesDownloadStatusMsg struct {
 	Err error
 	// a peer who posted the story
 	Peer Peer
+	// Photos are the saved story photos to show in the image viewer, set when they were asked
+	// for instead of opening them in the default app
+	Photos []string
 }
 
 type GetUserChatsResult struct {
@@ -151,3 +204,80 @@ type SingleMessageMsg struct {
 	Message *FormattedMessage
 	Err     error
 }
+
+type DownloadMediaMsg struct {
+	Request DownloadMediaRequest
+	// Path the file was saved to
+	Path string
+	Err  error
+}
+
+type VotePollMsg struct {
+	Request VotePollRequest
+	// Update has the results after the vote, nil when the server didn't send them along
+	Update *PollUpdateNotification
+	Err    error
+}
+
+type SendPollMsg struct {
+	Request SendPollRequest
+	// Message is the poll message as sent, nil when the server didn't send it along
+	Message *tg.Message
+	Err     error
+}
+
+type BotCallbackAnswerMsg struct {
+	Request BotCallbackRequest
+	// Message is the bot's answer, empty when it only acknowledged the press. Alert asks for it
+	// to stay until it is closed
+	Message string
+	Alert   bool
+	// URL is a link the bot wants opened, games open this way
+	URL string
+	Err error
+}
+
+type BotCommandsMsg struct {
+	Request  BotCommandsRequest
+	Commands []BotCommand
+	Err      error
+}
+
+// InlineResult is one result of an inline query, Text is the message it sends when it is text
+type InlineResult struct {
+	// QueryID has to go along when the result is sent, every page of results has its own
+	QueryID     int64
+	ID          string
+	Type        string
+	Title       string
+	Description string
+	Text        string
+	URL         string
+}
+
+type InlineQueryMsg struct {
+	Request InlineQueryRequest
+	Results []InlineResult
+	// NextOffset asks for the next page, empty on the last one
+	NextOffset string
+	// Placeholder is the bot's hint for what to type
+	Placeholder string
+	// NotInlineBot is set when the username isn't an inline bot, the message just starts with a
+	// mention then
+	NotInlineBot bool
+	Err          error
+}
+
+type SendInlineResultMsg struct {
+	Request SendInlineResultRequest
+	// Message is the message as sent, nil when the server didn't send it along
+	Message *tg.Message
+	Err     error
+}
+
+type LoadImageMsg struct {
+	Request LoadImageRequest
+	// Data is the encoded image, jpeg, png or webp
+	Data []byte
+	Err  error
+}
@@ -0,0 +1,46 @@
+package termimage
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// pixels more transparent than this are left out, stickers have a transparent background
+const alphaThreshold = 0x8000
+
+// renderBlocks draws two pixels per cell, the top one is the foreground of ▀ and the bottom one
+// its background. colors go through the terminal's profile, so 256 and 16 color terminals get
+// the closest they have
+func renderBlocks(img image.Image, cols, rows int) string {
+	scaled := scale(img, cols, rows*2)
+	profile := lipgloss.ColorProfile()
+	lines := make([]string, 0, rows)
+	for y := 0; y < rows*2; y += 2 {
+		var line strings.Builder
+		for x := range cols {
+			top, bottom := scaled.At(x, y), scaled.At(x, y+1)
+			topVisible, bottomVisible := visible(top), visible(bottom)
+			cell := termenv.String(" ")
+			switch {
+			case topVisible && bottomVisible:
+				cell = termenv.String("▀").Foreground(profile.FromColor(top)).Background(profile.FromColor(bottom))
+			case topVisible:
+				cell = termenv.String("▀").Foreground(profile.FromColor(top))
+			case bottomVisible:
+				cell = termenv.String("▄").Foreground(profile.FromColor(bottom))
+			}
+			line.WriteString(cell.String())
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func visible(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a >= alphaThreshold
+}
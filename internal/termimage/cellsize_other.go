@@ -0,0 +1,8 @@
+//go:build !unix
+
+package termimage
+
+// cellSize is the size of a terminal cell in pixels, there is no way to ask for it here
+func cellSize() (int, int) {
+	return defaultCellWidth, defaultCellHeight
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package termimage
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cellSize is the size of a terminal cell in pixels. most terminals report it with the window
+// size, the usual 10×20 is assumed for those that don't
+func cellSize() (int, int) {
+	size, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 || size.Row == 0 || size.Xpixel == 0 || size.Ypixel == 0 {
+		return defaultCellWidth, defaultCellHeight
+	}
+	return int(size.Xpixel / size.Col), int(size.Ypixel / size.Row)
+}
@@ -0,0 +1,20 @@
+package termimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+// renderITerm2 sends the picture as an inline png file, wezterm understands it as well
+func renderITerm2(img image.Image, cols, rows int) string {
+	cellWidth, cellHeight := cellSize()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, shrink(img, cols*cellWidth, rows*cellHeight)); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1;doNotMoveCursor=1:%s\a",
+		encoded.Len(), cols, rows, base64.StdEncoding.EncodeToString(encoded.Bytes()))
+}
@@ -0,0 +1,47 @@
+package termimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+)
+
+const (
+	// the viewer shows one picture at a time, a fixed id makes a redraw replace it
+	kittyImageID = 4242
+	// kitty takes the payload in chunks of at most this many bytes
+	kittyChunkSize = 4096
+)
+
+// renderKitty sends the picture as png, the terminal scales it into the cols×rows box. C=1 keeps
+// the cursor where it was and q=2 silences the replies that would otherwise end up on stdin
+func renderKitty(img image.Image, cols, rows int) string {
+	cellWidth, cellHeight := cellSize()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, shrink(img, cols*cellWidth, rows*cellHeight)); err != nil {
+		return ""
+	}
+	payload := base64.StdEncoding.EncodeToString(encoded.Bytes())
+
+	var b strings.Builder
+	for start := 0; start < len(payload); start += kittyChunkSize {
+		end := min(len(payload), start+kittyChunkSize)
+		more := 0
+		if end < len(payload) {
+			more = 1
+		}
+		if start == 0 {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,i=%d,p=1,q=2,C=1,c=%d,r=%d,m=%d;%s\x1b\\", kittyImageID, cols, rows, more, payload[start:end])
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, payload[start:end])
+		}
+	}
+	return b.String()
+}
+
+func kittyClear() string {
+	return fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", kittyImageID)
+}
@@ -0,0 +1,102 @@
+package termimage
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// renderSixel draws the picture as sixels in a box of width×height pixels. sixel terminals don't
+// scale, so the picture is resized and dithered down to the 256 plan9 colors here
+func renderSixel(img image.Image, width, height int) string {
+	bounds := img.Bounds()
+	if bounds.Empty() || width <= 0 || height <= 0 {
+		return ""
+	}
+	ratio := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	scaled := scale(img, max(1, int(float64(bounds.Dx())*ratio)), max(1, int(float64(bounds.Dy())*ratio)))
+	box := scaled.Bounds()
+	paletted := image.NewPaletted(box, palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, box, scaled, image.Point{})
+
+	// -1 marks a transparent pixel, it is left undrawn
+	pixels := make([]int, box.Dx()*box.Dy())
+	used := make([]bool, len(palette.Plan9))
+	for y := range box.Dy() {
+		for x := range box.Dx() {
+			index := -1
+			if scaled.NRGBAAt(x, y).A >= 0x80 {
+				index = int(paletted.ColorIndexAt(x, y))
+				used[index] = true
+			}
+			pixels[y*box.Dx()+x] = index
+		}
+	}
+
+	var b strings.Builder
+	// P2=1 keeps undrawn pixels transparent, the raster attributes say 1:1 pixels and the size
+	fmt.Fprintf(&b, "\x1bP0;1;0q\"1;1;%d;%d", box.Dx(), box.Dy())
+	for i, c := range palette.Plan9 {
+		if used[i] {
+			r, g, bl, _ := c.RGBA()
+			fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+		}
+	}
+	// a sixel is a column of six pixels, each band of six rows is drawn once per color in it
+	inBand := make([]bool, len(palette.Plan9))
+	for top := 0; top < box.Dy(); top += 6 {
+		clear(inBand)
+		for y := top; y < min(top+6, box.Dy()); y++ {
+			for _, index := range pixels[y*box.Dx() : (y+1)*box.Dx()] {
+				if index != -1 {
+					inBand[index] = true
+				}
+			}
+		}
+		for index, present := range inBand {
+			if !present {
+				continue
+			}
+			fmt.Fprintf(&b, "#%d", index)
+			writeSixelRow(&b, pixels, box.Dx(), box.Dy(), top, index)
+			// back to the start of the band for the next color
+			b.WriteByte('$')
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRow writes the sixels of one color in one band, runs of the same sixel are compressed
+func writeSixelRow(b *strings.Builder, pixels []int, width, height, top, index int) {
+	var last byte
+	run := 0
+	flush := func() {
+		switch {
+		case run > 3:
+			fmt.Fprintf(b, "!%d%c", run, last)
+		case run > 0:
+			b.WriteString(strings.Repeat(string(last), run))
+		}
+	}
+	for x := range width {
+		bits := 0
+		for k := range 6 {
+			if y := top + k; y < height && pixels[y*width+x] == index {
+				bits |= 1 << k
+			}
+		}
+		sixel := byte(63 + bits)
+		if sixel == last {
+			run++
+			continue
+		}
+		flush()
+		last, run = sixel, 1
+	}
+	flush()
+}
@@ -0,0 +1,171 @@
+// Package termimage draws images in the terminal with the best protocol it understands: kitty
+// graphics, iterm2 inline images, sixel, or unicode half blocks that work anywhere with colors
+package termimage
+
+import (
+	"image"
+	_ "image/gif" // decoders for Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/kumneger0/cligram/internal/config"
+	"github.com/muesli/termenv"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // stickers
+)
+
+// the usual cell size in pixels, for terminals that don't report theirs
+const (
+	defaultCellWidth  = 10
+	defaultCellHeight = 20
+	// the biggest side Decode keeps, plenty for a full screen picture
+	maxDecodedSize = 2560
+)
+
+type Protocol string
+
+const (
+	Kitty  Protocol = "kitty"
+	ITerm2 Protocol = "iterm2"
+	Sixel  Protocol = "sixel"
+	// Blocks draws two pixels per cell with ▀ and colors, it is plain text to the terminal
+	Blocks Protocol = "blocks"
+	// None is a terminal without colors, images can't be shown at all
+	None Protocol = "none"
+)
+
+// Graphics reports whether the protocol draws real pixels rather than text
+func (p Protocol) Graphics() bool {
+	return p == Kitty || p == ITerm2 || p == Sixel
+}
+
+// Detect picks the protocol from images.protocol, or from the environment when that is unset
+// or "auto". terminals can be asked what they support, but the answer would arrive on stdin
+// which bubbletea reads, so the environment is all we go by
+func Detect() Protocol {
+	if protocol := config.GetConfig().Images.Protocol; protocol != nil {
+		switch p := Protocol(*protocol); p {
+		case Kitty, ITerm2, Sixel, Blocks, None:
+			return p
+		}
+	}
+	if termenv.EnvColorProfile() == termenv.Ascii {
+		return None
+	}
+	return detect(os.Getenv)
+}
+
+func detect(getenv func(string) string) Protocol {
+	term, program := getenv("TERM"), getenv("TERM_PROGRAM")
+	if term == "dumb" {
+		return None
+	}
+	// tmux and screen drop graphics escapes unless passthrough is set up, blocks always get through
+	if getenv("TMUX") != "" || getenv("STY") != "" || strings.HasPrefix(term, "screen") || strings.HasPrefix(term, "tmux") {
+		return Blocks
+	}
+	switch {
+	case getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || program == "ghostty":
+		return Kitty
+	case program == "iTerm.app" || program == "WezTerm" || getenv("LC_TERMINAL") == "iTerm2":
+		return ITerm2
+	case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || term == "mlterm" ||
+		program == "contour" || getenv("KONSOLE_VERSION") != "" || getenv("WT_SESSION") != "":
+		return Sixel
+	}
+	return Blocks
+}
+
+// PreviewsEnabled is images.previews, photo previews in the chat are on unless turned off
+func PreviewsEnabled() bool {
+	previews := config.GetConfig().Images.Previews
+	return previews == nil || *previews
+}
+
+// Decode reads a jpeg, png, gif or webp image. a big one is scaled down right away, no terminal
+// shows that many pixels and every render would have to go through all of them
+func Decode(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return shrink(img, maxDecodedSize, maxDecodedSize), nil
+}
+
+// Fit is the biggest box of at most cols×rows cells that shows a width×height image without
+// stretching it
+func Fit(width, height, cols, rows int) (int, int) {
+	if width <= 0 || height <= 0 || cols <= 0 || rows <= 0 {
+		return 0, 0
+	}
+	cellWidth, cellHeight := cellSize()
+	// rows the image takes at the full width
+	fitRows := int(math.Round(float64(cols) * float64(cellWidth) * float64(height) / float64(width) / float64(cellHeight)))
+	if fitRows <= rows {
+		return cols, max(1, fitRows)
+	}
+	fitCols := int(math.Round(float64(rows) * float64(cellHeight) * float64(width) / float64(height) / float64(cellWidth)))
+	return max(1, min(cols, fitCols)), rows
+}
+
+// Render draws img in a box of cols×rows cells and takes exactly rows lines. the box should come
+// from Fit. graphics protocols put the picture over blank lines, the caller must not draw over them
+func Render(img image.Image, protocol Protocol, cols, rows int) string {
+	if cols <= 0 || rows <= 0 {
+		return ""
+	}
+	var picture string
+	switch protocol {
+	case Kitty:
+		picture = renderKitty(img, cols, rows)
+	case ITerm2:
+		picture = renderITerm2(img, cols, rows)
+	case Sixel:
+		cellWidth, cellHeight := cellSize()
+		picture = renderSixel(img, cols*cellWidth, rows*cellHeight)
+	case Blocks:
+		return renderBlocks(img, cols, rows)
+	default:
+		return ""
+	}
+	// the escape is zero width, the blank cells hold the place of the picture for the layout
+	blank := strings.Repeat(" ", cols)
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = blank
+	}
+	// the cursor is saved and restored around the picture, bubbletea expects it where the text left it
+	lines[0] = "\x1b7" + picture + "\x1b8" + blank
+	return strings.Join(lines, "\n")
+}
+
+// Clear removes pictures a graphics protocol left on screen. kitty keeps them over the text
+// until they are deleted, the other protocols paint cells that text simply overwrites
+func Clear(protocol Protocol) string {
+	if protocol == Kitty {
+		return kittyClear()
+	}
+	return ""
+}
+
+// scale resizes img to width×height pixels
+func scale(img image.Image, width, height int) *image.NRGBA {
+	scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return scaled
+}
+
+// shrink scales img down to fit in width×height pixels, smaller images are kept as they are.
+// there is no point sending the terminal more pixels than it shows
+func shrink(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width && bounds.Dy() <= height {
+		return img
+	}
+	ratio := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	return scale(img, max(1, int(float64(bounds.Dx())*ratio)), max(1, int(float64(bounds.Dy())*ratio)))
+}
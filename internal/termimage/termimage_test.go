@@ -0,0 +1,36 @@
+package termimage
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want Protocol
+	}{
+		{"nothing set", nil, Blocks},
+		{"dumb terminal", map[string]string{"TERM": "dumb"}, None},
+		{"kitty window", map[string]string{"TERM": "xterm-256color", "KITTY_WINDOW_ID": "1"}, Kitty},
+		{"kitty terminfo", map[string]string{"TERM": "xterm-kitty"}, Kitty},
+		{"ghostty", map[string]string{"TERM_PROGRAM": "ghostty"}, Kitty},
+		{"iterm2", map[string]string{"TERM_PROGRAM": "iTerm.app"}, ITerm2},
+		{"wezterm", map[string]string{"TERM_PROGRAM": "WezTerm"}, ITerm2},
+		{"iterm2 over ssh", map[string]string{"LC_TERMINAL": "iTerm2"}, ITerm2},
+		{"sixel terminfo", map[string]string{"TERM": "xterm-sixel"}, Sixel},
+		{"foot", map[string]string{"TERM": "foot-extra"}, Sixel},
+		{"konsole", map[string]string{"TERM": "xterm-256color", "KONSOLE_VERSION": "230804"}, Sixel},
+		{"windows terminal", map[string]string{"WT_SESSION": "abc"}, Sixel},
+		{"plain xterm", map[string]string{"TERM": "xterm-256color"}, Blocks},
+		{"kitty inside tmux", map[string]string{"TERM": "tmux-256color", "TMUX": "/tmp/tmux", "KITTY_WINDOW_ID": "1"}, Blocks},
+		{"iterm2 inside screen", map[string]string{"TERM": "screen", "STY": "1.pts", "TERM_PROGRAM": "iTerm.app"}, Blocks},
+		{"dumb wins over tmux", map[string]string{"TERM": "dumb", "TMUX": "/tmp/tmux"}, None},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			getenv := func(key string) string { return c.env[key] }
+			if got := detect(getenv); got != c.want {
+				t.Errorf("detect() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
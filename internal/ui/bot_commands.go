@@ -0,0 +1,179 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// the menu shows this many commands, typing more of the command narrows it down
+const commandMenuSize = 8
+
+// botCommandMenu holds the commands of the bots in the open chat, they are loaded the first time
+// / is typed there
+type botCommandMenu struct {
+	PeerID   string
+	Commands []types.BotCommand
+	Loading  bool
+	// Index is the highlighted command of the matches
+	Index int
+}
+
+// commandMatches are the commands starting with what was typed after the /, nil unless the
+// input holds nothing but the start of a command
+func (m Model) commandMatches() []types.BotCommand {
+	value := m.Input.Value()
+	if m.FocusedOn != Input || !strings.HasPrefix(value, "/") || strings.ContainsAny(value, " \n") {
+		return nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok || m.BotCommands.PeerID != peer.ID {
+		return nil
+	}
+	typed, bot, _ := strings.Cut(strings.ToLower(value[1:]), "@")
+	var matches []types.BotCommand
+	for _, command := range m.BotCommands.Commands {
+		if !strings.HasPrefix(strings.ToLower(command.Command), typed) {
+			continue
+		}
+		if bot != "" && !strings.HasPrefix(strings.ToLower(command.BotUsername), bot) {
+			continue
+		}
+		matches = append(matches, command)
+	}
+	// a command typed out in full comes first, enter sends /start and not /startgame listed before it
+	slices.SortStableFunc(matches, func(a, b types.BotCommand) int {
+		aExact, bExact := strings.EqualFold(a.Command, typed), strings.EqualFold(b.Command, typed)
+		switch {
+		case aExact && !bExact:
+			return -1
+		case bExact && !aExact:
+			return 1
+		}
+		return 0
+	})
+	return matches
+}
+
+// commandText is what a picked command puts into the input, groups name the bot since more than
+// one may listen there
+func (m Model) commandText(command types.BotCommand) string {
+	if m.Mode == ModeGroups && command.BotUsername != "" {
+		return "/" + command.Command + "@" + command.BotUsername
+	}
+	return "/" + command.Command
+}
+
+// handleCommandMenuKey loads the commands once / is typed and moves through the menu while it
+// shows. handled is false when the key is for the input
+func (m Model) handleCommandMenuKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	if m.FocusedOn != Input || (m.Mode != ModeBots && m.Mode != ModeGroups) {
+		return m, nil, false
+	}
+	key := msg.String()
+	if key == "/" && m.Input.Value() == "" {
+		return m, m.loadBotCommands(), false
+	}
+	matches := m.commandMatches()
+	if len(matches) == 0 {
+		return m, nil, false
+	}
+	index := min(m.BotCommands.Index, len(matches)-1)
+	switch key {
+	case "up":
+		m.BotCommands.Index = max(0, index-1)
+		return m, nil, true
+	case "down":
+		m.BotCommands.Index = min(len(matches)-1, index+1)
+		return m, nil, true
+	case "tab":
+		m.BotCommands.Index = 0
+		m.Input.SetValue(m.commandText(matches[index]) + " ")
+		m.Input.CursorEnd()
+		return m, nil, true
+	case "enter":
+		m.BotCommands.Index = 0
+		model, cmd := m.sendButtonText(m.commandText(matches[index]))
+		return model, cmd, true
+	}
+	// what was typed changes the matches, the first one is highlighted again
+	m.BotCommands.Index = 0
+	return m, nil, false
+}
+
+func (m *Model) loadBotCommands() tea.Cmd {
+	peer, ok := m.openChatPeer()
+	if !ok || m.BotCommands.PeerID == peer.ID {
+		return nil
+	}
+	m.BotCommands = botCommandMenu{PeerID: peer.ID, Loading: true}
+	return telegram.Cligram.GetBotCommands(telegram.Cligram.Context(), types.BotCommandsRequest{Peer: peer})
+}
+
+func (m Model) handleBotCommands(msg types.BotCommandsMsg) (tea.Model, tea.Cmd) {
+	if msg.Request.Peer.ID != m.BotCommands.PeerID {
+		return m, nil
+	}
+	if msg.Err != nil {
+		slog.Error("failed to get bot commands", "peer", msg.Request.Peer.ID, "error", msg.Err.Error())
+		// the next / tries again
+		m.BotCommands = botCommandMenu{}
+		return m, nil
+	}
+	m.BotCommands.Commands = msg.Commands
+	m.BotCommands.Loading = false
+	return m, nil
+}
+
+// handleBotCommandsChanged reloads the menu of the open chat when one of its bots changed its
+// commands or a bot joined or left the group, the client cache knows about it already
+func (m Model) handleBotCommandsChanged(msg types.BotCommandsNotification) (tea.Model, tea.Cmd) {
+	if m.BotCommands.PeerID == "" || m.BotCommands.Loading {
+		return m, nil
+	}
+	fromMenuBot := msg.BotID != "" && slices.ContainsFunc(m.BotCommands.Commands, func(command types.BotCommand) bool {
+		return command.BotID == msg.BotID
+	})
+	if msg.PeerID != m.BotCommands.PeerID && msg.BotID != m.BotCommands.PeerID && !fromMenuBot {
+		return m, nil
+	}
+	m.BotCommands.PeerID = ""
+	return m, m.loadBotCommands()
+}
+
+func (m *Model) commandMenuView() string {
+	if m.BotCommands.Loading && strings.HasPrefix(m.Input.Value(), "/") && m.FocusedOn == Input {
+		return timestampStyle.Render("loading commands…")
+	}
+	matches := m.commandMatches()
+	if len(matches) == 0 {
+		return ""
+	}
+	index := min(m.BotCommands.Index, len(matches)-1)
+	// the highlighted command stays in view
+	start := max(0, index-commandMenuSize+1)
+	end := min(len(matches), start+commandMenuSize)
+	lines := make([]string, 0, end-start+1)
+	for i := start; i < end; i++ {
+		style := buttonStyle
+		if i == index {
+			style = focusedButtonStyle
+		}
+		line := style.Render(m.commandText(matches[i]))
+		if matches[i].Description != "" {
+			line += " " + timestampStyle.Render(matches[i].Description)
+		}
+		lines = append(lines, line)
+	}
+	hint := "↑ ↓ picks · tab completes · enter sends"
+	if len(matches) > commandMenuSize {
+		hint = fmt.Sprintf("%d of %d · %s", end-start, len(matches), hint)
+	}
+	lines = append(lines, timestampStyle.Render(hint))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,386 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+// the order ctrl+t cycles through in the chat search overlay
+var chatSearchFilters = []types.MessageSearchFilter{
+	types.SearchFilterNone,
+	types.SearchFilterLinks,
+	types.SearchFilterFiles,
+	types.SearchFilterPhotos,
+}
+
+// ChatSearch is the last search inside the open chat, n and N walk through its results
+type ChatSearch struct {
+	Request types.SearchMessagesRequest
+	// newest first, like the server returns them
+	Results []types.FormattedMessage
+	Count   int
+	// index into Results of the result on screen, -1 until the user picks one
+	Current int
+	loading bool
+	// n was pressed on the last loaded result, step once the next page is here
+	stepAfterLoad bool
+}
+
+type ChatSearchQueryMsg struct {
+	Query  string
+	Filter types.MessageSearchFilter
+}
+
+// ChatSearchJumpMsg is sent when a result is picked in the overlay
+type ChatSearchJumpMsg struct {
+	Index int
+}
+
+func nextChatSearchFilter(filter types.MessageSearchFilter) types.MessageSearchFilter {
+	for i, f := range chatSearchFilters {
+		if f == filter {
+			return chatSearchFilters[(i+1)%len(chatSearchFilters)]
+		}
+	}
+	return types.SearchFilterNone
+}
+
+func chatSearchFilterName(filter types.MessageSearchFilter) string {
+	if filter == types.SearchFilterNone {
+		return "all"
+	}
+	return string(filter)
+}
+
+// parseChatSearchQuery pulls a from:<sender> token out of the query, everything else is the text to search for
+func parseChatSearchQuery(input string) (query string, from string) {
+	var words []string
+	for _, word := range strings.Fields(input) {
+		if sender, ok := strings.CutPrefix(word, "from:"); ok && from == "" && sender != "" {
+			from = sender
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), from
+}
+
+// sameChatSearch tells whether a result belongs to the search we are showing, pages of it
+// only differ in the offset
+func sameChatSearch(a, b types.SearchMessagesRequest) bool {
+	a.OffsetID, b.OffsetID = 0, 0
+	if (a.From == nil) != (b.From == nil) || (a.From != nil && a.From.ID != b.From.ID) {
+		return false
+	}
+	if (a.TopMsgID == nil) != (b.TopMsgID == nil) || (a.TopMsgID != nil && *a.TopMsgID != *b.TopMsgID) {
+		return false
+	}
+	return a.Peer.ID == b.Peer.ID && a.Query == b.Query && a.FromUsername == b.FromUsername && a.Filter == b.Filter
+}
+
+// findLoadedSender matches from:Name against the senders of the messages on screen, that is
+// the only place we know group members from without asking the server
+func (m *Model) findLoadedSender(name string) *types.UserInfo {
+	name = strings.ToLower(name)
+	var partial *types.UserInfo
+	for _, msg := range m.Conversations.Messages {
+		user := msg.SenderUserInfo
+		if user == nil {
+			continue
+		}
+		fullName := strings.ToLower(strings.TrimSpace(user.FirstName + " " + user.LastName))
+		if strings.ToLower(user.FirstName) == name || fullName == name || strings.ToLower(user.Username) == name {
+			return user
+		}
+		if partial == nil && strings.HasPrefix(fullName, name) {
+			partial = user
+		}
+	}
+	return partial
+}
+
+func (m Model) handleChatSearchKey() (tea.Model, tea.Cmd) {
+	if m.ShowForumTopics && m.SelectedForumTopic == nil {
+		return m, nil
+	}
+	if _, ok := m.openChatPeer(); !ok {
+		return m, nil
+	}
+	return m, func() tea.Msg {
+		return OpenModalMsg{ModalMode: ModalModeChatSearch}
+	}
+}
+
+func (m Model) handleChatSearchQuery(msg ChatSearchQueryMsg) (tea.Model, tea.Cmd) {
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	query, from := parseChatSearchQuery(msg.Query)
+	if query == "" && from == "" && msg.Filter == types.SearchFilterNone {
+		return m, nil
+	}
+	req := types.SearchMessagesRequest{
+		Peer:     peer,
+		TopMsgID: m.openTopicID(),
+		Query:    query,
+		Filter:   msg.Filter,
+		Limit:    historyPageSize,
+	}
+	switch {
+	case from == "":
+	case from == "me" || strings.HasPrefix(from, "@"):
+		req.FromUsername = from
+	default:
+		sender := m.findLoadedSender(from)
+		if sender == nil {
+			// goes back to the overlay, which shows it under the prompt
+			m.ChatSearch = nil
+			err := fmt.Errorf("no one called %q in the loaded messages, try from:@username", from)
+			return m, func() tea.Msg { return types.SearchMessagesMsg{Request: req, Err: err} }
+		}
+		req.From = &types.Peer{ID: sender.PeerID, AccessHash: sender.AccessHash, ChatType: types.UserChat}
+	}
+
+	m.ChatSearch = &ChatSearch{Request: req, Current: -1, loading: true}
+	return m, telegram.Cligram.SearchMessages(telegram.Cligram.Context(), req)
+}
+
+func (m Model) handleSearchMessages(msg types.SearchMessagesMsg) (tea.Model, tea.Cmd) {
+	search := m.ChatSearch
+	if search == nil || !sameChatSearch(search.Request, msg.Request) {
+		return m, nil
+	}
+	search.loading = false
+	stepAfterLoad := search.stepAfterLoad
+	search.stepAfterLoad = false
+	if msg.Err != nil {
+		m.Alert = m.Alert.WithAllowEscToClose().WithPosition(bubbleup.TopLeftPosition)
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
+	}
+	if msg.Request.OffsetID == 0 {
+		search.Results = msg.Messages
+	} else {
+		search.Results = append(search.Results, msg.Messages...)
+	}
+	search.Count = max(msg.Count, len(search.Results))
+	if len(msg.Messages) == 0 {
+		// the server has nothing past what we have, don't ask again
+		search.Count = len(search.Results)
+	}
+	if stepAfterLoad {
+		return m.stepChatSearch(1)
+	}
+	return m, nil
+}
+
+// stepChatSearch moves to the next older (1) or newer (-1) result, loading the next page of
+// results when the last loaded one is passed
+func (m Model) stepChatSearch(delta int) (tea.Model, tea.Cmd) {
+	search := m.ChatSearch
+	if search == nil || len(search.Results) == 0 {
+		return m, nil
+	}
+	next := search.Current + delta
+	if next < 0 {
+		return m, nil
+	}
+	if next >= len(search.Results) {
+		if len(search.Results) >= search.Count || search.loading {
+			return m, nil
+		}
+		search.loading = true
+		search.stepAfterLoad = true
+		req := search.Request
+		req.OffsetID = search.Results[len(search.Results)-1].ID
+		return m, telegram.Cligram.SearchMessages(telegram.Cligram.Context(), req)
+	}
+	return m.jumpToChatSearchResult(next)
+}
+
+func (m Model) jumpToChatSearchResult(index int) (tea.Model, tea.Cmd) {
+	search := m.ChatSearch
+	if search == nil || index < 0 || index >= len(search.Results) {
+		return m, nil
+	}
+	search.Current = index
+	id := search.Results[index].ID
+	// no need to reload the history when the result is already on screen
+	if i := m.Conversations.ItemIndex(id); i != -1 {
+		m.FocusedOn = Main
+		m.ChatUI.Select(i)
+		return m, nil
+	}
+	return m.handleGoTo(GoToMsg{Target: goToTarget{MessageID: id}})
+}
+
+func chatSearchStatus(search *ChatSearch) string {
+	if search == nil {
+		return ""
+	}
+	label := search.Request.Query
+	if label == "" {
+		label = chatSearchFilterName(search.Request.Filter)
+	}
+	if search.loading && len(search.Results) == 0 {
+		return fmt.Sprintf("search %q: searching…", label)
+	}
+	if search.Current < 0 {
+		return fmt.Sprintf("search %q: %d results", label, search.Count)
+	}
+	return fmt.Sprintf("search %q: %d/%d · n next · N previous", label, search.Current+1, search.Count)
+}
+
+type ChatSearchDelegate struct {
+	list.DefaultDelegate
+	*Foreground
+}
+
+func (d ChatSearchDelegate) Height() int {
+	return 1
+}
+
+func (d ChatSearchDelegate) Spacing() int {
+	return 0
+}
+
+func (d ChatSearchDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+func (d ChatSearchDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	result, ok := item.(types.FormattedMessage)
+	if !ok {
+		return
+	}
+	width := 40
+	if d.Foreground != nil {
+		width = max(40, d.Foreground.windowWidth/2)
+	}
+	content := strings.Join(strings.Fields(result.Content), " ")
+	if result.IsUnsupportedMessage || content == "" {
+		content = mediaSnippet(result)
+	} else if result.MediaInfo != nil {
+		content = mediaSnippet(result) + " " + content
+	}
+	line := fmt.Sprintf("%s · %s: %s", result.Date.Format("02/01/2006"), result.Sender, content)
+	str := lipgloss.NewStyle().Width(width).MaxWidth(width).Render(line)
+	if index == m.Index() {
+		fmt.Fprint(w, selectedStyle.Render(" "+str+" "))
+	} else {
+		fmt.Fprint(w, normalStyle.Render(" "+str+" "))
+	}
+}
+
+func (f *Foreground) handleChatSearchResults(msg types.SearchMessagesMsg) tea.Cmd {
+	f.chatSearchLoading = false
+	if msg.Err != nil {
+		f.chatSearchErr = msg.Err.Error()
+		return nil
+	}
+	f.chatSearchErr = ""
+	items := make([]list.Item, 0, len(msg.Messages))
+	if msg.Request.OffsetID != 0 {
+		items = append(items, f.chatSearchResults.Items()...)
+	}
+	for _, result := range msg.Messages {
+		items = append(items, result)
+	}
+	f.chatSearchCount = max(msg.Count, len(items))
+	if msg.Request.OffsetID != 0 && len(msg.Messages) == 0 {
+		// the server has nothing past what we have, don't ask again
+		f.chatSearchCount = len(items)
+	}
+	request := msg.Request
+	f.chatSearchRequest = &request
+	return f.chatSearchResults.SetItems(items)
+}
+
+// loadMoreChatResults asks for the next page once the cursor gets close to the last result,
+// the model appends the same page to its copy so picked indexes keep matching
+func (f *Foreground) loadMoreChatResults() tea.Cmd {
+	if f.chatSearchRequest == nil || f.chatSearchLoading {
+		return nil
+	}
+	items := f.chatSearchResults.Items()
+	if len(items) == 0 || len(items) >= f.chatSearchCount {
+		return nil
+	}
+	if f.chatSearchResults.Index() < len(items)-resultsPrefetch {
+		return nil
+	}
+	last, ok := items[len(items)-1].(types.FormattedMessage)
+	if !ok {
+		return nil
+	}
+	req := *f.chatSearchRequest
+	req.OffsetID = last.ID
+	f.chatSearchLoading = true
+	return telegram.Cligram.SearchMessages(telegram.Cligram.Context(), req)
+}
+
+func (f Foreground) chatSearchView(foreStyle lipgloss.Style) string {
+	title := lipgloss.NewStyle().Foreground(DefaultTheme.PrimaryText).Bold(true).Render("Search in Chat")
+	hintStyle := lipgloss.NewStyle().Foreground(DefaultTheme.SecondaryText).Italic(true)
+	hint := hintStyle.Render("from:me, from:@username or from:Name to filter by sender")
+	filter := hintStyle.Render("showing: " + chatSearchFilterName(f.chatSearchFilter) + " · ctrl+t to change · tab to switch to results")
+
+	var status string
+	switch {
+	case f.chatSearchErr != "":
+		status = lipgloss.NewStyle().Foreground(DefaultTheme.ErrorColor).Render(f.chatSearchErr)
+	case f.chatSearchLoading:
+		status = lipgloss.NewStyle().Foreground(DefaultTheme.AccentColor).Render("searching...")
+	case f.chatSearchSubmitted && f.chatSearchCount == 0:
+		status = hintStyle.Render("no messages found")
+	case f.chatSearchSubmitted:
+		status = hintStyle.Render(fmt.Sprintf("%d results", f.chatSearchCount))
+	}
+
+	resultBorderStyle := lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(DefaultTheme.BorderColor)
+	if f.focusedOn == LIST {
+		resultBorderStyle = lipgloss.NewStyle().Border(lipgloss.DoubleBorder()).BorderForeground(DefaultTheme.AccentColor)
+	}
+	f.chatSearchResults.SetWidth(max(40, f.windowWidth/2) + 2)
+	f.chatSearchResults.SetHeight(max(5, f.windowHeight/3))
+	results := resultBorderStyle.Render(f.chatSearchResults.View())
+
+	lines := []string{title, getSearchView(f), hint, filter}
+	if status != "" {
+		lines = append(lines, status)
+	}
+	lines = append(lines, results)
+	return foreStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func handleChatSearchEnter(m *Foreground) (tea.Model, tea.Cmd) {
+	if m.focusedOn == LIST {
+		if m.chatSearchResults.SelectedItem() == nil {
+			return m, nil
+		}
+		index := m.chatSearchResults.Index()
+		return m, tea.Batch(
+			func() tea.Msg { return CloseOverlay{} },
+			func() tea.Msg { return ChatSearchJumpMsg{Index: index} },
+		)
+	}
+	query := strings.TrimSpace(m.input.Value())
+	if query == "" && m.chatSearchFilter == types.SearchFilterNone {
+		return m, nil
+	}
+	m.chatSearchLoading = true
+	m.chatSearchSubmitted = true
+	m.chatSearchErr = ""
+	filter := m.chatSearchFilter
+	return m, func() tea.Msg {
+		return ChatSearchQueryMsg{Query: query, Filter: filter}
+	}
+}
@@ -0,0 +1,245 @@
+package ui
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kumneger0/cligram/internal/config"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"github.com/muesli/reflow/truncate"
+	"github.com/muesli/reflow/wordwrap"
+)
+
+// messageText is a message's content in UTF-16 code units, the unit entity offsets are counted in
+type messageText []uint16
+
+func (t messageText) slice(from, to int) string {
+	return string(utf16.Decode(t[from:to]))
+}
+
+func isBlockEntity(entity types.MessageEntity) bool {
+	return entity.Type == types.EntityPre || entity.Type == types.EntityBlockquote
+}
+
+// renderMessageContent styles a message by its entities and wraps it to width. code blocks and
+// quotes get lines of their own, spoilers stay hidden until revealSpoilers is set
+func renderMessageContent(content string, entities []types.MessageEntity, width int, revealSpoilers bool) string {
+	if len(entities) == 0 {
+		return wordwrap.String(content, width)
+	}
+	text := messageText(utf16.Encode([]rune(content)))
+	entities = clampEntities(entities, len(text))
+
+	// blocks can't overlap each other, a broken message just loses the overlapping ones
+	var blocks []types.MessageEntity
+	for _, entity := range entities {
+		if isBlockEntity(entity) && (len(blocks) == 0 || entity.Offset >= blocks[len(blocks)-1].Offset+blocks[len(blocks)-1].Length) {
+			blocks = append(blocks, entity)
+		}
+	}
+
+	var parts []string
+	position := 0
+	for i, block := range blocks {
+		if block.Offset > position {
+			inline := renderInline(text, entities, position, block.Offset, revealSpoilers)
+			if i > 0 {
+				inline = strings.TrimPrefix(inline, "\n")
+			}
+			parts = append(parts, wordwrap.String(strings.TrimSuffix(inline, "\n"), width))
+		}
+		end := block.Offset + block.Length
+		if block.Type == types.EntityPre {
+			parts = append(parts, renderCodeBlock(text.slice(block.Offset, end), block.Language, width))
+		} else {
+			parts = append(parts, renderBlockquote(renderInline(text, entities, block.Offset, end, revealSpoilers), width))
+		}
+		position = end
+	}
+	if position < len(text) {
+		inline := renderInline(text, entities, position, len(text), revealSpoilers)
+		if len(blocks) > 0 {
+			inline = strings.TrimPrefix(inline, "\n")
+		}
+		parts = append(parts, wordwrap.String(inline, width))
+	}
+	parts = slices.DeleteFunc(parts, func(part string) bool { return part == "" })
+	return strings.Join(parts, "\n")
+}
+
+// clampEntities drops entities that point outside the text, offsets come from the server and
+// a message edited by an older client can carry stale ones
+func clampEntities(entities []types.MessageEntity, length int) []types.MessageEntity {
+	var valid []types.MessageEntity
+	for _, entity := range entities {
+		if entity.Offset < 0 || entity.Length <= 0 || entity.Offset >= length {
+			continue
+		}
+		entity.Length = min(entity.Length, length-entity.Offset)
+		valid = append(valid, entity)
+	}
+	slices.SortStableFunc(valid, func(a, b types.MessageEntity) int { return a.Offset - b.Offset })
+	return valid
+}
+
+// renderInline styles text[from:to], the range is cut wherever an entity starts or ends and
+// every piece gets the styles of all the entities covering it
+func renderInline(text messageText, entities []types.MessageEntity, from, to int, revealSpoilers bool) string {
+	cuts := []int{from, to}
+	for _, entity := range entities {
+		for _, cut := range []int{entity.Offset, entity.Offset + entity.Length} {
+			if cut > from && cut < to {
+				cuts = append(cuts, cut)
+			}
+		}
+	}
+	slices.Sort(cuts)
+	cuts = slices.Compact(cuts)
+
+	var out strings.Builder
+	for i := 0; i < len(cuts)-1; i++ {
+		start, end := cuts[i], cuts[i+1]
+		var covering []types.MessageEntity
+		for _, entity := range entities {
+			if !isBlockEntity(entity) && entity.Offset <= start && entity.Offset+entity.Length >= end {
+				covering = append(covering, entity)
+			}
+		}
+		out.WriteString(renderPiece(text.slice(start, end), covering, revealSpoilers))
+
+		// a text link shows where it goes after its last piece, unless the text is the address already
+		for _, entity := range covering {
+			if entity.Type == types.EntityTextURL && entity.Offset+entity.Length == end {
+				label := text.slice(entity.Offset, end)
+				if entity.URL != "" && entity.URL != label {
+					out.WriteString(" " + codeLanguageStyle.Render("("+entity.URL+")"))
+				}
+			}
+		}
+	}
+	return out.String()
+}
+
+func renderPiece(piece string, covering []types.MessageEntity, revealSpoilers bool) string {
+	if len(covering) == 0 {
+		return piece
+	}
+	style := lipgloss.NewStyle()
+	for _, entity := range covering {
+		switch entity.Type {
+		case types.EntityBold:
+			style = style.Bold(true)
+		case types.EntityItalic:
+			style = style.Italic(true)
+		case types.EntityUnderline:
+			style = style.Underline(true)
+		case types.EntityStrike:
+			style = style.Strikethrough(true)
+		case types.EntityCode:
+			style = style.Inherit(inlineCodeStyle)
+		case types.EntityURL, types.EntityTextURL, types.EntityEmail, types.EntityPhone:
+			style = style.Inherit(linkStyle)
+		case types.EntityMention, types.EntityMentionName, types.EntityHashtag, types.EntityCashtag, types.EntityBotCommand:
+			style = style.Inherit(mentionStyle)
+		case types.EntitySpoiler:
+			if !revealSpoilers {
+				return spoilerStyle.Render(hideSpoiler(piece))
+			}
+			style = style.Inherit(revealedSpoilerStyle)
+		}
+	}
+	// lipgloss pads every line of a multi line string to the same width, style line by line instead
+	lines := strings.Split(piece, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = style.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// hideSpoiler keeps the shape of the hidden text so revealing it doesn't move anything around
+func hideSpoiler(piece string) string {
+	var hidden strings.Builder
+	for _, r := range piece {
+		switch {
+		case r == '\n' || r == ' ':
+			hidden.WriteRune(r)
+		default:
+			hidden.WriteString(strings.Repeat("░", max(1, lipgloss.Width(string(r)))))
+		}
+	}
+	return hidden.String()
+}
+
+func renderCodeBlock(code, language string, width int) string {
+	code = strings.TrimSuffix(code, "\n")
+	// border and padding take two columns on each side
+	innerWidth := max(10, width-4)
+	lines := strings.Split(highlightCode(code, language), "\n")
+	for i, line := range lines {
+		lines[i] = truncate.StringWithTail(line, uint(innerWidth), "…")
+	}
+	block := codeBlockStyle.Render(strings.Join(lines, "\n"))
+	if language == "" {
+		return block
+	}
+	return codeLanguageStyle.Render(language) + "\n" + block
+}
+
+func highlightCode(code, language string) string {
+	if language == "" {
+		return code
+	}
+	if enabled := config.GetConfig().Chat.SyntaxHighlighting; enabled != nil && !*enabled {
+		return code
+	}
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		return code
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return code
+	}
+	var highlighted strings.Builder
+	if err := formatters.TTY256.Format(&highlighted, styles.Get("monokai"), iterator); err != nil {
+		return code
+	}
+	return strings.TrimSuffix(highlighted.String(), "\n")
+}
+
+func renderBlockquote(quote string, width int) string {
+	bar := blockquoteBarStyle.Render("┃ ")
+	lines := strings.Split(wordwrap.String(strings.Trim(quote, "\n"), max(10, width-2)), "\n")
+	for i, line := range lines {
+		lines[i] = bar + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func hasSpoiler(entities []types.MessageEntity) bool {
+	return slices.ContainsFunc(entities, func(entity types.MessageEntity) bool {
+		return entity.Type == types.EntitySpoiler
+	})
+}
+
+// handleRevealSpoilerKey shows or hides again the spoilers of the selected message
+func (m Model) handleRevealSpoilerKey() (tea.Model, tea.Cmd) {
+	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || !hasSpoiler(selectedMessage.Entities) {
+		return m, nil
+	}
+	if m.RevealedSpoilers == nil {
+		m.RevealedSpoilers = map[int]bool{}
+	}
+	m.RevealedSpoilers[selectedMessage.ID] = !m.RevealedSpoilers[selectedMessage.ID]
+	return m, nil
+}
@@ -0,0 +1,260 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+type searchTab string
+
+const (
+	searchTabContacts searchTab = "contacts"
+	searchTabMessages searchTab = "messages"
+)
+
+// how close to the end of a results list the cursor gets before the next page is requested
+const resultsPrefetch = 3
+
+var debouncedGlobalSearch = Debounce(func(args ...any) tea.Msg {
+	req := args[0].(types.SearchGlobalRequest)
+	return telegram.Cligram.SearchGlobal(telegram.Cligram.Context(), req)()
+}, 300*time.Millisecond)
+
+// OpenGlobalSearchResultMsg opens the chat of a global search hit with the hit selected
+type OpenGlobalSearchResultMsg struct {
+	Result types.GlobalSearchResult
+}
+
+type GlobalSearchDelegate struct {
+	list.DefaultDelegate
+	*Foreground
+}
+
+func (d GlobalSearchDelegate) Height() int {
+	return 2
+}
+
+func (d GlobalSearchDelegate) Spacing() int {
+	return 0
+}
+
+func (d GlobalSearchDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+func (d GlobalSearchDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	result, ok := item.(types.GlobalSearchResult)
+	if !ok {
+		return
+	}
+	width := 40
+	if d.Foreground != nil {
+		width = max(40, d.Foreground.windowWidth/2)
+	}
+
+	icon := "👤 "
+	switch result.Peer.ChatType {
+	case types.BotChat:
+		icon = "🤖 "
+	case types.GroupChat:
+		icon = "👥 "
+	case types.ChannelChat:
+		icon = "📢 "
+	}
+	header := icon + result.ChatTitle
+	if result.Message.Sender != "" && result.Message.Sender != result.ChatTitle {
+		header += " · " + result.Message.Sender
+	}
+	header += " · " + result.Message.Date.Format("02/01/2006")
+
+	snippet := strings.Join(strings.Fields(result.Message.Content), " ")
+	if result.Message.IsUnsupportedMessage || snippet == "" {
+		snippet = mediaSnippet(result.Message)
+	} else if result.Message.MediaInfo != nil {
+		snippet = mediaSnippet(result.Message) + " " + snippet
+	}
+
+	lineStyle := lipgloss.NewStyle().Width(width).MaxWidth(width)
+	text := lineStyle.Render(header) + "\n" + lineStyle.Foreground(DefaultTheme.SecondaryText).Render(snippet)
+	if index == m.Index() {
+		fmt.Fprint(w, selectedStyle.Render(text))
+	} else {
+		fmt.Fprint(w, normalStyle.Render(text))
+	}
+}
+
+// searchGlobal runs the messages tab search for what is in the input, typing is debounced the
+// same way the contacts search is
+func (f *Foreground) searchGlobal() tea.Cmd {
+	query := strings.TrimSpace(f.input.Value())
+	if query == f.globalSearchQuery {
+		return nil
+	}
+	f.globalSearchQuery = query
+	f.globalSearchNext = nil
+	f.globalSearchErr = ""
+	if len(query) < 3 {
+		f.globalSearchLoading = false
+		return f.globalSearchResults.SetItems([]list.Item{})
+	}
+	f.globalSearchLoading = true
+	return debouncedGlobalSearch(types.SearchGlobalRequest{Query: query, Limit: historyPageSize})
+}
+
+func (f *Foreground) handleGlobalSearchResults(msg types.SearchGlobalMsg) tea.Cmd {
+	// results for something the user has typed over already
+	if msg.Request.Query != f.globalSearchQuery {
+		return nil
+	}
+	f.globalSearchLoading = false
+	if msg.Err != nil {
+		f.globalSearchErr = msg.Err.Error()
+		return nil
+	}
+	f.globalSearchErr = ""
+
+	var items []list.Item
+	if msg.Request.OffsetID != 0 {
+		items = f.globalSearchResults.Items()
+	}
+	for _, result := range msg.Results {
+		items = append(items, result)
+	}
+
+	f.globalSearchNext = nil
+	if msg.NextRate != 0 && len(msg.Results) > 0 {
+		last := msg.Results[len(msg.Results)-1]
+		f.globalSearchNext = &types.SearchGlobalRequest{
+			Query:      msg.Request.Query,
+			Limit:      msg.Request.Limit,
+			OffsetRate: msg.NextRate,
+			OffsetPeer: &last.Peer,
+			OffsetID:   last.Message.ID,
+		}
+	}
+	return f.globalSearchResults.SetItems(items)
+}
+
+// loadMoreGlobalResults asks for the next page once the cursor gets close to the last result
+func (f *Foreground) loadMoreGlobalResults() tea.Cmd {
+	if f.globalSearchNext == nil || f.globalSearchLoading {
+		return nil
+	}
+	if f.globalSearchResults.Index() < len(f.globalSearchResults.Items())-resultsPrefetch {
+		return nil
+	}
+	f.globalSearchLoading = true
+	return telegram.Cligram.SearchGlobal(telegram.Cligram.Context(), *f.globalSearchNext)
+}
+
+func handleGlobalSearchSelection(m *Foreground) (tea.Model, tea.Cmd) {
+	result, ok := m.globalSearchResults.SelectedItem().(types.GlobalSearchResult)
+	if !ok {
+		return m, nil
+	}
+	return m, tea.Batch(
+		func() tea.Msg { return CloseOverlay{} },
+		func() tea.Msg { return OpenGlobalSearchResultMsg{Result: result} },
+	)
+}
+
+func renderSearchTabs(f Foreground) string {
+	var tabs []string
+	for _, tab := range []searchTab{searchTabContacts, searchTabMessages} {
+		style := lipgloss.NewStyle().Padding(0, 1).Foreground(DefaultTheme.SecondaryText)
+		if tab == f.searchTab {
+			style = style.Foreground(DefaultTheme.SelectedFg).Background(DefaultTheme.AccentColor)
+		}
+		tabs = append(tabs, style.Render(string(tab)))
+	}
+	hint := lipgloss.NewStyle().Foreground(DefaultTheme.SecondaryText).Italic(true).Render(" ctrl+t to switch")
+	return lipgloss.JoinHorizontal(lipgloss.Top, append(tabs, hint)...)
+}
+
+func (f Foreground) globalSearchView() string {
+	var status string
+	hintStyle := lipgloss.NewStyle().Foreground(DefaultTheme.SecondaryText).Italic(true)
+	switch {
+	case f.globalSearchErr != "":
+		status = lipgloss.NewStyle().Foreground(DefaultTheme.ErrorColor).Render(f.globalSearchErr)
+	case f.globalSearchLoading && len(f.globalSearchResults.Items()) == 0:
+		status = lipgloss.NewStyle().Foreground(DefaultTheme.AccentColor).Render("searching...")
+	case len(f.globalSearchQuery) < 3:
+		status = hintStyle.Render("type at least 3 characters to search all chats")
+	case len(f.globalSearchResults.Items()) == 0:
+		status = hintStyle.Render("no messages found")
+	}
+
+	borderStyle := lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(DefaultTheme.BorderColor)
+	if f.focusedOn == LIST {
+		borderStyle = lipgloss.NewStyle().Border(lipgloss.DoubleBorder()).BorderForeground(DefaultTheme.AccentColor)
+	}
+	f.globalSearchResults.SetWidth(max(40, f.windowWidth/2) + 2)
+	f.globalSearchResults.SetHeight(max(6, f.windowHeight/2))
+
+	lines := []string{}
+	if status != "" {
+		lines = append(lines, status)
+	}
+	lines = append(lines, borderStyle.Render(f.globalSearchResults.View()))
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
+// handleGlobalSearchResult opens the chat a search hit is in the same way picking it from the
+// contacts tab does, with the history loaded around the hit
+func (m Model) handleGlobalSearchResult(msg OpenGlobalSearchResultMsg) (tea.Model, tea.Cmd) {
+	result := msg.Result
+	messageID := result.Message.ID
+
+	var model tea.Model
+	var cmd tea.Cmd
+	switch {
+	case result.User != nil:
+		model, cmd = m.handleSearchedUser(*result.User, &messageID)
+	case result.Channel != nil && result.Channel.IsBroadcast:
+		model, cmd = m.handleSearchedChannel(*result.Channel, &messageID)
+	case result.Channel != nil:
+		model, cmd = m.handleSearchedGroup(*result.Channel, &messageID)
+	default:
+		return m, nil
+	}
+
+	opened := model.(Model)
+	if !searchedChatOpened(opened, result) {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, "couldn't open the chat of this message")
+	}
+	m = opened
+	// forum topics are listed first, there is no single history to position
+	if !(m.Mode == ModeGroups && m.SelectedGroup.IsForum) {
+		m.GoToTarget = &goToTarget{MessageID: messageID}
+		m.FocusedOn = Main
+	}
+	return m, cmd
+}
+
+// searchedChatOpened tells whether the sidebar now has the chat of the hit selected, a chat
+// that couldn't be added to its list is never opened
+func searchedChatOpened(m Model, result types.GlobalSearchResult) bool {
+	switch {
+	case result.User != nil:
+		user, ok := listForUser(&m, *result.User).SelectedItem().(types.UserInfo)
+		return ok && (m.Mode == ModeUsers || m.Mode == ModeBots) && user.PeerID == result.User.PeerID
+	case result.Channel != nil:
+		mode, chats := ModeGroups, m.Groups
+		if result.Channel.IsBroadcast {
+			mode, chats = ModeChannels, m.Channels
+		}
+		channel, ok := chats.SelectedItem().(types.ChannelInfo)
+		return ok && m.Mode == mode && channel.ID == result.Channel.ID
+	}
+	return false
+}
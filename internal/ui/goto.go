@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+// the same day/month order the chat view shows dates in is accepted next to iso dates
+var goToDateLayouts = []string{
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04",
+	"02/01/2006",
+}
+
+// goToTarget is where the go to prompt wants to jump, either a message id or a point in time
+type goToTarget struct {
+	MessageID int
+	Date      time.Time
+}
+
+type GoToMsg struct {
+	Target goToTarget
+}
+
+func parseGoToTarget(input string) (goToTarget, error) {
+	input = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(input), "#"))
+	if input == "" {
+		return goToTarget{}, errors.New("type a message id or a date")
+	}
+	if id, err := strconv.Atoi(input); err == nil {
+		if id <= 0 {
+			return goToTarget{}, errors.New("message ids start at 1")
+		}
+		return goToTarget{MessageID: id}, nil
+	}
+	for _, layout := range goToDateLayouts {
+		if date, err := time.ParseInLocation(layout, input, time.Local); err == nil {
+			return goToTarget{Date: date}, nil
+		}
+	}
+	return goToTarget{}, errors.New("not a message id or a date like 2024-05-01 or 2024-05-01 18:30")
+}
+
+func (m Model) handleGoToKey() (tea.Model, tea.Cmd) {
+	if m.ShowForumTopics && m.SelectedForumTopic == nil {
+		return m, nil
+	}
+	if _, ok := m.openChatPeer(); !ok {
+		return m, nil
+	}
+	return m, func() tea.Msg {
+		return OpenModalMsg{ModalMode: ModalModeGoTo}
+	}
+}
+
+// handleGoTo replaces the loaded pages with the ones around the target, the target is
+// selected once they arrive and paging works from there in both directions
+func (m Model) handleGoTo(msg GoToMsg) (tea.Model, tea.Cmd) {
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	req := types.GetMessagesRequest{
+		Peer:      peer,
+		Limit:     historyPageSize,
+		TopMsgID:  m.openTopicID(),
+		Direction: types.HistoryAround,
+	}
+	if msg.Target.MessageID != 0 {
+		id := msg.Target.MessageID
+		req.OffsetID = &id
+	} else {
+		req.OffsetDate = int(msg.Target.Date.Unix())
+	}
+
+	target := msg.Target
+	m.GoToTarget = &target
+	m.Conversations.Reset()
+	m.ConversationsFromCache = false
+	m.CachedHistoryPending = false
+	m.MainViewLoading = true
+	m.FocusedOn = Main
+	m.ChatUI.SetItems([]list.Item{})
+	m.ChatUI.ResetSelected()
+	return m, telegram.Cligram.GetMessages(telegram.Cligram.Context(), req)
+}
+
+// selectGoToTarget puts the cursor on the message the user jumped to, or on the closest
+// one after it when that message is gone or nothing was sent at that exact time
+func (m *Model) selectGoToTarget() tea.Cmd {
+	target := m.GoToTarget
+	m.GoToTarget = nil
+	if target == nil || m.Conversations.Len() == 0 {
+		return nil
+	}
+
+	index := -1
+	exact := false
+	for i, msg := range m.Conversations.Messages {
+		if target.MessageID != 0 && msg.ID >= target.MessageID {
+			index, exact = i, msg.ID == target.MessageID
+			break
+		}
+		if target.MessageID == 0 && !msg.Date.Before(target.Date) {
+			index, exact = i, true
+			break
+		}
+	}
+	if index == -1 {
+		index = m.Conversations.Len() - 1
+	}
+	m.ChatUI.Select(m.Conversations.ItemIndex(m.Conversations.Messages[index].ID))
+
+	if target.MessageID != 0 && !exact {
+		m.Alert = m.Alert.WithAllowEscToClose().WithPosition(bubbleup.TopLeftPosition)
+		return m.Alert.NewAlertCmd(bubbleup.WarnKey, "message "+strconv.Itoa(target.MessageID)+" not found, showing the closest one")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package ui
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// loadMoreHistory asks for the next page once the cursor sits on the first or the last
+// loaded message and there is more in that direction
+func (m *Model) loadMoreHistory() tea.Cmd {
+	if m.FocusedOn != Main || m.MainViewLoading || m.ConversationsFromCache || m.Conversations.Len() == 0 {
+		return nil
+	}
+	if m.ShowForumTopics && m.SelectedForumTopic == nil {
+		return nil
+	}
+
+	var direction types.HistoryDirection
+	var offsetID int
+	var ok bool
+	switch index := m.ChatUI.Index(); {
+	case index == 0 && !m.Conversations.ReachedOldest:
+		direction = types.HistoryOlder
+		offsetID, ok = m.Conversations.OldestID()
+	case index == len(m.ChatUI.Items())-1 && !m.Conversations.ReachedLatest:
+		direction = types.HistoryNewer
+		offsetID, ok = m.Conversations.NewestID()
+	}
+	if !ok {
+		return nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok || !m.Conversations.StartLoading() {
+		return nil
+	}
+	return telegram.Cligram.GetMessages(telegram.Cligram.Context(), types.GetMessagesRequest{
+		Peer:      peer,
+		Limit:     historyPageSize,
+		OffsetID:  &offsetID,
+		TopMsgID:  m.openTopicID(),
+		Direction: direction,
+	})
+}
+
+// jumpToLatest drops the loaded pages and loads the newest messages again, for when the
+// user scrolled back so far that the newest ones are no longer loaded
+func (m *Model) jumpToLatest() tea.Cmd {
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return nil
+	}
+	m.Conversations.Reset()
+	m.MainViewLoading = true
+	m.ChatUI.SetItems([]list.Item{})
+	m.ChatUI.ResetSelected()
+	return telegram.Cligram.GetMessages(telegram.Cligram.Context(), types.GetMessagesRequest{
+		Peer:     peer,
+		Limit:    historyPageSize,
+		TopMsgID: m.openTopicID(),
+	})
+}
+
+// handleChannelTooLong catches up on a channel that missed too many updates: the chat list
+// brings back its unread count and an open chat loads its newest messages again
+func (m *Model) handleChannelTooLong(msg types.ChannelTooLongNotification) tea.Cmd {
+	cmds := []tea.Cmd{telegram.Cligram.RefreshAllChats(telegram.Cligram.Context())}
+	peer, ok := m.openChatPeer()
+	if ok && peer.ID == msg.PeerID && !m.MainViewLoading && !(m.ShowForumTopics && m.SelectedForumTopic == nil) {
+		cmds = append(cmds, m.jumpToLatest())
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m Model) handleOlderHistory(msg types.GetMessagesMsg) (tea.Model, tea.Cmd) {
+	if !msg.HasMore {
+		m.Conversations.ReachedOldest = true
+	}
+	index := m.ChatUI.Index()
+	before := len(m.ChatUI.Items())
+	added := m.Conversations.Prepend(msg.Messages)
+	if added == 0 {
+		return m, nil
+	}
+	cmd := m.refreshConversations()
+	// keep the cursor on the message it was on, it moved down by the page we put above it.
+	// counted in list items, an album in the page is one
+	m.ChatUI.Select(index + len(m.ChatUI.Items()) - before)
+	return m, tea.Batch(m.checkAndFetchCustomEmojis(msg.Messages), cmd)
+}
+
+func (m Model) handleNewerHistory(msg types.GetMessagesMsg) (tea.Model, tea.Cmd) {
+	if !msg.HasMore {
+		m.Conversations.ReachedLatest = true
+	}
+	selected, _ := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	m.Conversations.Append(msg.Messages...)
+	m.appendOutboxMessages()
+	cmd := m.refreshConversations()
+	// past the memory cap the oldest messages were dropped and everything moved up, the cursor
+	// follows the message it was on
+	m.ChatUI.Select(max(0, m.Conversations.ItemIndex(selected.ID)))
+	return m, tea.Batch(m.checkAndFetchCustomEmojis(msg.Messages), cmd)
+}
+
+// isOpenHistory reports whether a history page belongs to the chat and topic on screen
+func (m Model) isOpenHistory(peer types.Peer, topMsgID *int) bool {
+	if peer.ID == "" {
+		return true
+	}
+	open, ok := m.openChatPeer()
+	if !ok || open.ID != peer.ID {
+		return false
+	}
+	openTopicID := m.openTopicID()
+	if openTopicID == nil || topMsgID == nil {
+		return openTopicID == nil && topMsgID == nil
+	}
+	return *openTopicID == *topMsgID
+}
+
+func (m Model) openChatPeer() (types.Peer, bool) {
+	switch m.Mode {
+	case ModeUsers:
+		return types.Peer{ID: m.SelectedUser.PeerID, AccessHash: m.SelectedUser.AccessHash, ChatType: types.UserChat}, m.SelectedUser.PeerID != ""
+	case ModeBots:
+		return types.Peer{ID: m.SelectedUser.PeerID, AccessHash: m.SelectedUser.AccessHash, ChatType: types.BotChat}, m.SelectedUser.PeerID != ""
+	case ModeChannels:
+		return types.Peer{ID: m.SelectedChannel.ID, AccessHash: m.SelectedChannel.AccessHash, ChatType: types.ChannelChat}, m.SelectedChannel.ID != ""
+	case ModeGroups:
+		return types.Peer{ID: m.SelectedGroup.ID, AccessHash: m.SelectedGroup.AccessHash, ChatType: types.GroupChat}, m.SelectedGroup.ID != ""
+	}
+	return types.Peer{}, false
+}
+
+func (m Model) openTopicID() *int {
+	if m.SelectedForumTopic == nil {
+		return nil
+	}
+	id := m.SelectedForumTopic.ID
+	return &id
+}
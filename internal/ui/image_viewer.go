@@ -0,0 +1,304 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"github.com/kumneger0/cligram/internal/termimage"
+	"go.dalton.dog/bubbleup"
+)
+
+// imageProtocol is how this terminal draws images, it doesn't change while we run
+var imageProtocol = sync.OnceValue(termimage.Detect)
+
+const (
+	// the previews in the chat stay small, the viewer is there for a proper look
+	previewMaxCols = 24
+	previewMaxRows = 6
+	// previews are cached per message, the cache starts over once it holds this many
+	previewCacheSize = 512
+)
+
+// imageViewer shows pictures full screen, the photos of a message or album or the photos of a story
+type imageViewer struct {
+	Items []viewerItem
+	Index int
+	// the last render, drawing a picture again on every frame would be slow
+	rendered                   string
+	renderedIndex              int
+	renderedCols, renderedRows int
+}
+
+type viewerItem struct {
+	Title string
+	// Key is the message the picture is loaded from, Path a file on disk when it isn't set
+	Key     downloadKey
+	Path    string
+	Image   image.Image
+	Err     error
+	Loading bool
+}
+
+// imageLoadedMsg carries a decoded picture for the viewer, decoding happens off the ui
+type imageLoadedMsg struct {
+	Key   downloadKey
+	Path  string
+	Image image.Image
+	Err   error
+}
+
+// canView tells whether the viewer has something to show for the media, videos show their frame
+func canView(info *types.MediaInfo) bool {
+	if info == nil {
+		return false
+	}
+	switch info.Kind {
+	case types.MediaPhoto, types.MediaSticker, types.MediaVideo, types.MediaAnimation, types.MediaVideoNote:
+		return true
+	}
+	return strings.HasPrefix(info.MimeType, "image/")
+}
+
+func loadViewerImage(item viewerItem, peer types.Peer) tea.Cmd {
+	if item.Path != "" {
+		return func() tea.Msg {
+			file, err := os.Open(item.Path)
+			if err != nil {
+				return imageLoadedMsg{Path: item.Path, Err: err}
+			}
+			defer file.Close()
+			img, err := termimage.Decode(file)
+			return imageLoadedMsg{Path: item.Path, Image: img, Err: err}
+		}
+	}
+	load := telegram.Cligram.LoadImage(telegram.Cligram.Context(), types.LoadImageRequest{Peer: peer, MessageID: item.Key.MessageID})
+	return func() tea.Msg {
+		loaded, _ := load().(types.LoadImageMsg)
+		if loaded.Err != nil {
+			return imageLoadedMsg{Key: item.Key, Err: loaded.Err}
+		}
+		img, err := termimage.Decode(bytes.NewReader(loaded.Data))
+		if err != nil {
+			err = fmt.Errorf("can't show this picture: %w", err)
+		}
+		return imageLoadedMsg{Key: item.Key, Image: img, Err: err}
+	}
+}
+
+// handleViewKey opens the viewer on the selected message, an album shows all its pictures
+func (m Model) handleViewKey() (tea.Model, tea.Cmd) {
+	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || selectedMessage.SendState != "" {
+		return m, nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	parts := []types.FormattedMessage{selectedMessage}
+	if len(selectedMessage.Album) > 0 {
+		parts = selectedMessage.Album
+	}
+	viewer := &imageViewer{renderedIndex: -1}
+	var cmds []tea.Cmd
+	for _, part := range parts {
+		if !canView(part.MediaInfo) {
+			continue
+		}
+		item := viewerItem{
+			Title:   mediaSummary(part.MediaInfo),
+			Key:     downloadKey{PeerID: peer.ID, MessageID: part.ID},
+			Loading: true,
+		}
+		viewer.Items = append(viewer.Items, item)
+		cmds = append(cmds, loadViewerImage(item, peer))
+	}
+	if len(viewer.Items) == 0 {
+		return m, nil
+	}
+	m.ImageViewer = viewer
+	return m, tea.Batch(append(cmds, tea.ClearScreen)...)
+}
+
+// viewStoryPhotos opens the viewer on story photos that were saved instead of opened elsewhere
+func (m Model) viewStoryPhotos(paths []string) (tea.Model, tea.Cmd) {
+	viewer := &imageViewer{renderedIndex: -1}
+	cmds := []tea.Cmd{tea.ClearScreen, func() tea.Msg { return CloseOverlay{} }}
+	for i, path := range paths {
+		item := viewerItem{Title: fmt.Sprintf("story %d", i+1), Path: path, Loading: true}
+		viewer.Items = append(viewer.Items, item)
+		cmds = append(cmds, loadViewerImage(item, types.Peer{}))
+	}
+	m.ImageViewer = viewer
+	return m, tea.Batch(cmds...)
+}
+
+func (m Model) handleImageLoaded(msg imageLoadedMsg) (tea.Model, tea.Cmd) {
+	if m.ImageViewer == nil {
+		return m, nil
+	}
+	for i, item := range m.ImageViewer.Items {
+		if item.Key == msg.Key && item.Path == msg.Path {
+			item.Image, item.Err, item.Loading = msg.Image, msg.Err, false
+			m.ImageViewer.Items[i] = item
+			if i == m.ImageViewer.Index {
+				m.ImageViewer.renderedIndex = -1
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m Model) handleImageViewerKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	viewer := m.ImageViewer
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q", "v":
+		m.ImageViewer = nil
+		return m, tea.ClearScreen
+	case "left", "h":
+		if viewer.Index > 0 {
+			viewer.Index--
+			return m, tea.ClearScreen
+		}
+	case "right", "l":
+		if viewer.Index < len(viewer.Items)-1 {
+			viewer.Index++
+			return m, tea.ClearScreen
+		}
+	case "o":
+		return m.openViewerItem(viewer.Items[viewer.Index])
+	}
+	return m, nil
+}
+
+// openViewerItem hands the picture to the default app, a message is downloaded for that first
+func (m Model) openViewerItem(item viewerItem) (tea.Model, tea.Cmd) {
+	if item.Path != "" {
+		// this only starts the app, it doesn't wait for it
+		if err := shared.OpenFileInDefaultApp(item.Path); err != nil {
+			return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, "failed to open "+item.Path+": "+err.Error())
+		}
+		return m, nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok || peer.ID != item.Key.PeerID {
+		return m, nil
+	}
+	if _, running := m.Downloads[item.Key]; running {
+		return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "already downloading this file")
+	}
+	if m.Downloads == nil {
+		m.Downloads = map[downloadKey]downloadProgress{}
+	}
+	m.Downloads[item.Key] = downloadProgress{}
+	return m, telegram.Cligram.DownloadMedia(telegram.Cligram.Context(), types.DownloadMediaRequest{
+		Peer:      peer,
+		MessageID: item.Key.MessageID,
+		Open:      true,
+	})
+}
+
+// imageViewerView takes the whole screen: a line about the picture, then the picture
+func (m Model) imageViewerView() string {
+	viewer := m.ImageViewer
+	item := viewer.Items[viewer.Index]
+	header := item.Title
+	hints := "o opens · esc closes"
+	if len(viewer.Items) > 1 {
+		header = fmt.Sprintf("%d/%d · %s", viewer.Index+1, len(viewer.Items), header)
+		hints = "← → · " + hints
+	}
+	top := lipgloss.NewStyle().MaxWidth(m.Width).Render(mediaStyle.Render(header) + "  " + timestampStyle.Render(hints))
+
+	cols, rows := m.Width, m.Height-2
+	var body string
+	switch {
+	case imageProtocol() == termimage.None:
+		body = "this terminal can't show pictures, o opens it in the default app"
+	case item.Loading:
+		body = "loading…"
+	case item.Err != nil:
+		body = item.Err.Error()
+	default:
+		body = viewer.render(item.Image, cols, rows)
+	}
+	if item.Image == nil || imageProtocol() == termimage.None {
+		body = lipgloss.Place(cols, rows, lipgloss.Center, lipgloss.Center, timestampStyle.Render(body))
+	}
+	return top + "\n\n" + body
+}
+
+func (v *imageViewer) render(img image.Image, cols, rows int) string {
+	if v.renderedIndex == v.Index && v.renderedCols == cols && v.renderedRows == rows {
+		return v.rendered
+	}
+	bounds := img.Bounds()
+	width, height := termimage.Fit(bounds.Dx(), bounds.Dy(), cols, rows)
+	picture := termimage.Render(img, imageProtocol(), width, height)
+	// centered, the padding goes before every line so the picture lands after it
+	padding := strings.Repeat(" ", max(0, (cols-width)/2))
+	lines := strings.Split(picture, "\n")
+	for i, line := range lines {
+		lines[i] = padding + line
+	}
+	v.rendered = strings.Join(lines, "\n")
+	v.renderedIndex, v.renderedCols, v.renderedRows = v.Index, cols, rows
+	return v.rendered
+}
+
+type previewKey struct {
+	PeerID    string
+	MessageID int
+	Cols      int
+}
+
+// previews keeps the rendered thumbnails of the chat, the delegate renders rows on every frame.
+// only the ui goroutine renders, so there is no lock
+var previews = map[previewKey]string{}
+
+// forgetPreview drops the thumbnails of a message at every width, an edit may have replaced its media
+func forgetPreview(peerID string, messageID int) {
+	maps.DeleteFunc(previews, func(key previewKey, _ string) bool {
+		return key.PeerID == peerID && key.MessageID == messageID
+	})
+}
+
+// renderPreview draws the thumbnail of a message with half blocks. pixel pictures can't go into
+// list rows, bubbletea redraws and moves those rows as plain text
+func (d MessagesDelegate) renderPreview(entry types.FormattedMessage, width int) string {
+	info := entry.MediaInfo
+	if info == nil || len(info.Thumbnail) == 0 || imageProtocol() == termimage.None || !termimage.PreviewsEnabled() {
+		return ""
+	}
+	peer, _ := d.Model.openChatPeer()
+	key := previewKey{PeerID: peer.ID, MessageID: entry.ID, Cols: min(previewMaxCols, width/4)}
+	if preview, ok := previews[key]; ok {
+		return preview
+	}
+	if len(previews) >= previewCacheSize {
+		clear(previews)
+	}
+	var preview string
+	if img, err := termimage.Decode(bytes.NewReader(info.Thumbnail)); err == nil {
+		imageWidth, imageHeight := info.Width, info.Height
+		if imageWidth <= 0 || imageHeight <= 0 {
+			imageWidth, imageHeight = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+		cols, rows := termimage.Fit(imageWidth, imageHeight, key.Cols, previewMaxRows)
+		preview = termimage.Render(img, termimage.Blocks, cols, rows)
+	}
+	previews[key] = preview
+	return preview
+}
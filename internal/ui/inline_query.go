@@ -0,0 +1,260 @@
+package ui
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+// the results panel shows this many results, the arrows scroll through the rest
+const inlineResultsSize = 5
+
+// the bot is asked once typing pauses this long
+const inlineQueryDelay = 400 * time.Millisecond
+
+// inlineQueryTickMsg fires once typing paused, Seq tells whether more was typed since
+type inlineQueryTickMsg struct {
+	Seq     int
+	Request types.InlineQueryRequest
+}
+
+// inlineQuery is what an inline bot answered to what follows its username in the input
+type inlineQuery struct {
+	PeerID      string
+	Bot         string
+	Query       string
+	Results     []types.InlineResult
+	NextOffset  string
+	Placeholder string
+	Loading     bool
+	Err         string
+	// Index is the highlighted result
+	Index int
+	// Confirmed is set once the bot answered, before that the input may just start with a mention
+	Confirmed bool
+	// NotInlineBot is set when Bot turned out to be a person or a bot without inline mode
+	NotInlineBot bool
+	// Seq grows with every query typed, ticks of older ones are dropped
+	Seq int
+}
+
+// parseInlineQuery splits "@bot query" into the bot and the query, the space after the username
+// is what turns it into an inline query
+func parseInlineQuery(value string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return "", "", false
+	}
+	bot, query, ok := strings.Cut(rest, " ")
+	if !ok || len(bot) < 4 {
+		return "", "", false
+	}
+	for _, r := range bot {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return "", "", false
+		}
+	}
+	return bot, strings.TrimSpace(query), true
+}
+
+// inlineQueryShown tells whether the input holds the query the results panel is about
+func (m Model) inlineQueryShown() bool {
+	if m.FocusedOn != Input || m.InlineQuery.Bot == "" || !m.InlineQuery.Confirmed {
+		return false
+	}
+	bot, query, ok := parseInlineQuery(m.Input.Value())
+	peer, _ := m.openChatPeer()
+	return ok && strings.EqualFold(bot, m.InlineQuery.Bot) && query == m.InlineQuery.Query && peer.ID == m.InlineQuery.PeerID
+}
+
+// queryInlineBot asks the bot again once the input holds a different inline query, the request
+// waits for typing to pause
+func (m *Model) queryInlineBot() tea.Cmd {
+	if m.FocusedOn != Input {
+		return nil
+	}
+	bot, query, ok := parseInlineQuery(m.Input.Value())
+	if !ok {
+		m.InlineQuery = inlineQuery{Seq: m.InlineQuery.Seq}
+		return nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return nil
+	}
+	sameBot := strings.EqualFold(bot, m.InlineQuery.Bot) && peer.ID == m.InlineQuery.PeerID
+	if sameBot && (query == m.InlineQuery.Query || m.InlineQuery.NotInlineBot) {
+		// a mention of someone who isn't an inline bot isn't asked about again while typing on
+		m.InlineQuery.Query = query
+		return nil
+	}
+	seq := m.InlineQuery.Seq + 1
+	m.InlineQuery = inlineQuery{PeerID: peer.ID, Bot: bot, Query: query, Loading: true, Confirmed: sameBot && m.InlineQuery.Confirmed, Seq: seq}
+	request := types.InlineQueryRequest{Peer: peer, Bot: bot, Query: query}
+	return tea.Tick(inlineQueryDelay, func(time.Time) tea.Msg {
+		return inlineQueryTickMsg{Seq: seq, Request: request}
+	})
+}
+
+// handleInlineQueryTick asks the bot once typing paused, ticks of queries typed over are dropped
+func (m Model) handleInlineQueryTick(msg inlineQueryTickMsg) (tea.Model, tea.Cmd) {
+	if msg.Seq != m.InlineQuery.Seq {
+		return m, nil
+	}
+	return m, telegram.Cligram.QueryInlineBot(telegram.Cligram.Context(), msg.Request)
+}
+
+func (m Model) handleInlineQueryResults(msg types.InlineQueryMsg) (tea.Model, tea.Cmd) {
+	request := msg.Request
+	if request.Peer.ID != m.InlineQuery.PeerID || !strings.EqualFold(request.Bot, m.InlineQuery.Bot) || request.Query != m.InlineQuery.Query {
+		// typing went on, a newer query is on its way
+		return m, nil
+	}
+	m.InlineQuery.Loading = false
+	if msg.NotInlineBot {
+		m.InlineQuery.NotInlineBot = true
+		m.InlineQuery.Confirmed = false
+		return m, nil
+	}
+	if msg.Err != nil {
+		m.InlineQuery.Err = msg.Err.Error()
+		return m, nil
+	}
+	if request.Offset == "" {
+		m.InlineQuery.Results = nil
+		m.InlineQuery.Index = 0
+	}
+	m.InlineQuery.Err = ""
+	m.InlineQuery.Confirmed = true
+	m.InlineQuery.Results = append(m.InlineQuery.Results, msg.Results...)
+	m.InlineQuery.NextOffset = msg.NextOffset
+	m.InlineQuery.Placeholder = msg.Placeholder
+	return m, nil
+}
+
+// handleInlineQueryKey scrolls through the results and sends the highlighted one, handled is
+// false when the key is for the input
+func (m Model) handleInlineQueryKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	if !m.inlineQueryShown() || len(m.InlineQuery.Results) == 0 {
+		return m, nil, false
+	}
+	results := m.InlineQuery.Results
+	switch msg.String() {
+	case "up":
+		m.InlineQuery.Index = max(0, m.InlineQuery.Index-1)
+		return m, nil, true
+	case "down":
+		m.InlineQuery.Index = min(len(results)-1, m.InlineQuery.Index+1)
+		return m, m.loadMoreInlineResults(), true
+	case "enter":
+		peer, ok := m.openChatPeer()
+		if !ok {
+			return m, nil, true
+		}
+		// results don't wait in the outbox, keep the query until we are back
+		if m.Connection.State != types.ConnectionConnected {
+			return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "not connected, the result can be sent once the connection is back"), true
+		}
+		result := results[min(m.InlineQuery.Index, len(results)-1)]
+		cmd := telegram.Cligram.SendInlineResult(telegram.Cligram.Context(), types.SendInlineResultRequest{
+			Peer:     peer,
+			QueryID:  result.QueryID,
+			ResultID: result.ID,
+			TopMsgID: m.openTopicID(),
+			RandID:   rand.Int(),
+		})
+		m.InlineQuery = inlineQuery{Seq: m.InlineQuery.Seq}
+		m.Input.Reset()
+		return m, cmd, true
+	}
+	return m, nil, false
+}
+
+// loadMoreInlineResults asks for the next page once the cursor gets close to the end
+func (m *Model) loadMoreInlineResults() tea.Cmd {
+	query := &m.InlineQuery
+	if query.Loading || query.NextOffset == "" || query.Index < len(query.Results)-resultsPrefetch {
+		return nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return nil
+	}
+	query.Loading = true
+	return telegram.Cligram.QueryInlineBot(telegram.Cligram.Context(), types.InlineQueryRequest{
+		Peer:   peer,
+		Bot:    query.Bot,
+		Query:  query.Query,
+		Offset: query.NextOffset,
+	})
+}
+
+func (m Model) handleSendInlineResult(msg types.SendInlineResultMsg) (tea.Model, tea.Cmd) {
+	if msg.Err != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
+	}
+	return m.showSentMessage(msg.Request.Peer, msg.Message)
+}
+
+// inlineResultsView lists the results above the input, a title line and a line of what the
+// result is about for each
+func (m *Model) inlineResultsView(width int) string {
+	if !m.inlineQueryShown() {
+		return ""
+	}
+	query := m.InlineQuery
+	header := "@" + query.Bot
+	if query.Placeholder != "" && query.Query == "" {
+		header += " · " + query.Placeholder
+	}
+	lines := []string{mediaStyle.Render(header)}
+	switch {
+	case query.Err != "":
+		lines = append(lines, lipgloss.NewStyle().Foreground(DefaultTheme.ErrorColor).Render(query.Err))
+	case query.Loading && len(query.Results) == 0:
+		lines = append(lines, timestampStyle.Render("loading…"))
+	case len(query.Results) == 0:
+		lines = append(lines, timestampStyle.Render("no results"))
+	}
+	line := lipgloss.NewStyle().MaxWidth(max(10, width))
+	index := min(query.Index, len(query.Results)-1)
+	start := max(0, index-inlineResultsSize+1)
+	end := min(len(query.Results), start+inlineResultsSize)
+	for i := start; i < end; i++ {
+		result := query.Results[i]
+		style := buttonStyle
+		if i == index {
+			style = focusedButtonStyle
+		}
+		title := result.Title
+		if title == "" {
+			title = result.Type
+		}
+		lines = append(lines, line.Render(style.Render(title)))
+		about := result.Description
+		if about == "" {
+			about = result.Text
+		}
+		if about == "" {
+			about = result.URL
+		}
+		if about = strings.Join(strings.Fields(about), " "); about != "" {
+			lines = append(lines, line.Render("  "+timestampStyle.Render(about)))
+		}
+	}
+	if len(query.Results) > 0 {
+		hint := fmt.Sprintf("%d/%d · ↑ ↓ scrolls · enter sends", index+1, len(query.Results))
+		if query.NextOffset != "" {
+			hint = fmt.Sprintf("%d/%d+ · ↑ ↓ scrolls · enter sends", index+1, len(query.Results))
+		}
+		lines = append(lines, timestampStyle.Render(hint))
+	}
+	return strings.Join(lines, "\n")
+}
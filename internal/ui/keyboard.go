@@ -0,0 +1,296 @@
+package ui
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"github.com/muesli/termenv"
+	"go.dalton.dog/bubbleup"
+)
+
+var (
+	buttonStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.AccentColor)
+
+	focusedButtonStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.AccentColor).
+				Reverse(true)
+)
+
+// buttonFocus is the highlighted button under the selected message, counted over all rows
+type buttonFocus struct {
+	MessageID int
+	Index     int
+}
+
+func buttonLabel(button types.KeyboardButton) string {
+	switch button.Kind {
+	case types.ButtonURL:
+		return button.Text + " ↗"
+	case types.ButtonSwitchInline:
+		return button.Text + " ↪"
+	}
+	return button.Text
+}
+
+// renderKeyboard draws the rows of buttons, focused is counted over all rows and -1 for none
+func renderKeyboard(rows [][]types.KeyboardButton, focused int) string {
+	lines := make([]string, 0, len(rows))
+	index := 0
+	for _, row := range rows {
+		buttons := make([]string, 0, len(row))
+		for _, button := range row {
+			style := buttonStyle
+			if index == focused {
+				style = focusedButtonStyle
+			}
+			buttons = append(buttons, style.Render("[ "+buttonLabel(button)+" ]"))
+			index++
+		}
+		lines = append(lines, strings.Join(buttons, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func buttonAt(rows [][]types.KeyboardButton, index int) (types.KeyboardButton, bool) {
+	for _, row := range rows {
+		if index < len(row) {
+			return row[index], true
+		}
+		index -= len(row)
+	}
+	return types.KeyboardButton{}, false
+}
+
+func buttonCount(rows [][]types.KeyboardButton) int {
+	count := 0
+	for _, row := range rows {
+		count += len(row)
+	}
+	return count
+}
+
+// focusedButton is the highlighted button of a message, the first one until another is picked
+func (m Model) focusedButton(message types.FormattedMessage) int {
+	if m.ButtonFocus.MessageID != message.ID {
+		return 0
+	}
+	return m.ButtonFocus.Index
+}
+
+func (d MessagesDelegate) renderInlineKeyboard(entry types.FormattedMessage, selected bool) string {
+	if len(entry.InlineKeyboard) == 0 {
+		return ""
+	}
+	focused := -1
+	if selected {
+		focused = d.Model.focusedButton(entry)
+	}
+	keyboard := renderKeyboard(entry.InlineKeyboard, focused)
+	if selected {
+		keyboard += "\n" + timestampStyle.Render("shift+← shift+→ picks a button · enter presses it")
+	}
+	return keyboard
+}
+
+// currentReplyKeyboard is the keyboard the bot of the open chat asked for with its latest
+// message that had one, nil when there is none or the bot took it away
+func (m Model) currentReplyKeyboard() (*types.ReplyKeyboard, int) {
+	if m.Mode != ModeBots {
+		return nil, 0
+	}
+	for i := len(m.Conversations.Messages) - 1; i >= 0; i-- {
+		message := m.Conversations.Messages[i]
+		if message.IsFromMe || message.ReplyKeyboard == nil {
+			continue
+		}
+		if message.ReplyKeyboard.Hide || len(message.ReplyKeyboard.Rows) == 0 || message.ID == m.UsedReplyKeyboard {
+			return nil, 0
+		}
+		return message.ReplyKeyboard, message.ID
+	}
+	return nil, 0
+}
+
+func (m *Model) replyKeyboardView() string {
+	keyboard, _ := m.currentReplyKeyboard()
+	if keyboard == nil {
+		return ""
+	}
+	view := renderKeyboard(keyboard.Rows, m.ReplyButton-1)
+	hint := "shift+↑ picks a button · enter sends it"
+	if m.ReplyButton > 0 {
+		hint = "shift+↑ shift+↓ picks a button · enter sends it · typing goes back to the input"
+	}
+	if keyboard.Placeholder != "" {
+		hint = keyboard.Placeholder + " · " + hint
+	}
+	if m.FocusedOn == Input && m.Input.Value() == "" {
+		view += "\n" + timestampStyle.Render(hint)
+	}
+	return view
+}
+
+// handleKeyboardKey moves through bot buttons and presses them. handled is false when the key is
+// for the chat or the input instead, the plain arrows stay with the list pages and the input
+// history so buttons take shift+arrows
+func (m Model) handleKeyboardKey(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	key := msg.String()
+	switch m.FocusedOn {
+	case Main:
+		message, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+		if !ok || len(message.InlineKeyboard) == 0 || (key != "shift+left" && key != "shift+right") {
+			return m, nil, false
+		}
+		index := m.focusedButton(message)
+		if key == "shift+left" {
+			index = max(0, index-1)
+		} else {
+			index = min(buttonCount(message.InlineKeyboard)-1, index+1)
+		}
+		m.ButtonFocus = buttonFocus{MessageID: message.ID, Index: index}
+		return m, m.refreshConversations(), true
+	case Input:
+		keyboard, messageID := m.currentReplyKeyboard()
+		if keyboard == nil || m.Input.Value() != "" {
+			m.ReplyButton = 0
+			return m, nil, false
+		}
+		count := buttonCount(keyboard.Rows)
+		switch key {
+		case "shift+up":
+			if m.ReplyButton == 0 {
+				m.ReplyButton = count
+			} else {
+				m.ReplyButton = max(1, m.ReplyButton-1)
+			}
+			return m, nil, true
+		case "shift+down":
+			if m.ReplyButton > 0 {
+				m.ReplyButton = (m.ReplyButton + 1) % (count + 1)
+				return m, nil, true
+			}
+		case "enter":
+			if m.ReplyButton > 0 {
+				button, ok := buttonAt(keyboard.Rows, m.ReplyButton-1)
+				m.ReplyButton = 0
+				if !ok {
+					return m, nil, true
+				}
+				if keyboard.SingleUse {
+					m.UsedReplyKeyboard = messageID
+				}
+				model, cmd := m.sendButtonText(button.Text)
+				return model, cmd, true
+			}
+		}
+		m.ReplyButton = 0
+	}
+	return m, nil, false
+}
+
+// sendButtonText sends the text of a button as if it was typed, which is all text buttons do
+func (m Model) sendButtonText(text string) (tea.Model, tea.Cmd) {
+	m.Input.SetValue(text)
+	return sendMessage(&m)
+}
+
+// handleInlineButtonEnter presses the highlighted button of the selected message, handled is
+// false when the message has no buttons
+func (m Model) handleInlineButtonEnter() (tea.Model, tea.Cmd, bool) {
+	message, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || len(message.InlineKeyboard) == 0 {
+		return m, nil, false
+	}
+	button, ok := buttonAt(message.InlineKeyboard, m.focusedButton(message))
+	if !ok {
+		return m, nil, false
+	}
+	model, cmd := m.pressInlineButton(message, button)
+	return model, cmd, true
+}
+
+func (m Model) pressInlineButton(message types.FormattedMessage, button types.KeyboardButton) (tea.Model, tea.Cmd) {
+	switch button.Kind {
+	case types.ButtonText:
+		return m.sendButtonText(button.Text)
+	case types.ButtonCallback, types.ButtonGame:
+		if button.RequiresPassword {
+			return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "this button asks for your password, use an official app for it")
+		}
+		peer, ok := m.openChatPeer()
+		if !ok {
+			return m, nil
+		}
+		return m, telegram.Cligram.PressBotButton(telegram.Cligram.Context(), types.BotCallbackRequest{
+			Peer:      peer,
+			MessageID: message.ID,
+			Data:      button.Data,
+			Game:      button.Kind == types.ButtonGame,
+		})
+	case types.ButtonURL:
+		return m.openLink(button.URL)
+	case types.ButtonSwitchInline:
+		username := m.botUsername(message)
+		if username == "" {
+			return m, nil
+		}
+		// without SamePeer telegram would ask for a chat to send to, the open chat is the one here
+		m.Input.SetValue(strings.TrimSpace("@" + username + " " + button.Query))
+		m.Input.CursorEnd()
+		m.FocusedOn = Input
+		return m, nil
+	case types.ButtonCopy:
+		termenv.Copy(button.CopyText)
+		return m, m.Alert.NewAlertCmd(bubbleup.InfoKey, "copied")
+	}
+	return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "this button isn't supported here, use an official app for it")
+}
+
+// botUsername is the username of the bot that sent the message, inline queries start with it
+func (m Model) botUsername(message types.FormattedMessage) string {
+	if message.SenderUserInfo != nil && message.SenderUserInfo.Username != "" {
+		return message.SenderUserInfo.Username
+	}
+	if m.Mode == ModeBots {
+		return m.SelectedUser.Username
+	}
+	return ""
+}
+
+// openLink opens telegram links as a chat here and anything else in the browser
+func (m Model) openLink(url string) (tea.Model, tea.Cmd) {
+	if entity := getEntityName(url); entity != nil {
+		return m, func() tea.Msg {
+			return types.OpenNewChatWithPeerMsg{Chat: entity}
+		}
+	}
+	if err := shared.OpenFileInDefaultApp(url); err != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, "failed to open "+url+": "+err.Error())
+	}
+	return m, nil
+}
+
+func (m Model) handleBotCallbackAnswer(msg types.BotCallbackAnswerMsg) (tea.Model, tea.Cmd) {
+	if msg.Err != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
+	}
+	var cmds []tea.Cmd
+	if msg.Message != "" {
+		key := bubbleup.InfoKey
+		if msg.Alert {
+			key = bubbleup.WarnKey
+		}
+		cmds = append(cmds, m.Alert.NewAlertCmd(key, msg.Message))
+	}
+	if msg.URL != "" {
+		model, cmd := m.openLink(msg.URL)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	}
+	return m, tea.Batch(cmds...)
+}
@@ -47,11 +47,20 @@ func (m Manager) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	case OpenModalMsg:
 		m.State = ModalView
 	case tea.KeyMsg:
+		// the image viewer closes with esc and q, they must not quit the app while it is open
+		if m.State == MainView && viewingImage(m.Background) {
+			bg, bgCmd := m.Background.Update(message)
+			m.Background = bg
+			return m, bgCmd
+		}
 		switch msg.String() {
 		case "q", "ctrl+c", "esc":
 			if m.State == MainView {
 				return m, tea.Quit
 			}
+			if fg, ok := m.Foreground.(*Foreground); ok && msg.String() == "q" && fg.acceptsText() {
+				break
+			}
 			m.State = MainView
 			return m, nil
 		case "ctrl+k":
@@ -113,3 +122,13 @@ func (m Manager) View() string {
 	}
 	return m.Background.View()
 }
+
+func viewingImage(background tea.Model) bool {
+	switch model := background.(type) {
+	case Model:
+		return model.ImageViewer != nil
+	case *Model:
+		return model.ImageViewer != nil
+	}
+	return false
+}
@@ -0,0 +1,434 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+// downloadKey identifies a download, they keep running when another chat is opened
+type downloadKey struct {
+	PeerID    string
+	MessageID int
+}
+
+type downloadProgress struct {
+	Downloaded int64
+	Total      int64
+}
+
+func (p downloadProgress) String() string {
+	if p.Total <= 0 {
+		return "⬇ " + formatFileSize(p.Downloaded)
+	}
+	return fmt.Sprintf("⬇ %d%%", min(100, p.Downloaded*100/p.Total))
+}
+
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f TB", value)
+}
+
+func formatMediaDuration(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// mediaSummary is the one line a message shows for its file: what it is, how long and how big
+func mediaSummary(info *types.MediaInfo) string {
+	var icon, name string
+	switch info.Kind {
+	case types.MediaPhoto:
+		icon, name = "🖼", "photo"
+	case types.MediaVideo:
+		icon, name = "🎬", "video"
+	case types.MediaAnimation:
+		icon, name = "🎞", "gif"
+	case types.MediaVideoNote:
+		icon, name = "⭕", "video message"
+	case types.MediaVoice:
+		icon, name = "🎤", "voice message"
+	case types.MediaSticker:
+		icon, name = "🏷", "sticker"
+	case types.MediaAudio:
+		icon, name = "🎵", "audio"
+		if info.Title != "" {
+			name = info.Title
+			if info.Performer != "" {
+				name = info.Performer + " – " + info.Title
+			}
+		}
+	default:
+		icon, name = "📄", "file"
+	}
+	if info.FileName != "" && info.Kind != types.MediaAudio && info.Kind != types.MediaVoice {
+		name = info.FileName
+	}
+
+	details := []string{icon + " " + name}
+	if info.Duration > 0 {
+		details = append(details, formatMediaDuration(info.Duration))
+	}
+	if info.Width > 0 && info.Height > 0 && info.Kind != types.MediaSticker {
+		details = append(details, fmt.Sprintf("%d×%d", info.Width, info.Height))
+	}
+	if info.Size > 0 {
+		details = append(details, formatFileSize(info.Size))
+	}
+	return strings.Join(details, " · ")
+}
+
+// mediaSnippet stands in for the text of a media message in search results and the like
+func mediaSnippet(message types.FormattedMessage) string {
+	if message.MediaInfo == nil {
+		return "[media]"
+	}
+	return mediaSummary(message.MediaInfo)
+}
+
+func (d MessagesDelegate) renderMedia(entry types.FormattedMessage, selected bool, width int) string {
+	if len(entry.Album) > 0 {
+		return d.renderAlbum(entry, selected)
+	}
+	line := mediaStyle.Render(mediaSummary(entry.MediaInfo))
+	if entry.SendState == types.OutboxPending {
+		if upload, ok := d.Model.Uploads[entry.ID]; ok {
+			return line + " " + downloadProgressStyle.Render(upload.percent())
+		}
+	}
+	if progress, ok := d.downloadProgress(entry.ID); ok {
+		line += " " + downloadProgressStyle.Render(progress.String())
+	} else if selected && entry.SendState == "" {
+		hint := "enter opens · D saves"
+		if canView(entry.MediaInfo) {
+			hint += " · v views"
+		}
+		line += " " + timestampStyle.Render(hint)
+	}
+	if preview := d.renderPreview(entry, width); preview != "" {
+		line += "\n" + preview
+	}
+	return line
+}
+
+// renderAlbum shows the files of an album one per line under a header
+func (d MessagesDelegate) renderAlbum(entry types.FormattedMessage, selected bool) string {
+	header := fmt.Sprintf("🗂 album · %d items", len(entry.Album))
+	if entry.SendState == types.OutboxPending {
+		if upload, ok := d.Model.Uploads[entry.ID]; ok {
+			header += " " + downloadProgressStyle.Render(upload.percent())
+		}
+	} else if selected && entry.SendState == "" {
+		hint := "enter or D saves all"
+		if slices.ContainsFunc(entry.Album, func(part types.FormattedMessage) bool { return canView(part.MediaInfo) }) {
+			hint += " · v views"
+		}
+		header += " " + timestampStyle.Render(hint)
+	}
+	lines := []string{mediaStyle.Render(header)}
+	for _, part := range entry.Album {
+		if part.MediaInfo == nil {
+			continue
+		}
+		line := "  " + mediaStyle.Render(mediaSummary(part.MediaInfo))
+		if progress, ok := d.downloadProgress(part.ID); ok {
+			line += " " + downloadProgressStyle.Render(progress.String())
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (d MessagesDelegate) downloadProgress(messageID int) (downloadProgress, bool) {
+	peer, _ := d.Model.openChatPeer()
+	progress, ok := d.Model.Downloads[downloadKey{PeerID: peer.ID, MessageID: messageID}]
+	return progress, ok
+}
+
+// handleDownloadKey downloads the file of the selected message, downloading a file a second time
+// finds the first copy. albums are saved as a whole
+func (m Model) handleDownloadKey() (tea.Model, tea.Cmd) {
+	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || selectedMessage.SendState != "" {
+		return m, nil
+	}
+	parts := []types.FormattedMessage{selectedMessage}
+	if len(selectedMessage.Album) > 0 {
+		parts = selectedMessage.Album
+	}
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	if m.Downloads == nil {
+		m.Downloads = map[downloadKey]downloadProgress{}
+	}
+	var cmds []tea.Cmd
+	for _, part := range parts {
+		if part.MediaInfo == nil {
+			continue
+		}
+		key := downloadKey{PeerID: peer.ID, MessageID: part.ID}
+		if _, running := m.Downloads[key]; running {
+			continue
+		}
+		m.Downloads[key] = downloadProgress{Total: part.MediaInfo.Size}
+		cmds = append(cmds, telegram.Cligram.DownloadMedia(telegram.Cligram.Context(), types.DownloadMediaRequest{
+			Peer:      peer,
+			MessageID: part.ID,
+		}))
+	}
+	if len(cmds) == 0 && len(parts) == 1 && parts[0].MediaInfo != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "already downloading this file")
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// handleOpenMediaKey opens the file of the selected message without downloading anything: a saved
+// file goes to the default app and pictures to the viewer. anything else has to be saved with D
+// first
+func (m Model) handleOpenMediaKey() (tea.Model, tea.Cmd) {
+	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || selectedMessage.SendState != "" {
+		return m, nil
+	}
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	if path, saved := m.SavedFiles[downloadKey{PeerID: peer.ID, MessageID: selectedMessage.ID}]; saved {
+		if err := shared.OpenFileInDefaultApp(path); err != nil {
+			return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, "failed to open "+path+": "+err.Error())
+		}
+		return m, nil
+	}
+	parts := []types.FormattedMessage{selectedMessage}
+	if len(selectedMessage.Album) > 0 {
+		parts = selectedMessage.Album
+	}
+	for _, part := range parts {
+		if canView(part.MediaInfo) {
+			return m.handleViewKey()
+		}
+	}
+	return m, m.Alert.NewAlertCmd(bubbleup.InfoKey, "press D to download it, enter opens it afterwards")
+}
+
+func (m Model) handleDownloadProgress(msg types.DownloadProgressNotification) (tea.Model, tea.Cmd) {
+	key := downloadKey{PeerID: msg.Peer.ID, MessageID: msg.MessageID}
+	// progress can arrive after the result of a download that was already done
+	if _, running := m.Downloads[key]; running {
+		m.Downloads[key] = downloadProgress{Downloaded: msg.Downloaded, Total: msg.Total}
+	}
+	return m, nil
+}
+
+func (m Model) handleDownloadResult(msg types.DownloadMediaMsg) (tea.Model, tea.Cmd) {
+	key := downloadKey{PeerID: msg.Request.Peer.ID, MessageID: msg.Request.MessageID}
+	delete(m.Downloads, key)
+	if msg.Path != "" {
+		if m.SavedFiles == nil {
+			m.SavedFiles = map[downloadKey]string{}
+		}
+		m.SavedFiles[key] = msg.Path
+	}
+	if msg.Err != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
+	}
+	if msg.Request.Open {
+		return m, nil
+	}
+	return m, m.Alert.NewAlertCmd(bubbleup.InfoKey, "saved to "+msg.Path)
+}
+
+func downloadsStatus(downloads map[downloadKey]downloadProgress) string {
+	switch len(downloads) {
+	case 0:
+		return ""
+	case 1:
+		for _, progress := range downloads {
+			return progress.String()
+		}
+	}
+	return fmt.Sprintf("⬇ %d downloads", len(downloads))
+}
+
+type uploadProgress struct {
+	FileName  string
+	Uploaded  int64
+	Total     int64
+	StartedAt time.Time
+}
+
+func (p uploadProgress) percent() string {
+	if p.Total <= 0 {
+		return "⬆ 0%"
+	}
+	return fmt.Sprintf("⬆ %d%%", min(100, p.Uploaded*100/p.Total))
+}
+
+// localMediaInfo describes a file we are sending until the server has the real message. the
+// kind is a guess from the extension, the client decides for real once it has read the file
+func localMediaInfo(path string, asFile bool) *types.MediaInfo {
+	info := &types.MediaInfo{Kind: types.MediaDocument, FileName: filepath.Base(path)}
+	if !asFile {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jpg", ".jpeg", ".png":
+			info.Kind = types.MediaPhoto
+		case ".mp4", ".mov", ".m4v":
+			info.Kind = types.MediaVideo
+		}
+	}
+	if stat, err := os.Stat(path); err == nil {
+		info.Size = stat.Size()
+	}
+	return info
+}
+
+// localAlbum is the optimistic album of files we are sending, only the media of the parts is set
+func localAlbum(paths []string, asFile bool) []types.FormattedMessage {
+	album := make([]types.FormattedMessage, 0, len(paths))
+	for _, path := range paths {
+		album = append(album, types.FormattedMessage{MediaInfo: localMediaInfo(path, asFile)})
+	}
+	return album
+}
+
+func (m *Model) startUpload(randID int, paths []string) {
+	if m.Uploads == nil {
+		m.Uploads = map[int]uploadProgress{}
+	}
+	upload := uploadProgress{FileName: filepath.Base(paths[0]), StartedAt: time.Now()}
+	if len(paths) > 1 {
+		upload.FileName = fmt.Sprintf("album of %d files", len(paths))
+	}
+	for _, path := range paths {
+		if stat, err := os.Stat(path); err == nil {
+			upload.Total += stat.Size()
+		}
+	}
+	m.Uploads[randID] = upload
+}
+
+// pickFile adds a file chosen in the picker to the next message, a marked file is unmarked
+// again. done closes the picker
+func (m *Model) pickFile(path string, done bool) tea.Cmd {
+	var cmd tea.Cmd
+	switch i := slices.Index(m.SelectedFiles, path); {
+	case i != -1:
+		if !done {
+			m.SelectedFiles = slices.Delete(m.SelectedFiles, i, i+1)
+		}
+	case len(m.SelectedFiles) >= types.MaxAlbumSize:
+		cmd = m.Alert.NewAlertCmd(bubbleup.WarnKey, fmt.Sprintf("an album holds at most %d files", types.MaxAlbumSize))
+	default:
+		if len(m.SelectedFiles) == 0 {
+			m.SendAsFile = false
+		}
+		m.SelectedFiles = append(m.SelectedFiles, path)
+	}
+	if done {
+		m.IsFilepickerVisible = false
+	}
+	return cmd
+}
+
+// handleUploadProgress also picks up uploads the client retries from the outbox on its own
+func (m Model) handleUploadProgress(msg types.UploadProgressNotification) (tea.Model, tea.Cmd) {
+	if m.Uploads == nil {
+		m.Uploads = map[int]uploadProgress{}
+	}
+	upload, ok := m.Uploads[msg.RandID]
+	if !ok {
+		// the last report of an upload can come in after its result
+		if msg.Uploaded >= msg.Total {
+			return m, nil
+		}
+		upload = uploadProgress{FileName: msg.FileName, StartedAt: time.Now()}
+	}
+	upload.Uploaded, upload.Total = msg.Uploaded, msg.Total
+	m.Uploads[msg.RandID] = upload
+	return m, nil
+}
+
+// handleCancelUploadKey cancels the upload of the selected message, or the latest one when the
+// selected message isn't uploading
+func (m Model) handleCancelUploadKey() (tea.Model, tea.Cmd) {
+	if len(m.Uploads) == 0 {
+		return m, nil
+	}
+	randID := -1
+	if selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok && m.FocusedOn == Main {
+		if _, uploading := m.Uploads[selectedMessage.ID]; uploading {
+			randID = selectedMessage.ID
+		}
+	}
+	if randID == -1 {
+		var latest time.Time
+		for id, upload := range m.Uploads {
+			if upload.StartedAt.After(latest) {
+				randID, latest = id, upload.StartedAt
+			}
+		}
+	}
+	if randID == -1 {
+		return m, nil
+	}
+	// an upload that finished meanwhile can't be cancelled, its progress goes away with the result
+	if !telegram.Cligram.CancelUpload(randID) {
+		return m, nil
+	}
+	delete(m.Uploads, randID)
+	m.Conversations.Remove(randID)
+	return m, tea.Batch(m.refreshConversations(), m.Alert.NewAlertCmd(bubbleup.InfoKey, "upload cancelled"))
+}
+
+func isCancelledUpload(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// uploadsView is shown under the input while files are going up
+func uploadsView(uploads map[int]uploadProgress) string {
+	if len(uploads) == 0 {
+		return ""
+	}
+	sorted := make([]uploadProgress, 0, len(uploads))
+	for _, upload := range uploads {
+		sorted = append(sorted, upload)
+	}
+	slices.SortFunc(sorted, func(a, b uploadProgress) int { return a.StartedAt.Compare(b.StartedAt) })
+	var lines []string
+	for _, upload := range sorted {
+		size := formatFileSize(upload.Uploaded)
+		if upload.Total > 0 {
+			size += " / " + formatFileSize(upload.Total)
+		}
+		lines = append(lines, downloadProgressStyle.Render(upload.percent())+" "+upload.FileName+" "+timestampStyle.Render(size))
+	}
+	lines[len(lines)-1] += timestampStyle.Render(" · ctrl+x cancels")
+	return strings.Join(lines, "\n")
+}
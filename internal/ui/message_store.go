@@ -0,0 +1,202 @@
+package ui
+
+import (
+	"slices"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+const (
+	// how many messages one history request asks for
+	historyPageSize = 50
+	// long sessions scrolling far back would otherwise keep every page around,
+	// past this the end furthest from where the user is reading gets dropped
+	maxLoadedMessages = 1000
+)
+
+// MessageStore holds the loaded part of the open chat's history, oldest first.
+// it grows a page at a time in either direction as the cursor reaches the top or
+// the bottom of the chat view
+type MessageStore struct {
+	Messages []types.FormattedMessage
+	// nothing older on the server, scrolling up won't load more
+	ReachedOldest bool
+	// the newest message of the chat is loaded, live messages can be appended
+	ReachedLatest bool
+	// a page request is in flight, so holding a key at the top doesn't fire one per keypress
+	loading bool
+}
+
+func (s *MessageStore) Reset() {
+	*s = MessageStore{}
+}
+
+func (s *MessageStore) Len() int {
+	return len(s.Messages)
+}
+
+func (s *MessageStore) Replace(messages []types.FormattedMessage, reachedOldest, reachedLatest bool) {
+	s.Messages = slices.Clone(messages)
+	s.ReachedOldest = reachedOldest
+	s.ReachedLatest = reachedLatest
+	s.trimOldest()
+}
+
+// Prepend adds an older page and returns how many messages were new, the caller
+// moves the cursor by that much to stay on the same message
+func (s *MessageStore) Prepend(messages []types.FormattedMessage) int {
+	older := s.withoutKnown(messages)
+	s.Messages = append(older, s.Messages...)
+	if len(s.Messages) > maxLoadedMessages {
+		s.Messages = s.Messages[:maxLoadedMessages]
+		s.ReachedLatest = false
+	}
+	return len(older)
+}
+
+// Append adds a newer page or a live message at the end
+func (s *MessageStore) Append(messages ...types.FormattedMessage) int {
+	newer := s.withoutKnown(messages)
+	s.Messages = append(s.Messages, newer...)
+	s.trimOldest()
+	return len(newer)
+}
+
+func (s *MessageStore) Has(id int) bool {
+	return s.Index(id) != -1
+}
+
+func (s *MessageStore) Index(id int) int {
+	if id == 0 {
+		return -1
+	}
+	return slices.IndexFunc(s.Messages, func(msg types.FormattedMessage) bool { return msg.ID == id })
+}
+
+// Remove drops the messages with the given ids and reports whether anything changed
+func (s *MessageStore) Remove(ids ...int) bool {
+	before := len(s.Messages)
+	s.Messages = slices.DeleteFunc(s.Messages, func(msg types.FormattedMessage) bool {
+		return slices.Contains(ids, msg.ID)
+	})
+	return len(s.Messages) != before
+}
+
+// OldestID and NewestID are the offsets for the next page, messages still in the
+// outbox only carry a rand id and are skipped
+func (s *MessageStore) OldestID() (int, bool) {
+	for _, msg := range s.Messages {
+		if msg.SendState == "" && msg.ID != 0 {
+			return msg.ID, true
+		}
+	}
+	return 0, false
+}
+
+func (s *MessageStore) NewestID() (int, bool) {
+	for _, msg := range slices.Backward(s.Messages) {
+		if msg.SendState == "" && msg.ID != 0 {
+			return msg.ID, true
+		}
+	}
+	return 0, false
+}
+
+func (s *MessageStore) StartLoading() bool {
+	if s.loading {
+		return false
+	}
+	s.loading = true
+	return true
+}
+
+func (s *MessageStore) DoneLoading() {
+	s.loading = false
+}
+
+func (s *MessageStore) IsLoading() bool {
+	return s.loading
+}
+
+// Items is what the chat view shows, the messages of an album make up one item
+func (s *MessageStore) Items() []list.Item {
+	items := make([]list.Item, 0, len(s.Messages))
+	for i := 0; i < len(s.Messages); {
+		end := s.albumEnd(i)
+		if end-i == 1 {
+			items = append(items, s.Messages[i])
+		} else {
+			items = append(items, albumItem(s.Messages[i:end]))
+		}
+		i = end
+	}
+	return items
+}
+
+// ItemIndex is the position in Items of the message with the given id, -1 when it isn't loaded
+func (s *MessageStore) ItemIndex(id int) int {
+	index := s.Index(id)
+	if index == -1 {
+		return -1
+	}
+	item := 0
+	for start := s.albumEnd(0); start <= index; start = s.albumEnd(start) {
+		item++
+	}
+	return item
+}
+
+// albumEnd is where the album starting at i ends, i+1 for a message that isn't part of one
+func (s *MessageStore) albumEnd(i int) int {
+	end := i + 1
+	if groupedID := s.Messages[i].GroupedID; groupedID != 0 {
+		for end < len(s.Messages) && s.Messages[end].GroupedID == groupedID {
+			end++
+		}
+	}
+	return end
+}
+
+// albumItem stands for all messages of an album. it is the message with the caption, that is
+// the one replies and edits go to, with every message of the album in Album
+func albumItem(messages []types.FormattedMessage) types.FormattedMessage {
+	item := messages[0]
+	for _, msg := range messages {
+		if msg.Content != "" {
+			item = msg
+			break
+		}
+	}
+	item.Album = slices.Clone(messages)
+	return item
+}
+
+// albumIDs are the ids of every message a list item stands for
+func albumIDs(item types.FormattedMessage) []int {
+	if len(item.Album) == 0 {
+		return []int{item.ID}
+	}
+	ids := make([]int, 0, len(item.Album))
+	for _, msg := range item.Album {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func (s *MessageStore) withoutKnown(messages []types.FormattedMessage) []types.FormattedMessage {
+	var unknown []types.FormattedMessage
+	for _, msg := range messages {
+		if msg.ID != 0 && !s.Has(msg.ID) {
+			unknown = append(unknown, msg)
+		}
+	}
+	return unknown
+}
+
+func (s *MessageStore) trimOldest() {
+	if extra := len(s.Messages) - maxLoadedMessages; extra > 0 {
+		s.Messages = slices.Delete(s.Messages, 0, extra)
+		s.ReachedOldest = false
+	}
+}
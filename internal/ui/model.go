@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,6 +19,8 @@ import (
 	"github.com/gotd/td/tg"
 	"github.com/kumneger0/cligram/internal/config"
 	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/client"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
 	"github.com/kumneger0/cligram/internal/telegram/types"
 	"github.com/muesli/reflow/wordwrap"
 	"go.dalton.dog/bubbleup"
@@ -176,16 +179,39 @@ func (d MessagesDelegate) Render(w io.Writer, m list.Model, index int, item list
 	if !ok {
 		return
 	}
+	if entry.Service {
+		fmt.Fprint(w, renderServiceMessage(entry, index == m.Index() && d.Model.FocusedOn == Main, m.Width()))
+		return
+	}
+	revealSpoilers := d.Model.RevealedSpoilers[entry.ID]
+	content := renderMessageContent(entry.Content, entry.Entities, m.Width(), revealSpoilers)
+	if entry.MediaInfo != nil || len(entry.Album) > 0 {
+		media := d.renderMedia(entry, index == m.Index() && d.Model.FocusedOn == Main, m.Width())
+		if content != "" {
+			media += "\n" + content
+		}
+		content = media
+	}
+	if entry.Poll != nil {
+		poll := d.renderPoll(entry, index == m.Index() && d.Model.FocusedOn == Main)
+		if content != "" {
+			poll = content + "\n" + poll
+		}
+		content = poll
+	}
 	if entry.ReplyTo != nil {
 		var strBuilder strings.Builder
 		messageReplayedTo := entry.ReplyTo.Content
+		if entry.ReplyTo.MediaInfo != nil && messageReplayedTo == "" {
+			messageReplayedTo = mediaSnippet(*entry.ReplyTo)
+		}
 		strBuilder.WriteString("> ")
 		strBuilder.WriteString(replyMessageStyle.Render(messageReplayedTo))
 		strBuilder.WriteString("\n")
-		strBuilder.WriteString(wordwrap.String(entry.Title(), m.Width()))
+		strBuilder.WriteString(content)
 		title = strBuilder.String()
 	} else {
-		title = wordwrap.String(entry.Title(), m.Width())
+		title = content
 	}
 
 	var readMaxOutboxID int
@@ -200,9 +226,14 @@ func (d MessagesDelegate) Render(w io.Writer, m list.Model, index int, item list
 	}
 
 	var readState string
-	if entry.IsFromMe && entry.ID <= readMaxOutboxID {
+	switch {
+	case entry.SendState == types.OutboxPending:
+		readState = sendPendingStyle.Render("🕓 sending")
+	case entry.SendState == types.OutboxFailed:
+		readState = sendFailedStyle.Render("✕ failed · R retry · X discard")
+	case entry.IsFromMe && entry.ID <= readMaxOutboxID:
 		readState = readStateStyleDouble.Render("✓✓")
-	} else if entry.IsFromMe {
+	case entry.IsFromMe:
 		readState = readStateStyleSingle.Render("✓")
 	}
 
@@ -230,7 +261,18 @@ func (d MessagesDelegate) Render(w io.Writer, m list.Model, index int, item list
 		title = title + preview
 	}
 
-	date := strings.Repeat(" ", 4) + timestampStyle.Render(entry.Date.Format("02/01/2006 03:04 PM")) + readState
+	if keyboard := d.renderInlineKeyboard(entry, index == m.Index() && d.Model.FocusedOn == Main); keyboard != "" {
+		title = title + "\n" + keyboard
+	}
+
+	dateText := entry.Date.Format("02/01/2006 03:04 PM")
+	if entry.IsEdited {
+		dateText += " edited"
+	}
+	if !revealSpoilers && hasSpoiler(entry.Entities) {
+		dateText += " · s shows spoiler"
+	}
+	date := strings.Repeat(" ", 4) + timestampStyle.Render(dateText) + readState
 
 	if reactions != "" {
 		title = title + "\n" + reactions
@@ -272,6 +314,16 @@ func (d MessagesDelegate) Render(w io.Writer, m list.Model, index int, item list
 	}
 }
 
+// renderServiceMessage puts what happened in the chat in the middle, dimmed so it doesn't pass
+// for a message
+func renderServiceMessage(entry types.FormattedMessage, selected bool, width int) string {
+	text := entry.Content + " · " + entry.Date.Format("03:04 PM")
+	if selected {
+		return selectedStyle.Width(width).Align(lipgloss.Center).Render(text)
+	}
+	return lipgloss.NewStyle().Width(width).Align(lipgloss.Center).Render(timestampStyle.Render(text))
+}
+
 type Mode string
 
 const (
@@ -291,32 +343,37 @@ const (
 )
 
 type Model struct {
-	Alert                    bubbleup.AlertModel
-	Filepicker               filepicker.Model
-	IsFilepickerVisible      bool
-	SelectedFile             string
-	Users                    list.Model
-	Bots                     list.Model
-	SelectedUser             types.UserInfo
-	Channels                 list.Model
-	IsModalVisible           bool
-	ModalContent             string
-	SelectedChannel          types.ChannelInfo
-	Groups                   list.Model
-	SelectedGroup            types.ChannelInfo
-	Height                   int
-	Width                    int
-	MainViewLoading          bool
-	SideBarLoading           bool
-	Mode                     Mode
-	Input                    textinput.Model
-	viewport                 viewport.Model
-	FocusedOn                FocusedOn
-	ChatUI                   list.Model
-	Conversations            [50]types.FormattedMessage
-	IsReply                  bool
-	ReplyTo                  *types.FormattedMessage
-	EditMessage              *types.FormattedMessage
+	Alert               bubbleup.AlertModel
+	Filepicker          filepicker.Model
+	IsFilepickerVisible bool
+	// SelectedFiles go out with the next message, more than one is sent as an album
+	SelectedFiles []string
+	// SendAsFile sends the selected photo or video as a plain document, toggled with ctrl+t
+	SendAsFile      bool
+	Users           list.Model
+	Bots            list.Model
+	SelectedUser    types.UserInfo
+	Channels        list.Model
+	IsModalVisible  bool
+	ModalContent    string
+	SelectedChannel types.ChannelInfo
+	Groups          list.Model
+	SelectedGroup   types.ChannelInfo
+	Height          int
+	Width           int
+	MainViewLoading bool
+	SideBarLoading  bool
+	Mode            Mode
+	Input           textinput.Model
+	viewport        viewport.Model
+	FocusedOn       FocusedOn
+	ChatUI          list.Model
+	Conversations   MessageStore
+	IsReply         bool
+	ReplyTo         *types.FormattedMessage
+	EditMessage     *types.FormattedMessage
+	// the markup the edited message was written out in, it is parsed back with the same
+	EditMarkup               shared.MarkupMode
 	SkipNextInput            bool
 	OffsetDate, OffsetID     int
 	OnPagination             bool
@@ -328,6 +385,43 @@ type Model struct {
 	ForumTopicLoading        bool
 	ShowForumTopics          bool
 	SelectedForumTopic       *types.ForumTopicInfo
+	// the sidebar was built from the on-disk cache and still waits for the server copy
+	ChatsFromCache bool
+	// the open chat shows cached messages, the next GetMessagesMsg replaces them
+	ConversationsFromCache bool
+	// the cached page of the open chat was requested alongside the server one and may still
+	// show, it is dropped once the server answered first
+	CachedHistoryPending bool
+	// who is typing where, keyed by typingChatKey and then by user id
+	Typing map[string]map[string]TypingIndicator
+	// state of the telegram connection shown in the status bar
+	Connection types.ConnectionStateNotification
+	// set while the history around a go to target loads, selected once it arrives
+	GoToTarget *goToTarget
+	// the last search inside the open chat, cleared when another chat is opened
+	ChatSearch *ChatSearch
+	// ids of messages in the open chat whose spoilers were revealed with s
+	RevealedSpoilers map[int]bool
+	// media downloads still running, in any chat
+	Downloads map[downloadKey]downloadProgress
+	// where finished downloads were saved, enter opens them from there
+	SavedFiles map[downloadKey]string
+	// files being sent, keyed by the rand id of their message
+	Uploads map[int]uploadProgress
+	// the full screen picture viewer, nil while it is closed
+	ImageViewer *imageViewer
+	// answers picked in a multiple choice poll that aren't sent yet
+	PollChoice *pollChoice
+	// the highlighted button under the selected message
+	ButtonFocus buttonFocus
+	// the highlighted button of a bot's reply keyboard counted from 1, 0 while the input has the keys
+	ReplyButton int
+	// a single use reply keyboard stays hidden once a button was pressed, this is its message
+	UsedReplyKeyboard int
+	// commands of the bots in the open chat for the menu that opens on /
+	BotCommands botCommandMenu
+	// results of the inline query typed into the input
+	InlineQuery inlineQuery
 }
 
 type CustomEmojiDocumentMsg struct {
@@ -352,26 +446,6 @@ func FetchCustomEmojiDocumentCmd(documentID int64) tea.Cmd {
 	}
 }
 
-func filterEmptyMessages(msgs [50]types.FormattedMessage) []types.FormattedMessage {
-	var filteredMsgs []types.FormattedMessage
-	for _, m := range msgs {
-		if m.ID != 0 {
-			filteredMsgs = append(filteredMsgs, m)
-		}
-	}
-	return filteredMsgs
-}
-
-func formatMessages(msgs [50]types.FormattedMessage) []list.Item {
-	filteredMsgs := filterEmptyMessages(msgs)
-	var lines []list.Item
-	for _, m := range filteredMsgs {
-		lines = append(lines, m)
-	}
-
-	return lines
-}
-
 // this is just temporary just to get things working
 // definitely i need to remove this
 func GetModalContent(errorMessage string) string {
@@ -386,7 +460,11 @@ func setItemStyles(m *Model) string {
 	if m.IsModalVisible {
 		return renderModal(m)
 	}
-	dimensions := calculateLayoutDimensions(m)
+	statusBar := prepareStatusBar(m)
+	above, below := prepareInputExtras(m)
+
+	// the rows around the input come out of the chat area, or the frame grows past the terminal
+	dimensions := calculateLayoutDimensions(m, lipgloss.Height(statusBar)+rowsHeight(above)+rowsHeight(below))
 
 	updateListDimensions(m, dimensions)
 
@@ -394,10 +472,56 @@ func setItemStyles(m *Model) string {
 
 	sidebarContent := prepareSidebarContent(m, dimensions)
 
-	inputView := prepareInputView(m, dimensions)
+	inputView := prepareInputView(m, dimensions, above, below)
 
 	row := lipgloss.JoinHorizontal(lipgloss.Top, sidebarContent, mainContent)
-	return lipgloss.NewStyle().Background(DefaultTheme.SubtleBg).Render(lipgloss.JoinVertical(lipgloss.Top, row, inputView))
+	return lipgloss.NewStyle().Background(DefaultTheme.SubtleBg).Render(lipgloss.JoinVertical(lipgloss.Top, row, inputView, statusBar))
+}
+
+func prepareStatusBar(m *Model) string {
+	var state string
+	switch m.Connection.State {
+	case types.ConnectionConnecting:
+		state = statusWarningStyle.Render("◌ connecting…")
+	case types.ConnectionReconnecting:
+		state = statusWarningStyle.Render(fmt.Sprintf("◌ reconnecting… (offline since %s)", m.Connection.Since.Format("03:04 PM")))
+	case types.ConnectionDead:
+		state = statusErrorStyle.Render("✕ connection lost, restart cligram to reconnect")
+	default:
+		state = statusConnectedStyle.Render("● connected")
+	}
+
+	if m.Connection.PendingSends > 0 {
+		noun := "messages"
+		if m.Connection.PendingSends == 1 {
+			noun = "message"
+		}
+		state += fmt.Sprintf(" · %d %s waiting to be sent", m.Connection.PendingSends, noun)
+	}
+	if telegram.Cligram != nil {
+		if delivery := deliveryStatus(telegram.Cligram.DeliveryStats()); delivery != "" {
+			state += " · " + delivery
+		}
+	}
+	if search := chatSearchStatus(m.ChatSearch); search != "" {
+		state += " · " + search
+	}
+	if downloads := downloadsStatus(m.Downloads); downloads != "" {
+		state += " · " + downloads
+	}
+	return statusBarStyle.MaxWidth(m.Width).Render(state)
+}
+
+// deliveryStatus shows how far behind the ui is on updates, nothing once it caught up
+func deliveryStatus(stats client.DeliveryStats) string {
+	if stats.Pending == 0 {
+		return ""
+	}
+	status := fmt.Sprintf("catching up on %d updates", stats.Pending)
+	if stats.Coalesced > 0 {
+		status += fmt.Sprintf(", %d merged so far", stats.Coalesced)
+	}
+	return status
 }
 
 type layoutDimensions struct {
@@ -407,16 +531,26 @@ type layoutDimensions struct {
 	inputHeight   int
 }
 
-func calculateLayoutDimensions(m *Model) layoutDimensions {
+// calculateLayoutDimensions splits the terminal between the chats and the input, reserved is
+// the height of what shows besides them
+func calculateLayoutDimensions(m *Model, reserved int) layoutDimensions {
 	sidebarWidth := m.Width * 30 / 100
 	return layoutDimensions{
 		sidebarWidth:  sidebarWidth,
 		mainWidth:     m.Width - sidebarWidth,
-		contentHeight: m.Height * 90 / 100,
+		contentHeight: max(0, m.Height*90/100-reserved),
 		inputHeight:   m.Height - (m.Height * 90 / 100),
 	}
 }
 
+// rowsHeight is how many lines the rows take, lipgloss counts an empty string as one line
+func rowsHeight(rows string) int {
+	if rows == "" {
+		return 0
+	}
+	return lipgloss.Height(rows)
+}
+
 func updateListDimensions(m *Model, d layoutDimensions) {
 	listHeight := max(0, d.contentHeight-4)
 	listWidth := max(0, d.sidebarWidth-4)
@@ -448,11 +582,17 @@ func renderModal(m *Model) string {
 func getUserOrChannelName(m *Model) string {
 	switch m.Mode {
 	case ModeUsers, ModeBots:
+		if typing := m.typingText(m.SelectedUser.PeerID, types.UserChat); typing != "" {
+			return typing
+		}
 		return formatUserName(m.SelectedUser)
 	case ModeChannels:
 		return formatChannelName(m.SelectedChannel)
 	case ModeGroups:
 		groupName := formatGroupName(m.SelectedGroup)
+		if typing := m.typingText(m.SelectedGroup.ID, types.GroupChat); typing != "" {
+			groupName += " · " + typing
+		}
 		if m.SelectedForumTopic != nil {
 			return groupName + " > " + m.SelectedForumTopic.TopicTitle
 		}
@@ -467,9 +607,6 @@ func getUserOrChannelName(m *Model) string {
 
 func formatUserName(user types.UserInfo) string {
 	name := user.Title()
-	if user.IsTyping {
-		return name + " Typing..."
-	}
 	if user.IsOnline && !user.IsBot {
 		return name + " Online"
 	}
@@ -564,10 +701,13 @@ func prepareMainContent(m *Model, d layoutDimensions) string {
 func prepareFilepickerView(m *Model) string {
 	var s strings.Builder
 	s.WriteString("\n  ")
-	if m.SelectedFile == "" {
-		s.WriteString("Pick a file:")
+	if len(m.SelectedFiles) == 0 {
+		s.WriteString(fmt.Sprintf("Pick a file: enter picks it, space marks up to %d for an album", types.MaxAlbumSize))
 	} else {
-		s.WriteString("Selected file: click ctrl + a to close file picker\n" + m.Filepicker.Styles.Selected.Render(m.SelectedFile))
+		s.WriteString(fmt.Sprintf("Selected files (%d/%d): space marks more or unmarks, ctrl + a closes the file picker", len(m.SelectedFiles), types.MaxAlbumSize))
+		for _, path := range m.SelectedFiles {
+			s.WriteString("\n  " + m.Filepicker.Styles.Selected.Render(path))
+		}
 	}
 	s.WriteString("\n\n" + m.Filepicker.View() + "\n")
 	return s.String()
@@ -601,24 +741,64 @@ func prepareSidebarContent(m *Model, d layoutDimensions) string {
 	return getSideBarStyles(d.sidebarWidth, d.contentHeight, m).Render(joinedView)
 }
 
-func prepareInputView(m *Model, d layoutDimensions) string {
+func prepareInputView(m *Model, d layoutDimensions, above, below string) string {
 	if m.FocusedOn == Input {
 		m.Input.Focus()
 	}
 
 	inputView := getInputStyle(m, d.inputHeight).Render(m.Input.View())
+	if above != "" {
+		inputView = lipgloss.JoinVertical(lipgloss.Top, above, inputView)
+	}
+	if below != "" {
+		inputView = lipgloss.JoinVertical(lipgloss.Top, inputView, below)
+	}
+	return inputView
+}
+
+// prepareInputExtras renders what shows above and below the input: the reply, the picked files,
+// bot keyboards, menus and uploads
+func prepareInputExtras(m *Model) (string, string) {
+	var above []string
+
+	if len(m.SelectedFiles) > 0 {
+		fileContext := fmt.Sprintf("File \n%s", strings.Split(m.SelectedFiles[0], "\n")[0])
+		if len(m.SelectedFiles) > 1 {
+			names := make([]string, 0, len(m.SelectedFiles))
+			for _, path := range m.SelectedFiles {
+				names = append(names, filepath.Base(path))
+			}
+			fileContext = fmt.Sprintf("Album of %d files \n%s", len(m.SelectedFiles), strings.Join(names, ", "))
+		}
+		if info := localMediaInfo(m.SelectedFiles[0], m.SendAsFile); info.Kind != types.MediaDocument {
+			fileContext += timestampStyle.Render(" · ctrl+t sends as file")
+		} else if m.SendAsFile {
+			fileContext += timestampStyle.Render(" · as file, ctrl+t undoes")
+		}
+		above = append(above, fileContext)
+	}
 
 	if m.IsReply && m.ReplyTo != nil {
-		replyContext := fmt.Sprintf("Reply to \n%s", strings.Split(m.ReplyTo.Content, "\n")[0])
-		inputView = lipgloss.JoinVertical(lipgloss.Top, replyContext, inputView)
+		above = append(above, fmt.Sprintf("Reply to \n%s", strings.Split(m.ReplyTo.Content, "\n")[0]))
 	}
 
-	if m.SelectedFile != "" {
-		fileContext := fmt.Sprintf("File \n%s", strings.Split(m.SelectedFile, "\n")[0])
-		inputView = lipgloss.JoinVertical(lipgloss.Top, fileContext, inputView)
+	if results := m.inlineResultsView(m.Width - 4); results != "" {
+		above = append(above, results)
 	}
 
-	return inputView
+	if menu := m.commandMenuView(); menu != "" {
+		above = append(above, menu)
+	}
+
+	if keyboard := m.replyKeyboardView(); keyboard != "" {
+		above = append(above, keyboard)
+	}
+
+	var aboveView string
+	if len(above) > 0 {
+		aboveView = lipgloss.JoinVertical(lipgloss.Top, above...)
+	}
+	return aboveView, uploadsView(m.Uploads)
 }
 
 func Debounce(fn func(args ...any) tea.Msg, delay time.Duration) func(args ...any) tea.Cmd {
@@ -0,0 +1,161 @@
+package ui
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+// our own messages keep their rand id as ID until the server gives them a real one,
+// so that is what outbox results are matched on
+func (m *Model) setSendState(randID int, state types.OutboxState, messageID *int) bool {
+	i := m.Conversations.Index(randID)
+	if i == -1 {
+		return false
+	}
+	if state == types.OutboxSent {
+		m.Conversations.Messages[i].SendState = ""
+		if messageID != nil {
+			m.Conversations.Messages[i].ID = *messageID
+		}
+	} else {
+		m.Conversations.Messages[i].SendState = state
+	}
+	return true
+}
+
+// splitSentAlbum turns the one message an album was shown as while sending into the messages
+// the server made of it, so they match what comes back with the history
+func (m *Model) splitSentAlbum(response types.SendMessageResponse) {
+	if len(response.AlbumIDs) == 0 {
+		return
+	}
+	i := m.Conversations.Index(response.AlbumIDs[0])
+	if i == -1 || len(m.Conversations.Messages[i].Album) != len(response.AlbumIDs) {
+		return
+	}
+	sent := m.Conversations.Messages[i]
+	parts := make([]types.FormattedMessage, 0, len(sent.Album))
+	for k, part := range sent.Album {
+		message := sent
+		message.ID, message.GroupedID = response.AlbumIDs[k], response.GroupedID
+		message.MediaInfo, message.Album = part.MediaInfo, nil
+		// the caption goes with the first file
+		if k > 0 {
+			message.Content, message.Entities = "", nil
+		}
+		parts = append(parts, message)
+	}
+	m.Conversations.Messages = slices.Replace(m.Conversations.Messages, i, i+1, parts...)
+}
+
+func (m Model) handleSendMessageResult(msg types.SendMessageMsg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+	delete(m.Uploads, msg.RandID)
+	if msg.Err != nil && isCancelledUpload(msg.Err) {
+		// cancelled with ctrl+x, the message is gone already
+		return m, nil
+	}
+	if msg.Err != nil {
+		slog.Error("Failed to send message", "error", msg.Err.Error())
+		// the message stays in the outbox, keep it on screen so it can be retried or discarded
+		m.setSendState(msg.RandID, types.OutboxFailed, nil)
+		m.Alert = m.Alert.WithAllowEscToClose().WithPosition(bubbleup.TopLeftPosition)
+		cmds = append(cmds, m.Alert.NewAlertCmd(bubbleup.WarnKey, "message not sent, select it and press R to retry or X to discard"))
+	} else {
+		var messageID *int
+		if msg.Response != nil {
+			messageID = msg.Response.MessageID
+		}
+		m.setSendState(msg.RandID, types.OutboxSent, messageID)
+		if msg.Response != nil && msg.Response.GroupedID != 0 {
+			m.splitSentAlbum(*msg.Response)
+		}
+	}
+	cmds = append(cmds, m.refreshConversations())
+	return m, tea.Batch(cmds...)
+}
+
+// handleOutboxNotification follows messages the client retries in the background
+func (m Model) handleOutboxNotification(msg types.OutboxNotification) (tea.Model, tea.Cmd) {
+	if msg.State != types.OutboxPending {
+		delete(m.Uploads, msg.RandID)
+	}
+	if !m.setSendState(msg.RandID, msg.State, msg.MessageID) {
+		return m, nil
+	}
+	if msg.Err != nil {
+		slog.Warn("retrying message failed", "randId", msg.RandID, "error", msg.Err.Error())
+	}
+	return m, m.refreshConversations()
+}
+
+func (m Model) handleRetryKey() (tea.Model, tea.Cmd) {
+	if m.FocusedOn != Main {
+		return m, nil
+	}
+	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || selectedMessage.SendState != types.OutboxFailed {
+		return m, nil
+	}
+	cmd := telegram.Cligram.RetryOutboxMessage(telegram.Cligram.Context(), selectedMessage.ID)
+	if cmd == nil {
+		return m, nil
+	}
+	m.setSendState(selectedMessage.ID, types.OutboxPending, nil)
+	return m, tea.Batch(cmd, m.refreshConversations())
+}
+
+func (m Model) handleDiscardKey() (tea.Model, tea.Cmd) {
+	if m.FocusedOn != Main {
+		return m, nil
+	}
+	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || selectedMessage.SendState != types.OutboxFailed {
+		return m, nil
+	}
+	telegram.Cligram.DiscardOutboxMessage(selectedMessage.ID)
+
+	m.Conversations.Remove(selectedMessage.ID)
+	return m, m.refreshConversations()
+}
+
+// appendOutboxMessages puts messages of the open chat that are still in the outbox after the
+// newest loaded message, this is how messages that never went out show up again after a restart
+func (m *Model) appendOutboxMessages() {
+	if !m.Conversations.ReachedLatest {
+		return
+	}
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return
+	}
+	for _, entry := range telegram.Cligram.OutboxMessages(peer, m.openTopicID()) {
+		content, entities := shared.ParseMarkup(entry.Request.Message)
+		message := types.FormattedMessage{
+			ID:        entry.Request.RandID,
+			Sender:    "you",
+			IsFromMe:  true,
+			Content:   content,
+			Entities:  entities,
+			Date:      entry.CreatedAt,
+			SendState: entry.State,
+		}
+		switch {
+		case len(entry.Request.FilePaths) > 0:
+			message.Album = localAlbum(entry.Request.FilePaths, entry.Request.SendAsFile)
+		case entry.Request.IsFile:
+			message.MediaInfo = localMediaInfo(entry.Request.FilePath, entry.Request.SendAsFile)
+		}
+		if message.Date.IsZero() {
+			message.Date = time.Now()
+		}
+		m.Conversations.Append(message)
+	}
+}
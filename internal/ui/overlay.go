@@ -181,6 +181,9 @@ const (
 	ModalModeDeleteMessage  ModalMode = "DELETE_MESSAGE"
 	ModalModeShowStories    ModalMode = "SHOW_STORIES"
 	ModalModeSendReaction   ModalMode = "SEND_REACTION"
+	ModalModeGoTo           ModalMode = "GO_TO"
+	ModalModeChatSearch     ModalMode = "CHAT_SEARCH"
+	ModalModeCreatePoll     ModalMode = "CREATE_POLL"
 )
 
 type OpenModalMsg struct {
@@ -216,6 +219,37 @@ type Foreground struct {
 	allReactions          []types.Reaction
 	selectedReactionIndex int
 	isMePremium           bool
+	// why the last go to input was rejected, shown under the prompt
+	goToErr string
+	// results of a search inside the open chat, the model keeps its own copy for n and N
+	chatSearchResults   list.Model
+	chatSearchFilter    types.MessageSearchFilter
+	chatSearchCount     int
+	chatSearchLoading   bool
+	chatSearchSubmitted bool
+	chatSearchErr       string
+	// the search the loaded results belong to, the next page continues it
+	chatSearchRequest *types.SearchMessagesRequest
+	// the search overlay looks for contacts or, on the messages tab, for messages in every chat
+	searchTab           searchTab
+	globalSearchResults list.Model
+	globalSearchQuery   string
+	// the request for the page after the loaded results, nil when there is none
+	globalSearchNext    *types.SearchGlobalRequest
+	globalSearchLoading bool
+	globalSearchErr     string
+	// the poll being written and why the last answer to the composer was rejected
+	pollDraft pollDraft
+	pollErr   string
+}
+
+// acceptsText tells whether keys go into the overlay's input, q is a letter then and not a way out
+func (f *Foreground) acceptsText() bool {
+	switch f.ModalMode {
+	case ModalModeSearch, ModalModeGoTo, ModalModeChatSearch, ModalModeCreatePoll:
+		return f.focusedOn == SEARCH
+	}
+	return false
 }
 
 func (f Foreground) Init() tea.Cmd {
@@ -332,8 +366,29 @@ func (f Foreground) View() string {
 		layout := lipgloss.JoinVertical(lipgloss.Left, title, content)
 		return foreStyle.Render(layout)
 	}
+	if f.ModalMode == ModalModeGoTo {
+		title := lipgloss.NewStyle().Foreground(DefaultTheme.PrimaryText).Bold(true).Render("Go To")
+		hint := lipgloss.NewStyle().
+			Foreground(DefaultTheme.SecondaryText).
+			Italic(true).
+			Render("a message id, or a date like 2024-05-01 or 2024-05-01 18:30")
+		lines := []string{title, getSearchView(f), hint}
+		if f.goToErr != "" {
+			lines = append(lines, lipgloss.NewStyle().Foreground(DefaultTheme.ErrorColor).Render(f.goToErr))
+		}
+		return foreStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	}
+	if f.ModalMode == ModalModeChatSearch {
+		return f.chatSearchView(foreStyle)
+	}
+	if f.ModalMode == ModalModeCreatePoll {
+		return f.pollComposerView(foreStyle)
+	}
 	title := lipgloss.NewStyle().Foreground(DefaultTheme.PrimaryText).Bold(true).Render("Search")
 	content := getSearchView(f)
+	if f.searchTab == searchTabMessages {
+		return foreStyle.Render(lipgloss.JoinVertical(lipgloss.Left, title, renderSearchTabs(f), content, f.globalSearchView()))
+	}
 	var searchResultBorderStyle lipgloss.Style
 	if f.focusedOn == SEARCH {
 		searchResultBorderStyle = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).BorderForeground(DefaultTheme.BorderColor)
@@ -342,7 +397,7 @@ func (f Foreground) View() string {
 	}
 
 	searchResult := searchResultBorderStyle.Render(f.searchResultCombined.View())
-	layout := lipgloss.JoinVertical(lipgloss.Left, title, content, searchResult)
+	layout := lipgloss.JoinVertical(lipgloss.Left, title, renderSearchTabs(f), content, searchResult)
 	return foreStyle.Render(layout)
 }
 
@@ -66,6 +66,17 @@ func (m *Foreground) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 		m.searchResultCombined.SetShowStatusBar(false)
 		m.searchResultCombined.SetShowFilter(false)
 		m.searchResultCombined.SetShowStatusBar(false)
+		m.chatSearchResults = list.New([]list.Item{}, ChatSearchDelegate{Foreground: m}, 10, 10)
+		m.chatSearchResults.SetShowTitle(false)
+		m.chatSearchResults.SetShowFilter(false)
+		m.chatSearchResults.SetShowStatusBar(false)
+		m.chatSearchResults.SetShowHelp(false)
+		m.globalSearchResults = list.New([]list.Item{}, GlobalSearchDelegate{Foreground: m}, 10, 10)
+		m.globalSearchResults.SetShowTitle(false)
+		m.globalSearchResults.SetShowFilter(false)
+		m.globalSearchResults.SetShowStatusBar(false)
+		m.globalSearchResults.SetShowHelp(false)
+		m.searchTab = searchTabContacts
 		m.windowWidth = msg.Width
 		m.windowHeight = msg.Height
 		m.focusedOn = SEARCH
@@ -79,6 +90,12 @@ func (m *Foreground) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 		model, cmd := m.handleKeyPress(msg, &cmds)
 		m = model.(*Foreground)
 		cmds = append(cmds, cmd)
+	case types.SearchMessagesMsg:
+		if m.ModalMode == ModalModeChatSearch {
+			cmds = append(cmds, m.handleChatSearchResults(msg))
+		}
+	case types.SearchGlobalMsg:
+		cmds = append(cmds, m.handleGlobalSearchResults(msg))
 	case types.SearchUsersMsg:
 		model, cmd := m.handleSearch(msg, &cmds)
 		m = model.(*Foreground)
@@ -90,6 +107,33 @@ func (m *Foreground) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 		m.UsersList = msg.UsersList
 		if msg.ModalMode == ModalModeSearch {
 			m.focusedOn = SEARCH
+			m.input.Placeholder = "Search..."
+			m.input.Prompt = "🔍 "
+		}
+		if msg.ModalMode == ModalModeGoTo {
+			m.focusedOn = SEARCH
+			m.goToErr = ""
+			m.input.Reset()
+			m.input.Placeholder = "message id or date"
+			m.input.Prompt = "↪ "
+			m.input.Focus()
+		}
+		if msg.ModalMode == ModalModeChatSearch {
+			m.focusedOn = SEARCH
+			m.input.Reset()
+			m.input.Placeholder = "Search in this chat..."
+			m.input.Prompt = "🔎 "
+			m.input.Focus()
+			m.chatSearchFilter = types.SearchFilterNone
+			m.chatSearchCount = 0
+			m.chatSearchLoading = false
+			m.chatSearchSubmitted = false
+			m.chatSearchErr = ""
+			m.chatSearchRequest = nil
+			cmds = append(cmds, m.chatSearchResults.SetItems([]list.Item{}))
+		}
+		if msg.ModalMode == ModalModeCreatePoll {
+			m.startPollDraft()
 		}
 	case types.CurrentUserMsg:
 		if msg.Err != nil {
@@ -100,6 +144,9 @@ func (m *Foreground) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 
 	input, cmd := m.input.Update(message)
 	m.input = input
+	if _, ok := message.(tea.KeyMsg); ok && m.ModalMode == ModalModeSearch && m.searchTab == searchTabMessages && m.focusedOn == SEARCH {
+		cmds = append(cmds, m.searchGlobal())
+	}
 
 	if m.UsersList != nil {
 		userList, userListCmd := m.UsersList.Update(message)
@@ -108,7 +155,15 @@ func (m *Foreground) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	cmds = append(cmds, cmd)
-	if m.focusedOn == LIST {
+	if m.focusedOn == LIST && m.ModalMode == ModalModeSearch && m.searchTab == searchTabMessages {
+		results, resultsCmd := m.globalSearchResults.Update(message)
+		m.globalSearchResults = results
+		cmds = append(cmds, resultsCmd, m.loadMoreGlobalResults())
+	} else if m.focusedOn == LIST && m.ModalMode == ModalModeChatSearch {
+		results, resultsCmd := m.chatSearchResults.Update(message)
+		m.chatSearchResults = results
+		cmds = append(cmds, resultsCmd, m.loadMoreChatResults())
+	} else if m.focusedOn == LIST {
 		users, userCmd := m.searchResultCombined.Update(message)
 		m.searchResultCombined = users
 		cmds = append(cmds, userCmd)
@@ -179,6 +234,9 @@ func (m *Foreground) handleKeyPress(msg tea.KeyMsg, cmdsFromParent *[]tea.Cmd) (
 			}
 		}
 	case "tab":
+		if m.ModalMode == ModalModeCreatePoll {
+			break
+		}
 		if m.focusedOn == SEARCH {
 			m.focusedOn = LIST
 			m.input.Blur()
@@ -186,6 +244,21 @@ func (m *Foreground) handleKeyPress(msg tea.KeyMsg, cmdsFromParent *[]tea.Cmd) (
 			m.focusedOn = SEARCH
 			m.input.Focus()
 		}
+	case "ctrl+t":
+		if m.ModalMode == ModalModeChatSearch {
+			m.chatSearchFilter = nextChatSearchFilter(m.chatSearchFilter)
+		}
+		if m.ModalMode == ModalModeCreatePoll {
+			m.cyclePollKind()
+		}
+		if m.ModalMode == ModalModeSearch {
+			if m.searchTab == searchTabMessages {
+				m.searchTab = searchTabContacts
+			} else {
+				m.searchTab = searchTabMessages
+				cmds = append(cmds, m.searchGlobal())
+			}
+		}
 	case "enter":
 		return handleEnterKey(m)
 	case "y", "Y":
@@ -208,7 +281,7 @@ func (m *Foreground) handleKeyPress(msg tea.KeyMsg, cmdsFromParent *[]tea.Cmd) (
 		}
 	}
 
-	if m.input.Focused() {
+	if m.input.Focused() && m.ModalMode == ModalModeSearch && m.searchTab == searchTabContacts {
 		searchValue := m.input.Value()
 		if len(searchValue) >= 3 {
 			searchCmd := debouncedSearch(searchValue)
@@ -230,11 +303,13 @@ func handleEnterKey(m *Foreground) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 		story.IsSelected = true
+		// story photos go to the image viewer when the terminal draws real pixels, half blocks
+		// are too coarse for a photo that fills the screen
 		return m, tea.Batch(m.stories.SetItem(m.stories.GlobalIndex(), story), telegram.Cligram.GetPeerStories(telegram.Cligram.Context(), types.Peer{
 			ID:         story.UserInfo.PeerID,
 			AccessHash: story.UserInfo.AccessHash,
 			ChatType:   types.UserChat,
-		}),
+		}, imageProtocol().Graphics()),
 		)
 	}
 	if m.ModalMode == ModalModeForwardMessage {
@@ -243,12 +318,38 @@ func handleEnterKey(m *Foreground) (tea.Model, tea.Cmd) {
 	if m.ModalMode == ModalModeSendReaction {
 		return handleSendReaction(m)
 	}
+	if m.ModalMode == ModalModeGoTo {
+		return handleGoTo(m)
+	}
+	if m.ModalMode == ModalModeChatSearch {
+		return handleChatSearchEnter(m)
+	}
+	if m.ModalMode == ModalModeCreatePoll {
+		return handlePollComposerEnter(m)
+	}
+	if m.focusedOn == LIST && m.ModalMode == ModalModeSearch && m.searchTab == searchTabMessages {
+		return handleGlobalSearchSelection(m)
+	}
 	if m.focusedOn == LIST {
 		return handleListSelection(m)
 	}
 	return m, nil
 }
 
+func handleGoTo(m *Foreground) (tea.Model, tea.Cmd) {
+	target, err := parseGoToTarget(m.input.Value())
+	if err != nil {
+		m.goToErr = err.Error()
+		return m, nil
+	}
+	m.goToErr = ""
+	m.input.Reset()
+	return m, tea.Batch(
+		func() tea.Msg { return CloseOverlay{} },
+		func() tea.Msg { return GoToMsg{Target: target} },
+	)
+}
+
 func handleSendReaction(m *Foreground) (tea.Model, tea.Cmd) {
 	if len(m.allReactions) == 0 {
 		return m, nil
@@ -0,0 +1,408 @@
+package ui
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gotd/td/tg"
+	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+	"go.dalton.dog/bubbleup"
+)
+
+// the keys that pick the answers of a poll, in order
+var pollKeys = []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "0"}
+
+// cells of the bar that shows the share of an answer
+const pollBarWidth = 10
+
+// pollChoice is what was picked so far in a multiple choice poll, enter sends it
+type pollChoice struct {
+	MessageID int
+	Answers   []int
+}
+
+// CreatePollMsg is sent by the poll composer once the poll is written
+type CreatePollMsg struct {
+	Poll types.NewPoll
+}
+
+func pollBar(percent int) string {
+	filled := percent * pollBarWidth / 100
+	return strings.Repeat("█", filled) + strings.Repeat("░", pollBarWidth-filled)
+}
+
+func pollKind(quiz, multipleChoice bool) string {
+	switch {
+	case quiz:
+		return "quiz"
+	case multipleChoice:
+		return "multiple choice poll"
+	}
+	return "poll"
+}
+
+// renderPoll shows the question and answers of a poll, with the share of every answer once we
+// voted or the poll is closed. telegram doesn't tell the results before that
+func (d MessagesDelegate) renderPoll(entry types.FormattedMessage, selected bool) string {
+	poll := entry.Poll
+	kind := pollKind(poll.Quiz, poll.MultipleChoice)
+	if !poll.PublicVoters {
+		kind = "anonymous " + kind
+	}
+	details := []string{kind}
+	switch poll.TotalVoters {
+	case 0:
+		details = append(details, "no votes yet")
+	case 1:
+		details = append(details, "1 vote")
+	default:
+		details = append(details, fmt.Sprintf("%d votes", poll.TotalVoters))
+	}
+	if poll.Closed {
+		details = append(details, "closed")
+	}
+	lines := []string{mediaStyle.Render("📊 " + poll.Question), timestampStyle.Render(strings.Join(details, " · "))}
+
+	showResults := poll.Voted() || poll.Closed
+	var picked []int
+	if choice := d.Model.PollChoice; choice != nil && choice.MessageID == entry.ID {
+		picked = choice.Answers
+	}
+	for i, answer := range poll.Answers {
+		var line string
+		if showResults {
+			percent := 0
+			if poll.TotalVoters > 0 {
+				percent = answer.Voters * 100 / poll.TotalVoters
+			}
+			mark := "  "
+			switch {
+			case poll.Quiz && answer.Correct:
+				mark = "✅"
+			case poll.Quiz && answer.Chosen:
+				mark = "❌"
+			case answer.Chosen:
+				mark = "✓ "
+			}
+			line = fmt.Sprintf("%s %3d%% %s %s", mark, percent, mediaStyle.Render(pollBar(percent)), answer.Text)
+		} else {
+			box := "○"
+			if poll.MultipleChoice {
+				box = "☐"
+				if slices.Contains(picked, i) {
+					box = "☑"
+				}
+			}
+			line = box + " " + answer.Text
+		}
+		if poll.CanVote() && i < len(pollKeys) {
+			line = timestampStyle.Render(pollKeys[i]) + " " + line
+		}
+		lines = append(lines, "  "+line)
+	}
+	if poll.Quiz && poll.Voted() && poll.Solution != "" {
+		lines = append(lines, "  💡 "+poll.Solution)
+	}
+
+	if selected && poll.CanVote() && entry.SendState == "" {
+		keys := fmt.Sprintf("1-%d", min(len(poll.Answers), len(pollKeys)))
+		var hint string
+		switch {
+		case poll.Voted():
+			hint = keys + " changes the vote · x takes it back"
+		case poll.MultipleChoice:
+			hint = keys + " picks · enter votes"
+		default:
+			hint = keys + " votes"
+		}
+		lines = append(lines, timestampStyle.Render(hint))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handlePollAnswerKey votes for an answer of the selected poll. in a multiple choice poll the
+// answer is picked or unpicked instead, enter sends the picks
+func (m Model) handlePollAnswerKey(key string) (tea.Model, tea.Cmd) {
+	message, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || message.Poll == nil || !message.Poll.CanVote() {
+		return m, nil
+	}
+	answer := slices.Index(pollKeys, key)
+	if answer == -1 || answer >= len(message.Poll.Answers) {
+		return m, nil
+	}
+	if !message.Poll.MultipleChoice {
+		return m.votePoll(message, []int{answer})
+	}
+	if m.PollChoice == nil || m.PollChoice.MessageID != message.ID {
+		m.PollChoice = &pollChoice{MessageID: message.ID}
+	}
+	if i := slices.Index(m.PollChoice.Answers, answer); i != -1 {
+		m.PollChoice.Answers = slices.Delete(m.PollChoice.Answers, i, i+1)
+	} else {
+		m.PollChoice.Answers = append(m.PollChoice.Answers, answer)
+	}
+	return m, m.refreshConversations()
+}
+
+// handlePollEnterKey sends the picks of a multiple choice poll, handled is false when the
+// selected message has none
+func (m Model) handlePollEnterKey() (tea.Model, tea.Cmd, bool) {
+	message, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || message.Poll == nil || m.PollChoice == nil || m.PollChoice.MessageID != message.ID || len(m.PollChoice.Answers) == 0 {
+		return m, nil, false
+	}
+	model, cmd := m.votePoll(message, m.PollChoice.Answers)
+	return model, cmd, true
+}
+
+func (m Model) handleRetractVoteKey() (tea.Model, tea.Cmd) {
+	message, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	if !ok || message.Poll == nil || !message.Poll.Voted() || !message.Poll.CanVote() {
+		return m, nil
+	}
+	return m.votePoll(message, nil)
+}
+
+func (m Model) votePoll(message types.FormattedMessage, answers []int) (tea.Model, tea.Cmd) {
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	options := make([][]byte, 0, len(answers))
+	for _, answer := range answers {
+		options = append(options, message.Poll.Answers[answer].Option)
+	}
+	m.PollChoice = nil
+	return m, telegram.Cligram.VotePoll(telegram.Cligram.Context(), types.VotePollRequest{
+		Peer:      peer,
+		MessageID: message.ID,
+		Options:   options,
+	})
+}
+
+func (m Model) handleVotePollResult(msg types.VotePollMsg) (tea.Model, tea.Cmd) {
+	if msg.Err != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
+	}
+	if msg.Update == nil {
+		return m, nil
+	}
+	return m.handlePollUpdate(*msg.Update)
+}
+
+// handlePollUpdate applies new results to every message of the open chat showing the poll, a
+// forwarded poll shares its id with the original
+func (m Model) handlePollUpdate(msg types.PollUpdateNotification) (tea.Model, tea.Cmd) {
+	changed := false
+	for i, message := range m.Conversations.Messages {
+		if message.Poll == nil || message.Poll.ID != msg.PollID {
+			continue
+		}
+		m.Conversations.Messages[i].Poll = shared.UpdatePoll(message.Poll, msg.Poll, msg.Results)
+		changed = true
+	}
+	if !changed {
+		return m, nil
+	}
+	return m, m.refreshConversations()
+}
+
+func (m Model) handleNewPollKey() (tea.Model, tea.Cmd) {
+	if m.ShowForumTopics && m.SelectedForumTopic == nil {
+		return m, nil
+	}
+	if _, ok := m.openChatPeer(); !ok {
+		return m, nil
+	}
+	// polls don't wait in the outbox, don't let the user write one that can't be sent
+	if m.Connection.State != types.ConnectionConnected {
+		return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "not connected, polls can be sent once the connection is back")
+	}
+	return m, func() tea.Msg {
+		return OpenModalMsg{ModalMode: ModalModeCreatePoll}
+	}
+}
+
+func (m Model) handleCreatePoll(msg CreatePollMsg) (tea.Model, tea.Cmd) {
+	peer, ok := m.openChatPeer()
+	if !ok {
+		return m, nil
+	}
+	var topMsgID *int
+	if m.SelectedForumTopic != nil {
+		id := m.SelectedForumTopic.ID
+		topMsgID = &id
+	}
+	return m, telegram.Cligram.SendPoll(telegram.Cligram.Context(), types.SendPollRequest{
+		Peer:     peer,
+		Poll:     msg.Poll,
+		TopMsgID: topMsgID,
+		RandID:   rand.Int(),
+	})
+}
+
+func (m Model) handleSendPollResult(msg types.SendPollMsg) (tea.Model, tea.Cmd) {
+	if msg.Err != nil {
+		return m, m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
+	}
+	return m.showSentMessage(msg.Request.Peer, msg.Message)
+}
+
+// showSentMessage adds a message we sent to the open chat the way a new message would show up,
+// for requests that return the message instead of going through the outbox
+func (m Model) showSentMessage(peer types.Peer, message *tg.Message) (tea.Model, tea.Cmd) {
+	if message == nil {
+		return m, nil
+	}
+	notification := types.NewMessageNotification{ID: message.ID, FromID: peer.ID, Message: message}
+	if peer.ChatType == types.GroupChat || peer.ChatType == types.ChannelChat {
+		notification.PeerType = peer.ChatType
+	}
+	return m.handleNewMessage(notification)
+}
+
+type pollStep int
+
+const (
+	pollStepQuestion pollStep = iota
+	pollStepOptions
+	pollStepCorrect
+	pollStepSolution
+)
+
+// pollDraft is the poll being written in the composer, one field at a time
+type pollDraft struct {
+	Poll types.NewPoll
+	Step pollStep
+}
+
+func (f *Foreground) startPollDraft() {
+	f.pollDraft = pollDraft{}
+	f.pollErr = ""
+	f.focusedOn = SEARCH
+	f.input.Reset()
+	f.input.Prompt = "📊 "
+	f.input.Placeholder = "question"
+	f.input.Focus()
+}
+
+// cyclePollKind switches the draft between a poll, a multiple choice poll and a quiz
+func (f *Foreground) cyclePollKind() {
+	poll := &f.pollDraft.Poll
+	switch {
+	case poll.Quiz:
+		poll.Quiz = false
+	case poll.MultipleChoice:
+		poll.MultipleChoice, poll.Quiz = false, true
+	default:
+		poll.MultipleChoice = true
+	}
+}
+
+func handlePollComposerEnter(m *Foreground) (tea.Model, tea.Cmd) {
+	draft := &m.pollDraft
+	value := strings.TrimSpace(m.input.Value())
+	m.pollErr = ""
+	switch draft.Step {
+	case pollStepQuestion:
+		if value == "" {
+			m.pollErr = "the poll needs a question"
+			return m, nil
+		}
+		draft.Poll.Question = value
+		draft.Step = pollStepOptions
+	case pollStepOptions:
+		if value != "" {
+			draft.Poll.Options = append(draft.Poll.Options, value)
+		}
+		if value != "" && len(draft.Poll.Options) < types.MaxPollOptions {
+			break
+		}
+		if len(draft.Poll.Options) < 2 {
+			m.pollErr = "add at least two answers"
+			return m, nil
+		}
+		if !draft.Poll.Quiz {
+			return m.sendPollDraft()
+		}
+		draft.Step = pollStepCorrect
+	case pollStepCorrect:
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > len(draft.Poll.Options) {
+			m.pollErr = fmt.Sprintf("type a number from 1 to %d", len(draft.Poll.Options))
+			return m, nil
+		}
+		draft.Poll.CorrectOption = number - 1
+		draft.Step = pollStepSolution
+	case pollStepSolution:
+		draft.Poll.Solution = value
+		return m.sendPollDraft()
+	}
+	m.input.Reset()
+	switch draft.Step {
+	case pollStepOptions:
+		m.input.Prompt = fmt.Sprintf("%d. ", len(draft.Poll.Options)+1)
+		m.input.Placeholder = "answer"
+	case pollStepCorrect:
+		m.input.Prompt = "✅ "
+		m.input.Placeholder = "number of the right answer"
+	case pollStepSolution:
+		m.input.Prompt = "💡 "
+		m.input.Placeholder = "explanation, optional"
+	}
+	return m, nil
+}
+
+func (m *Foreground) sendPollDraft() (tea.Model, tea.Cmd) {
+	poll := m.pollDraft.Poll
+	m.pollDraft = pollDraft{}
+	m.input.Reset()
+	return m, tea.Batch(
+		func() tea.Msg { return CloseOverlay{} },
+		func() tea.Msg { return CreatePollMsg{Poll: poll} },
+	)
+}
+
+func (f Foreground) pollComposerView(foreStyle lipgloss.Style) string {
+	draft := f.pollDraft
+	title := lipgloss.NewStyle().Foreground(DefaultTheme.PrimaryText).Bold(true).Render("New " + pollKind(draft.Poll.Quiz, draft.Poll.MultipleChoice))
+	lines := []string{title}
+	if draft.Poll.Question != "" {
+		lines = append(lines, mediaStyle.Render("📊 "+draft.Poll.Question))
+	}
+	for i, option := range draft.Poll.Options {
+		line := fmt.Sprintf("  %d. %s", i+1, option)
+		if draft.Step > pollStepCorrect && i == draft.Poll.CorrectOption {
+			line += " ✅"
+		}
+		lines = append(lines, line)
+	}
+	var hint string
+	switch draft.Step {
+	case pollStepQuestion:
+		hint = "enter goes on to the answers · ctrl+t switches between poll, multiple choice and quiz"
+	case pollStepOptions:
+		hint = fmt.Sprintf("enter adds the answer, up to %d · enter on an empty line finishes · ctrl+t changes the kind", types.MaxPollOptions)
+	case pollStepCorrect:
+		hint = "which answer is right"
+	case pollStepSolution:
+		hint = "shown to voters after they answered · enter sends the quiz"
+	}
+	lines = append(lines, getSearchView(f), lipgloss.NewStyle().
+		Foreground(DefaultTheme.SecondaryText).
+		Italic(true).
+		Width(max(20, f.windowWidth/3)).
+		Render(hint))
+	if f.pollErr != "" {
+		lines = append(lines, lipgloss.NewStyle().Foreground(DefaultTheme.ErrorColor).Render(f.pollErr))
+	}
+	return foreStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
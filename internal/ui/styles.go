@@ -121,6 +121,66 @@ var (
 	readStateStyleDouble = lipgloss.NewStyle().
 				Foreground(DefaultTheme.AccentColor).
 				Padding(0, 1)
+
+	sendPendingStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.SecondaryText).
+				Padding(0, 1)
+
+	sendFailedStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.ErrorColor).
+			Padding(0, 1)
+
+	statusBarStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.SecondaryText).
+			Padding(0, 1)
+
+	statusConnectedStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.OnlineStatus)
+
+	statusWarningStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.WarningColor).
+				Bold(true)
+
+	statusErrorStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.ErrorColor).
+				Bold(true)
+
+	inlineCodeStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.AccentColor).
+			Background(DefaultTheme.SubtleBg)
+
+	codeBlockStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(DefaultTheme.SecondaryText).
+			Padding(0, 1)
+
+	codeLanguageStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.SecondaryText).
+				Italic(true)
+
+	linkStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.AccentColor).
+			Underline(true)
+
+	mentionStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.AccentColor).
+			Bold(true)
+
+	spoilerStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.SecondaryText)
+
+	revealedSpoilerStyle = lipgloss.NewStyle().
+				Background(DefaultTheme.SelectedBg)
+
+	blockquoteBarStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.AccentColor)
+
+	mediaStyle = lipgloss.NewStyle().
+			Foreground(DefaultTheme.AccentColor)
+
+	downloadProgressStyle = lipgloss.NewStyle().
+				Foreground(DefaultTheme.SecondaryText).
+				Bold(true)
 )
 
 func getSideBarStyles(sidebarWidth int, contentHeight int, m *Model) lipgloss.Style {
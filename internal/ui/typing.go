@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kumneger0/cligram/internal/telegram/types"
+)
+
+// telegram clients repeat a send action every ~5 seconds while it lasts,
+// an indicator that isn't refreshed within this window is dropped
+const typingIndicatorTimeout = 6 * time.Second
+
+type TypingIndicator struct {
+	Name   string
+	Action types.TypingAction
+	Until  time.Time
+}
+
+type typingExpiredMsg struct {
+	chatKey string
+	userID  string
+	until   time.Time
+}
+
+// user ids and chat ids live in different spaces, keep them apart
+func typingChatKey(peerID string, peerType types.ChatType) string {
+	if peerType == types.UserChat {
+		return "user:" + peerID
+	}
+	return "chat:" + peerID
+}
+
+func (m Model) handleUserTyping(msg types.UserTypingNotification) (tea.Model, tea.Cmd) {
+	peerID := msg.PeerID
+	if peerID == "" {
+		peerID = msg.User.PeerID
+	}
+	chatKey := typingChatKey(peerID, msg.PeerType)
+
+	// whoever acts in a private chat is online right now, even when they stop typing
+	var onlineCmd tea.Cmd
+	if msg.PeerType == types.UserChat {
+		var model tea.Model
+		model, onlineCmd = m.handleUserOnlineOffline(types.UserStatusNotification{
+			UserInfo: msg.User,
+			Status:   types.UserStatus{IsOnline: true, LastSeen: time.Now()},
+		})
+		m = model.(Model)
+		if m.SelectedUser.PeerID == msg.User.PeerID {
+			m.SelectedUser.IsOnline = true
+		}
+	}
+
+	if msg.Action == types.TypingActionCancel {
+		m.clearTyping(chatKey, msg.User.PeerID)
+		return m, onlineCmd
+	}
+
+	if m.Typing == nil {
+		m.Typing = map[string]map[string]TypingIndicator{}
+	}
+	if m.Typing[chatKey] == nil {
+		m.Typing[chatKey] = map[string]TypingIndicator{}
+	}
+	until := time.Now().Add(typingIndicatorTimeout)
+	m.Typing[chatKey][msg.User.PeerID] = TypingIndicator{
+		Name:   msg.User.Title(),
+		Action: msg.Action,
+		Until:  until,
+	}
+
+	userID := msg.User.PeerID
+	return m, tea.Batch(onlineCmd, tea.Tick(typingIndicatorTimeout, func(time.Time) tea.Msg {
+		return typingExpiredMsg{chatKey: chatKey, userID: userID, until: until}
+	}))
+}
+
+func (m Model) handleTypingExpired(msg typingExpiredMsg) (tea.Model, tea.Cmd) {
+	indicator, ok := m.Typing[msg.chatKey][msg.userID]
+	// a newer action restarted the timer, its own tick will clean it up
+	if !ok || !indicator.Until.Equal(msg.until) {
+		return m, nil
+	}
+	m.clearTyping(msg.chatKey, msg.userID)
+	return m, nil
+}
+
+func (m Model) clearTyping(chatKey, userID string) {
+	typers, ok := m.Typing[chatKey]
+	if !ok {
+		return
+	}
+	delete(typers, userID)
+	if len(typers) == 0 {
+		delete(m.Typing, chatKey)
+	}
+}
+
+// typingText describes who is doing what in the chat, e.g. "Alice is uploading a photo…",
+// empty when nobody is typing
+func (m Model) typingText(peerID string, peerType types.ChatType) string {
+	typers := m.Typing[typingChatKey(peerID, peerType)]
+	if len(typers) == 0 {
+		return ""
+	}
+	ids := slices.Sorted(maps.Keys(typers))
+	first := typers[ids[0]]
+	if len(ids) == 1 {
+		return fmt.Sprintf("%s %s…", first.Name, first.Action.Describe())
+	}
+
+	// "are uploading a photo" when they all do the same, otherwise just that something happens
+	doing := "are " + strings.TrimPrefix(first.Action.Describe(), "is ")
+	for _, id := range ids[1:] {
+		if typers[id].Action != first.Action {
+			doing = "are active"
+			break
+		}
+	}
+	if len(ids) == 2 {
+		return fmt.Sprintf("%s and %s %s…", first.Name, typers[ids[1]].Name, doing)
+	}
+	return fmt.Sprintf("%s and %d others %s…", first.Name, len(ids)-1, doing)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/gotd/td/tg"
 	"github.com/kumneger0/cligram/internal/notification"
 	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
 	"github.com/kumneger0/cligram/internal/telegram/types"
 	"go.dalton.dog/bubbleup"
 )
@@ -43,6 +44,30 @@ func (m *Model) checkAndFetchCustomEmojis(messages []types.FormattedMessage) tea
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
+	// the viewer covers everything, keys must not reach the chat behind it
+	if key, ok := msg.(tea.KeyMsg); ok && m.ImageViewer != nil {
+		return m.handleImageViewerKey(key)
+	}
+	// the arrows move through inline results, bot commands and buttons while there are some, the
+	// chat and input get the rest
+	if key, ok := msg.(tea.KeyMsg); ok {
+		model, cmd, handled := m.handleInlineQueryKey(key)
+		m = model.(Model)
+		if handled {
+			return m, cmd
+		}
+		model, cmd, handled = m.handleCommandMenuKey(key)
+		m = model.(Model)
+		if handled {
+			return m, cmd
+		}
+		cmds = append(cmds, cmd)
+		model, cmd, handled = m.handleKeyboardKey(key)
+		m = model.(Model)
+		if handled {
+			return m, cmd
+		}
+	}
 	switch msg := msg.(type) {
 	case types.GetChannelForumsResponseMsg:
 		m.ForumTopicLoading = false
@@ -65,7 +90,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		items := m.ChatUI.Items()
 		for i, item := range items {
 			if formattedMessage, ok := item.(types.FormattedMessage); ok {
-				if formattedMessage.ID == msg.MessageID {
+				if slices.Contains(albumIDs(formattedMessage), msg.MessageID) {
 					m.ChatUI.Select(i)
 				}
 			} else {
@@ -160,39 +185,18 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		model, cmd := handleUserChange(&m, offsetID, highlightTheSelectedMessageCmd)
 		m = model
 		cmds = append(cmds, cmd)
+	case types.AllChatsMsg:
+		model, cmd := m.handleAllChats(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
 	case types.OpenNewChatWithPeerMsg:
 		return m, telegram.Cligram.GetEntityInfo(msg.Chat)
 	case types.SendMessageMsg:
-		if msg.Err != nil {
-			slog.Error("Failed to send message", "error", msg.Err.Error())
-			m.IsModalVisible = true
-			m.ModalContent = GetModalContent(msg.Err.Error())
-			var updatedConversations [50]types.FormattedMessage
-			j := 0
-			for _, v := range m.Conversations {
-				if v.ID != msg.RandID {
-					updatedConversations[j] = v
-					j++
-				}
-			}
-			m.Conversations = updatedConversations
-
-			m.Alert = m.Alert.WithAllowEscToClose().WithPosition(bubbleup.TopLeftPosition)
-			alertCmd := m.Alert.NewAlertCmd(bubbleup.ErrorKey, msg.Err.Error())
-			cmds = append(cmds, alertCmd)
-		} else if msg.Response != nil && msg.Response.MessageID != nil {
-			for i, conv := range m.Conversations {
-				if conv.ID == msg.RandID {
-					m.Conversations[i].ID = *msg.Response.MessageID
-					break
-				}
-			}
-		}
-		cmds = append(cmds, m.updateConversations())
-		if m.SelectedFile == "uploading..." {
-			m.SelectedFile = ""
-		}
-		return m, tea.Batch(cmds...)
+		return m.handleSendMessageResult(msg)
+	case types.OutboxNotification:
+		model, cmd := m.handleOutboxNotification(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
 	case types.EditMessageMsg:
 		if msg.Err != nil {
 			slog.Error("Failed to edit message", "error", msg.Err.Error())
@@ -203,6 +207,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.Response {
 			if selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok {
 				selectedMessage.Content = msg.UpdatedMessage
+				selectedMessage.Entities = msg.Entities
 				items := m.ChatUI.Items()
 				items[m.ChatUI.GlobalIndex()] = selectedMessage
 				m.ChatUI.SetItems(items)
@@ -211,24 +216,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case types.UserTypingNotification:
-		user := msg.User
-		if m.SelectedUser.PeerID == user.PeerID {
-			m.SelectedUser = user
-		}
-		l := listForUser(&m, user)
-		userIndex := getUserIndex(*l, user)
-		if userIndex != -1 {
-			items := l.Items()
-			items[userIndex] = user
-			cmds = append(cmds, l.SetItems(items))
-		}
-		if user.IsTyping {
-			cmds = append(cmds, tea.Tick(time.Second*3, func(t time.Time) tea.Msg {
-				user.IsOnline = false
-				user.IsTyping = false
-				return types.UserTypingNotification{User: user}
-			}))
-		}
+		model, cmd := m.handleUserTyping(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case typingExpiredMsg:
+		model, cmd := m.handleTypingExpired(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
 	case types.ErrorNotification:
 		m.ModalContent = GetModalContent(msg.Error.Error())
 		m.IsModalVisible = true
@@ -241,6 +235,25 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m = model.(Model)
 		cmds = append(cmds, cmd)
 		return m, cmd
+	case types.ConnectionStateNotification:
+		wasOffline := m.Connection.State == types.ConnectionReconnecting
+		m.Connection = msg
+		// whatever changed in the chat list while we were away, pick it up now
+		if wasOffline && msg.State == types.ConnectionConnected {
+			cmds = append(cmds, telegram.Cligram.RefreshAllChats(telegram.Cligram.Context()))
+		}
+	case types.MessageEditedNotification:
+		model, cmd := m.handleMessageEdited(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.MessagesDeletedNotification:
+		model, cmd := m.handleMessagesDeleted(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.MessageReactionsNotification:
+		model, cmd := m.handleMessageReactions(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
 	case types.ReadHistoryOutboxNotification:
 		if msg.MaxID <= 0 {
 			return m, nil
@@ -256,6 +269,92 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		model, cmd := m.handleMessageDeletion(msg)
 		m = model.(Model)
 		cmds = append(cmds, cmd)
+	case GoToMsg:
+		model, cmd := m.handleGoTo(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case ChatSearchQueryMsg:
+		model, cmd := m.handleChatSearchQuery(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case OpenGlobalSearchResultMsg:
+		model, cmd := m.handleGlobalSearchResult(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case ChatSearchJumpMsg:
+		model, cmd := m.jumpToChatSearchResult(msg.Index)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.SearchMessagesMsg:
+		model, cmd := m.handleSearchMessages(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.UploadProgressNotification:
+		model, cmd := m.handleUploadProgress(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.DownloadProgressNotification:
+		model, cmd := m.handleDownloadProgress(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.DownloadMediaMsg:
+		model, cmd := m.handleDownloadResult(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.ChannelTooLongNotification:
+		cmds = append(cmds, m.handleChannelTooLong(msg))
+	case types.ServiceMessageNotification:
+		model, cmd := m.handleServiceMessage(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.PollUpdateNotification:
+		model, cmd := m.handlePollUpdate(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.VotePollMsg:
+		model, cmd := m.handleVotePollResult(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case inlineQueryTickMsg:
+		model, cmd := m.handleInlineQueryTick(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.InlineQueryMsg:
+		model, cmd := m.handleInlineQueryResults(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.SendInlineResultMsg:
+		model, cmd := m.handleSendInlineResult(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.BotCommandsMsg:
+		model, cmd := m.handleBotCommands(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.BotCommandsNotification:
+		model, cmd := m.handleBotCommandsChanged(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.BotCallbackAnswerMsg:
+		model, cmd := m.handleBotCallbackAnswer(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case CreatePollMsg:
+		model, cmd := m.handleCreatePoll(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.SendPollMsg:
+		model, cmd := m.handleSendPollResult(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case imageLoadedMsg:
+		model, cmd := m.handleImageLoaded(msg)
+		m = model.(Model)
+		cmds = append(cmds, cmd)
+	case types.StoriesDownloadStatusMsg:
+		if len(msg.Photos) > 0 {
+			return m.viewStoryPhotos(msg.Photos)
+		}
 	case types.GetMessagesMsg:
 		model, cmd := m.handleGetMessages(msg)
 		m = model.(Model)
@@ -308,12 +407,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		if isValid {
-			for i, conv := range m.Conversations {
-				if conv.ID == msg.Message.ID {
-					m.Conversations[i] = *msg.Message
-					cmds = append(cmds, m.updateConversations())
-					break
-				}
+			if i := m.Conversations.Index(msg.Message.ID); i != -1 {
+				m.Conversations.Messages[i] = *msg.Message
+				cmds = append(cmds, m.updateConversations())
 			}
 		}
 
@@ -377,7 +473,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	m.Alert = outAlert.(bubbleup.AlertModel)
 
 	cmds = append(cmds, outCmd)
-	return updateFocusedComponent(&m, msg, &cmds)
+	m, cmd := updateFocusedComponent(&m, msg, &cmds)
+	if _, ok := msg.(tea.KeyMsg); ok {
+		// "@bot query" in the input asks the bot once typing pauses
+		cmd = tea.Batch(cmd, m.queryInlineBot())
+	}
+	return m, cmd
 }
 
 func (m Model) updateUserStories(msg types.GetAllStoriesMsg) (tea.Model, tea.Cmd) {
@@ -439,6 +540,106 @@ func sendNewMessageNotification[T types.UserInfo | types.ChannelInfo](item T, me
 	}
 }
 
+// isOpenChat reports whether the peer of an update is the chat currently on screen
+func (m Model) isOpenChat(peerID string, peerType types.ChatType) bool {
+	switch m.Mode {
+	case ModeUsers, ModeBots:
+		return peerType == types.UserChat && m.SelectedUser.PeerID == peerID
+	case ModeChannels:
+		return peerType != types.UserChat && m.SelectedChannel.ID == peerID
+	case ModeGroups:
+		return peerType != types.UserChat && m.SelectedGroup.ID == peerID
+	}
+	return false
+}
+
+// handleServiceMessage shows what just happened in the open chat between its messages
+func (m Model) handleServiceMessage(msg types.ServiceMessageNotification) (tea.Model, tea.Cmd) {
+	if !m.isOpenChat(msg.PeerID, msg.PeerType) || !m.isTopicOpen(msg.TopicID) {
+		return m, nil
+	}
+	if m.Conversations.Has(msg.Message.ID) || !m.Conversations.ReachedLatest {
+		return m, nil
+	}
+	m.Conversations.Append(msg.Message)
+	return m, m.updateConversations()
+}
+
+func (m Model) handleMessageEdited(msg types.MessageEditedNotification) (tea.Model, tea.Cmd) {
+	if msg.Message == nil || !m.isOpenChat(msg.PeerID, msg.PeerType) {
+		return m, nil
+	}
+	forgetPreview(msg.PeerID, msg.Message.ID)
+	for i, v := range m.Conversations.Messages {
+		if v.ID == 0 || v.ID != msg.Message.ID {
+			continue
+		}
+		// keep sender and reply info, only what can change on an edit is replaced
+		webPageMedia, _ := msg.Message.Media.(*tg.MessageMediaWebPage)
+		v.MediaInfo, v.IsUnsupportedMessage = shared.ClassifyMedia(msg.Message.Media)
+		v.Content = msg.Message.Message
+		v.Entities = shared.ConvertEntities(msg.Message.Entities)
+		if v.IsUnsupportedMessage {
+			v.Content = "This Message is not supported by this Telegram client."
+			v.Entities = nil
+		}
+		v.HasWebPagePreview = webPageMedia != nil
+		v.MessageMediaWebPage = webPageMedia
+		// results in an edit may leave out our own vote, it is kept from what we had
+		if pollMedia, ok := msg.Message.Media.(*tg.MessageMediaPoll); ok {
+			v.Poll = shared.UpdatePoll(v.Poll, &pollMedia.Poll, pollMedia.Results)
+		}
+		// bots change their buttons by editing the message
+		v.InlineKeyboard = shared.InlineKeyboard(msg.Message.ReplyMarkup)
+		v.ReplyKeyboard = shared.ReplyKeyboard(msg.Message.ReplyMarkup)
+		v.IsEdited = shared.IsMessageEdited(msg.Message)
+		if _, ok := msg.Message.GetReactions(); ok {
+			reactions := msg.Message.Reactions
+			v.Reactions = &reactions
+		}
+		m.Conversations.Messages[i] = v
+		return m, m.refreshConversations()
+	}
+	return m, nil
+}
+
+func (m Model) handleMessagesDeleted(msg types.MessagesDeletedNotification) (tea.Model, tea.Cmd) {
+	if msg.PeerID == "" {
+		// ids without a chat belong to private chats and basic groups, supergroups and channels have their own ids
+		isPrivate := m.Mode == ModeUsers || m.Mode == ModeBots
+		if !isPrivate && !(m.Mode == ModeGroups && isBasicGroup(m.SelectedGroup)) {
+			return m, nil
+		}
+	} else if !m.isOpenChat(msg.PeerID, types.ChannelChat) {
+		return m, nil
+	}
+
+	if !m.Conversations.Remove(msg.MessageIDs...) {
+		return m, nil
+	}
+	return m, m.refreshConversations()
+}
+
+func (m Model) handleMessageReactions(msg types.MessageReactionsNotification) (tea.Model, tea.Cmd) {
+	if !m.isOpenChat(msg.PeerID, msg.PeerType) {
+		return m, nil
+	}
+	for i, v := range m.Conversations.Messages {
+		if v.ID == 0 || v.ID != msg.MessageID {
+			continue
+		}
+		reactions := msg.Reactions
+		m.Conversations.Messages[i].Reactions = &reactions
+		return m, m.refreshConversations()
+	}
+	return m, nil
+}
+
+// basic groups are plain chats, they have no access hash unlike supergroups
+func isBasicGroup(group types.ChannelInfo) bool {
+	return group.AccessHash == "" || group.AccessHash == "0"
+}
+
 func (m Model) handleReadHistoryOutbox(msg types.ReadHistoryOutboxNotification) (tea.Model, tea.Cmd) {
 	peerID := msg.PeerID
 	var userInfo *types.UserInfo
@@ -494,11 +695,19 @@ func (m Model) handleReadHistoryOutbox(msg types.ReadHistoryOutboxNotification)
 
 func (m Model) handleNewMessage(msg types.NewMessageNotification) (tea.Model, tea.Cmd) {
 	peerID := msg.FromID
+	// a sent message ends whatever its author was doing
+	if msg.PeerType == "" {
+		m.clearTyping(typingChatKey(peerID, types.UserChat), peerID)
+	} else if msg.Sender != nil {
+		m.clearTyping(typingChatKey(peerID, msg.PeerType), msg.Sender.PeerID)
+	}
 	var userInfo *types.UserInfo
-	for _, v := range slices.Concat(m.Users.Items(), m.Bots.Items()) {
-		if user, ok := v.(types.UserInfo); ok && user.PeerID == peerID {
-			userInfo = &user
-			break
+	if msg.PeerType == "" {
+		for _, v := range slices.Concat(m.Users.Items(), m.Bots.Items()) {
+			if user, ok := v.(types.UserInfo); ok && user.PeerID == peerID {
+				userInfo = &user
+				break
+			}
 		}
 	}
 
@@ -521,36 +730,46 @@ func (m Model) handleNewMessage(msg types.NewMessageNotification) (tea.Model, te
 	}
 
 	if channelOrGroupInfo != nil {
-		if m.Mode != ModeGroups && m.Mode != ModeChannels {
-			return m, nil
-		}
-
-		chatType := types.GroupChat
-		if m.Mode == ModeChannels {
-			chatType = types.ChannelChat
-		}
-
-		if m.SelectedChannel.ID == channelOrGroupInfo.ID || m.SelectedGroup.ID == channelOrGroupInfo.ID {
+		l, chatType := &m.Groups, types.GroupChat
+		if channelOrGroupInfo.IsBroadcast {
+			l, chatType = &m.Channels, types.ChannelChat
+		}
+
+		isOpen := (chatType == types.ChannelChat && m.Mode == ModeChannels && m.SelectedChannel.ID == channelOrGroupInfo.ID) ||
+			(chatType == types.GroupChat && m.Mode == ModeGroups && m.SelectedGroup.ID == channelOrGroupInfo.ID)
+		if isOpen && m.isMessageInOpenTopic(msg.Message) {
+			// the same message can show up twice when a recovered update races the history request,
+			// and while older pages are on screen a live message would leave a gap behind it
+			if m.Conversations.Has(msg.ID) || !m.Conversations.ReachedLatest {
+				return m, nil
+			}
 			formattedMessage := getFormattedMessageFunc(GetFormattedMessageArg{
 				ChatType:           chatType,
 				ChannelOrGroupInfo: channelOrGroupInfo,
+				UserInfo:           msg.Sender,
 				Message:            msg.Message,
 			})
 
-			filled := len(filterEmptyMessages(m.Conversations))
-			if filled < len(m.Conversations) {
-				m.Conversations[filled] = formattedMessage
-			} else {
-				copy(m.Conversations[:], m.Conversations[1:])
-				m.Conversations[len(m.Conversations)-1] = formattedMessage
-			}
-			return m, m.updateConversations()
+			m.Conversations.Append(formattedMessage)
+			fetchCmd := m.checkAndFetchCustomEmojis([]types.FormattedMessage{formattedMessage})
+			return m, tea.Batch(fetchCmd, m.updateConversations())
 		}
 
-		if groupIndex := getGroupIndex(m, *channelOrGroupInfo); groupIndex != -1 && !msg.Message.Out {
-			group := m.Groups.Items()[groupIndex].(types.ChannelInfo)
-			group.UnreadCount++
-			m.Groups.SetItem(groupIndex, group)
+		if msg.Message.Out {
+			return m, nil
+		}
+		for i, v := range l.Items() {
+			item, ok := v.(types.ChannelInfo)
+			if !ok || item.ID != channelOrGroupInfo.ID {
+				continue
+			}
+			// messages recovered after a restart may already be counted in the dialog we loaded
+			if msg.ID <= item.TopMessageID {
+				return m, nil
+			}
+			item.UnreadCount++
+			item.TopMessageID = msg.ID
+			return m, l.SetItem(i, item)
 		}
 		return m, nil
 	}
@@ -563,7 +782,11 @@ func (m Model) handleNewMessage(msg types.NewMessageNotification) (tea.Model, te
 		l := listForUser(&m, *userInfo)
 		if userIndex := getUserIndex(*l, *userInfo); userIndex != -1 {
 			user := l.Items()[userIndex].(types.UserInfo)
+			if msg.ID <= user.TopMessageID {
+				return m, nil
+			}
 			user.UnreadCount++
+			user.TopMessageID = msg.ID
 			return m, l.SetItem(userIndex, user)
 		}
 		return m, nil
@@ -574,8 +797,13 @@ func (m Model) handleNewMessage(msg types.NewMessageNotification) (tea.Model, te
 		chatType = types.BotChat
 	}
 
-	if !msg.Message.Out {
+	if m.Conversations.Has(msg.ID) {
+		return m, nil
+	}
+
+	if !msg.Message.Out && msg.ID > m.SelectedUser.TopMessageID {
 		m.SelectedUser.UnreadCount++
+		m.SelectedUser.TopMessageID = msg.ID
 	}
 
 	formattedMessage := getFormattedMessageFunc(GetFormattedMessageArg{
@@ -584,18 +812,38 @@ func (m Model) handleNewMessage(msg types.NewMessageNotification) (tea.Model, te
 		Message:  msg.Message,
 	})
 
-	filled := len(filterEmptyMessages(m.Conversations))
-	if filled < len(m.Conversations) {
-		m.Conversations[filled] = formattedMessage
-	} else {
-		copy(m.Conversations[:], m.Conversations[1:])
-		m.Conversations[len(m.Conversations)-1] = formattedMessage
+	if !m.Conversations.ReachedLatest {
+		return m, nil
 	}
+	m.Conversations.Append(formattedMessage)
 	cmd := m.updateConversations()
 	fetchCmd := m.checkAndFetchCustomEmojis([]types.FormattedMessage{formattedMessage})
 	return m, tea.Batch(fetchCmd, cmd)
 }
 
+// isMessageInOpenTopic reports whether a message of the open group belongs on screen,
+// in forums that means it was posted in the topic we are looking at
+func (m Model) isMessageInOpenTopic(message *tg.Message) bool {
+	return m.isTopicOpen(messageTopicID(message))
+}
+
+// isTopicOpen tells whether messages of the topic belong on screen, chats without topics always do
+func (m Model) isTopicOpen(topicID int) bool {
+	if !m.SelectedGroup.IsForum || m.Mode != ModeGroups {
+		return true
+	}
+	if m.SelectedForumTopic == nil {
+		return false
+	}
+	return topicID == m.SelectedForumTopic.ID
+}
+
+// messageTopicID returns the forum topic a message was posted in, the general topic has id 1
+func messageTopicID(message *tg.Message) int {
+	replyTo, _ := message.GetReplyTo()
+	return shared.ReplyTopicID(replyTo)
+}
+
 type GetFormattedMessageArg struct {
 	ChatType           types.ChatType
 	ChannelOrGroupInfo *types.ChannelInfo
@@ -613,6 +861,11 @@ func getFormattedMessageFunc(arg GetFormattedMessageArg) types.FormattedMessage
 	} else if arg.ChannelOrGroupInfo != nil {
 		sender = arg.ChannelOrGroupInfo.ChannelTitle
 		fromID = &arg.ChannelOrGroupInfo.ID
+		// in groups show who wrote the message, channel posts are signed by the channel
+		if arg.UserInfo != nil {
+			sender = arg.UserInfo.FirstName
+			fromID = &arg.UserInfo.PeerID
+		}
 	}
 
 	if arg.Message.Out {
@@ -624,18 +877,31 @@ func getFormattedMessageFunc(arg GetFormattedMessageArg) types.FormattedMessage
 		mediaStr := fmt.Sprintf("%T", arg.Message.Media)
 		media = &mediaStr
 	}
+	mediaInfo, unsupported := shared.ClassifyMedia(arg.Message.Media)
+
+	var entities []types.MessageEntity
+	if !unsupported {
+		entities = shared.ConvertEntities(arg.Message.Entities)
+	}
 
 	return types.FormattedMessage{
 		ID:                   arg.Message.ID,
 		Sender:               sender,
 		Content:              arg.Message.Message,
+		Entities:             entities,
 		IsFromMe:             arg.Message.GetOut(),
 		Media:                media,
-		IsUnsupportedMessage: media != nil,
+		MediaInfo:            mediaInfo,
+		IsUnsupportedMessage: unsupported,
 		Date:                 time.Unix(int64(arg.Message.Date), 0),
 		FromID:               fromID,
 		ReplyTo:              nil,
 		SenderUserInfo:       arg.UserInfo,
+		IsEdited:             shared.IsMessageEdited(arg.Message),
+		GroupedID:            arg.Message.GroupedID,
+		Poll:                 shared.MessagePoll(arg.Message.Media),
+		InlineKeyboard:       shared.InlineKeyboard(arg.Message.ReplyMarkup),
+		ReplyKeyboard:        shared.ReplyKeyboard(arg.Message.ReplyMarkup),
 	}
 }
 
@@ -665,9 +931,11 @@ func (m Model) handleMessageDeletion(msg MessageDeletionConfirmResponseMsg) (tea
 	}
 	peer := getMessageParams(&m)
 	selectedItemInChat := m.ChatUI.SelectedItem().(types.FormattedMessage)
+	ids := albumIDs(selectedItemInChat)
 	response, err := telegram.Cligram.DeleteMessage(telegram.Cligram.Context(), types.DeleteMessageRequest{
 		Peer:      peer,
-		MessageID: int(selectedItemInChat.ID),
+		MessageID: ids[0],
+		AlbumIDs:  ids[1:],
 	})
 	if err != nil {
 		m.IsModalVisible = true
@@ -675,70 +943,91 @@ func (m Model) handleMessageDeletion(msg MessageDeletionConfirmResponseMsg) (tea
 		return m, nil
 	}
 	if response.Status == "success" {
-		var updatedConversations [50]types.FormattedMessage
-		for i, v := range m.Conversations {
-			if v.ID != selectedItemInChat.ID {
-				updatedConversations[i] = v
-			}
-		}
-		m.Conversations = updatedConversations
-		cmd := m.ChatUI.SetItems(formatMessages(updatedConversations))
+		m.Conversations.Remove(ids...)
+		cmd := m.ChatUI.SetItems(m.Conversations.Items())
 		return m, cmd
 	}
 	return m, nil
 }
 
 func (m Model) handleGetMessages(msg types.GetMessagesMsg) (tea.Model, tea.Cmd) {
+	// a page for a chat or topic we have already left
+	if !m.isOpenHistory(msg.Peer, msg.TopMsgID) {
+		return m, nil
+	}
+	if msg.FromCache {
+		// the server page got here first, the cached one is older than what is shown
+		if !m.CachedHistoryPending {
+			return m, nil
+		}
+		m.CachedHistoryPending = false
+		m.MainViewLoading = false
+		m.Conversations.Replace(msg.Messages, false, true)
+		m.appendOutboxMessages()
+		m.ConversationsFromCache = true
+		cmd := m.updateConversations()
+		m.ChatUI.Select(len(m.ChatUI.Items()) - 1)
+		return m, cmd
+	}
 	m.MainViewLoading = false
+	m.Conversations.DoneLoading()
 	if msg.Err != nil {
 		slog.Error("Failed to get messages", "error", msg.Err.Error())
 		m.IsModalVisible = true
 		m.ModalContent = GetModalContent(msg.Err.Error())
 		return m, nil
 	}
+	// offline the cached page can still show after the error above, not after a real answer
+	m.CachedHistoryPending = false
 
-	messagesWeGot := len(filterEmptyMessages(msg.Messages))
-	if messagesWeGot < 1 {
+	switch msg.Direction {
+	case types.HistoryOlder:
+		return m.handleOlderHistory(msg)
+	case types.HistoryNewer:
+		return m.handleNewerHistory(msg)
+	}
+
+	if len(msg.Messages) < 1 {
+		if msg.Direction == types.HistoryAround && m.GoToTarget != nil {
+			m.GoToTarget = nil
+			m.Alert = m.Alert.WithAllowEscToClose().WithPosition(bubbleup.TopLeftPosition)
+			return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "nothing found there")
+		}
+		if msg.Direction == types.HistoryLatest {
+			m.Conversations.ReachedOldest = true
+			m.Conversations.ReachedLatest = true
+		}
 		if selectedChat, ok := m.Users.SelectedItem().(types.UserInfo); ok && selectedChat.IsBot {
 			m.Input.SetValue("/start")
 		}
 		return m, nil
 	}
 
-	m.Conversations = m.mergeConversations(msg.Messages, messagesWeGot)
+	if msg.Direction == types.HistoryAround {
+		m.Conversations.Replace(msg.Messages, false, false)
+	} else {
+		m.Conversations.Replace(msg.Messages, !msg.HasMore, true)
+	}
+	m.ConversationsFromCache = false
+	m.appendOutboxMessages()
 	cmd := m.updateConversations()
-	m.ChatUI.Select(len(m.Conversations) - 1)
+	m.ChatUI.Select(len(m.ChatUI.Items()) - 1)
+	if msg.Direction == types.HistoryAround {
+		cmd = tea.Batch(cmd, m.selectGoToTarget())
+	}
 
-	fetchCmd := m.checkAndFetchCustomEmojis(filterEmptyMessages(m.Conversations))
+	fetchCmd := m.checkAndFetchCustomEmojis(m.Conversations.Messages)
 	return m, tea.Batch(fetchCmd, cmd)
 }
 
-func (m Model) mergeConversations(newMessages [50]types.FormattedMessage, messagesWeGot int) [50]types.FormattedMessage {
-	if messagesWeGot >= 50 {
-		return newMessages
-	}
-	var oldMessages []types.FormattedMessage
-	for _, v := range m.ChatUI.Items() {
-		if msg, ok := v.(types.FormattedMessage); ok && msg.ID != 0 {
-			oldMessages = append(oldMessages, msg)
-		}
-	}
-	var updatedConversations [50]types.FormattedMessage
-	if (messagesWeGot + len(oldMessages)) <= 50 {
-		updatedConversations = newMessages
-	} else {
-		take := 50 - messagesWeGot
-		combined := append(m.Conversations[:take], newMessages[:]...)
-		copy(updatedConversations[:], combined)
-	}
-	return updatedConversations
-}
-
 func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 	switch msg.String() {
 	case "shift+down":
 		if m.FocusedOn == Main {
+			if !m.Conversations.ReachedLatest && !m.MainViewLoading {
+				return m, m.jumpToLatest()
+			}
 			m.ChatUI.Select(len(m.ChatUI.Items()) - 1)
 		}
 	case "ctrl+a":
@@ -750,8 +1039,9 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "backspace":
 		if m.FocusedOn == Main && m.ShowForumTopics && m.SelectedForumTopic != nil {
 			m.SelectedForumTopic = nil
+			m.ChatSearch = nil
 			m.MainViewLoading = false
-			m.Conversations = [50]types.FormattedMessage{}
+			m.Conversations.Reset()
 			m.ChatUI.SetItems([]list.Item{})
 			m.ChatUI.ResetSelected()
 			return m, nil
@@ -808,6 +1098,14 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m, cmd := m.handleEditKey()
 		cmds = append(cmds, cmd)
 		return m, tea.Batch(cmds...)
+	case "R":
+		if m.FocusedOn == Main {
+			return m.handleRetryKey()
+		}
+	case "X":
+		if m.FocusedOn == Main {
+			return m.handleDiscardKey()
+		}
 	case "ctrl+r":
 		cmds := []tea.Cmd{telegram.Cligram.GetAvailableReactions(telegram.Cligram.Context())}
 		if m.CurrentUser == nil {
@@ -819,6 +1117,47 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, tea.Batch(cmds...)
 	case "alt+s":
 		return m, func() tea.Msg { return m.Stories }
+	case "ctrl+g":
+		return m.handleGoToKey()
+	case "ctrl+f":
+		return m.handleChatSearchKey()
+	case "s":
+		if m.FocusedOn == Main {
+			return m.handleRevealSpoilerKey()
+		}
+	case "n":
+		if m.FocusedOn == Main && m.ChatSearch != nil {
+			return m.stepChatSearch(1)
+		}
+	case "N":
+		if m.FocusedOn == Main && m.ChatSearch != nil {
+			return m.stepChatSearch(-1)
+		}
+	case "D":
+		if m.FocusedOn == Main {
+			return m.handleDownloadKey()
+		}
+	case "v":
+		if m.FocusedOn == Main {
+			return m.handleViewKey()
+		}
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9", "0":
+		if m.FocusedOn == Main {
+			return m.handlePollAnswerKey(msg.String())
+		}
+	case "x":
+		if m.FocusedOn == Main {
+			return m.handleRetractVoteKey()
+		}
+	case "ctrl+p":
+		return m.handleNewPollKey()
+	case "ctrl+x":
+		return m.handleCancelUploadKey()
+	case "ctrl+t":
+		if len(m.SelectedFiles) > 0 {
+			m.SendAsFile = !m.SendAsFile
+			return m, nil
+		}
 	}
 	cmds = append(cmds, SendUserIsTyping(&m))
 	return m, tea.Batch(cmds...)
@@ -840,7 +1179,7 @@ func (m Model) handleListPagination() (Model, tea.Cmd) {
 
 func (m Model) handleEditKey() (tea.Model, tea.Cmd) {
 	if m.FocusedOn == Main {
-		if selectedItem, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok && strings.ToLower(selectedItem.Sender) == "you" {
+		if selectedItem, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok && strings.ToLower(selectedItem.Sender) == "you" && !selectedItem.Service {
 			sentDate := selectedItem.Date
 			now := time.Now()
 			diff := now.Sub(sentDate)
@@ -850,8 +1189,11 @@ func (m Model) handleEditKey() (tea.Model, tea.Cmd) {
 				return m, alertCmd
 			}
 			m.FocusedOn = Input
-			m.Input.SetValue(selectedItem.Content)
+			// the text goes back through the markup parser when it's sent, so formatting is written out as markup
+			markup, mode := shared.FormatMarkup(selectedItem.Content, selectedItem.Entities)
+			m.Input.SetValue(markup)
 			m.EditMessage = &selectedItem
+			m.EditMarkup = mode
 			m.SkipNextInput = true
 		}
 	}
@@ -888,8 +1230,9 @@ func (m Model) handleEnterKey() (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 		m.SelectedForumTopic = &forumTopic
+		m.ChatSearch = nil
 		m.MainViewLoading = true
-		m.Conversations = [50]types.FormattedMessage{}
+		m.Conversations.Reset()
 		m.ChatUI.SetItems([]list.Item{})
 		m.ChatUI.ResetSelected()
 
@@ -897,13 +1240,24 @@ func (m Model) handleEnterKey() (tea.Model, tea.Cmd) {
 		topicID := forumTopic.ID
 		cmd := telegram.Cligram.GetMessages(telegram.Cligram.Context(), types.GetMessagesRequest{
 			Peer:     pInfo,
-			Limit:    50,
+			Limit:    historyPageSize,
 			TopMsgID: &topicID,
 		})
-		return m, cmd
+		m.ConversationsFromCache = false
+		m.CachedHistoryPending = true
+		return m, tea.Batch(telegram.Cligram.GetCachedChatHistoryCmd(pInfo, historyPageSize, &topicID), cmd)
 	}
 
 	if m.FocusedOn == Main && m.ChatUI.SelectedItem() != nil {
+		if model, cmd, handled := m.handlePollEnterKey(); handled {
+			return model, cmd
+		}
+		if model, cmd, handled := m.handleInlineButtonEnter(); handled {
+			return model, cmd
+		}
+		if selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok && (selectedMessage.MediaInfo != nil || len(selectedMessage.Album) > 0) {
+			return m.handleOpenMediaKey()
+		}
 		if selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok && selectedMessage.MessageMediaWebPage != nil {
 			if webPage, ok := selectedMessage.MessageMediaWebPage.Webpage.(*tg.WebPage); ok {
 				if entity := getEntityName(webPage.URL); entity != nil {
@@ -968,8 +1322,9 @@ func (m Model) handleReplyKey() (tea.Model, tea.Cmd) {
 	if m.FocusedOn == Main {
 		canWrite := (m.Mode == ModeUsers || m.Mode == ModeGroups) || (m.Mode == ModeChannels && m.SelectedChannel.IsCreator)
 		if canWrite {
-			m.IsReply = true
-			if selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok {
+			// nobody can reply to what happened in the chat, only to messages
+			if selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage); ok && !selectedMessage.Service {
+				m.IsReply = true
 				m.FocusedOn = Input
 				m.SkipNextInput = true
 				m.ReplyTo = &selectedMessage
@@ -994,7 +1349,7 @@ func (m Model) handleForwardKey() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 	selectedMessage, ok := m.ChatUI.SelectedItem().(types.FormattedMessage)
-	if !ok {
+	if !ok || selectedMessage.Service {
 		return m, nil
 	}
 
@@ -1028,6 +1383,61 @@ func appendListItems[T types.FilterableItem](l *list.Model, items []T) tea.Cmd {
 	return l.SetItems(current)
 }
 
+// handleAllChats swaps the cached sidebar lists for the fresh ones from the server,
+// keeping the cursor on the same chat
+func (m Model) handleAllChats(msg types.AllChatsMsg) (tea.Model, tea.Cmd) {
+	if msg.Err != nil {
+		slog.Error("Failed to refresh chats", "error", msg.Err.Error())
+		m.Alert = m.Alert.WithAllowEscToClose().WithPosition(bubbleup.TopLeftPosition)
+		return m, m.Alert.NewAlertCmd(bubbleup.WarnKey, "offline: showing cached chats")
+	}
+	if msg.Response == nil {
+		return m, nil
+	}
+	m.ChatsFromCache = false
+
+	var users, bots []list.Item
+	for _, u := range msg.Response.PrivateChats {
+		if u.IsBot {
+			bots = append(bots, u)
+			continue
+		}
+		users = append(users, u)
+	}
+	var channels, groups []list.Item
+	for _, c := range msg.Response.Channels {
+		channels = append(channels, c)
+	}
+	for _, g := range msg.Response.Groups {
+		groups = append(groups, g)
+	}
+
+	cmds := []tea.Cmd{
+		reconcileListItems(&m.Users, users),
+		reconcileListItems(&m.Bots, bots),
+		reconcileListItems(&m.Channels, channels),
+		reconcileListItems(&m.Groups, groups),
+	}
+	m.OffsetDate = msg.Response.OffsetDate
+	m.OffsetID = msg.Response.OffsetID
+	return m, tea.Batch(cmds...)
+}
+
+func reconcileListItems(l *list.Model, items []list.Item) tea.Cmd {
+	var selectedID string
+	if selected := l.SelectedItem(); selected != nil {
+		selectedID = peerFromItem(selected).ID
+	}
+	cmd := l.SetItems(items)
+	for i, item := range items {
+		if selectedID != "" && peerFromItem(item).ID == selectedID {
+			l.Select(i)
+			break
+		}
+	}
+	return cmd
+}
+
 func (m Model) handleUserChats(msg types.UserChatsMsg) (tea.Model, tea.Cmd) {
 	if msg.Err != nil {
 		m.IsModalVisible = true
@@ -1081,7 +1491,7 @@ func (m Model) handleForwardMessage(msg ForwardMsg) (tea.Model, tea.Cmd) {
 	err := telegram.Cligram.ForwardMessages(telegram.Cligram.Context(), types.ForwardMessagesRequest{
 		FromPeer:   from,
 		ToPeer:     toPeer,
-		MessageIDs: []int{int(msg.msg.ID)},
+		MessageIDs: albumIDs(*msg.msg),
 	})
 	if err != nil {
 		slog.Error("Failed to forward message", "error", err.Error())
@@ -1135,18 +1545,18 @@ func (m Model) handleSearchedUserResult(msg SelectSearchedUserResult) (tea.Model
 		if userInfo == nil {
 			userInfo = msg.Bot
 		}
-		return m.handleSearchedUser(*userInfo)
+		return m.handleSearchedUser(*userInfo, nil)
 	}
 	if msg.channel != nil {
-		return m.handleSearchedChannel(*msg.channel)
+		return m.handleSearchedChannel(*msg.channel, nil)
 	}
 	if msg.group != nil {
-		return m.handleSearchedGroup(*msg.group)
+		return m.handleSearchedGroup(*msg.group, nil)
 	}
 	return m, nil
 }
 
-func (m Model) handleSearchedUser(user types.UserInfo) (tea.Model, tea.Cmd) {
+func (m Model) handleSearchedUser(user types.UserInfo, offsetID *int) (tea.Model, tea.Cmd) {
 	m.SelectedUser = user
 	l := listForUser(&m, user)
 	targetMode := ModeUsers
@@ -1159,7 +1569,7 @@ func (m Model) handleSearchedUser(user types.UserInfo) (tea.Model, tea.Cmd) {
 		l.Select(index)
 		m.FocusedOn = SideBar
 		m.Mode = targetMode
-		return handleUserChange(&m, nil, nil)
+		return handleUserChange(&m, offsetID, nil)
 	}
 
 	updateUserCmd := l.SetItems(append(l.Items(), user))
@@ -1168,20 +1578,20 @@ func (m Model) handleSearchedUser(user types.UserInfo) (tea.Model, tea.Cmd) {
 		l.Select(index)
 		m.FocusedOn = SideBar
 		m.Mode = targetMode
-		m, handleUserChangeCmd := handleUserChange(&m, nil, nil)
+		m, handleUserChangeCmd := handleUserChange(&m, offsetID, nil)
 		return m, tea.Batch(updateUserCmd, handleUserChangeCmd)
 	}
 	return m, updateUserCmd
 }
 
-func (m Model) handleSearchedChannel(channel types.ChannelInfo) (tea.Model, tea.Cmd) {
+func (m Model) handleSearchedChannel(channel types.ChannelInfo, offsetID *int) (tea.Model, tea.Cmd) {
 	m.SelectedChannel = channel
 	index := getChannelIndex(m, channel)
 	if index != -1 {
 		m.Channels.Select(index)
 		m.FocusedOn = SideBar
 		m.Mode = ModeChannels
-		return handleUserChange(&m, nil, nil)
+		return handleUserChange(&m, offsetID, nil)
 	}
 
 	setItemsCmd := m.Channels.SetItems(append(m.Channels.Items(), channel))
@@ -1190,20 +1600,20 @@ func (m Model) handleSearchedChannel(channel types.ChannelInfo) (tea.Model, tea.
 		m.Channels.Select(index)
 		m.FocusedOn = SideBar
 		m.Mode = ModeChannels
-		m, handleChangeUserCmd := handleUserChange(&m, nil, nil)
+		m, handleChangeUserCmd := handleUserChange(&m, offsetID, nil)
 		return m, tea.Batch(setItemsCmd, handleChangeUserCmd)
 	}
 	return m, setItemsCmd
 }
 
-func (m Model) handleSearchedGroup(group types.ChannelInfo) (tea.Model, tea.Cmd) {
+func (m Model) handleSearchedGroup(group types.ChannelInfo, offsetID *int) (tea.Model, tea.Cmd) {
 	m.SelectedGroup = group
 	index := getGroupIndex(m, group)
 	if index != -1 {
 		m.Groups.Select(index)
 		m.FocusedOn = SideBar
 		m.Mode = ModeGroups
-		return handleUserChange(&m, nil, nil)
+		return handleUserChange(&m, offsetID, nil)
 	}
 
 	setItemsCmd := m.Groups.SetItems(append(m.Groups.Items(), group))
@@ -1212,7 +1622,7 @@ func (m Model) handleSearchedGroup(group types.ChannelInfo) (tea.Model, tea.Cmd)
 		m.Groups.Select(index)
 		m.FocusedOn = SideBar
 		m.Mode = ModeGroups
-		m, handleChangeUserCmd := handleUserChange(&m, nil, nil)
+		m, handleChangeUserCmd := handleUserChange(&m, offsetID, nil)
 		return m, tea.Batch(setItemsCmd, handleChangeUserCmd)
 	}
 	return m, setItemsCmd
@@ -14,7 +14,9 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/kumneger0/cligram/internal/config"
 	"github.com/kumneger0/cligram/internal/telegram"
+	"github.com/kumneger0/cligram/internal/telegram/shared"
 	"github.com/kumneger0/cligram/internal/telegram/types"
+	"github.com/kumneger0/cligram/internal/termimage"
 )
 
 type CustomDelegate struct {
@@ -41,6 +43,9 @@ func (d CustomDelegate) Render(w io.Writer, m list.Model, index int, item list.I
 	switch item := item.(type) {
 	case types.UserInfo:
 		title = item.Title()
+		if typing := d.Model.typingText(item.PeerID, types.UserChat); typing != "" {
+			title = typing
+		}
 		if item.IsOnline {
 			prefix = "🟢 "
 		} else {
@@ -51,6 +56,9 @@ func (d CustomDelegate) Render(w io.Writer, m list.Model, index int, item list.I
 		}
 	case types.ChannelInfo:
 		title = item.Title()
+		if typing := d.Model.typingText(item.ID, types.GroupChat); typing != "" {
+			title += ": " + typing
+		}
 		if item.IsBroadcast {
 			prefix = "📢 "
 		} else {
@@ -97,7 +105,11 @@ func (m Model) Init() tea.Cmd {
 	filePickerInitCMD := m.Filepicker.Init()
 	storiesCMD := telegram.Cligram.GetAllStories(telegram.Cligram.Context())
 
-	return tea.Batch(filePickerInitCMD, storiesCMD)
+	cmds := []tea.Cmd{filePickerInitCMD, storiesCMD}
+	if m.ChatsFromCache {
+		cmds = append(cmds, telegram.Cligram.RefreshAllChats(telegram.Cligram.Context()))
+	}
+	return tea.Batch(cmds...)
 }
 
 func getChannelIndex(m Model, channel types.ChannelInfo) int {
@@ -139,17 +151,13 @@ func sendMessage(m *Model) (Model, tea.Cmd) {
 	if m.ReplyTo != nil {
 		messageToReply = *m.ReplyTo
 	}
-	var isFile bool
-	if m.SelectedFile != "" {
-		isFile = true
-	}
-	var filepath string
-	if isFile {
-		filepath = m.SelectedFile
-	}
-	if _, err := os.Stat(m.SelectedFile); os.IsNotExist(err) && isFile {
-		m.Input.SetValue("Invalid file path")
-		return *m, nil
+	files := m.SelectedFiles
+	isFile := len(files) > 0
+	for _, path := range files {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			m.Input.SetValue("Invalid file path")
+			return *m, nil
+		}
 	}
 	var cmds []tea.Cmd
 	if m.EditMessage != nil {
@@ -171,23 +179,32 @@ func sendMessage(m *Model) (Model, tea.Cmd) {
 		topMsgID = &id
 	}
 
-	cmds = append(cmds, telegram.Cligram.SendMessage(telegram.Cligram.Context(),
-		types.SendMessageRequest{
-			RandID:           randID,
-			Peer:             peerInfo,
-			Message:          userMsg,
-			IsReply:          m.IsReply && m.ReplyTo != nil,
-			ReplyToMessageID: replyToMessageID,
-			IsFile:           isFile,
-			FilePath:         filepath,
-			TopMsgID:         topMsgID,
-		}))
-	if isFile {
-		m.SelectedFile = "uploading..."
+	request := types.SendMessageRequest{
+		RandID:           randID,
+		Peer:             peerInfo,
+		Message:          userMsg,
+		IsReply:          m.IsReply && m.ReplyTo != nil,
+		ReplyToMessageID: replyToMessageID,
+		IsFile:           isFile,
+		TopMsgID:         topMsgID,
+		SendAsFile:       isFile && m.SendAsFile,
 	}
-	content := userMsg
+	var mediaInfo *types.MediaInfo
+	var album []types.FormattedMessage
+	switch {
+	case len(files) == 1:
+		request.FilePath = files[0]
+		mediaInfo = localMediaInfo(files[0], m.SendAsFile)
+	case len(files) > 1:
+		request.FilePaths = files
+		album = localAlbum(files, m.SendAsFile)
+	}
+	cmds = append(cmds, telegram.Cligram.SendMessage(telegram.Cligram.Context(), request))
+	content, entities := shared.ParseMarkup(userMsg)
 	if isFile {
-		content = "This Message is not supported by this Telegram client."
+		m.startUpload(randID, files)
+		m.SelectedFiles = nil
+		m.SendAsFile = false
 	}
 	cligramConfig := config.GetConfig()
 	newMessage := types.FormattedMessage{
@@ -195,16 +212,23 @@ func sendMessage(m *Model) (Model, tea.Cmd) {
 		Sender:               "you",
 		IsFromMe:             true,
 		Content:              content,
+		Entities:             entities,
+		MediaInfo:            mediaInfo,
+		Album:                album,
 		Media:                nil,
 		Date:                 time.Now(),
-		IsUnsupportedMessage: isFile,
+		IsUnsupportedMessage: false,
 		WebPage:              nil,
 		Document:             nil,
 		FromID:               nil,
+		SendState:            types.OutboxPending,
+	}
+	if m.Conversations.ReachedLatest {
+		m.Conversations.Append(newMessage)
+	} else {
+		// the newest messages aren't loaded, the new one comes back with them from the outbox
+		cmds = append(cmds, m.jumpToLatest())
 	}
-	firstOneRemoved := m.Conversations[1:]
-	firstOneRemoved = append(firstOneRemoved, newMessage)
-	copy(m.Conversations[:], firstOneRemoved)
 	if *cligramConfig.Chat.ReadReceiptMode == "default" {
 		cmd := telegram.Cligram.MarkMessagesAsRead(telegram.Cligram.Context(), types.MarkAsReadRequest{
 			Peer: peerInfo,
@@ -225,6 +249,7 @@ func (m *Model) editMessage(peerInfo types.Peer, userMsg string) (Model, tea.Cmd
 			Peer:       peerInfo,
 			MessageID:  int(m.EditMessage.ID),
 			NewMessage: userMsg,
+			Markup:     string(m.EditMarkup),
 		})
 		return nil
 	}
@@ -239,8 +264,8 @@ func updateFocusedComponent(m *Model, msg tea.Msg, cmdsFromParent *[]tea.Cmd) (M
 	m.Filepicker.SetHeight(m.Height - 13)
 	cmds = append(cmds, cmd)
 	if didSelect, path := m.Filepicker.DidSelectFile(msg); didSelect && m.IsFilepickerVisible {
-		m.SelectedFile = path
-		m.IsFilepickerVisible = false
+		keyMsg, _ := msg.(tea.KeyMsg)
+		cmds = append(cmds, m.pickFile(path, keyMsg.String() != " "))
 	}
 
 	switch m.FocusedOn {
@@ -272,7 +297,7 @@ func updateFocusedComponent(m *Model, msg tea.Msg, cmdsFromParent *[]tea.Cmd) (M
 			cmds = append(cmds, cmd)
 		} else {
 			m.ChatUI, cmd = m.ChatUI.Update(msg)
-			cmds = append(cmds, cmd)
+			cmds = append(cmds, cmd, m.loadMoreHistory())
 		}
 	}
 	m.SkipNextInput = false
@@ -282,20 +307,31 @@ func updateFocusedComponent(m *Model, msg tea.Msg, cmdsFromParent *[]tea.Cmd) (M
 func handleUserChange(m *Model, offsetID *int, afterMessagesCmd tea.Cmd) (Model, tea.Cmd) {
 	m.ShowForumTopics = false
 	m.SelectedForumTopic = nil
+	m.GoToTarget = nil
+	m.ChatSearch = nil
+	m.RevealedSpoilers = nil
+	// thumbnails of the chat we leave are never drawn again
+	clear(previews)
 
 	pInfo := getMessageParams(m)
 	if m.Mode == ModeGroups && m.SelectedGroup.IsForum {
 		m.ForumTopicLoading = true
-		m.Conversations = [50]types.FormattedMessage{}
+		m.Conversations.Reset()
 		m.ChatUI.SetItems([]list.Item{})
 		return *m, telegram.Cligram.GetChannelForums(pInfo)
 	}
 	cmd := telegram.Cligram.GetMessages(telegram.Cligram.Context(), types.GetMessagesRequest{
 		Peer:          pInfo,
-		Limit:         50,
+		Limit:         historyPageSize,
 		OffsetID:      offsetID,
 		ChatAreaWidth: nil,
 	})
+	m.CachedHistoryPending = offsetID == nil
+	if offsetID == nil {
+		// render what we have on disk while the server is asked, its response replaces it
+		cmd = tea.Batch(telegram.Cligram.GetCachedChatHistoryCmd(pInfo, historyPageSize, nil), cmd)
+	}
+	m.ConversationsFromCache = false
 	cligramConfig := config.GetConfig()
 	if *cligramConfig.Chat.ReadReceiptMode == "instant" {
 		markAsReadCmd := telegram.Cligram.MarkMessagesAsRead(telegram.Cligram.Context(), types.MarkAsReadRequest{
@@ -303,7 +339,7 @@ func handleUserChange(m *Model, offsetID *int, afterMessagesCmd tea.Cmd) (Model,
 		})
 		return *m, tea.Batch(cmd, markAsReadCmd)
 	}
-	m.Conversations = [50]types.FormattedMessage{}
+	m.Conversations.Reset()
 	m.MainViewLoading = true
 	m.ChatUI.ResetSelected()
 	m.ChatUI.SetItems([]list.Item{})
@@ -379,10 +415,11 @@ func changeSideBarMode(m *Model, msg string) (Model, tea.Cmd) {
 						m.Users.Select(foundIndex)
 					}
 					m.ChatUI.SetItems(nil)
+					m.Conversations.Reset()
 					return *m, telegram.Cligram.GetMessages(telegram.Cligram.Context(), types.GetMessagesRequest{
 						Peer: getMessageParams(m),
 						//TODO:  i might need to revisit this one
-						Limit:         50,
+						Limit:         historyPageSize,
 						OffsetID:      nil,
 						ChatAreaWidth: nil,
 					})
@@ -412,12 +449,20 @@ func (m *Model) getMessageSenderUserInfo() *types.UserInfo {
 }
 
 func (m *Model) updateConversations() tea.Cmd {
-	cmd := m.ChatUI.SetItems(formatMessages(m.Conversations))
+	cmd := m.ChatUI.SetItems(m.Conversations.Items())
 	m.viewport.SetContent(m.ChatUI.View())
 	m.viewport.GotoBottom()
 	return cmd
 }
 
+// refreshConversations re-renders messages already on screen without scrolling,
+// used when an edit, deletion or reaction changes a message the user may be reading
+func (m *Model) refreshConversations() tea.Cmd {
+	cmd := m.ChatUI.SetItems(m.Conversations.Items())
+	m.viewport.SetContent(m.ChatUI.View())
+	return cmd
+}
+
 func (m Model) View() string {
 	m.Users.Title = "Chats"
 	m.Channels.Title = "Channels"
@@ -425,10 +470,15 @@ func (m Model) View() string {
 	m.Users.SetShowStatusBar(false)
 	m.Groups.Title = "Groups"
 	m.Groups.SetShowStatusBar(false)
+	if m.ImageViewer != nil {
+		return m.Alert.Render(m.imageViewerView())
+	}
 	m.updateDelegates()
 
 	ui := setItemStyles(&m)
-	return m.Alert.Render(ui)
+	// kitty keeps a picture of the viewer over the text until it is deleted. the first line only
+	// goes out again when it changed, so this costs nothing while the chat is shown
+	return termimage.Clear(imageProtocol()) + m.Alert.Render(ui)
 }
 
 func (m *Model) updateDelegates() {